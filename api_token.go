@@ -6,6 +6,9 @@ package goatcounter
 
 import (
 	"context"
+	"fmt"
+	"net/netip"
+	"slices"
 	"strings"
 	"time"
 
@@ -21,13 +24,16 @@ import (
 //
 // DO NOT change the values of these constants; they're stored in the database.
 const (
-	APIPermNothing    zint.Bitflag64 = 1 << iota
-	APIPermCount                     // 2
-	APIPermExport                    // 4
-	APIPermSiteRead                  // 8
-	APIPermSiteCreate                // 16
-	APIPermSiteUpdate                // 32
-	APIPermStats                     // 64
+	APIPermNothing     zint.Bitflag64 = 1 << iota
+	APIPermCount                      // 2
+	APIPermExport                     // 4
+	APIPermSiteRead                   // 8
+	APIPermSiteCreate                 // 16
+	APIPermSiteUpdate                 // 32
+	APIPermStats                      // 64
+	APIPermUsers                      // 128
+	APIPermAuditLog                   // 256
+	APIPermPathsDelete                // 512
 )
 
 type APIToken struct {
@@ -39,6 +45,22 @@ type APIToken struct {
 	Token       string         `db:"token" json:"-"`
 	Permissions zint.Bitflag64 `db:"permissions" json:"permissions"`
 
+	// Token is no longer valid after this time; never expires if nil.
+	ExpiresAt *time.Time `db:"expires_at" json:"expires_at"`
+
+	// Only allow using this token for these sites; all sites the user has
+	// access to if empty.
+	Sites Ints `db:"sites" json:"sites"`
+
+	// Only allow using this token from these IP ranges (in CIDR notation, or
+	// a single IP address); any IP if empty.
+	AllowedIPs Strings `db:"allowed_ips" json:"allowed_ips"`
+
+	// Previous token after rotating it with Rotate(); this is still accepted
+	// until PreviousExpiresAt.
+	PreviousToken     *string    `db:"previous_token" json:"-"`
+	PreviousExpiresAt *time.Time `db:"previous_expires_at" json:"-"`
+
 	CreatedAt  time.Time  `db:"created_at" json:"-"`
 	LastUsedAt *time.Time `db:"last_used_at" json:"-"`
 }
@@ -84,6 +106,21 @@ func (t APIToken) PermissionFlags(only ...zint.Bitflag64) []PermissionFlag {
 			Label: "Update sites",
 			Flag:  APIPermSiteUpdate,
 		},
+		{
+			Label: "Manage users",
+			Help:  "Manage users and their access; requires full access to all sites",
+			Flag:  APIPermUsers,
+		},
+		{
+			Label: "Read audit log",
+			Help:  "Read the audit log; requires full access to all sites",
+			Flag:  APIPermAuditLog,
+		},
+		{
+			Label: "Merge and purge paths",
+			Help:  "Merge or permanently delete paths and their pageviews",
+			Flag:  APIPermPathsDelete,
+		},
 	}
 
 	if len(only) == 0 {
@@ -100,7 +137,9 @@ func (t APIToken) PermissionFlags(only ...zint.Bitflag64) []PermissionFlag {
 	return filter
 }
 
-func (t APIToken) FormatPermissions() string {
+// PermissionNames gets the names of all permissions this token has, as used in
+// error messages and the API documentation.
+func (t APIToken) PermissionNames() []string {
 	var all []string
 	if t.Permissions.Has(APIPermCount) {
 		all = append(all, "count")
@@ -117,7 +156,23 @@ func (t APIToken) FormatPermissions() string {
 	if t.Permissions.Has(APIPermSiteUpdate) {
 		all = append(all, "site-update")
 	}
-	return "'" + strings.Join(all, "', '") + "'"
+	if t.Permissions.Has(APIPermStats) {
+		all = append(all, "stats")
+	}
+	if t.Permissions.Has(APIPermUsers) {
+		all = append(all, "users")
+	}
+	if t.Permissions.Has(APIPermAuditLog) {
+		all = append(all, "audit-log")
+	}
+	if t.Permissions.Has(APIPermPathsDelete) {
+		all = append(all, "paths-delete")
+	}
+	return all
+}
+
+func (t APIToken) FormatPermissions() string {
+	return "'" + strings.Join(t.PermissionNames(), "', '") + "'"
 }
 
 // Defaults sets fields to default values, unless they're already set.
@@ -136,9 +191,82 @@ func (t *APIToken) Validate(ctx context.Context) error {
 	if t.Permissions == 1 {
 		v.Append("permissions", "must set at least one permission")
 	}
+
+	if t.ExpiresAt != nil && !t.ExpiresAt.After(t.CreatedAt) {
+		v.Append("expires_at", "must be after the creation date")
+	}
+	for _, ip := range t.AllowedIPs {
+		if _, err := parseCIDR(ip); err != nil {
+			v.Append("allowed_ips", fmt.Sprintf("invalid IP or CIDR %q", ip))
+		}
+	}
+	if len(t.Sites) > 0 {
+		var n int
+		err := zdb.Get(ctx, &n, `select count(*) from sites where site_id in (?) and (site_id=? or parent=?)`,
+			[]int64(t.Sites), MustGetSite(ctx).IDOrParent(), MustGetSite(ctx).IDOrParent())
+		if err != nil {
+			return errors.Wrap(err, "APIToken.Validate")
+		}
+		if n != len(slices.Compact(slices.Sorted(slices.Values(t.Sites)))) {
+			v.Append("sites", "unknown site")
+		}
+	}
 	return v.ErrorOrNil()
 }
 
+// Expired reports if this token is expired.
+func (t APIToken) Expired() bool {
+	return t.ExpiresAt != nil && !t.ExpiresAt.After(ztime.Now())
+}
+
+// PreviousValid reports if the previous secret of a rotated token is still
+// valid.
+func (t APIToken) PreviousValid() bool {
+	return t.PreviousToken != nil && t.PreviousExpiresAt != nil && t.PreviousExpiresAt.After(ztime.Now())
+}
+
+// AllowSite reports if this token can be used for the site.
+func (t APIToken) AllowSite(siteID int64) bool {
+	return len(t.Sites) == 0 || slices.Contains(t.Sites, siteID)
+}
+
+// AllowIP reports if this token can be used from the IP address; the address
+// may include a port.
+func (t APIToken) AllowIP(ip string) bool {
+	if len(t.AllowedIPs) == 0 {
+		return true
+	}
+	addrPort, err := netip.ParseAddrPort(ip)
+	addr := addrPort.Addr()
+	if err != nil {
+		addr, err = netip.ParseAddr(ip)
+		if err != nil {
+			return false
+		}
+	}
+	addr = addr.Unmap()
+	for _, a := range t.AllowedIPs {
+		p, err := parseCIDR(a)
+		if err == nil && p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCIDR parses a CIDR range or a single IP address.
+func parseCIDR(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return addr.Prefix(addr.BitLen())
+	}
+	p, err := netip.ParsePrefix(s)
+	return p.Masked(), err
+}
+
 // Insert a new row.
 func (t *APIToken) Insert(ctx context.Context) error {
 	if t.ID > 0 {
@@ -152,12 +280,12 @@ func (t *APIToken) Insert(ctx context.Context) error {
 	}
 
 	t.ID, err = zdb.InsertID(ctx, "api_token_id",
-		`insert into api_tokens (site_id, user_id, name, token, permissions, created_at) values (?)`,
-		[]any{t.SiteID, GetUser(ctx).ID, t.Name, t.Token, t.Permissions, t.CreatedAt})
+		`insert into api_tokens (site_id, user_id, name, token, permissions, expires_at, sites, allowed_ips, created_at) values (?)`,
+		[]any{t.SiteID, GetUser(ctx).ID, t.Name, t.Token, t.Permissions, t.ExpiresAt, t.Sites, t.AllowedIPs, t.CreatedAt})
 	return errors.Wrap(err, "APIToken.Insert")
 }
 
-// Update the name and permissions.
+// Update the name, permissions, expiry, and restrictions.
 func (t *APIToken) Update(ctx context.Context) error {
 	if t.ID == 0 {
 		return errors.New("ID == 0")
@@ -169,11 +297,30 @@ func (t *APIToken) Update(ctx context.Context) error {
 		return err
 	}
 
-	err = zdb.Exec(ctx, `update api_tokens set name=?, permissions=? where api_token_id=?`,
-		t.Name, t.Permissions, t.ID)
+	err = zdb.Exec(ctx, `update api_tokens set
+			name=?, permissions=?, expires_at=?, sites=?, allowed_ips=?
+			where api_token_id=?`,
+		t.Name, t.Permissions, t.ExpiresAt, t.Sites, t.AllowedIPs, t.ID)
 	return errors.Wrap(err, "APIToken.Update")
 }
 
+// Rotate generates a new secret for this token; the current secret will
+// remain valid for the grace period.
+func (t *APIToken) Rotate(ctx context.Context, grace time.Duration) error {
+	if t.ID == 0 {
+		return errors.New("ID == 0")
+	}
+
+	t.PreviousToken = ztype.Ptr(t.Token)
+	t.PreviousExpiresAt = ztype.Ptr(ztime.Now().Add(grace))
+	t.Token = zcrypto.Secret256()
+	err := zdb.Exec(ctx, `update api_tokens set
+			token=?, previous_token=?, previous_expires_at=?
+			where api_token_id=?`,
+		t.Token, t.PreviousToken, t.PreviousExpiresAt, t.ID)
+	return errors.Wrap(err, "APIToken.Rotate")
+}
+
 // UpdateLastUsed sets the last used time to the current time.
 func (t *APIToken) UpdateLastUsed(ctx context.Context) error {
 	if t.ID == 0 {
@@ -198,10 +345,13 @@ func (t *APIToken) ByID(ctx context.Context, id int64) error {
 		id, MustGetSite(ctx).ID), "APIToken.ByID %d", id)
 }
 
+// ByToken gets a token by the secret; the previous secret of a rotated token
+// is also accepted until the grace period expires.
 func (t *APIToken) ByToken(ctx context.Context, token string) error {
-	return errors.Wrap(zdb.Get(ctx, t,
-		`/* APIToken.ByID */ select * from api_tokens where token=$1 and site_id=$2`,
-		token, MustGetSite(ctx).ID), "APIToken.ByToken")
+	return errors.Wrap(zdb.Get(ctx, t, `/* APIToken.ByToken */
+		select * from api_tokens where site_id=? and
+			(token=? or (previous_token=? and previous_expires_at > ?))`,
+		MustGetSite(ctx).ID, token, token, ztime.Now()), "APIToken.ByToken")
 }
 
 func (t *APIToken) Delete(ctx context.Context) error {
@@ -0,0 +1,226 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"time"
+
+	"zgo.at/errors"
+	"zgo.at/json"
+	"zgo.at/zdb"
+	"zgo.at/zstd/ztime"
+)
+
+// Audit log actions.
+const (
+	AuditSiteSettings     = "site.settings"
+	AuditSiteCode         = "site.code"
+	AuditSiteCreate       = "site.create"
+	AuditSiteRestore      = "site.restore"
+	AuditSiteDelete       = "site.delete"
+	AuditSiteCopySettings = "site.copy-settings"
+	AuditPathUpdate       = "path.update"
+	AuditPathsPurge       = "paths.purge"
+	AuditPathsMerge       = "paths.merge"
+	AuditPathsRewrite     = "paths.rewrite"
+	AuditCampaignUpdate   = "campaign.update"
+	AuditCampaignsMerge   = "campaigns.merge"
+	AuditImport           = "import"
+	AuditUserCreate       = "user.create"
+	AuditUserUpdate       = "user.update"
+	AuditUserDelete       = "user.delete"
+	AuditUserPassword     = "user.password"
+	AuditUserTOTP         = "user.totp"
+	AuditUserLogout       = "user.logout"
+	AuditUserUnlock       = "user.unlock"
+	AuditAPITokenCreate   = "apitoken.create"
+	AuditAPITokenDelete   = "apitoken.delete"
+	AuditAPITokenRotate   = "apitoken.rotate"
+	AuditAccountDelete    = "account.delete"
+)
+
+// AuditActions is a list of all audit log actions.
+var AuditActions = []string{
+	AuditSiteSettings, AuditSiteCode, AuditSiteCreate, AuditSiteRestore,
+	AuditSiteDelete, AuditSiteCopySettings, AuditPathUpdate, AuditPathsPurge,
+	AuditPathsMerge, AuditPathsRewrite, AuditCampaignUpdate,
+	AuditCampaignsMerge, AuditImport, AuditUserCreate, AuditUserUpdate,
+	AuditUserDelete, AuditUserPassword, AuditUserTOTP, AuditUserLogout,
+	AuditUserUnlock, AuditAPITokenCreate, AuditAPITokenDelete,
+	AuditAPITokenRotate, AuditAccountDelete,
+}
+
+// AuditLog is an entry in the audit log, which records administrative actions
+// such as changing settings or adding users.
+//
+// The audit log is append-only: entries are never updated or deleted.
+type AuditLog struct {
+	ID        int64 `db:"audit_log_id" json:"id,readonly"`
+	AccountID int64 `db:"account_id" json:"-"`
+
+	// Site this action applies to.
+	SiteID int64 `db:"site_id" json:"site_id,readonly"`
+
+	// User who performed this action, and their email at the time. The user
+	// ID may refer to a user that no longer exists.
+	UserID *int64 `db:"user_id" json:"user_id,readonly"`
+	Actor  string `db:"actor" json:"actor,readonly"`
+
+	// API token used to perform this action; this is null if it was done
+	// from the web interface.
+	APITokenID *int64 `db:"api_token_id" json:"api_token_id,readonly"`
+
+	// Action that was performed; for example "site.settings" or
+	// "user.create".
+	Action string `db:"action" json:"action,readonly"`
+
+	// Fields that were changed.
+	Diff AuditDiff `db:"diff" json:"diff,readonly"`
+
+	IP        string    `db:"ip" json:"ip,readonly"`
+	CreatedAt time.Time `db:"created_at" json:"created_at,readonly"`
+}
+
+// Insert a new audit log entry.
+//
+// The AccountID, SiteID, UserID, and Actor are set from the context if not
+// set.
+func (a *AuditLog) Insert(ctx context.Context) error {
+	if a.ID > 0 {
+		return errors.New("AuditLog.Insert: ID > 0")
+	}
+
+	if s := GetSite(ctx); s != nil {
+		if a.SiteID == 0 {
+			a.SiteID = s.ID
+		}
+		if a.AccountID == 0 {
+			a.AccountID = s.IDOrParent()
+		}
+	}
+	if u := GetUser(ctx); u != nil && u.ID > 0 && a.UserID == nil {
+		a.UserID = &u.ID
+		a.Actor = u.Email
+	}
+	if a.Diff == nil {
+		a.Diff = make(AuditDiff)
+	}
+	a.CreatedAt = ztime.Now()
+
+	var err error
+	a.ID, err = zdb.InsertID(ctx, "audit_log_id",
+		`insert into audit_log (account_id, site_id, user_id, actor, api_token_id, action, diff, ip, created_at)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		a.AccountID, a.SiteID, a.UserID, a.Actor, a.APITokenID, a.Action, a.Diff, a.IP, a.CreatedAt)
+	return errors.Wrap(err, "AuditLog.Insert")
+}
+
+type AuditLogs []AuditLog
+
+// AuditLogFilter filters the audit log; zero values are ignored.
+type AuditLogFilter struct {
+	SiteID int64
+	UserID int64
+	Action string
+}
+
+// List audit log entries for this account, newest first.
+//
+// Only entries before the given ID are selected if before is >0. Returns true
+// if there are more entries.
+func (a *AuditLogs) List(ctx context.Context, filter AuditLogFilter, before int64, limit int) (bool, error) {
+	err := zdb.Select(ctx, a, "load:audit_log.List", map[string]any{
+		"account": MustGetAccount(ctx).ID,
+		"site":    filter.SiteID,
+		"user":    filter.UserID,
+		"action":  filter.Action,
+		"before":  before,
+		"limit":   limit + 1,
+	})
+	if err != nil {
+		return false, errors.Wrap(err, "AuditLogs.List")
+	}
+
+	more := len(*a) > limit
+	if more {
+		aa := *a
+		aa = aa[:len(aa)-1]
+		*a = aa
+	}
+	return more, nil
+}
+
+// AuditDiff records changed fields, as "field": [before, after].
+//
+// Nested fields are joined with a ".", for example "settings.public".
+type AuditDiff map[string][2]any
+
+// NewAuditDiff creates a diff of all fields that differ between before and
+// after, as they would be encoded to JSON. Either may be nil.
+func NewAuditDiff(before, after any) AuditDiff {
+	d := make(AuditDiff)
+	d.add("", auditFlatten(before), auditFlatten(after))
+	return d
+}
+
+func (d AuditDiff) add(prefix string, before, after any) {
+	bm, bok := before.(map[string]any)
+	am, aok := after.(map[string]any)
+	// Record added or removed objects as-is, rather than every field.
+	if (!bok && !aok) || (prefix != "" && (before == nil || after == nil)) {
+		if !reflect.DeepEqual(before, after) {
+			d[prefix] = [2]any{before, after}
+		}
+		return
+	}
+
+	keys := make(map[string]struct{}, len(bm)+len(am))
+	for k := range bm {
+		keys[k] = struct{}{}
+	}
+	for k := range am {
+		keys[k] = struct{}{}
+	}
+	for k := range keys {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		d.add(key, bm[k], am[k])
+	}
+}
+
+func auditFlatten(v any) any {
+	if v == nil {
+		return nil
+	}
+	j, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var r any
+	if err := json.Unmarshal(j, &r); err != nil {
+		return nil
+	}
+	return r
+}
+
+// Value implements the SQL Value function to determine what to store in the DB.
+func (d AuditDiff) Value() (driver.Value, error) { return json.Marshal(d) }
+
+// Scan converts the data returned from the DB into the struct.
+func (d *AuditDiff) Scan(v any) error {
+	switch vv := v.(type) {
+	case []byte:
+		return json.Unmarshal(vv, d)
+	case string:
+		return json.Unmarshal([]byte(vv), d)
+	default:
+		return errors.Errorf("AuditDiff.Scan: unsupported type: %T", v)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter_test
+
+import (
+	"testing"
+
+	"zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zstd/zjson"
+	"zgo.at/zstd/ztest"
+)
+
+func TestNewAuditDiff(t *testing.T) {
+	tests := []struct {
+		before, after any
+		want          string
+	}{
+		{nil, nil, `{}`},
+		{map[string]any{"a": 1}, map[string]any{"a": 1}, `{}`},
+		{map[string]any{"a": 1}, map[string]any{"a": 2}, `{"a": [1, 2]}`},
+		{nil, map[string]any{"a": 1}, `{"a": [null, 1]}`},
+		{map[string]any{"a": 1}, nil, `{"a": [1, null]}`},
+		{
+			map[string]any{"s": map[string]any{"x": true, "y": "q"}},
+			map[string]any{"s": map[string]any{"x": false, "y": "q"}},
+			`{"s.x": [true, false]}`,
+		},
+		{
+			map[string]any{"s": map[string]any{"x": true}},
+			nil,
+			`{"s": [{"x": true}, null]}`,
+		},
+		{
+			goatcounter.UserAccesses{"all": "r"},
+			goatcounter.UserAccesses{"all": "r", "2": "-"},
+			`{"2": [null, "-"]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			have := zjson.MustMarshalString(goatcounter.NewAuditDiff(tt.before, tt.after))
+			if d := ztest.Diff(have, tt.want, ztest.DiffJSON); d != "" {
+				t.Error(d)
+			}
+		})
+	}
+}
+
+func TestAuditLogList(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	for _, a := range []string{goatcounter.AuditSiteSettings, goatcounter.AuditUserCreate, goatcounter.AuditSiteSettings} {
+		l := goatcounter.AuditLog{Action: a}
+		err := l.Insert(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var l goatcounter.AuditLogs
+	more, err := l.List(ctx, goatcounter.AuditLogFilter{Action: goatcounter.AuditSiteSettings}, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !more || len(l) != 1 || l[0].ID != 3 {
+		t.Fatalf("more=%t; %v", more, l)
+	}
+
+	l = nil
+	more, err = l.List(ctx, goatcounter.AuditLogFilter{Action: goatcounter.AuditSiteSettings}, 3, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if more || len(l) != 1 || l[0].ID != 1 {
+		t.Fatalf("more=%t; %v", more, l)
+	}
+}
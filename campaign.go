@@ -6,16 +6,86 @@ package goatcounter
 
 import (
 	"context"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 
 	"zgo.at/errors"
+	"zgo.at/guru"
 	"zgo.at/zdb"
+	"zgo.at/zstd/zbool"
+	"zgo.at/zstd/ztype"
 	"zgo.at/zvalidate"
 )
 
 type Campaign struct {
-	ID     int64  `db:"campaign_id" json:"campaign_id"`
-	SiteID int64  `db:"site_id" json:"site_id"`
-	Name   string `db:"name" json:"name"`
+	ID     int64      `db:"campaign_id" json:"campaign_id"`
+	SiteID int64      `db:"site_id" json:"site_id"`
+	Name   string     `db:"name" json:"name"`
+	Hidden zbool.Bool `db:"hidden" json:"hidden"` // Hidden from the dashboard?
+
+	// Number of visitors; only set by Campaigns.List().
+	Visitors int `db:"visitors" json:"visitors,readonly"`
+}
+
+type Campaigns []Campaign
+
+// UTM are the campaign parameters from the query string besides the campaign
+// name and source; the source is stored as the referrer.
+type UTM struct {
+	Medium  string // utm_medium
+	Content string // utm_content
+	Term    string // utm_term
+}
+
+// CampaignKey identifies a campaign, the source and medium within a campaign,
+// or the content within that. This is used as the HitStat.ID when listing
+// campaign stats.
+//
+// The text form is the campaign ID followed by the other fields as a query
+// string, for example "1?medium=email&source=newsletter".
+type CampaignKey struct {
+	Campaign int64
+	Source   *string
+	Medium   *string
+	Content  *string
+}
+
+// ParseCampaignKey parses the text form of a CampaignKey.
+func ParseCampaignKey(s string) (CampaignKey, error) {
+	id, q, _ := strings.Cut(s, "?")
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return CampaignKey{}, errors.Wrap(err, "ParseCampaignKey")
+	}
+	vals, err := url.ParseQuery(q)
+	if err != nil {
+		return CampaignKey{}, errors.Wrap(err, "ParseCampaignKey")
+	}
+
+	k := CampaignKey{Campaign: n}
+	if vals.Has("source") || vals.Has("medium") {
+		src, med := vals.Get("source"), vals.Get("medium")
+		k.Source, k.Medium = &src, &med
+		if vals.Has("content") {
+			c := vals.Get("content")
+			k.Content = &c
+		}
+	}
+	return k, nil
+}
+
+func (k CampaignKey) String() string {
+	s := strconv.FormatInt(k.Campaign, 10)
+	if k.Source == nil {
+		return s
+	}
+	vals := url.Values{"source": {*k.Source}, "medium": {ztype.Deref(k.Medium, "")}}
+	if k.Content != nil {
+		vals.Set("content", *k.Content)
+	}
+	return s + "?" + vals.Encode()
 }
 
 func (c *Campaign) Defaults(ctx context.Context) {}
@@ -46,14 +116,21 @@ func (c *Campaign) Insert(ctx context.Context) error {
 }
 
 func (c *Campaign) ByName(ctx context.Context, name string) error {
-	k := c.Name
+	site := MustGetSite(ctx)
+	k := strconv.FormatInt(site.ID, 10) + "\x00c" + name
+	if !site.Settings.CampaignMatchCase {
+		k = strconv.FormatInt(site.ID, 10) + "\x00i" + strings.ToLower(name)
+	}
 	if cc, ok := cacheCampaigns(ctx).Get(k); ok {
 		*c = *cc.(*Campaign)
 		return nil
 	}
 
-	err := zdb.Get(ctx, c, `select * from campaigns where site_id=? and lower(name)=lower(?)`,
-		MustGetSite(ctx).ID, name)
+	query := `/* Campaign.ByName */ select * from campaigns where site_id=? and lower(name)=lower(?) order by campaign_id limit 1`
+	if site.Settings.CampaignMatchCase {
+		query = `/* Campaign.ByName */ select * from campaigns where site_id=? and name=? order by campaign_id limit 1`
+	}
+	err := zdb.Get(ctx, c, query, site.ID, name)
 	if err != nil {
 		return errors.Wrap(err, "Campaign.ByName")
 	}
@@ -61,3 +138,105 @@ func (c *Campaign) ByName(ctx context.Context, name string) error {
 	cacheCampaigns(ctx).SetDefault(k, c)
 	return nil
 }
+
+func (c *Campaign) ByID(ctx context.Context, id int64) error {
+	return errors.Wrapf(zdb.Get(ctx, c,
+		`/* Campaign.ByID */ select * from campaigns where campaign_id=? and site_id=?`,
+		id, MustGetSite(ctx).ID), "Campaign.ByID %d", id)
+}
+
+// Update the campaign name and hidden flag.
+func (c *Campaign) Update(ctx context.Context) error {
+	if c.ID == 0 {
+		return errors.New("ID == 0")
+	}
+
+	c.Defaults(ctx)
+	err := c.Validate()
+	if err != nil {
+		return err
+	}
+
+	var exists Campaign
+	err = exists.ByName(ctx, c.Name)
+	if err != nil && !zdb.ErrNoRows(err) {
+		return errors.Wrap(err, "Campaign.Update")
+	}
+	if err == nil && exists.ID != c.ID {
+		return guru.Errorf(400, "campaign %q already exists; merge the campaigns instead", c.Name)
+	}
+
+	err = zdb.Exec(ctx, `update campaigns set name=?, hidden=? where campaign_id=? and site_id=?`,
+		c.Name, c.Hidden, c.ID, MustGetSite(ctx).ID)
+	if err != nil {
+		return errors.Wrap(err, "Campaign.Update")
+	}
+
+	cacheCampaigns(ctx).Flush()
+	return nil
+}
+
+// List all campaigns for this site, ordered by name.
+func (c *Campaigns) List(ctx context.Context) error {
+	return errors.Wrap(zdb.Select(ctx, c, `/* Campaigns.List */
+		select
+			campaigns.*,
+			coalesce((
+				select sum(count) from campaign_stats
+				where campaign_stats.site_id = campaigns.site_id and campaign_stats.campaign_id = campaigns.campaign_id
+			), 0) as visitors
+		from campaigns
+		where site_id = ?
+		order by lower(name), campaign_id`,
+		MustGetSite(ctx).ID), "Campaigns.List")
+}
+
+// Merge the campaigns in to dst.
+//
+// All stats are moved to dst, and the campaigns are deleted.
+func (c *Campaigns) Merge(ctx context.Context, dst int64, campaignIDs []int64) error {
+	campaignIDs = slices.DeleteFunc(campaignIDs, func(id int64) bool { return id == dst })
+	if len(campaignIDs) == 0 {
+		return nil
+	}
+
+	err := (&Campaign{}).ByID(ctx, dst) // Ensure this site owns the campaign.
+	if err != nil {
+		return errors.Wrap(err, "Campaigns.Merge")
+	}
+
+	site := MustGetSite(ctx).ID
+	err = zdb.TX(ctx, func(ctx context.Context) error {
+		conflict := `on conflict(site_id, path_id, campaign_id, ref, medium, content, term, hour)`
+		if zdb.SQLDialect(ctx) == zdb.DialectPostgreSQL {
+			conflict = `on conflict on constraint "campaign_stats#site_id#path_id#campaign_id#ref#utm#hour"`
+		}
+		err := zdb.Exec(ctx, `/* Campaigns.Merge */
+			insert into campaign_stats (site_id, path_id, hour, campaign_id, ref, medium, content, term, count)
+				select site_id, path_id, hour, :dst, ref, medium, content, term, sum(count)
+				from campaign_stats
+				where site_id = :site and campaign_id in (:ids)
+				group by site_id, path_id, hour, ref, medium, content, term
+			`+conflict+` do update set count = campaign_stats.count + excluded.count`,
+			map[string]any{"site": site, "dst": dst, "ids": campaignIDs})
+		if err != nil {
+			return err
+		}
+
+		err = zdb.Exec(ctx, `delete from campaign_stats where site_id=? and campaign_id in (?)`, site, campaignIDs)
+		if err != nil {
+			return err
+		}
+		err = zdb.Exec(ctx, `update hits set campaign=? where site_id=? and campaign in (?)`, dst, site, campaignIDs)
+		if err != nil {
+			return err
+		}
+		return zdb.Exec(ctx, `delete from campaigns where site_id=? and campaign_id in (?)`, site, campaignIDs)
+	})
+	if err != nil {
+		return errors.Wrap(err, "Campaigns.Merge")
+	}
+
+	cacheCampaigns(ctx).Flush()
+	return nil
+}
@@ -0,0 +1,42 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter_test
+
+import (
+	"testing"
+
+	. "zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zdb"
+)
+
+func TestCampaignMatchCase(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	gctest.StoreHits(ctx, t, false,
+		Hit{Site: 1, Query: "utm_campaign=Newsletter"},
+		Hit{Site: 1, Query: "utm_campaign=newsletter"})
+
+	site := MustGetSite(ctx)
+	site.Settings.CampaignMatchCase = true
+	err := site.Update(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx = WithSite(ctx, site)
+
+	gctest.StoreHits(ctx, t, false,
+		Hit{Site: 1, Query: "utm_campaign=Newsletter"},
+		Hit{Site: 1, Query: "utm_campaign=newsletter"})
+
+	have := zdb.DumpString(ctx, `select campaign_id, name from campaigns order by campaign_id`)
+	want := `
+		campaign_id  name
+		1            Newsletter
+		2            newsletter`
+	if d := zdb.Diff(have, want); d != "" {
+		t.Error(d)
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter
+
+import (
+	"context"
+	"strings"
+
+	"zgo.at/z18n"
+)
+
+// Traffic channels.
+const (
+	ChannelDirect   = "direct"
+	ChannelSearch   = "search"
+	ChannelSocial   = "social"
+	ChannelEmail    = "email"
+	ChannelRSS      = "rss"
+	ChannelCampaign = "campaign"
+	ChannelAI       = "ai"
+	ChannelOther    = "other"
+)
+
+// Channels is a list of all channels.
+var Channels = []string{ChannelDirect, ChannelSearch, ChannelSocial, ChannelEmail,
+	ChannelRSS, ChannelCampaign, ChannelAI, ChannelOther}
+
+// ChannelName gets the translated display name for a channel.
+func ChannelName(ctx context.Context, channel string) string {
+	switch channel {
+	case ChannelDirect:
+		return z18n.T(ctx, "channel/direct|Direct")
+	case ChannelSearch:
+		return z18n.T(ctx, "channel/search|Search")
+	case ChannelSocial:
+		return z18n.T(ctx, "channel/social|Social")
+	case ChannelEmail:
+		return z18n.T(ctx, "channel/email|Email")
+	case ChannelRSS:
+		return z18n.T(ctx, "channel/rss|RSS")
+	case ChannelCampaign:
+		return z18n.T(ctx, "channel/campaign|Campaign")
+	case ChannelAI:
+		return z18n.T(ctx, "channel/ai|AI assistant")
+	default:
+		return z18n.T(ctx, "channel/other|Other")
+	}
+}
+
+// Channels for the built-in groups in ref.go.
+var channelGroups = map[string]string{
+	"Google":             ChannelSearch,
+	"Yahoo":              ChannelSearch,
+	"Baidu":              ChannelSearch,
+	"Email":              ChannelEmail,
+	"RSS":                ChannelRSS,
+	"Hacker News":        ChannelSocial,
+	"Telegram Messenger": ChannelSocial,
+	"Slack Chat":         ChannelSocial,
+}
+
+// Channels by host; this also matches subdomains.
+var channelHosts = map[string]string{
+	"bing.com":             ChannelSearch,
+	"duckduckgo.com":       ChannelSearch,
+	"ecosia.org":           ChannelSearch,
+	"kagi.com":             ChannelSearch,
+	"qwant.com":            ChannelSearch,
+	"search.brave.com":     ChannelSearch,
+	"startpage.com":        ChannelSearch,
+	"yandex.ru":            ChannelSearch,
+	"yandex.com":           ChannelSearch,
+	"naver.com":            ChannelSearch,
+	"seznam.cz":            ChannelSearch,
+	"search.yahoo.com":     ChannelSearch,
+	"presearch.com":        ChannelSearch,
+	"mojeek.com":           ChannelSearch,
+	"search.marginalia.nu": ChannelSearch,
+
+	"bsky.app":             ChannelSocial,
+	"facebook.com":         ChannelSocial,
+	"habr.com":             ChannelSocial,
+	"instagram.com":        ChannelSocial,
+	"linkedin.com":         ChannelSocial,
+	"lnkd.in":              ChannelSocial,
+	"lobste.rs":            ChannelSocial,
+	"mastodon.social":      ChannelSocial,
+	"news.ycombinator.com": ChannelSocial,
+	"pinterest.com":        ChannelSocial,
+	"reddit.com":           ChannelSocial,
+	"t.co":                 ChannelSocial,
+	"threads.net":          ChannelSocial,
+	"tiktok.com":           ChannelSocial,
+	"tumblr.com":           ChannelSocial,
+	"twitter.com":          ChannelSocial,
+	"vk.com":               ChannelSocial,
+	"weibo.com":            ChannelSocial,
+	"x.com":                ChannelSocial,
+	"youtube.com":          ChannelSocial,
+
+	"mail.google.com":       ChannelEmail,
+	"mail.yahoo.com":        ChannelEmail,
+	"mail.proton.me":        ChannelEmail,
+	"outlook.live.com":      ChannelEmail,
+	"outlook.office.com":    ChannelEmail,
+	"outlook.office365.com": ChannelEmail,
+	"mail.aol.com":          ChannelEmail,
+	"app.fastmail.com":      ChannelEmail,
+
+	"feedly.com":       ChannelRSS,
+	"inoreader.com":    ChannelRSS,
+	"newsblur.com":     ChannelRSS,
+	"theoldreader.com": ChannelRSS,
+	"feedbin.com":      ChannelRSS,
+	"usepanda.com":     ChannelRSS,
+
+	"chatgpt.com":           ChannelAI,
+	"chat.openai.com":       ChannelAI,
+	"claude.ai":             ChannelAI,
+	"perplexity.ai":         ChannelAI,
+	"gemini.google.com":     ChannelAI,
+	"copilot.microsoft.com": ChannelAI,
+	"chat.deepseek.com":     ChannelAI,
+	"chat.mistral.ai":       ChannelAI,
+	"you.com":               ChannelAI,
+	"phind.com":             ChannelAI,
+	"meta.ai":               ChannelAI,
+}
+
+// RefChannel gets the channel for a referrer as stored in the database.
+//
+// Grouped referrers (scheme RefSchemeGenerated) use the channel of the group,
+// which can be set with the groups rules. Pageviews with a campaign or a
+// referrer from the query parameters (scheme RefSchemeCampaign) are always in
+// the campaign channel.
+func RefChannel(ref string, scheme *string, hasCampaign bool, groups RefGroups) string {
+	if hasCampaign || (scheme != nil && *scheme == *RefSchemeCampaign) {
+		return ChannelCampaign
+	}
+	if ref == "" {
+		return ChannelDirect
+	}
+
+	if scheme != nil && *scheme == *RefSchemeGenerated {
+		if g := findRefGroupName(groups, ref); g != nil {
+			if g.Channel != "" {
+				return g.Channel
+			}
+			ref = g.Match
+		} else if c, ok := channelGroups[ref]; ok {
+			return c
+		}
+	}
+	if g := findRefGroup(groups, ref); g != nil && g.Channel != "" {
+		return g.Channel
+	}
+
+	host, _, _ := strings.Cut(ref, "/")
+	host = strings.ToLower(host)
+	if strings.HasPrefix(host, "www.google.") || strings.HasPrefix(host, "google.") {
+		return ChannelSearch
+	}
+	for h := host; h != ""; {
+		if c, ok := channelHosts[h]; ok {
+			return c
+		}
+		_, h, _ = strings.Cut(h, ".")
+	}
+	return ChannelOther
+}
@@ -0,0 +1,158 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter_test
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	. "zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zstd/ztype"
+)
+
+func TestParseRefGroups(t *testing.T) {
+	have, err := ParseRefGroups(`
+		# Comment
+		news.example.com   = Newsletter; Email
+		example.com/blog = Example blog
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := RefGroups{
+		{Match: "news.example.com", Name: "Newsletter", Channel: "email"},
+		{Match: "example.com/blog", Name: "Example blog"},
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("\nhave: %#v\nwant: %#v", have, want)
+	}
+
+	again, err := ParseRefGroups(have.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again, want) {
+		t.Errorf("round-trip\nhave: %#v\nwant: %#v", again, want)
+	}
+
+	_, err = ParseRefGroups("example.com")
+	if err == nil {
+		t.Error("no error for missing name")
+	}
+
+	err = RefGroups{
+		{Match: "", Name: "x"},
+		{Match: "https://example.com", Name: "x"},
+		{Match: "example.com", Name: "x", Channel: "carrier-pigeon"},
+	}.Validate(gctest.Context(nil))
+	if err == nil {
+		t.Fatal("no validation error")
+	}
+	for _, k := range []string{"1.match", "2.match", "3.channel"} {
+		if !strings.Contains(err.Error(), k) {
+			t.Errorf("no error for %s:\n%s", k, err)
+		}
+	}
+}
+
+func TestRefChannel(t *testing.T) {
+	groups := RefGroups{
+		{Match: "news.example.com", Name: "Newsletter", Channel: ChannelEmail},
+		{Match: "example.com/blog", Name: "Example blog"},
+		{Match: "kagi.com", Name: "Kagi"},
+	}
+
+	tests := []struct {
+		ref         string
+		scheme      *string
+		hasCampaign bool
+		want        string
+	}{
+		{"", nil, false, ChannelDirect},
+		{"", nil, true, ChannelCampaign},
+		{"example.org", nil, true, ChannelCampaign},
+		{"newsletter", RefSchemeCampaign, false, ChannelCampaign},
+
+		{"www.google.com/search", RefSchemeHTTP, false, ChannelSearch},
+		{"google.co.uk", RefSchemeHTTP, false, ChannelSearch},
+		{"Google", RefSchemeGenerated, false, ChannelSearch},
+		{"duckduckgo.com", RefSchemeHTTP, false, ChannelSearch},
+		{"old.reddit.com/r/golang", RefSchemeHTTP, false, ChannelSocial},
+		{"Email", RefSchemeGenerated, false, ChannelEmail},
+		{"feedly.com", RefSchemeHTTP, false, ChannelRSS},
+		{"chatgpt.com", RefSchemeHTTP, false, ChannelAI},
+		{"example.org", RefSchemeHTTP, false, ChannelOther},
+		{"com.example.android", RefSchemeOther, false, ChannelOther},
+
+		// Site groups.
+		{"Newsletter", RefSchemeGenerated, false, ChannelEmail},
+		{"news.example.com/x", RefSchemeHTTP, false, ChannelEmail},
+		{"Example blog", RefSchemeGenerated, false, ChannelOther},
+		{"Kagi", RefSchemeGenerated, false, ChannelSearch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			have := RefChannel(tt.ref, tt.scheme, tt.hasCampaign, groups)
+			if have != tt.want {
+				t.Errorf("have %q, want %q", have, tt.want)
+			}
+		})
+	}
+}
+
+func TestRefGroupsHit(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	tmp := filepath.Join(t.TempDir(), "groups")
+	err := os.WriteFile(tmp, []byte("example.net = Global\nexample.com = Overridden\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = LoadRefGroups(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		os.WriteFile(tmp, nil, 0o644)
+		LoadRefGroups(tmp)
+	}()
+
+	site := MustGetSite(ctx)
+	site.Settings.RefGroups = RefGroups{
+		{Match: "example.com/blog", Name: "Example blog"},
+		{Match: "example.com", Name: "Example"},
+	}
+	ctx = WithSite(ctx, site)
+
+	tests := []struct {
+		in, wantRef, wantScheme string
+	}{
+		{"https://example.com/blog/post?a=b", "Example blog", "g"},
+		{"https://www.example.com/other", "Example", "g"},
+		{"https://sub.example.net", "Global", "g"},
+		{"https://example.org/blog", "example.org/blog", "h"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			h := Hit{Ref: tt.in}
+			h.RefURL, _ = url.Parse(tt.in)
+			h.Defaults(ctx, false)
+
+			if h.Ref != tt.wantRef {
+				t.Errorf("wrong Ref\nhave: %q\nwant: %q", h.Ref, tt.wantRef)
+			}
+			if s := ztype.Deref(h.RefScheme, ""); s != tt.wantScheme {
+				t.Errorf("wrong RefScheme\nhave: %q\nwant: %q", s, tt.wantScheme)
+			}
+		})
+	}
+}
@@ -0,0 +1,500 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+// Package client is a client for the GoatCounter API.
+//
+// A new client is created with New(), which requires the URL of a GoatCounter
+// site and an API key:
+//
+//	c := client.New("https://stats.example.com", os.Getenv("GOATCOUNTER_API_KEY"))
+//	sites, err := c.Sites(ctx)
+//
+// Requests that are ratelimited are retried after waiting for the time in the
+// Retry-After header (or X-Rate-Limit-Reset, for older GoatCounter versions);
+// use the context to set a deadline.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"zgo.at/errors"
+	"zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/count"
+	"zgo.at/zstd/zint"
+	"zgo.at/zstd/zstring"
+)
+
+// MaxBatch is the maximum number of pageviews the API accepts in one request;
+// Count() splits larger batches in several requests.
+const MaxBatch = count.MaxHits
+
+// ErrExportRunning is returned by ExportDownload() if the export is still
+// being generated.
+var ErrExportRunning = errors.New("export is still being generated")
+
+// Client for the GoatCounter API.
+type Client struct {
+	url, key string
+
+	// HTTP client to use; this has a timeout of 15 seconds by default.
+	HTTP *http.Client
+
+	// Additional headers to send with every request.
+	Header http.Header
+
+	// Maximum number of times to retry a request on network errors; default
+	// is 5. Ratelimited requests are always retried.
+	MaxRetries int
+
+	// Called before waiting to retry a request; err is an *Error with
+	// StatusCode 429 for ratelimited requests. May be nil.
+	OnRetry func(err error, wait time.Duration)
+}
+
+// New creates a new API client for the site at siteURL; "https://" is added
+// if it has no scheme.
+func New(siteURL, key string) *Client {
+	siteURL = strings.TrimRight(siteURL, "/")
+	if !zstring.HasPrefixes(siteURL, "http://", "https://") {
+		siteURL = "https://" + siteURL
+	}
+	return &Client{
+		url:        siteURL,
+		key:        key,
+		HTTP:       &http.Client{Timeout: 15 * time.Second},
+		Header:     make(http.Header),
+		MaxRetries: 5,
+	}
+}
+
+// URL gets the site URL.
+func (c *Client) URL() string { return c.url }
+
+// Error is returned for any response with a status code of 400 or higher.
+type Error struct {
+	URL        string
+	StatusCode int
+	Status     string
+	Body       []byte
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.URL, e.Status,
+		zstring.ElideLeft(strings.TrimSpace(string(e.Body)), 200))
+}
+
+// Message gets the error message from the response body, if any.
+func (e *Error) Message() string {
+	var apiErr struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(e.Body, &apiErr) != nil {
+		return ""
+	}
+	return apiErr.Error
+}
+
+// CountError is returned by Count() if some pageviews could not be processed;
+// all other pageviews were processed successfully.
+type CountError = count.Error
+
+// Backoff time for network errors; this is a variable so tests can change it.
+var backoff = func(i int) time.Duration { return time.Duration(i*i) * time.Second }
+
+func retryAfter(resp *http.Response) time.Duration {
+	if ra := resp.Header.Get("Retry-After"); ra != "" {
+		if s, err := strconv.Atoi(ra); err == nil {
+			return time.Duration(s) * time.Second
+		}
+		if t, err := http.ParseTime(ra); err == nil {
+			return time.Until(t)
+		}
+	}
+	if s, err := strconv.Atoi(resp.Header.Get("X-Rate-Limit-Reset")); err == nil {
+		return time.Duration(s) * time.Second
+	}
+	return time.Second
+}
+
+func wait(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// Do a request; body is encoded as JSON if it's not nil. The caller is
+// responsible for closing the response body.
+//
+// An *Error is returned if the status code is 400 or higher.
+func (c *Client) Do(ctx context.Context, method, path string, query url.Values, body any) (*http.Response, error) {
+	var b []byte
+	if body != nil {
+		var err error
+		b, err = json.Marshal(body)
+		if err != nil {
+			return nil, errors.Wrap(err, "client.Do")
+		}
+	}
+
+	u := c.url + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	for i := 0; ; {
+		r, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(b))
+		if err != nil {
+			return nil, errors.Wrap(err, "client.Do")
+		}
+		for k, v := range c.Header {
+			r.Header[k] = v
+		}
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Authorization", "Bearer "+c.key)
+
+		resp, err := c.HTTP.Do(r)
+		if err != nil {
+			if ctx.Err() != nil || i >= c.MaxRetries {
+				return nil, err
+			}
+			i++
+			w := backoff(i)
+			if c.OnRetry != nil {
+				c.OnRetry(err, w)
+			}
+			if err := wait(ctx, w); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if resp.StatusCode < 400 {
+			return resp, nil
+		}
+
+		rb, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		apiErr := &Error{URL: u, StatusCode: resp.StatusCode, Status: resp.Status, Body: rb}
+		if resp.StatusCode != http.StatusTooManyRequests {
+			return nil, apiErr
+		}
+
+		w := retryAfter(resp)
+		if c.OnRetry != nil {
+			c.OnRetry(apiErr, w)
+		}
+		if err := wait(ctx, w); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Do a request and decode the JSON response in to scanTo.
+func (c *Client) doJSON(ctx context.Context, method, path string, query url.Values, body, scanTo any) error {
+	resp, err := c.Do(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return errors.Wrap(json.NewDecoder(resp.Body).Decode(scanTo), path)
+}
+
+// Me gets the current user and API token.
+func (c *Client) Me(ctx context.Context) (goatcounter.User, goatcounter.APIToken, error) {
+	var me struct {
+		User  goatcounter.User     `json:"user"`
+		Token goatcounter.APIToken `json:"token"`
+	}
+	err := c.doJSON(ctx, "GET", "/api/v0/me", nil, nil, &me)
+	return me.User, me.Token, err
+}
+
+// CheckPermissions checks that the API token has all the given permissions.
+func (c *Client) CheckPermissions(ctx context.Context, perms ...zint.Bitflag64) error {
+	_, token, err := c.Me(ctx)
+	if err != nil {
+		return err
+	}
+	var missing zint.Bitflag64
+	for _, p := range perms {
+		if !token.Permissions.Has(p) {
+			missing |= p
+		}
+	}
+	if missing != 0 {
+		return fmt.Errorf("API token %q is missing the required permissions: %s",
+			token.Name, goatcounter.APIToken{Permissions: missing}.FormatPermissions())
+	}
+	return nil
+}
+
+// Count pageviews.
+//
+// This sends the pageviews in batches of MaxBatch. A *CountError is returned
+// if some pageviews could not be processed; the indexes in it refer to
+// req.Hits. Other errors stop sending further batches.
+func (c *Client) Count(ctx context.Context, req count.Request) error {
+	var (
+		hits  = req.Hits
+		cErr  = &CountError{Errors: make(map[int]string)}
+		batch = req
+	)
+	for start := 0; start < len(hits); start += MaxBatch {
+		batch.Hits = hits[start:min(start+MaxBatch, len(hits))]
+
+		resp, err := c.Do(ctx, "POST", "/api/v0/count", nil, batch)
+		if err != nil {
+			var apiErr *Error
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != 400 {
+				return err
+			}
+			var errs struct {
+				Errors map[int]string `json:"errors"`
+			}
+			if json.Unmarshal(apiErr.Body, &errs) != nil || len(errs.Errors) == 0 {
+				return err
+			}
+			for i, e := range errs.Errors {
+				cErr.Errors[start+i] = e
+			}
+			continue
+		}
+		resp.Body.Close()
+	}
+
+	if len(cErr.Errors) > 0 {
+		return cErr
+	}
+	return nil
+}
+
+// Sites lists all sites the API token has access to.
+func (c *Client) Sites(ctx context.Context) (goatcounter.Sites, error) {
+	var sites struct {
+		Sites goatcounter.Sites `json:"sites"`
+	}
+	err := c.doJSON(ctx, "GET", "/api/v0/sites", nil, nil, &sites)
+	return sites.Sites, err
+}
+
+// Site gets a site by ID.
+func (c *Client) Site(ctx context.Context, id int64) (goatcounter.Site, error) {
+	var site goatcounter.Site
+	err := c.doJSON(ctx, "GET", "/api/v0/sites/"+strconv.FormatInt(id, 10), nil, nil, &site)
+	return site, err
+}
+
+// Paths gets all paths for this site, fetching all pages.
+func (c *Client) Paths(ctx context.Context) (goatcounter.Paths, error) {
+	var (
+		all   goatcounter.Paths
+		after int64
+	)
+	for {
+		var paths struct {
+			Paths goatcounter.Paths `json:"paths"`
+			More  bool              `json:"more"`
+		}
+		err := c.doJSON(ctx, "GET", "/api/v0/paths", url.Values{
+			"limit": {"200"},
+			"after": {strconv.FormatInt(after, 10)},
+		}, nil, &paths)
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, paths.Paths...)
+		if !paths.More || len(paths.Paths) == 0 {
+			return all, nil
+		}
+		after = paths.Paths[len(paths.Paths)-1].ID
+	}
+}
+
+// Export starts a new export in the background, starting from the given hit ID.
+func (c *Client) Export(ctx context.Context, startFromHitID int64) (goatcounter.Export, error) {
+	var export goatcounter.Export
+	err := c.doJSON(ctx, "POST", "/api/v0/export", nil,
+		map[string]int64{"start_from_hit_id": startFromHitID}, &export)
+	return export, err
+}
+
+// ExportGet gets details about an export.
+func (c *Client) ExportGet(ctx context.Context, id int64) (goatcounter.Export, error) {
+	var export goatcounter.Export
+	err := c.doJSON(ctx, "GET", "/api/v0/export/"+strconv.FormatInt(id, 10), nil, nil, &export)
+	return export, err
+}
+
+// ExportDownload downloads an export as a gzipped CSV file. The caller is
+// responsible for closing the returned reader.
+//
+// ErrExportRunning is returned if the export is still being generated.
+func (c *Client) ExportDownload(ctx context.Context, id int64) (io.ReadCloser, error) {
+	resp, err := c.Do(ctx, "GET", "/api/v0/export/"+strconv.FormatInt(id, 10)+"/download", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusAccepted {
+		resp.Body.Close()
+		return nil, ErrExportRunning
+	}
+	return resp.Body, nil
+}
+
+// StatsOptions are options for the stats endpoints; zero values are left to
+// the server default.
+type StatsOptions struct {
+	Start, End   time.Time
+	IncludePaths []int64
+	Limit        int
+	Offset       int
+}
+
+func (o StatsOptions) query() url.Values {
+	q := make(url.Values)
+	if !o.Start.IsZero() {
+		q.Set("start", o.Start.UTC().Format(time.RFC3339))
+	}
+	if !o.End.IsZero() {
+		q.Set("end", o.End.UTC().Format(time.RFC3339))
+	}
+	if len(o.IncludePaths) > 0 {
+		q.Set("include_paths", zint.Join(o.IncludePaths, ","))
+	}
+	if o.Limit > 0 {
+		q.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if o.Offset > 0 {
+		q.Set("offset", strconv.Itoa(o.Offset))
+	}
+	return q
+}
+
+// Total gets the total number of pageviews; only Start, End, and
+// IncludePaths are used.
+func (c *Client) Total(ctx context.Context, opt StatsOptions) (goatcounter.TotalCount, error) {
+	opt.Limit, opt.Offset = 0, 0
+	var tc goatcounter.TotalCount
+	err := c.doJSON(ctx, "GET", "/api/v0/stats/total", opt.query(), nil, &tc)
+	return tc, err
+}
+
+// HitsResponse is the response for Hits().
+type HitsResponse struct {
+	Hits  goatcounter.HitLists  `json:"hits"`
+	Tree  goatcounter.PathNodes `json:"tree"`
+	Total int                   `json:"total"`
+	More  bool                  `json:"more"`
+}
+
+// Hits gets an overview of pageviews, grouped by hour, day, week, or month;
+// Offset is not used, use exclude to paginate.
+func (c *Client) Hits(ctx context.Context, opt StatsOptions, group goatcounter.Group, exclude ...int64) (HitsResponse, error) {
+	q := opt.query()
+	q.Del("offset")
+	if group != goatcounter.GroupHourly {
+		q.Set("group", group.String())
+	}
+	if len(exclude) > 0 {
+		q.Set("exclude_paths", zint.Join(exclude, ","))
+	}
+	var hits HitsResponse
+	err := c.doJSON(ctx, "GET", "/api/v0/stats/hits", q, nil, &hits)
+	return hits, err
+}
+
+// HitsTree gets the directories and paths directly below prefix, with the
+// pageviews and visitors of all paths in a directory added together; Offset is
+// not used.
+func (c *Client) HitsTree(ctx context.Context, opt StatsOptions, prefix string) (HitsResponse, error) {
+	q := opt.query()
+	q.Del("offset")
+	q.Set("group_by_prefix", prefix)
+	var hits HitsResponse
+	err := c.doJSON(ctx, "GET", "/api/v0/stats/hits", q, nil, &hits)
+	return hits, err
+}
+
+// StatsResponse is the response for Stats() and StatsDetail().
+type StatsResponse struct {
+	Stats []goatcounter.HitStat `json:"stats"`
+	More  bool                  `json:"more"`
+}
+
+// Stats gets browser, system, etc. stats; page is one of browsers, systems,
+// locations, languages, sizes, campaigns, channels, or toprefs.
+func (c *Client) Stats(ctx context.Context, page string, opt StatsOptions) (StatsResponse, error) {
+	var stats StatsResponse
+	err := c.doJSON(ctx, "GET", "/api/v0/stats/"+url.PathEscape(page), opt.query(), nil, &stats)
+	return stats, err
+}
+
+// StatsAll gets all stats for page, fetching all pages.
+func (c *Client) StatsAll(ctx context.Context, page string, opt StatsOptions) ([]goatcounter.HitStat, error) {
+	var all []goatcounter.HitStat
+	for {
+		stats, err := c.Stats(ctx, page, opt)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, stats.Stats...)
+		if !stats.More || len(stats.Stats) == 0 {
+			return all, nil
+		}
+		opt.Offset += len(stats.Stats)
+	}
+}
+
+// Groups gets the number of visitors for all content groups; only Start, End,
+// and IncludePaths are used.
+func (c *Client) Groups(ctx context.Context, opt StatsOptions) (goatcounter.PathGroupStats, error) {
+	opt.Limit, opt.Offset = 0, 0
+	var resp struct {
+		Groups goatcounter.PathGroupStats `json:"groups"`
+	}
+	err := c.doJSON(ctx, "GET", "/api/v0/stats/groups", opt.query(), nil, &resp)
+	return resp.Groups, err
+}
+
+// StatsDetail gets detailed stats for an ID from Stats(), such as all versions
+// of a browser. For campaigns the IDs from StatsDetail() can be used again to
+// get the utm_content and utm_term.
+func (c *Client) StatsDetail(ctx context.Context, page, id string, opt StatsOptions) (StatsResponse, error) {
+	var stats StatsResponse
+	err := c.doJSON(ctx, "GET", "/api/v0/stats/"+url.PathEscape(page)+"/"+url.PathEscape(id),
+		opt.query(), nil, &stats)
+	return stats, err
+}
+
+// StatsSeries gets the number of visitors per day, week, or month for the top
+// opt.Limit items of page; page is one of browsers, systems, locations,
+// languages, sizes, campaigns, channels, or groups. Group is "day", "week", or
+// "month", and Offset is not used.
+func (c *Client) StatsSeries(ctx context.Context, page, group string, opt StatsOptions) ([]goatcounter.HitStatSeries, error) {
+	opt.Offset = 0
+	q := opt.query()
+	if group != "" {
+		q.Set("group", group)
+	}
+	var resp struct {
+		Series []goatcounter.HitStatSeries `json:"series"`
+	}
+	err := c.doJSON(ctx, "GET", "/api/v0/stats/series/"+url.PathEscape(page), q, nil, &resp)
+	return resp.Series, err
+}
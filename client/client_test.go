@@ -0,0 +1,309 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/count"
+)
+
+func newTest(t *testing.T, h http.HandlerFunc) *Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer key" {
+			w.WriteHeader(401)
+			fmt.Fprint(w, `{"error": "wrong key"}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		h(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	c := New(srv.URL, "key")
+	c.HTTP = srv.Client()
+	return c
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"stats.example.com", "https://stats.example.com"},
+		{"stats.example.com/", "https://stats.example.com"},
+		{"http://localhost:8081/", "http://localhost:8081"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			have := New(tt.in, "").URL()
+			if have != tt.want {
+				t.Errorf("\nhave: %q\nwant: %q", have, tt.want)
+			}
+		})
+	}
+}
+
+func TestError(t *testing.T) {
+	c := newTest(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(404)
+		fmt.Fprint(w, `{"error": "not found"}`)
+	})
+	_, err := c.Site(context.Background(), 42)
+
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("wrong error: %#v", err)
+	}
+	if apiErr.StatusCode != 404 || apiErr.Message() != "not found" {
+		t.Errorf("%#v", apiErr)
+	}
+
+	c.key = "wrong"
+	_, _, err = c.Me(context.Background())
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 401 {
+		t.Errorf("wrong error: %#v", err)
+	}
+}
+
+func TestRatelimit(t *testing.T) {
+	var n int
+	c := newTest(t, func(w http.ResponseWriter, r *http.Request) {
+		n++
+		if n < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(429)
+			return
+		}
+		fmt.Fprint(w, `{"sites": [{"id": 1}]}`)
+	})
+	var retries []time.Duration
+	c.OnRetry = func(err error, wait time.Duration) {
+		var apiErr *Error
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != 429 {
+			t.Errorf("wrong error: %#v", err)
+		}
+		retries = append(retries, wait)
+	}
+
+	sites, err := c.Sites(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sites) != 1 || sites[0].ID != 1 {
+		t.Errorf("%#v", sites)
+	}
+	if n != 3 || len(retries) != 2 {
+		t.Errorf("n=%d; retries=%v", n, retries)
+	}
+
+	t.Run("context", func(t *testing.T) {
+		c := newTest(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(429)
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := c.Sites(ctx)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("wrong error: %#v", err)
+		}
+	})
+}
+
+func TestRetry(t *testing.T) {
+	backoff = func(int) time.Duration { return 0 }
+	t.Cleanup(func() { backoff = func(i int) time.Duration { return time.Duration(i*i) * time.Second } })
+
+	c := New("http://localhost:1", "key")
+	c.MaxRetries = 2
+	var n int
+	c.OnRetry = func(error, time.Duration) { n++ }
+
+	_, err := c.Sites(context.Background())
+	if err == nil {
+		t.Fatal("err is nil")
+	}
+	if n != 2 {
+		t.Errorf("n=%d", n)
+	}
+}
+
+func TestCount(t *testing.T) {
+	var batches []int
+	c := newTest(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/api/v0/count" {
+			t.Errorf("%s %s", r.Method, r.URL)
+		}
+		var args count.Request
+		err := json.NewDecoder(r.Body).Decode(&args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !args.NoSessions {
+			t.Error("NoSessions not set")
+		}
+
+		batches = append(batches, len(args.Hits))
+		errs := make(map[int]string)
+		for i, h := range args.Hits {
+			if h.Path == "" {
+				errs[i] = "path: must be set"
+			}
+		}
+		if len(errs) > 0 {
+			w.WriteHeader(400)
+			json.NewEncoder(w).Encode(map[string]any{"errors": errs})
+			return
+		}
+		w.WriteHeader(202)
+		fmt.Fprint(w, `{"status":"ok"}`)
+	})
+
+	hits := make([]count.Hit, 1200)
+	for i := range hits {
+		hits[i].Path = "/" + strconv.Itoa(i)
+	}
+	hits[2].Path = ""
+	hits[1003].Path = ""
+
+	err := c.Count(context.Background(), count.Request{NoSessions: true, Hits: hits})
+	var cErr *CountError
+	if !errors.As(err, &cErr) {
+		t.Fatalf("wrong error: %#v", err)
+	}
+	if len(cErr.Errors) != 2 || cErr.Errors[2] == "" || cErr.Errors[1003] == "" {
+		t.Errorf("%#v", cErr.Errors)
+	}
+	if fmt.Sprint(batches) != "[500 500 200]" {
+		t.Errorf("%v", batches)
+	}
+}
+
+func TestPaths(t *testing.T) {
+	c := newTest(t, func(w http.ResponseWriter, r *http.Request) {
+		after, _ := strconv.ParseInt(r.URL.Query().Get("after"), 10, 64)
+		var resp struct {
+			Paths goatcounter.Paths `json:"paths"`
+			More  bool              `json:"more"`
+		}
+		for i := after + 1; i <= min(after+2, 5); i++ {
+			resp.Paths = append(resp.Paths, goatcounter.Path{ID: i, Path: "/" + strconv.FormatInt(i, 10)})
+		}
+		resp.More = after+2 < 5
+		json.NewEncoder(w).Encode(resp)
+	})
+
+	paths, err := c.Paths(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 5 || paths[4].Path != "/5" {
+		t.Errorf("%#v", paths)
+	}
+}
+
+func TestStats(t *testing.T) {
+	var queries []string
+	c := newTest(t, func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.String())
+		switch r.URL.Path {
+		case "/api/v0/stats/total":
+			fmt.Fprint(w, `{"total": 10, "total_events": 1, "total_utc": 9}`)
+		case "/api/v0/stats/browsers":
+			if r.URL.Query().Get("offset") == "" {
+				fmt.Fprint(w, `{"stats": [{"name": "Firefox", "count": 6}], "more": true}`)
+			} else {
+				fmt.Fprint(w, `{"stats": [{"name": "Chrome", "count": 4}], "more": false}`)
+			}
+		case "/api/v0/stats/series/browsers":
+			fmt.Fprint(w, `{"series": [{"id": "Firefox", "name": "Firefox", "count": 6,
+				"stats": [{"day": "2020-06-01", "count": 6}]}]}`)
+		default:
+			w.WriteHeader(404)
+		}
+	})
+
+	var (
+		ctx = context.Background()
+		opt = StatsOptions{
+			Start: time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC),
+			End:   time.Date(2020, 6, 18, 0, 0, 0, 0, time.UTC),
+			Limit: 1,
+		}
+	)
+	tc, err := c.Total(ctx, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tc.Total != 10 || tc.TotalUTC != 9 {
+		t.Errorf("%#v", tc)
+	}
+
+	stats, err := c.StatsAll(ctx, "browsers", opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 || stats[1].Name != "Chrome" {
+		t.Errorf("%#v", stats)
+	}
+
+	series, err := c.StatsSeries(ctx, "browsers", "month", opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(series) != 1 || len(series[0].Stats) != 1 || series[0].Stats[0].Count != 6 {
+		t.Errorf("%#v", series)
+	}
+
+	want := []string{
+		"/api/v0/stats/total?end=2020-06-18T00%3A00%3A00Z&start=2020-06-01T00%3A00%3A00Z",
+		"/api/v0/stats/browsers?end=2020-06-18T00%3A00%3A00Z&limit=1&start=2020-06-01T00%3A00%3A00Z",
+		"/api/v0/stats/browsers?end=2020-06-18T00%3A00%3A00Z&limit=1&offset=1&start=2020-06-01T00%3A00%3A00Z",
+		"/api/v0/stats/series/browsers?end=2020-06-18T00%3A00%3A00Z&group=month&limit=1&start=2020-06-01T00%3A00%3A00Z",
+	}
+	if fmt.Sprint(queries) != fmt.Sprint(want) {
+		t.Errorf("\nhave: %v\nwant: %v", queries, want)
+	}
+}
+
+func TestExportDownload(t *testing.T) {
+	var done bool
+	c := newTest(t, func(w http.ResponseWriter, r *http.Request) {
+		if !done {
+			w.WriteHeader(202)
+			fmt.Fprint(w, `{"error": "still being generated"}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/gzip")
+		fmt.Fprint(w, "data")
+	})
+
+	_, err := c.ExportDownload(context.Background(), 1)
+	if !errors.Is(err, ErrExportRunning) {
+		t.Fatalf("wrong error: %#v", err)
+	}
+
+	done = true
+	fp, err := c.ExportDownload(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	b, _ := io.ReadAll(fp)
+	if string(b) != "data" {
+		t.Errorf("%q", b)
+	}
+}
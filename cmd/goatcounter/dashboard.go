@@ -5,11 +5,9 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
 	"math"
-	"net/http"
 	"os"
 	"strconv"
 	"strings"
@@ -17,6 +15,7 @@ import (
 
 	"zgo.at/errors"
 	"zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/client"
 	"zgo.at/termtext"
 	"zgo.at/zli"
 	"zgo.at/zstd/zint"
@@ -49,8 +48,6 @@ Environment:
                         permissions.
 `
 
-var dashClient = http.Client{Timeout: 15 * time.Second}
-
 // Load the dashboard in the terminal with the API.
 func cmdDashboard(f zli.Flags) error {
 	if len(f.Args) == 0 {
@@ -77,22 +74,12 @@ func cmdDashboard(f zli.Flags) error {
 		return err
 	}
 
-	url := strings.TrimRight(site.String(), "/")
-	if !zstring.HasPrefixes(url, "http://", "https://") {
-		url = "https://" + url
-	}
-
-	// Get API key and ensure we have the correct permissions.
-	key := os.Getenv("GOATCOUNTER_API_KEY")
-	if key == "" {
-		return errors.New("GOATCOUNTER_API_KEY must be set")
-	}
-	err = checkSite(url, key, goatcounter.APIPermSiteRead, goatcounter.APIPermStats)
+	c, err := newClient(site.String(), goatcounter.APIPermSiteRead, goatcounter.APIPermStats)
 	if err != nil {
 		return err
 	}
 
-	return dash(url, key, rng)
+	return dash(c, rng)
 }
 
 // Parse -range flag.
@@ -134,85 +121,24 @@ func parseRange(rangeFlag string) (ztime.Range, error) {
 	return rng, nil
 }
 
-// Create a new request.
-func newRequest(method, url, key string, body io.Reader) (*http.Request, error) {
-	r, err := http.NewRequest(method, url, body)
-	if err != nil {
-		return nil, err
-	}
-	r.Header.Set("Content-Type", "application/json")
-	r.Header.Set("Authorization", "Bearer "+key)
-	return r, nil
-}
-
-// Verify that the site is live and that we've got the correct permissions.
-func checkSite(url, key string, perms ...zint.Bitflag64) error {
-	r, err := newRequest("GET", url+"/api/v0/me", key, nil)
-	if err != nil {
-		return err
-	}
-
-	resp, err := importClient.Do(r)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == 429 { // Ratelimit, try again.
-		return checkSite(url, key, perms...)
-	}
-
-	b, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("%s: %s: %s", url+"/api/v0/me",
-			resp.Status, zstring.ElideLeft(string(b), 200))
-	}
-
-	var perm struct {
-		Token goatcounter.APIToken `json:"token"`
-	}
-	err = json.Unmarshal(b, &perm)
-	if err != nil {
-		return err
-	}
-	for _, p := range perms {
-		if !perm.Token.Permissions.Has(p) {
-			// TODO: better error here; include the permission we need.
-			return fmt.Errorf("API token %q is missing the required permissions", perm.Token.Name)
-		}
-	}
-
-	return nil
-}
-
-// Make a HTTP request, taking rate limiting in to account.
-func doRequest(scanTo any, key string, url string, urlFmt ...any) error {
-	r, err := newRequest("GET", fmt.Sprintf(url, urlFmt...), key, nil)
-	if err != nil {
-		return err
+// Create a new API client with the key from GOATCOUNTER_API_KEY, and verify
+// that the site is live and that we've got the correct permissions.
+func newClient(site string, perms ...zint.Bitflag64) (*client.Client, error) {
+	key := os.Getenv("GOATCOUNTER_API_KEY")
+	if key == "" {
+		return nil, errors.New("GOATCOUNTER_API_KEY must be set")
 	}
 
-	resp, err := dashClient.Do(r)
+	c := client.New(site, key)
+	err := c.CheckPermissions(context.Background(), perms...)
 	if err != nil {
-		return err
-	}
-
-	defer resp.Body.Close()
-
-	if resp.StatusCode == 429 { // Ratelimit
-		return doRequest(scanTo, key, url, urlFmt...)
-	}
-
-	if resp.StatusCode != 200 {
-		b, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("status %s: %s", resp.Status, b)
+		return nil, err
 	}
-
-	return json.NewDecoder(resp.Body).Decode(scanTo)
+	return c, nil
 }
 
 // Render the dashboard.
-func dash(url, key string, rng ztime.Range) error {
+func dash(c *client.Client, rng ztime.Range) error {
 	type row struct {
 		text  string
 		color zli.Color
@@ -224,7 +150,7 @@ func dash(url, key string, rng ztime.Range) error {
 		nr        = func(s string) row { return row{text: s} }
 	)
 
-	data, err := getData(url, key, rng)
+	data, err := getData(c, rng)
 	if err != nil {
 		return err
 	}
@@ -318,57 +244,42 @@ func dash(url, key string, rng ztime.Range) error {
 	return nil
 }
 
-type (
-	dashboardData struct {
-		total goatcounter.TotalCount
-		hits  struct {
-			Hits  goatcounter.HitLists `json:"hits"`
-			Total int                  `json:"total"`
-			More  bool                 `json:"more"`
-		}
-		stats map[string]stats
-	}
-	stats struct {
-		Stats []goatcounter.HitStat `json:"stats"`
-		More  bool                  `json:"more"`
-	}
-)
+type dashboardData struct {
+	total goatcounter.TotalCount
+	hits  client.HitsResponse
+	stats map[string]client.StatsResponse
+}
 
 // Get the required data for the dashboard.
-func getData(url, key string, rng ztime.Range) (dashboardData, error) {
-	data := dashboardData{stats: make(map[string]stats)}
+func getData(c *client.Client, rng ztime.Range) (dashboardData, error) {
+	var (
+		ctx  = context.Background()
+		opt  = client.StatsOptions{Start: rng.Start, End: rng.End}
+		data = dashboardData{stats: make(map[string]client.StatsResponse)}
+		err  error
+	)
 
 	// Get totals
-	err := doRequest(&data.total, key, "%s/api/v0/stats/total?start=%s&end=%s", url,
-		rng.Start.Format("2006-01-02T15:04:05Z"), rng.End.Format("2006-01-02T15:04:05Z"))
+	data.total, err = c.Total(ctx, opt)
 	if err != nil {
 		return data, err
 	}
 
 	// Get pages overview.
-	err = doRequest(&data.hits, key, "%s/api/v0/stats/hits?limit=8&start=%s&end=%s", url,
-		rng.Start.Format("2006-01-02T15:04:05Z"), rng.End.Format("2006-01-02T15:04:05Z"))
+	opt.Limit = 8
+	data.hits, err = c.Hits(ctx, opt, goatcounter.GroupHourly)
 	if err != nil {
 		return data, err
 	}
 
 	// Get browser, system stats.
-	getStat := func(page string) error {
-		limit := 4
+	for _, page := range []string{"toprefs", "browsers", "systems", "sizes", "locations", "languages", "campaigns"} {
+		opt.Limit = 4
 		if page == "toprefs" {
-			limit = 8
+			opt.Limit = 8
 		}
-		var stats stats
-		err = doRequest(&stats, key, "%s/api/v0/stats/%s?limit=%d&start=%s&end=%s", url, page, limit,
-			rng.Start.Format("2006-01-02T15:04:05Z"), rng.End.Format("2006-01-02T15:04:05Z"))
+		data.stats[page], err = c.Stats(ctx, page, opt)
 		if err != nil {
-			return err
-		}
-		data.stats[page] = stats
-		return err
-	}
-	for _, page := range []string{"toprefs", "browsers", "systems", "sizes", "locations", "languages", "campaigns"} {
-		if err := getStat(page); err != nil {
 			return data, err
 		}
 	}
@@ -10,6 +10,7 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"golang.org/x/text/language"
 	"zgo.at/errors"
@@ -154,6 +155,24 @@ create and update commands:
                         site_read    Reading site information.
                         site_create  Creating new sites.
                         site_update  Updating existing sites.
+                        users        Managing users and their access.
+                        audit_log    Reading the audit log.
+                        paths_delete Merging and purging paths.
+
+        -expires    Date after which the key can no longer be used, as
+                    "2006-01-02". Never expires if empty.
+
+        -sites      Comma-separated list of site IDs the key can be used for.
+                    All sites the user has access to if empty.
+
+        -allow-ip   Comma-separated list of IP addresses or CIDR ranges (e.g.
+                    "192.0.2.0/24") the key can be used from. Any IP if empty.
+
+        Only for "update":
+
+            -rotate     Generate a new secret for the key. The old secret
+                        remains valid for the given duration (e.g. "24h"); use
+                        "0" to invalidate it immediately.
 
 migrate command:
 
@@ -182,6 +201,29 @@ migrate command:
     Note: you can also use -automigrate flag for the serve command to run migrations
     on startup.
 
+paths rewrite command:
+
+    Rewrite paths, for example after restructuring the URLs on your site. Paths
+    that are rewritten to the same path are merged, as are paths that are
+    rewritten to a path that already exists. All pageviews and statistics are
+    updated in one transaction. It will print which paths are rewritten and
+    merged first.
+
+    -site       Site to rewrite the paths for; as ID ("1") or vhost
+                ("stats.example.com").
+
+    -match      Prefix to match, or a regular expression if it starts with
+                "re:".
+
+    -replace    Replacement; for regular expressions this can refer to
+                submatches with $1, $2, etc.
+
+    -dry-run    Only show what would be changed, without changing anything.
+
+    For example:
+
+        $ goatcounter db paths rewrite -site=1 -match='re:^/blog/(\d+)/(.*)' -replace='/posts/$2'
+
 newdb command:
 
     Create a new database. This is the same what "goatcounter serve" or
@@ -336,6 +378,8 @@ const helpDBCommands = `List of commands:
 
                         Valid tables are "site", "user", and "apitoken".
 
+     paths rewrite      Rewrite and merge paths.
+
      newdb              Create a new database.
      migrate            Run or view database migrations.
      schema-sqlite      Print the SQLite schema.
@@ -403,6 +447,8 @@ start:
 		return cmdDBShow(f, cmd, dbConnect, debug, createdb)
 	case "delete":
 		return cmdDBDelete(f, cmd, dbConnect, debug, createdb)
+	case "paths":
+		return cmdDBPaths(f, dbConnect, debug, createdb)
 
 	case "create", "update":
 		tbl, err := getTable(&f, cmd)
@@ -664,6 +710,67 @@ func cmdDBDelete(f zli.Flags, cmd string, dbConnect, debug *string, createdb *bo
 	return finder.Delete(ctx, *force)
 }
 
+func cmdDBPaths(f zli.Flags, dbConnect, debug *string, createdb *bool) error {
+	_, err := f.ShiftCommand("rewrite")
+	if err != nil {
+		if errors.Is(err, zli.ErrCommandNoneGiven{}) {
+			return errors.New("\"db paths\" needs a subcommand\n" + helpDBShort)
+		}
+		return err
+	}
+
+	var (
+		findSite = f.String("", "site")
+		match    = f.String("", "match")
+		replace  = f.String("", "replace")
+		dryRun   = f.Bool(false, "dry-run")
+	)
+	db, ctx, err := dbParseFlag(f, dbConnect, debug, createdb)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	v := zvalidate.New()
+	v.Required("-site", findSite.String())
+	v.Required("-match", match.String())
+	if v.HasErrors() {
+		return v
+	}
+
+	var site goatcounter.Site
+	err = site.Find(ctx, findSite.String())
+	if err != nil {
+		return err
+	}
+	ctx = goatcounter.WithSite(ctx, &site)
+
+	var changes goatcounter.PathChanges
+	err = changes.Rewrite(ctx, match.String(), replace.String())
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		fmt.Fprintln(zli.Stdout, "No paths match")
+		return nil
+	}
+
+	var merged int
+	for _, c := range changes {
+		if c.MergeWith > 0 {
+			merged++
+			fmt.Fprintf(zli.Stdout, "merge   %s -> %s (path_id %d)\n", c.Path, c.NewPath, c.MergeWith)
+		} else {
+			fmt.Fprintf(zli.Stdout, "rename  %s -> %s\n", c.Path, c.NewPath)
+		}
+	}
+	fmt.Fprintf(zli.Stdout, "\n%d paths renamed, %d paths merged\n", len(changes)-merged, merged)
+	if dryRun.Bool() {
+		return nil
+	}
+	return changes.Apply(ctx)
+}
+
 func cmdDBSite(f zli.Flags, cmd string, dbConnect, debug *string, createdb *bool) error {
 	// TODO(depr): The second values are for compat with <2.0
 	var (
@@ -945,13 +1052,18 @@ func getAccess(a string) goatcounter.UserAccesses {
 
 func cmdDBAPIToken(f zli.Flags, cmd string, dbConnect, debug *string, createdb *bool) error {
 	var (
-		user = f.String("", "user")
-		name = f.String("", "name")
-		perm = f.String("", "perm")
-		find *[]string
+		user    = f.String("", "user")
+		name    = f.String("", "name")
+		perm    = f.String("", "perm")
+		expires = f.String("", "expires")
+		sites   = f.String("", "sites")
+		allowIP = f.String("", "allow-ip")
+		find    *[]string
+		rotate  stringFlag
 	)
 	if cmd == "update" {
 		find = f.StringList(nil, "find").Pointer()
+		rotate = f.String("", "rotate")
 	}
 	db, ctx, err := dbParseFlag(f, dbConnect, debug, createdb)
 	if err != nil {
@@ -960,13 +1072,14 @@ func cmdDBAPIToken(f zli.Flags, cmd string, dbConnect, debug *string, createdb *
 	defer db.Close()
 
 	if cmd == "create" {
-		return cmdDBAPITokenCreate(ctx, user.String(), perm.String(), name.String())
+		return cmdDBAPITokenCreate(ctx, user.String(), perm.String(), name.String(),
+			expires.String(), sites.String(), allowIP.String())
 	}
-	return cmdDBAPITokenUpdate(ctx, *find, name, perm)
+	return cmdDBAPITokenUpdate(ctx, *find, name, perm, expires, sites, allowIP, rotate)
 }
 
 func cmdDBAPITokenCreate(ctx context.Context,
-	findUser, permFlag, name string,
+	findUser, permFlag, name, expires, sites, allowIP string,
 ) error {
 
 	v := zvalidate.New()
@@ -998,16 +1111,21 @@ func cmdDBAPITokenCreate(ctx context.Context,
 		return err
 	}
 
-	return (&goatcounter.APIToken{
+	t := goatcounter.APIToken{
 		SiteID:      user.Site,
 		UserID:      user.ID,
 		Name:        name,
 		Permissions: perm,
-	}).Insert(ctx)
+	}
+	err = setAPITokenRestrict(&t, expires, sites, allowIP)
+	if err != nil {
+		return err
+	}
+	return t.Insert(ctx)
 }
 
 func cmdDBAPITokenUpdate(ctx context.Context, find []string,
-	name, perm stringFlag,
+	name, perm, expires, sites, allowIP, rotate stringFlag,
 ) error {
 
 	v := zvalidate.New()
@@ -1037,6 +1155,42 @@ func cmdDBAPITokenUpdate(ctx context.Context, find []string,
 				}
 				t.Permissions = p
 			}
+			if expires.Set() || sites.Set() || allowIP.Set() {
+				var e, s, a string
+				if expires.Set() {
+					e = expires.String()
+				} else if t.ExpiresAt != nil {
+					e = t.ExpiresAt.Format("2006-01-02")
+				}
+				if sites.Set() {
+					s = sites.String()
+				} else {
+					s = t.Sites.String()
+				}
+				if allowIP.Set() {
+					a = allowIP.String()
+				} else {
+					a = t.AllowedIPs.String()
+				}
+				err := setAPITokenRestrict(&t, e, s, a)
+				if err != nil {
+					return err
+				}
+			}
+
+			// Rotate before Update, as Update sets a new (unsaved) token
+			// in Defaults().
+			if rotate != nil && rotate.Set() {
+				grace, err := time.ParseDuration(rotate.String())
+				if err != nil {
+					return fmt.Errorf("-rotate: %w", err)
+				}
+				err = t.Rotate(ctx, grace)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(zli.Stdout, "new token for %d: %s\n", t.ID, t.Token)
+			}
 
 			err := t.Update(ctx)
 			if err != nil {
@@ -1047,15 +1201,40 @@ func cmdDBAPITokenUpdate(ctx context.Context, find []string,
 	})
 }
 
+// setAPITokenRestrict sets the expiry and restrictions from the flags; an
+// empty string clears the value.
+func setAPITokenRestrict(t *goatcounter.APIToken, expires, sites, allowIP string) error {
+	t.ExpiresAt = nil
+	if expires != "" {
+		e, err := time.Parse("2006-01-02", expires)
+		if err != nil {
+			return fmt.Errorf("-expires: %w", err)
+		}
+		t.ExpiresAt = &e
+	}
+
+	t.Sites = nil
+	err := t.Sites.Scan(sites)
+	if err != nil {
+		return fmt.Errorf("-sites: %w", err)
+	}
+
+	t.AllowedIPs = nil
+	return t.AllowedIPs.Scan(allowIP)
+}
+
 func getPerm(permFlag string) (zint.Bitflag64, error) {
 	var perm zint.Bitflag64
 	for _, p := range zstring.Fields(permFlag, ",") {
 		pp, ok := map[string]zint.Bitflag64{
-			"count":       goatcounter.APIPermCount,
-			"export":      goatcounter.APIPermExport,
-			"site_read":   goatcounter.APIPermSiteRead,
-			"site_create": goatcounter.APIPermSiteCreate,
-			"site_update": goatcounter.APIPermSiteUpdate,
+			"count":        goatcounter.APIPermCount,
+			"export":       goatcounter.APIPermExport,
+			"site_read":    goatcounter.APIPermSiteRead,
+			"site_create":  goatcounter.APIPermSiteCreate,
+			"site_update":  goatcounter.APIPermSiteUpdate,
+			"users":        goatcounter.APIPermUsers,
+			"audit_log":    goatcounter.APIPermAuditLog,
+			"paths_delete": goatcounter.APIPermPathsDelete,
 		}[p]
 		if !ok {
 			return 0, fmt.Errorf("-perm: invalid value %q", p)
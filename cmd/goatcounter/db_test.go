@@ -98,6 +98,39 @@ func TestDBMigrate(t *testing.T) {
 	}
 }
 
+func TestDBPathsRewrite(t *testing.T) {
+	exit, _, out, ctx, dbc := startTest(t)
+
+	gctest.StoreHits(ctx, t, false,
+		goatcounter.Hit{Path: "/blog/1/a"},
+		goatcounter.Hit{Path: "/blog/2/a"},
+		goatcounter.Hit{Path: "/other"})
+
+	runCmd(t, exit, "db", "paths", "rewrite", "-db="+dbc, "-site=1",
+		`-match=re:^/blog/\d+/(.*)`, "-replace=/posts/$1", "-dry-run")
+	wantExit(t, exit, out, 0)
+	want := "rename  /blog/1/a -> /posts/a\nmerge   /blog/2/a -> /posts/a (path_id 1)\n\n1 paths renamed, 1 paths merged\n"
+	if out.String() != want {
+		t.Errorf("\nhave: %q\nwant: %q", out.String(), want)
+	}
+	if have := zdb.DumpString(ctx, `select path from paths order by path_id`); !strings.Contains(have, "/blog/2/a") {
+		t.Errorf("changed with -dry-run:\n%s", have)
+	}
+	out.Reset()
+
+	runCmd(t, exit, "db", "paths", "rewrite", "-db="+dbc, "-site=1",
+		`-match=re:^/blog/\d+/(.*)`, "-replace=/posts/$1")
+	wantExit(t, exit, out, 0)
+	have := zdb.DumpString(ctx, `select path_id, path from paths order by path_id`)
+	want = `
+		path_id  path
+		1        /posts/a
+		3        /other`
+	if d := zdb.Diff(have, want); d != "" {
+		t.Error(d)
+	}
+}
+
 func TestDBSite(t *testing.T) {
 	exit, _, out, ctx, dbc := startTest(t)
 
@@ -396,12 +429,41 @@ func TestDBAPIToken(t *testing.T) {
 		out.Reset()
 	}
 
+	{ // restrict and rotate
+		var before string
+		err := zdb.Get(ctx, &before, `select token from api_tokens where api_token_id=1`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		runCmd(t, exit, "db", "update", "apitoken",
+			"-db="+dbc,
+			"-find=1",
+			"-expires=2099-01-01",
+			"-sites=1",
+			"-allow-ip=192.0.2.0/24,2001:db8::1",
+			"-rotate=1h")
+		wantExit(t, exit, out, 0)
+		if !strings.HasPrefix(out.String(), "new token for 1: ") {
+			t.Error(out.String())
+		}
+
+		have := zdb.DumpString(ctx, `select sites, allowed_ips, previous_token=$1 as prev from api_tokens order by api_token_id`, before)
+		want := `
+			sites  allowed_ips               prev
+			1      192.0.2.0/24,2001:db8::1  1`
+		if d := zdb.Diff(have, want); d != "" {
+			t.Error(d)
+		}
+		out.Reset()
+	}
+
 	{ // show
 		runCmd(t, exit, "db", "show", "apitoken",
 			"-db="+dbc,
 			"-find=1")
 		wantExit(t, exit, out, 0)
-		if !strings.HasPrefix(out.String(), `api_token_id  1`) {
+		if !strings.HasPrefix(out.String(), `api_token_id         1`) {
 			t.Error(out.String())
 		}
 		out.Reset()
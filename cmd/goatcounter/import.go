@@ -5,28 +5,23 @@
 package main
 
 import (
-	"bytes"
 	"compress/gzip"
 	"context"
 	"fmt"
 	"io"
-	"math"
 	"net"
-	"net/http"
 	"os"
-	"strconv"
 	"strings"
 	"time"
 
 	"zgo.at/errors"
 	"zgo.at/goatcounter/v2"
-	"zgo.at/goatcounter/v2/handlers"
+	"zgo.at/goatcounter/v2/client"
+	"zgo.at/goatcounter/v2/count"
 	"zgo.at/goatcounter/v2/logscan"
-	"zgo.at/json"
 	"zgo.at/zli"
 	"zgo.at/zlog"
 	"zgo.at/zstd/znet"
-	"zgo.at/zstd/zstring"
 )
 
 const usageImport = `
@@ -246,23 +241,25 @@ func cmdImport(f zli.Flags, ready chan<- struct{}, stop chan struct{}) error {
 
 		zlog.Config.SetDebug(debug)
 
-		url := strings.TrimRight(site, "/")
-		if !zstring.HasPrefixes(url, "http://", "https://") {
-			url = "https://" + url
-		}
-		key := os.Getenv("GOATCOUNTER_API_KEY")
-		if key == "" {
-			return errors.New("GOATCOUNTER_API_KEY must be set")
-		}
-
-		err = checkSite(url, key, goatcounter.APIPermCount)
+		c, err := newClient(site, goatcounter.APIPermCount)
 		if err != nil {
 			return err
 		}
+		c.Header.Set("X-Goatcounter-Import", "yes")
+		c.OnRetry = func(err error, wait time.Duration) {
+			var apiErr *client.Error
+			if errors.As(err, &apiErr) {
+				if !silent {
+					fmt.Fprintf(zli.Stdout, "\nwaiting %s for the ratelimiter\n", wait)
+				}
+				return
+			}
+			fmt.Fprintf(zli.Stderr, "non-fatal error; retrying in %s: %s\n", wait, err)
+		}
 
 		switch format {
 		default:
-			err = importLog(fp, ready, stop, url, key, files[0], format, date, tyme, datetime, follow, silent, exclude)
+			err = importLog(fp, ready, stop, c, files[0], format, date, tyme, datetime, follow, silent, exclude)
 		case "csv":
 			ready <- struct{}{}
 			if follow {
@@ -271,24 +268,24 @@ func cmdImport(f zli.Flags, ready chan<- struct{}, stop chan struct{}) error {
 			if len(exclude) > 0 {
 				return fmt.Errorf("cannot use -exclude with -format=csv")
 			}
-			err = importCSV(fp, url, key, silent)
+			err = importCSV(fp, c, silent)
 		}
 		return err
 	}(*debug, *site, *format, *date, *tyme, *datetime, *silent, *follow, *exclude)
 }
 
-func importCSV(fp io.ReadCloser, url, key string, silent bool) error {
+func importCSV(fp io.ReadCloser, c *client.Client, silent bool) error {
 	n := 0
 	ctx := goatcounter.WithSite(context.Background(), &goatcounter.Site{})
-	hits := make([]handlers.APICountRequestHit, 0, 500)
+	hits := make([]count.Hit, 0, 500)
 	_, err := goatcounter.Import(ctx, fp, false, false, func(hit goatcounter.Hit, final bool) {
 		if !final {
-			hits = append(hits, handlers.APICountRequestHit{
+			hits = append(hits, count.Hit{
 				Path:      hit.Path,
 				Title:     hit.Title,
 				Event:     hit.Event,
 				Ref:       hit.Ref,
-				Size:      hit.Size,
+				Size:      count.Floats(hit.Size),
 				Bot:       hit.Bot,
 				UserAgent: hit.UserAgentHeader,
 				Location:  hit.Location,
@@ -297,8 +294,8 @@ func importCSV(fp io.ReadCloser, url, key string, silent bool) error {
 			})
 		}
 
-		if len(hits) >= 500 || final {
-			err := importSend(url, key, silent, false, hits)
+		if len(hits) >= client.MaxBatch || final {
+			err := importSend(c, hits)
 			if err != nil {
 				fmt.Fprintln(zli.Stdout)
 				zli.Errorf(err)
@@ -309,7 +306,7 @@ func importCSV(fp io.ReadCloser, url, key string, silent bool) error {
 				zli.Replacef("Imported %d rows", n)
 			}
 
-			hits = make([]handlers.APICountRequestHit, 0, 500)
+			hits = make([]count.Hit, 0, 500)
 		}
 	})
 	return err
@@ -318,7 +315,7 @@ func importCSV(fp io.ReadCloser, url, key string, silent bool) error {
 func importLog(
 	fp io.ReadCloser,
 	ready chan<- struct{}, stop <-chan struct{},
-	url, key, file, format, date, tyme, datetime string, follow, silent bool, exclude []string,
+	c *client.Client, file, format, date, tyme, datetime string, follow, silent bool, exclude []string,
 ) error {
 	var (
 		scan *logscan.Scanner
@@ -334,7 +331,7 @@ func importLog(
 		return err
 	}
 
-	hits := make(chan handlers.APICountRequestHit, 100)
+	hits := make(chan count.Hit, 100)
 
 	// Persist every 10 seconds because it may take a while for 100 pageviews to
 	// arrive when using -follow.
@@ -344,7 +341,7 @@ func importLog(
 	go func() {
 		for {
 			<-t.C
-			persistLog(hits, url, key, silent, follow)
+			persistLog(hits, c)
 		}
 	}()
 
@@ -354,7 +351,7 @@ func importLog(
 		cancel()
 	}()
 
-	defer persistLog(hits, url, key, silent, follow)
+	defer persistLog(hits, c)
 	ready <- struct{}{}
 	n := 0
 	for {
@@ -367,7 +364,7 @@ func importLog(
 			return err
 		}
 
-		hit := handlers.APICountRequestHit{
+		hit := count.Hit{
 			Line:      raw,
 			LineNo:    lineno,
 			Path:      line.Path(),
@@ -399,7 +396,7 @@ func importLog(
 		if len(hits) >= cap(hits) {
 			n += len(hits)
 			t.Reset(d)
-			persistLog(hits, url, key, silent, follow)
+			persistLog(hits, c)
 			if !silent && !follow {
 				zli.Replacef("Imported %d rows", n)
 			}
@@ -410,91 +407,47 @@ func importLog(
 
 // Send everything off if we have 100 entries or if 10 seconds expired,
 // whichever happens first.
-func persistLog(hits <-chan handlers.APICountRequestHit, url, key string, silent, follow bool) {
+func persistLog(hits <-chan count.Hit, c *client.Client) {
 	l := len(hits)
 	if l == 0 {
 		return
 	}
-	collect := make([]handlers.APICountRequestHit, l)
+	collect := make([]count.Hit, l)
 	for i := 0; i < l; i++ {
 		collect[i] = <-hits
 	}
 
-	err := importSend(url, key, silent, follow, collect)
+	err := importSend(c, collect)
 	if err != nil {
 		zlog.Error(err)
 	}
 }
 
-var importClient = http.Client{Timeout: 5 * time.Second}
-
-func importSend(url, key string, silent, follow bool, hits []handlers.APICountRequestHit) error {
-	body, err := json.Marshal(handlers.APICountRequest{NoSessions: true, Hits: hits})
-	if err != nil {
-		return err
-	}
-
-	i := 0
-retry:
-	r, err := newRequest("POST", url+"/api/v0/count", key, bytes.NewReader(body))
-	if err != nil {
-		return err
-	}
-	r.Header.Set("X-Goatcounter-Import", "yes")
-
-	zlog.Module("import-api").Debugf("POST %s with %d hits", url, len(hits))
-	resp, err := importClient.Do(r)
-	if err != nil {
-		if i > 5 {
-			return err
-		}
-
-		i++
-		w := (time.Duration(math.Pow(float64(i), 2)) * time.Second).Round(time.Second)
-		fmt.Fprintf(zli.Stderr, "non-fatal error; retrying in %s: %s\n", w, err)
-		time.Sleep(w)
-		goto retry
+func importSend(c *client.Client, hits []count.Hit) error {
+	zlog.Module("import-api").Debugf("POST %s with %d hits", c.URL(), len(hits))
+	err := c.Count(context.Background(), count.Request{NoSessions: true, Hits: hits})
+	if err == nil {
+		return nil
 	}
-	defer resp.Body.Close()
 
-	showError := func(fatal bool) error {
-		var b []byte
-		b, _ = io.ReadAll(resp.Body)
-		var gcErr struct {
-			Errors map[int]string `json:"errors"`
-		}
-		jsErr := json.Unmarshal(b, &gcErr)
-		if jsErr == nil {
-			for i, e := range gcErr.Errors {
-				zlog.Fields(zlog.F{
-					"lineno": hits[i].LineNo,
-					"line":   strings.TrimRight(hits[i].Line, "\r\n"),
-					"error":  strings.TrimSpace(e),
-				}).Errorf("error processing line %d", hits[i].LineNo)
-			}
-		}
-		err := fmt.Errorf("%s: %s: %s", url, resp.Status, b)
-		if fatal {
-			return err
+	// Errors for individual pageviews aren't fatal.
+	var (
+		cErr   *client.CountError
+		apiErr *client.Error
+	)
+	switch {
+	case errors.As(err, &cErr):
+		for i, e := range cErr.Errors {
+			zlog.Fields(zlog.F{
+				"lineno": hits[i].LineNo,
+				"line":   strings.TrimRight(hits[i].Line, "\r\n"),
+				"error":  strings.TrimSpace(e),
+			}).Errorf("error processing line %d", hits[i].LineNo)
 		}
+		return nil
+	case errors.As(err, &apiErr) && apiErr.StatusCode == 400:
 		fmt.Fprintln(zli.Stderr, err.Error())
 		return nil
 	}
-
-	switch resp.StatusCode {
-	case 200, 202:
-		// Success, do nothing.
-	case http.StatusTooManyRequests:
-		s, _ := strconv.Atoi(resp.Header.Get("X-Rate-Limit-Reset"))
-		if !silent {
-			fmt.Fprintf(zli.Stdout, "\nwaiting %d seconds for the ratelimiter\n", s)
-		}
-		time.Sleep(time.Duration(s) * time.Second)
-		return importSend(url, key, silent, follow, hits)
-	case 400:
-		return showError(false)
-	default:
-		return showError(true)
-	}
-	return nil
+	return err
 }
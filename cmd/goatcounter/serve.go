@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/exec"
 	"os/signal"
@@ -123,6 +124,17 @@ Flags:
                version built-in; you only need this if you want to use a
                newer/different version, or if you want to record regions.
 
+  -refspam     File with additional referrer spam domains to ignore, in
+               addition to the built-in list. One domain per line; subdomains
+               are also ignored. Blank lines and lines starting with # are
+               ignored. The file is reloaded when it changes.
+
+  -refgroups   File with referrer grouping rules for all sites, which are
+               used after the site's own rules. One rule per line as
+               "match = name" or "match = name; channel"; blank lines and
+               lines starting with # are ignored. The file is reloaded when
+               it changes.
+
   -ratelimit   Set rate limits for various actions; the syntax is
                "name:num-requests/seconds"; multiple values are separated by
                a comma. The defaults are:
@@ -152,6 +164,29 @@ Flags:
                Higher values will give better performance, but it will take a
                bit longer for pageviews to show. The default is 10 seconds.
 
+  -auth-proxy  Log in users from a header set by an authenticating reverse proxy
+               such as oauth2-proxy or Authelia. This is a comma-separated list
+               with any of:
+
+                 header:name            Header with the user's email address,
+                                        e.g. "X-Forwarded-Email". Required.
+                 trust:ip[/mask]        Only accept the header from this IP or
+                                        CIDR range. Can be given more than once.
+                                        Required.
+                 create:access          Create users that don't exist yet, with
+                                        this access level: "readonly",
+                                        "settings", or "admin". Users that don't
+                                        exist are not logged in if omitted.
+
+               For example:
+
+                 -auth-proxy header:Remote-Email,trust:127.0.0.1,trust:10.0.0.0/8
+
+               The trusted IP is matched against the address that connects to
+               GoatCounter, not the IP from X-Forwarded-For and the like. Make
+               sure the proxy always sets or removes this header, as anyone who
+               can set it can log in as any user.
+
   -dev         Start in "dev mode".
 
   -debug       Modules to debug, comma-separated or 'all' for all modules.
@@ -172,6 +207,7 @@ func cmdServe(f zli.Flags, ready chan<- struct{}, stop chan struct{}) error {
 		port         = f.Int(0, "public-port", "port").Pointer()
 		basePath     = f.String("/", "base-path").Pointer()
 		domainStatic = f.String("", "static").Pointer()
+		authProxy    = f.String("", "auth-proxy").Pointer()
 	)
 	dbConnect, dbConn, dev, automigrate, listen, flagTLS, from, websocket, apiMax, err := flagsServe(f, &v)
 	if err != nil {
@@ -204,6 +240,7 @@ func cmdServe(f zli.Flags, ready chan<- struct{}, stop chan struct{}) error {
 
 		//from := flagFrom(from, "cfg.Domain", &v)
 		from := flagFrom(from, "", &v)
+		authHeader, authTrusted, authCreate := flagAuthProxy(*authProxy, &v)
 		if v.HasErrors() {
 			return v
 		}
@@ -224,6 +261,7 @@ func cmdServe(f zli.Flags, ready chan<- struct{}, stop chan struct{}) error {
 		c.BasePath = basePath
 		c.DomainCount = domainCount
 		c.Websocket = websocket
+		c.AuthProxyHeader, c.AuthProxyTrusted, c.AuthProxyCreate = authHeader, authTrusted, authCreate
 
 		// Set up HTTP handler and servers.
 		hosts := map[string]http.Handler{
@@ -292,7 +330,6 @@ func doServe(ctx context.Context, db zdb.DB,
 		if err != nil {
 			zlog.Error(err)
 		}
-		goatcounter.Memstore.StoreSessions(db)
 	})
 
 	time.Sleep(200 * time.Millisecond) // Only show message if it doesn't exit in 200ms.
@@ -334,6 +371,8 @@ func flagsServe(f zli.Flags, v *zvalidate.Validator) (string, string, bool, bool
 		errors      = f.String("", "errors").Pointer()
 		from        = f.String("", "email-from").Pointer()
 		geodb       = f.String("", "geodb").Pointer()
+		refspam     = f.String("", "refspam").Pointer()
+		refgroups   = f.String("", "refgroups").Pointer()
 		ratelimit   = f.String("", "ratelimit").Pointer()
 		apiMax      = f.Int(0, "api-max").Pointer()
 		storeEvery  = f.Int(10, "store-every").Pointer()
@@ -361,6 +400,18 @@ func flagsServe(f zli.Flags, v *zvalidate.Validator) (string, string, bool, bool
 	cron.SetPersistInterval(time.Duration(*storeEvery) * time.Second)
 
 	goatcounter.InitGeoDB(*geodb)
+	if *refspam != "" {
+		err := goatcounter.WatchRefspam(context.Background(), *refspam, 30*time.Second)
+		if err != nil {
+			v.Append("-refspam", err.Error())
+		}
+	}
+	if *refgroups != "" {
+		err := goatcounter.WatchRefGroups(context.Background(), *refgroups, 30*time.Second)
+		if err != nil {
+			v.Append("-refgroups", err.Error())
+		}
+	}
 
 	if *ratelimit != "" {
 		for _, r := range strings.Split(*ratelimit, ",") {
@@ -530,6 +581,56 @@ func flagErrors(errors string, v *zvalidate.Validator) {
 	}
 }
 
+func flagAuthProxy(flag string, v *zvalidate.Validator) (string, []netip.Prefix, goatcounter.UserAccess) {
+	if flag == "" {
+		return "", nil, ""
+	}
+
+	var (
+		header  string
+		trusted []netip.Prefix
+		create  goatcounter.UserAccess
+	)
+	for _, f := range strings.Split(flag, ",") {
+		k, val, _ := strings.Cut(strings.TrimSpace(f), ":")
+		switch k {
+		default:
+			v.Append("-auth-proxy", fmt.Sprintf("unknown value: %q", f))
+		case "header":
+			header = http.CanonicalHeaderKey(val)
+		case "trust":
+			p, err := netip.ParsePrefix(val)
+			if err != nil {
+				ip, err2 := netip.ParseAddr(val)
+				if err2 != nil {
+					v.Append("-auth-proxy", fmt.Sprintf("invalid IP or CIDR range: %q", val))
+					continue
+				}
+				p = netip.PrefixFrom(ip, ip.BitLen())
+			}
+			trusted = append(trusted, p.Masked())
+		case "create":
+			switch val {
+			case "readonly":
+				create = goatcounter.AccessReadOnly
+			case "settings":
+				create = goatcounter.AccessSettings
+			case "admin":
+				create = goatcounter.AccessAdmin
+			default:
+				v.Append("-auth-proxy", fmt.Sprintf("invalid access level for create: %q", val))
+			}
+		}
+	}
+	if header == "" {
+		v.Append("-auth-proxy", "header: is required")
+	}
+	if len(trusted) == 0 {
+		v.Append("-auth-proxy", "need at least one trust:")
+	}
+	return header, trusted, create
+}
+
 func flagFrom(from, domain string, v *zvalidate.Validator) string {
 	if from == "" {
 		if domain != "" { // saas only.
@@ -7,6 +7,7 @@ package goatcounter
 import (
 	"context"
 	"fmt"
+	"net/netip"
 	"time"
 
 	"zgo.at/z18n"
@@ -50,6 +51,12 @@ type GlobalConfig struct {
 	Websocket      bool
 	EmailFrom      string
 	BcryptMinCost  bool
+
+	// Log in users from a header set by an authenticating reverse proxy; see
+	// the -auth-proxy flag for "serve".
+	AuthProxyHeader  string
+	AuthProxyTrusted []netip.Prefix
+	AuthProxyCreate  UserAccess
 }
 
 // WithSite adds the site to the context.
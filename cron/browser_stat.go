@@ -17,7 +17,7 @@ func updateBrowserStats(ctx context.Context, hits []goatcounter.Hit) error {
 	return errors.Wrap(zdb.TX(ctx, func(ctx context.Context) error {
 		type gt struct {
 			count     int
-			day       string
+			hour      string
 			browserID int64
 			pathID    int64
 		}
@@ -30,11 +30,11 @@ func updateBrowserStats(ctx context.Context, hits []goatcounter.Hit) error {
 				continue
 			}
 
-			day := h.CreatedAt.Format("2006-01-02")
-			k := day + strconv.FormatInt(h.BrowserID, 10) + strconv.FormatInt(h.PathID, 10)
+			hour := h.CreatedAt.Format("2006-01-02 15:00:00")
+			k := hour + strconv.FormatInt(h.BrowserID, 10) + strconv.FormatInt(h.PathID, 10)
 			v := grouped[k]
 			if v.count == 0 {
-				v.day = day
+				v.hour = hour
 				v.browserID = h.BrowserID
 				v.pathID = h.PathID
 			}
@@ -46,19 +46,19 @@ func updateBrowserStats(ctx context.Context, hits []goatcounter.Hit) error {
 		}
 
 		siteID := goatcounter.MustGetSite(ctx).ID
-		ins := zdb.NewBulkInsert(ctx, "browser_stats", []string{"site_id", "day",
+		ins := zdb.NewBulkInsert(ctx, "browser_stats", []string{"site_id", "hour",
 			"path_id", "browser_id", "count"})
 		if zdb.SQLDialect(ctx) == zdb.DialectPostgreSQL {
-			ins.OnConflict(`on conflict on constraint "browser_stats#site_id#path_id#day#browser_id" do update set
+			ins.OnConflict(`on conflict on constraint "browser_stats#site_id#path_id#hour#browser_id" do update set
 				count = browser_stats.count + excluded.count`)
 		} else {
-			ins.OnConflict(`on conflict(site_id, path_id, day, browser_id) do update set
+			ins.OnConflict(`on conflict(site_id, path_id, hour, browser_id) do update set
 				count = browser_stats.count + excluded.count`)
 		}
 
 		for _, v := range grouped {
 			if v.count > 0 {
-				ins.Values(siteID, v.day, v.pathID, v.browserID, v.count)
+				ins.Values(siteID, v.hour, v.pathID, v.browserID, v.count)
 			}
 		}
 		return ins.Finish()
@@ -17,9 +17,10 @@ func updateCampaignStats(ctx context.Context, hits []goatcounter.Hit) error {
 	return errors.Wrap(zdb.TX(ctx, func(ctx context.Context) error {
 		type gt struct {
 			count      int
-			day        string
+			hour       string
 			campaignID int64
 			ref        string
+			utm        goatcounter.UTM
 			pathID     int64
 		}
 		grouped := map[string]gt{}
@@ -28,13 +29,15 @@ func updateCampaignStats(ctx context.Context, hits []goatcounter.Hit) error {
 				continue
 			}
 
-			day := h.CreatedAt.Format("2006-01-02")
-			k := day + strconv.FormatInt(*h.CampaignID, 10) + h.Ref + strconv.FormatInt(h.PathID, 10)
+			hour := h.CreatedAt.Format("2006-01-02 15:00:00")
+			k := hour + strconv.FormatInt(*h.CampaignID, 10) + h.Ref + "\x00" + h.UTM.Medium + "\x00" +
+				h.UTM.Content + "\x00" + h.UTM.Term + "\x00" + strconv.FormatInt(h.PathID, 10)
 			v := grouped[k]
 			if v.count == 0 {
-				v.day = day
+				v.hour = hour
 				v.campaignID = *h.CampaignID
 				v.ref = h.Ref
+				v.utm = h.UTM
 				v.pathID = h.PathID
 			}
 
@@ -45,19 +48,19 @@ func updateCampaignStats(ctx context.Context, hits []goatcounter.Hit) error {
 		}
 
 		siteID := goatcounter.MustGetSite(ctx).ID
-		ins := zdb.NewBulkInsert(ctx, "campaign_stats", []string{"site_id", "day",
-			"path_id", "campaign_id", "ref", "count"})
+		ins := zdb.NewBulkInsert(ctx, "campaign_stats", []string{"site_id", "hour",
+			"path_id", "campaign_id", "ref", "medium", "content", "term", "count"})
 		if zdb.SQLDialect(ctx) == zdb.DialectPostgreSQL {
-			ins.OnConflict(`on conflict on constraint "campaign_stats#site_id#path_id#campaign_id#ref#day" do update set
+			ins.OnConflict(`on conflict on constraint "campaign_stats#site_id#path_id#campaign_id#ref#utm#hour" do update set
 				count = campaign_stats.count + excluded.count`)
 		} else {
-			ins.OnConflict(`on conflict(site_id, path_id, campaign_id, ref, day) do update set
+			ins.OnConflict(`on conflict(site_id, path_id, campaign_id, ref, medium, content, term, hour) do update set
 				count = campaign_stats.count + excluded.count`)
 		}
 
 		for _, v := range grouped {
 			if v.count > 0 {
-				ins.Values(siteID, v.day, v.pathID, v.campaignID, v.ref, v.count)
+				ins.Values(siteID, v.hour, v.pathID, v.campaignID, v.ref, v.utm.Medium, v.utm.Content, v.utm.Term, v.count)
 			}
 		}
 		return ins.Finish()
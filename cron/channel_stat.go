@@ -0,0 +1,65 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package cron
+
+import (
+	"context"
+	"strconv"
+
+	"zgo.at/errors"
+	"zgo.at/goatcounter/v2"
+	"zgo.at/zdb"
+)
+
+func updateChannelStats(ctx context.Context, hits []goatcounter.Hit) error {
+	return errors.Wrap(zdb.TX(ctx, func(ctx context.Context) error {
+		type gt struct {
+			count   int
+			hour    string
+			channel string
+			pathID  int64
+		}
+		var (
+			site    = goatcounter.MustGetSite(ctx)
+			grouped = map[string]gt{}
+		)
+		for _, h := range hits {
+			if h.Bot > 0 {
+				continue
+			}
+
+			hour := h.CreatedAt.Format("2006-01-02 15:00:00")
+			channel := goatcounter.RefChannel(h.Ref, h.RefScheme, h.CampaignID != nil, site.Settings.RefGroups)
+			k := hour + channel + strconv.FormatInt(h.PathID, 10)
+			v := grouped[k]
+			if v.count == 0 {
+				v.hour = hour
+				v.channel = channel
+				v.pathID = h.PathID
+			}
+
+			if h.FirstVisit {
+				v.count += 1
+			}
+			grouped[k] = v
+		}
+
+		ins := zdb.NewBulkInsert(ctx, "channel_stats", []string{"site_id", "hour", "path_id", "channel", "count"})
+		if zdb.SQLDialect(ctx) == zdb.DialectPostgreSQL {
+			ins.OnConflict(`on conflict on constraint "channel_stats#site_id#path_id#hour#channel" do update set
+				count = channel_stats.count + excluded.count`)
+		} else {
+			ins.OnConflict(`on conflict(site_id, path_id, hour, channel) do update set
+				count = channel_stats.count + excluded.count`)
+		}
+
+		for _, v := range grouped {
+			if v.count > 0 {
+				ins.Values(site.ID, v.hour, v.pathID, v.channel, v.count)
+			}
+		}
+		return ins.Finish()
+	}), "cron.updateChannelStats")
+}
@@ -0,0 +1,56 @@
+package cron_test
+
+import (
+	"testing"
+	"time"
+
+	"zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zstd/zjson"
+	"zgo.at/zstd/ztest"
+	"zgo.at/zstd/ztime"
+)
+
+func TestChannelStats(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	site := goatcounter.MustGetSite(ctx)
+	site.Settings.RefGroups = goatcounter.RefGroups{{Match: "news.example.com", Name: "Newsletter", Channel: "email"}}
+	err := site.Update(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx = goatcounter.WithSite(ctx, site)
+	now := time.Date(2019, 8, 31, 14, 42, 0, 0, time.UTC)
+
+	gctest.StoreHits(ctx, t, false, []goatcounter.Hit{
+		{Site: site.ID, CreatedAt: now, FirstVisit: true},
+		{Site: site.ID, CreatedAt: now, FirstVisit: true},
+		{Site: site.ID, CreatedAt: now, Ref: "https://www.google.com/search", FirstVisit: true},
+		{Site: site.ID, CreatedAt: now, Ref: "https://duckduckgo.com", FirstVisit: true},
+		{Site: site.ID, CreatedAt: now, Ref: "https://duckduckgo.com"},
+		{Site: site.ID, CreatedAt: now, Ref: "https://news.example.com/x", FirstVisit: true},
+		{Site: site.ID, CreatedAt: now, Ref: "https://example.org", FirstVisit: true},
+		{Site: site.ID, CreatedAt: now, Query: "utm_campaign=one", FirstVisit: true},
+	}...)
+
+	var have goatcounter.HitStats
+	err = have.ListChannels(ctx, ztime.NewRange(now).Current(ztime.Day), nil, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{
+		"more": false,
+		"stats": [
+			{"count": 2, "id": "direct", "name": "Direct"},
+			{"count": 2, "id": "search", "name": "Search"},
+			{"count": 1, "id": "campaign", "name": "Campaign"},
+			{"count": 1, "id": "email", "name": "Email"},
+			{"count": 1, "id": "other", "name": "Other"}
+		]
+	}`
+	if d := ztest.Diff(zjson.MustMarshalString(have), want, ztest.DiffJSON); d != "" {
+		t.Error(d)
+	}
+}
@@ -104,11 +104,24 @@ type templateArgs struct {
 	Pages   goatcounter.HitLists
 	Total   goatcounter.HitList
 	Refs    goatcounter.HitStats
+	Groups  goatcounter.PathGroupStats
 
-	DisplayDate                  string
-	TextPagesTable, TextRefTable template.HTML
+	DisplayDate                                  string
+	TextPagesTable, TextRefTable, TextGroupTable template.HTML
 
-	Diffs []string
+	Diffs, GroupDiffs []string
+}
+
+// formatDiff formats the percentage difference from the previous period.
+func formatDiff(d float64) string {
+	switch {
+	case math.IsInf(d, 0):
+		return "(new)"
+	case d < 0:
+		return fmt.Sprintf("%+.0f%%", d)
+	default:
+		return fmt.Sprintf("%.0f%%", d)
+	}
 }
 
 func reportText(ctx context.Context, site goatcounter.Site, user goatcounter.User) (text, html []byte, subject string, err error) {
@@ -131,7 +144,7 @@ func reportText(ctx context.Context, site goatcounter.Site, user goatcounter.Use
 	subject = fmt.Sprintf("Your GoatCounter report for %s", args.DisplayDate)
 
 	{ // Get overview of paths.
-		_, _, err := args.Pages.List(ctx, rng, nil, nil, 10, true)
+		_, _, err := args.Pages.List(ctx, rng, nil, nil, 10, goatcounter.GroupDaily)
 		if err != nil {
 			return nil, nil, "", err
 		}
@@ -140,7 +153,7 @@ func reportText(ctx context.Context, site goatcounter.Site, user goatcounter.Use
 			return nil, nil, "", nil
 		}
 
-		_, err = args.Total.Totals(ctx, rng, nil, true, true)
+		_, err = args.Total.Totals(ctx, rng, nil, goatcounter.GroupDaily, true)
 		if err != nil {
 			return nil, nil, "", err
 		}
@@ -154,14 +167,7 @@ func reportText(ctx context.Context, site goatcounter.Site, user goatcounter.Use
 
 		diffStr := make([]string, len(args.Pages))
 		for i := range diffs {
-			switch {
-			case math.IsInf(diffs[i], 0):
-				diffStr[i] = "(new)"
-			case diffs[i] < 0:
-				diffStr[i] = fmt.Sprintf("%+.0f%%", diffs[i])
-			default:
-				diffStr[i] = fmt.Sprintf("%.0f%%", diffs[i])
-			}
+			diffStr[i] = formatDiff(diffs[i])
 		}
 		args.Diffs = diffStr
 
@@ -205,6 +211,46 @@ func reportText(ctx context.Context, site goatcounter.Site, user goatcounter.Use
 		args.TextRefTable = template.HTML(b.String())
 	}
 
+	if len(site.Settings.PathGroups) > 0 { // Get overview of content groups.
+		err := args.Groups.List(ctx, rng, nil)
+		if err != nil {
+			return nil, nil, "", err
+		}
+
+		d := -rng.End.Sub(rng.Start)
+		var prevGroups goatcounter.PathGroupStats
+		err = prevGroups.List(ctx, ztime.NewRange(rng.Start.Add(d)).To(rng.End.Add(d)), nil)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		prev := make(map[string]int, len(prevGroups))
+		for _, g := range prevGroups {
+			prev[g.Name] = g.Count
+		}
+
+		b := new(strings.Builder)
+		fmt.Fprintf(b, "    %-36s  %9s  %7s\n", "Group", "Visitors", "Growth")
+		b.WriteString("    " + strings.Repeat("-", 56) + "\n")
+		b.WriteByte('\n')
+		args.GroupDiffs = make([]string, len(args.Groups))
+		for i, g := range args.Groups {
+			var diff float64
+			switch p := prev[g.Name]; {
+			case p > 0:
+				diff = float64(g.Count-p) / float64(p) * 100
+			case g.Count > 0:
+				diff = math.Inf(0)
+			}
+			args.GroupDiffs[i] = formatDiff(diff)
+
+			fmt.Fprintf(b, "    %-36s  %9s  %7s\n",
+				template.HTMLEscapeString(zstring.ElideLeft(g.Name, 35)),
+				tplfunc.Number(g.Count, user.Settings.NumberFormat),
+				args.GroupDiffs[i])
+		}
+		args.TextGroupTable = template.HTML(b.String())
+	}
+
 	text, err = ztpl.ExecuteBytes("email_report.gotxt", args)
 	if err != nil {
 		return nil, nil, "", errors.Errorf("cron.report text: %w", err)
@@ -96,6 +96,49 @@ func TestEmailReports(t *testing.T) {
 				xx                                                     1
 			`,
 		},
+		{
+			"groups",
+			func(ctx context.Context) context.Context {
+				site := goatcounter.Site{Settings: goatcounter.SiteSettings{
+					PathGroups: goatcounter.PathGroups{
+						{Name: "Docs", Match: []string{"/a", "/b"}},
+						{Name: "Other", Match: []string{"/c*"}},
+						{Name: "Empty", Match: []string{"/nothing"}},
+					},
+				}}
+				site.Defaults(ctx)
+				ctx = gctest.Site(ctx, t, &site, &goatcounter.User{
+					LastReportAt: now.Add(-day),
+					Settings: goatcounter.UserSettings{
+						EmailReports: zint.Int(goatcounter.EmailReportDaily),
+						Timezone:     tz.UTC,
+					},
+				})
+				sID := goatcounter.MustGetSite(ctx).ID
+				gctest.StoreHits(ctx, t, false,
+					goatcounter.Hit{Site: sID, FirstVisit: true, Path: "/a", CreatedAt: now.Add(-1 * time.Hour)},
+					goatcounter.Hit{Site: sID, FirstVisit: true, Path: "/b", CreatedAt: now.Add(-1 * time.Hour)},
+					goatcounter.Hit{Site: sID, FirstVisit: true, Path: "/b", CreatedAt: now.Add(-1 * time.Hour)},
+					goatcounter.Hit{Site: sID, FirstVisit: true, Path: "/b", CreatedAt: now.Add(-25 * time.Hour)},
+					goatcounter.Hit{Site: sID, FirstVisit: true, Path: "/c", CreatedAt: now.Add(-1 * time.Hour)},
+					goatcounter.Hit{Site: sID, FirstVisit: true, Path: "/c2", CreatedAt: now.Add(-1 * time.Hour)},
+				)
+				return ctx
+			}, `
+				Path                                   Visitors   Growth
+				/b                                            2     100%
+				/c2                                           1    (new)
+				/c                                            1    (new)
+				/a                                            1    (new)
+				Referrer                                        Visitors
+				(no data)                                              5
+				Content groups
+				Group                                  Visitors   Growth
+				Docs                                          3     200%
+				Other                                         2    (new)
+				Empty                                         0       0%
+			`,
+		},
 		{
 			"week",
 			func(ctx context.Context) context.Context {
@@ -36,7 +36,7 @@ func TestHitStats(t *testing.T) {
 		var stats goatcounter.HitLists
 		display, more, err := stats.List(ctx,
 			ztime.NewRange(now.Add(-1*time.Hour)).To(now.Add(1*time.Hour)),
-			nil, nil, 10, false)
+			nil, nil, 10, goatcounter.GroupHourly)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -60,6 +60,7 @@ func TestHitStats(t *testing.T) {
 
 	check("1 false", `{
 			"count": 1,
+			"count_unique": 1,
 			"path_id":      1,
 			"path":         "/asd",
 			"event":        false,
@@ -92,6 +93,7 @@ func TestHitStats(t *testing.T) {
 
 	check("2 false", `{
 			"count":  2,
+			"count_unique": 1,
 			"path_id":       1,
 			"path":          "/asd",
 			"event":         false,
@@ -141,7 +143,7 @@ func TestHitStatsNoCollect(t *testing.T) {
 		var stats goatcounter.HitLists
 		display, more, err := stats.List(ctx,
 			ztime.NewRange(now.Add(-1*time.Hour)).To(now.Add(1*time.Hour)),
-			nil, nil, 10, false)
+			nil, nil, 10, goatcounter.GroupHourly)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -165,6 +167,7 @@ func TestHitStatsNoCollect(t *testing.T) {
 
 	check("3 false", `{
 			"count":         2,
+			"count_unique": 2,
 			"path_id":       1,
 			"path":          "/asd",
 			"event":         false,
@@ -177,6 +180,7 @@ func TestHitStatsNoCollect(t *testing.T) {
 		}]}`,
 		`{
 			"count":         1,
+			"count_unique": 1,
 			"path_id":       2,
 			"path":          "/zxc",
 			"event":         false,
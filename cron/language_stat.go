@@ -18,7 +18,7 @@ func updateLanguageStats(ctx context.Context, hits []goatcounter.Hit) error {
 	return errors.Wrap(zdb.TX(ctx, func(ctx context.Context) error {
 		type gt struct {
 			count    int
-			day      string
+			hour     string
 			language string
 			pathID   int64
 		}
@@ -28,11 +28,11 @@ func updateLanguageStats(ctx context.Context, hits []goatcounter.Hit) error {
 				continue
 			}
 
-			day := h.CreatedAt.Format("2006-01-02")
-			k := day + ztype.Deref(h.Language, "") + strconv.FormatInt(h.PathID, 10)
+			hour := h.CreatedAt.Format("2006-01-02 15:00:00")
+			k := hour + ztype.Deref(h.Language, "") + strconv.FormatInt(h.PathID, 10)
 			v := grouped[k]
 			if v.count == 0 {
-				v.day = day
+				v.hour = hour
 				v.language = ztype.Deref(h.Language, "")
 				v.pathID = h.PathID
 			}
@@ -44,18 +44,18 @@ func updateLanguageStats(ctx context.Context, hits []goatcounter.Hit) error {
 		}
 
 		siteID := goatcounter.MustGetSite(ctx).ID
-		ins := zdb.NewBulkInsert(ctx, "language_stats", []string{"site_id", "day", "path_id", "language", "count"})
+		ins := zdb.NewBulkInsert(ctx, "language_stats", []string{"site_id", "hour", "path_id", "language", "count"})
 		if zdb.SQLDialect(ctx) == zdb.DialectPostgreSQL {
-			ins.OnConflict(`on conflict on constraint "language_stats#site_id#path_id#day#language" do update set
+			ins.OnConflict(`on conflict on constraint "language_stats#site_id#path_id#hour#language" do update set
 				count = language_stats.count + excluded.count`)
 		} else {
-			ins.OnConflict(`on conflict(site_id, path_id, day, language) do update set
+			ins.OnConflict(`on conflict(site_id, path_id, hour, language) do update set
 				count = language_stats.count + excluded.count`)
 		}
 
 		for _, v := range grouped {
 			if v.count > 0 {
-				ins.Values(siteID, v.day, v.pathID, v.language, v.count)
+				ins.Values(siteID, v.hour, v.pathID, v.language, v.count)
 			}
 		}
 		return ins.Finish()
@@ -17,7 +17,7 @@ func updateLocationStats(ctx context.Context, hits []goatcounter.Hit) error {
 	return errors.Wrap(zdb.TX(ctx, func(ctx context.Context) error {
 		type gt struct {
 			count    int
-			day      string
+			hour     string
 			location string
 			pathID   int64
 		}
@@ -27,11 +27,11 @@ func updateLocationStats(ctx context.Context, hits []goatcounter.Hit) error {
 				continue
 			}
 
-			day := h.CreatedAt.Format("2006-01-02")
-			k := day + h.Location + strconv.FormatInt(h.PathID, 10)
+			hour := h.CreatedAt.Format("2006-01-02 15:00:00")
+			k := hour + h.Location + strconv.FormatInt(h.PathID, 10)
 			v := grouped[k]
 			if v.count == 0 {
-				v.day = day
+				v.hour = hour
 				v.location = h.Location
 				v.pathID = h.PathID
 			}
@@ -49,19 +49,19 @@ func updateLocationStats(ctx context.Context, hits []goatcounter.Hit) error {
 		}
 
 		siteID := goatcounter.MustGetSite(ctx).ID
-		ins := zdb.NewBulkInsert(ctx, "location_stats", []string{"site_id", "day",
+		ins := zdb.NewBulkInsert(ctx, "location_stats", []string{"site_id", "hour",
 			"path_id", "location", "count"})
 		if zdb.SQLDialect(ctx) == zdb.DialectPostgreSQL {
-			ins.OnConflict(`on conflict on constraint "location_stats#site_id#path_id#day#location" do update set
+			ins.OnConflict(`on conflict on constraint "location_stats#site_id#path_id#hour#location" do update set
 				count = location_stats.count + excluded.count`)
 		} else {
-			ins.OnConflict(`on conflict(site_id, path_id, day, location) do update set
+			ins.OnConflict(`on conflict(site_id, path_id, hour, location) do update set
 				count = location_stats.count + excluded.count`)
 		}
 
 		for _, v := range grouped {
 			if v.count > 0 {
-				ins.Values(siteID, v.day, v.pathID, v.location, v.count)
+				ins.Values(siteID, v.hour, v.pathID, v.location, v.count)
 			}
 		}
 		return ins.Finish()
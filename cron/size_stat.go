@@ -17,7 +17,7 @@ func updateSizeStats(ctx context.Context, hits []goatcounter.Hit) error {
 	return errors.Wrap(zdb.TX(ctx, func(ctx context.Context) error {
 		type gt struct {
 			count  int
-			day    string
+			hour   string
 			width  int
 			pathID int64
 		}
@@ -32,11 +32,11 @@ func updateSizeStats(ctx context.Context, hits []goatcounter.Hit) error {
 				width = int(h.Size[0]) // TODO: apply scaling?
 			}
 
-			day := h.CreatedAt.Format("2006-01-02")
-			k := day + strconv.Itoa(width) + strconv.FormatInt(h.PathID, 10)
+			hour := h.CreatedAt.Format("2006-01-02 15:00:00")
+			k := hour + strconv.Itoa(width) + strconv.FormatInt(h.PathID, 10)
 			v := grouped[k]
 			if v.count == 0 {
-				v.day = day
+				v.hour = hour
 				v.width = width
 				v.pathID = h.PathID
 			}
@@ -48,19 +48,19 @@ func updateSizeStats(ctx context.Context, hits []goatcounter.Hit) error {
 		}
 
 		siteID := goatcounter.MustGetSite(ctx).ID
-		ins := zdb.NewBulkInsert(ctx, "size_stats", []string{"site_id", "day",
+		ins := zdb.NewBulkInsert(ctx, "size_stats", []string{"site_id", "hour",
 			"path_id", "width", "count"})
 		if zdb.SQLDialect(ctx) == zdb.DialectPostgreSQL {
-			ins.OnConflict(`on conflict on constraint "size_stats#site_id#path_id#day#width" do update set
+			ins.OnConflict(`on conflict on constraint "size_stats#site_id#path_id#hour#width" do update set
 				count = size_stats.count + excluded.count`)
 		} else {
-			ins.OnConflict(`on conflict(site_id, path_id, day, width) do update set
+			ins.OnConflict(`on conflict(site_id, path_id, hour, width) do update set
 				count = size_stats.count + excluded.count`)
 		}
 
 		for _, v := range grouped {
 			if v.count > 0 {
-				ins.Values(siteID, v.day, v.pathID, v.width, v.count)
+				ins.Values(siteID, v.hour, v.pathID, v.width, v.count)
 			}
 		}
 		return ins.Finish()
@@ -31,7 +31,7 @@ func TestSizeStats(t *testing.T) {
 	}...)
 
 	var have goatcounter.HitStats
-	err := have.ListSizes(ctx, ztime.NewRange(now).To(now), nil)
+	err := have.ListSizes(ctx, ztime.NewRange(now).Current(ztime.Day), nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -63,7 +63,7 @@ func TestSizeStats(t *testing.T) {
 	}...)
 
 	have = goatcounter.HitStats{}
-	err = have.ListSizes(ctx, ztime.NewRange(now).To(now), nil)
+	err = have.ListSizes(ctx, ztime.NewRange(now).Current(ztime.Day), nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -17,7 +17,7 @@ func updateSystemStats(ctx context.Context, hits []goatcounter.Hit) error {
 	return errors.Wrap(zdb.TX(ctx, func(ctx context.Context) error {
 		type gt struct {
 			count    int
-			day      string
+			hour     string
 			systemID int64
 			pathID   int64
 		}
@@ -30,11 +30,11 @@ func updateSystemStats(ctx context.Context, hits []goatcounter.Hit) error {
 				continue
 			}
 
-			day := h.CreatedAt.Format("2006-01-02")
-			k := day + strconv.FormatInt(h.SystemID, 10) + strconv.FormatInt(h.PathID, 10)
+			hour := h.CreatedAt.Format("2006-01-02 15:00:00")
+			k := hour + strconv.FormatInt(h.SystemID, 10) + strconv.FormatInt(h.PathID, 10)
 			v := grouped[k]
 			if v.count == 0 {
-				v.day = day
+				v.hour = hour
 				v.systemID = h.SystemID
 				v.pathID = h.PathID
 			}
@@ -46,19 +46,19 @@ func updateSystemStats(ctx context.Context, hits []goatcounter.Hit) error {
 		}
 
 		siteID := goatcounter.MustGetSite(ctx).ID
-		ins := zdb.NewBulkInsert(ctx, "system_stats", []string{"site_id", "day",
+		ins := zdb.NewBulkInsert(ctx, "system_stats", []string{"site_id", "hour",
 			"path_id", "system_id", "count"})
 		if zdb.SQLDialect(ctx) == zdb.DialectPostgreSQL {
-			ins.OnConflict(`on conflict on constraint "system_stats#site_id#path_id#day#system_id" do update set
+			ins.OnConflict(`on conflict on constraint "system_stats#site_id#path_id#hour#system_id" do update set
 				count = system_stats.count + excluded.count`)
 		} else {
-			ins.OnConflict(`on conflict(site_id, path_id, day, system_id) do update set
+			ins.OnConflict(`on conflict(site_id, path_id, hour, system_id) do update set
 				count = system_stats.count + excluded.count`)
 		}
 
 		for _, v := range grouped {
 			if v.count > 0 {
-				ins.Values(siteID, v.day, v.pathID, v.systemID, v.count)
+				ins.Values(siteID, v.hour, v.pathID, v.systemID, v.count)
 			}
 		}
 		return ins.Finish()
@@ -143,6 +143,8 @@ func UpdateStats(ctx context.Context, site *goatcounter.Site, siteID int64, hits
 		updateLanguageStats,
 		updateSizeStats,
 		updateCampaignStats,
+		updateChannelStats,
+		updateVisitorStats,
 	}
 
 	for _, f := range funs {
@@ -205,16 +207,28 @@ func vacuumDeleted(ctx context.Context) error {
 	for _, s := range sites {
 		zlog.Module("vacuum").Printf("vacuum site %s/%d", s.Code, s.ID)
 		err := zdb.TX(ctx, func(ctx context.Context) error {
+			err := zdb.Exec(ctx, `delete from user_sessions where user_id in (select user_id from users where site_id=?)`, s.ID)
+			if err != nil {
+				return errors.Errorf("user_sessions: %w", err)
+			}
+
 			for _, t := range []string{"hits", "paths",
 				"hit_counts", "ref_counts",
 				"browser_stats", "system_stats", "hit_stats", "location_stats", "language_stats", "size_stats",
-				"campaign_stats", "exports", "api_tokens", "users", "sites"} {
+				"campaign_stats", "channel_stats", "visitor_stats", "exports", "api_tokens", "users", "sites"} {
 
 				err := zdb.Exec(ctx, fmt.Sprintf(`delete from %s where site_id=%d`, t, s.ID))
 				if err != nil {
 					return errors.Errorf("%s: %w", t, err)
 				}
 			}
+			// The audit log is kept for as long as the account exists.
+			if s.Parent == nil {
+				err := zdb.Exec(ctx, `delete from audit_log where account_id=?`, s.ID)
+				if err != nil {
+					return errors.Errorf("audit_log: %w", err)
+				}
+			}
 			return nil
 		})
 		if err != nil {
@@ -0,0 +1,127 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package cron
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"strconv"
+	"time"
+
+	"zgo.at/errors"
+	"zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/hll"
+	"zgo.at/zdb"
+)
+
+func updateVisitorStats(ctx context.Context, hits []goatcounter.Hit) error {
+	return errors.Wrap(zdb.TX(ctx, func(ctx context.Context) error {
+		// Group by day + pathID; the path_id 0 is for all paths.
+		type gt struct {
+			sketch *hll.Sketch
+			day    string
+			pathID int64
+		}
+		var (
+			grouped = map[string]gt{}
+			days    = map[string]struct{}{}
+			paths   = map[int64]struct{}{0: {}}
+		)
+		add := func(day string, pathID int64, hash uint64) {
+			k := day + strconv.FormatInt(pathID, 10)
+			v := grouped[k]
+			if v.sketch == nil {
+				v.sketch, v.day, v.pathID = hll.New(), day, pathID
+			}
+			v.sketch.Add(hash)
+			grouped[k] = v
+		}
+		for _, h := range hits {
+			if h.Bot > 0 {
+				continue
+			}
+
+			hash := h.VisitorHash
+			if hash == 0 {
+				// Merged paths and the like don't have the session key; the
+				// session ID is the best we can do.
+				if h.Session.IsZero() {
+					continue
+				}
+				s := sha256.Sum256(h.Session.Bytes())
+				hash = binary.LittleEndian.Uint64(s[:8])
+			}
+
+			day := h.CreatedAt.Format("2006-01-02")
+			days[day] = struct{}{}
+			paths[h.PathID] = struct{}{}
+			add(day, h.PathID, hash)
+			add(day, 0, hash)
+		}
+		if len(grouped) == 0 {
+			return nil
+		}
+
+		siteID := goatcounter.MustGetSite(ctx).ID
+
+		// Merge with existing sketches.
+		{
+			var (
+				d = make([]string, 0, len(days))
+				p = make([]int64, 0, len(paths))
+			)
+			for k := range days {
+				d = append(d, k)
+			}
+			for k := range paths {
+				p = append(p, k)
+			}
+			var existing []struct {
+				PathID int64     `db:"path_id"`
+				Day    time.Time `db:"day"`
+				Sketch []byte    `db:"sketch"`
+			}
+			err := zdb.Select(ctx, &existing, `/* updateVisitorStats */
+				select path_id, day, sketch from visitor_stats
+				where site_id = :site and day in (:days) and path_id in (:paths)`,
+				map[string]any{"site": siteID, "days": d, "paths": p})
+			if err != nil {
+				return errors.Wrap(err, "existing")
+			}
+			for _, e := range existing {
+				v, ok := grouped[e.Day.Format("2006-01-02")+strconv.FormatInt(e.PathID, 10)]
+				if !ok {
+					continue
+				}
+				var s hll.Sketch
+				err := s.UnmarshalBinary(e.Sketch)
+				if err != nil {
+					return errors.Wrapf(err, "path %d on %s", e.PathID, e.Day.Format("2006-01-02"))
+				}
+				v.sketch.Merge(&s)
+			}
+		}
+
+		ins := zdb.NewBulkInsert(ctx, "visitor_stats", []string{"site_id", "path_id",
+			"day", "sketch"})
+		if zdb.SQLDialect(ctx) == zdb.DialectPostgreSQL {
+			ins.OnConflict(`on conflict on constraint "visitor_stats#site_id#path_id#day" do update set
+				sketch = excluded.sketch`)
+		} else {
+			ins.OnConflict(`on conflict(site_id, path_id, day) do update set
+				sketch = excluded.sketch`)
+		}
+
+		for _, v := range grouped {
+			b, err := v.sketch.MarshalBinary()
+			if err != nil {
+				return errors.Wrap(err, "marshal")
+			}
+			ins.Values(siteID, v.pathID, v.day, b)
+		}
+		return ins.Finish()
+	}), "cron.updateVisitorStats")
+}
@@ -0,0 +1,3 @@
+alter table users add column login_failures integer not null default 0;
+alter table users add column login_failed_at timestamp null;
+alter table users add column locked_until timestamp null;
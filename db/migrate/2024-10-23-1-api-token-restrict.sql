@@ -0,0 +1,5 @@
+alter table api_tokens add column expires_at timestamp null;
+alter table api_tokens add column sites varchar not null default '';
+alter table api_tokens add column allowed_ips varchar not null default '';
+alter table api_tokens add column previous_token varchar null;
+alter table api_tokens add column previous_expires_at timestamp null;
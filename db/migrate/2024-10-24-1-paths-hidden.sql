@@ -0,0 +1 @@
+alter table paths add column hidden integer not null default 0;
@@ -0,0 +1 @@
+alter table campaigns add column hidden integer not null default 0;
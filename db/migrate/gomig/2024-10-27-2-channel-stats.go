@@ -0,0 +1,71 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package gomig
+
+import (
+	"context"
+
+	"zgo.at/errors"
+	"zgo.at/goatcounter/v2"
+	"zgo.at/zdb"
+)
+
+// ChannelStats fills channel_stats from the existing ref_counts.
+func ChannelStats(ctx context.Context) error {
+	err := zdb.TX(goatcounter.NewCache(goatcounter.NewConfig(ctx)), func(ctx context.Context) error {
+		var sites goatcounter.Sites
+		err := sites.UnscopedList(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, s := range sites {
+			var refs []struct {
+				ID        int64   `db:"ref_id"`
+				Ref       string  `db:"ref"`
+				RefScheme *string `db:"ref_scheme"`
+			}
+			err := zdb.Select(ctx, &refs, `
+				select ref_id, ref, ref_scheme from refs
+				where ref_id in (select distinct ref_id from ref_counts where site_id = ?)`, s.ID)
+			if err != nil {
+				return errors.Wrapf(err, "site %d", s.ID)
+			}
+
+			channels := make(map[string][]int64)
+			for _, r := range refs {
+				c := goatcounter.RefChannel(r.Ref, r.RefScheme, false, s.Settings.RefGroups)
+				channels[c] = append(channels[c], r.ID)
+			}
+
+			upsert := `on conflict(site_id, path_id, hour, channel) do update set count = channel_stats.count + excluded.count`
+			if zdb.SQLDialect(ctx) == zdb.DialectPostgreSQL {
+				upsert = `on conflict on constraint "channel_stats#site_id#path_id#hour#channel" do update set count = channel_stats.count + excluded.count`
+			}
+			for c, ids := range channels {
+				for len(ids) > 0 {
+					n := min(len(ids), 5_000)
+					err := zdb.Exec(ctx, `
+						insert into channel_stats (site_id, path_id, hour, channel, count)
+						select site_id, path_id, hour, :channel, sum(total) from ref_counts
+						where site_id = :site and ref_id in (:ids)
+						group by site_id, path_id, hour
+						having sum(total) > 0 `+upsert,
+						map[string]any{"site": s.ID, "channel": c, "ids": ids[:n]})
+					if err != nil {
+						return errors.Wrapf(err, "site %d", s.ID)
+					}
+					ids = ids[n:]
+				}
+			}
+		}
+		return nil
+	})
+
+	if err == nil {
+		err = zdb.Exec(ctx, `insert into version values ('2024-10-27-2-channel-stats')`)
+	}
+	return err
+}
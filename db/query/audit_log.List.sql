@@ -0,0 +1,9 @@
+select * from audit_log
+where
+	account_id = :account
+	{{:site   and site_id = :site}}
+	{{:user   and user_id = :user}}
+	{{:action and action = :action}}
+	{{:before and audit_log_id < :before}}
+order by audit_log_id desc
+{{:limit limit :limit}}
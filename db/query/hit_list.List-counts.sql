@@ -4,6 +4,7 @@ with x as (
 		hit_counts.site_id = :site and
 		{{:exclude path_id not in (:exclude) and}}
 		{{:filter path_id in (:filter) and}}
+		path_id not in (select path_id from paths where site_id = :site and hidden = 1) and
 		hour>=:start and hour<=:end
 	group by path_id
 	order by total desc, path_id desc
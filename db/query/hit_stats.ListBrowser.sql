@@ -4,7 +4,7 @@ select
 from browser_stats
 join browsers using (browser_id)
 where
-	site_id = :site and day >= :start and day <= :end and
+	site_id = :site and hour >= :start and hour <= :end and
 	{{:filter path_id in (:filter) and}}
 	lower(name) = lower(:browser)
 group by name, version
@@ -1,12 +1,12 @@
 select
-	ref               as name,
+	ref        as source,
+	medium,
 	sum(count) as count
 from campaign_stats
-join campaigns using (campaign_id)
 where
-	campaign_stats.site_id = :site and day >= :start and day <= :end and
+	site_id = :site and hour >= :start and hour <= :end and
 	{{:filter path_id in (:filter) and}}
 	campaign_id = :campaign
-group by campaign_id, ref
-order by count desc, ref asc
+group by ref, medium
+order by count desc, ref asc, medium asc
 limit :limit offset :offset
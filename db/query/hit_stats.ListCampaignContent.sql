@@ -0,0 +1,11 @@
+select
+	content,
+	sum(count) as count
+from campaign_stats
+where
+	site_id = :site and hour >= :start and hour <= :end and
+	{{:filter path_id in (:filter) and}}
+	campaign_id = :campaign and ref = :source and medium = :medium
+group by content
+order by count desc, content asc
+limit :limit offset :offset
@@ -4,8 +4,9 @@ with x as (
 		sum(count) as count
 	from campaign_stats
 	where
-		site_id = :site and day >= :start and day <= :end
+		site_id = :site and hour >= :start and hour <= :end
 		{{:filter and path_id in (:filter)}}
+		and campaign_id not in (select campaign_id from campaigns where site_id = :site and hidden = 1)
 	group by campaign_id
 	order by count desc, campaign_id
 	limit :limit offset :offset
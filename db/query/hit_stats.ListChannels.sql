@@ -0,0 +1,11 @@
+select
+	channel    as id,
+	channel    as name,
+	sum(count) as count
+from channel_stats
+where
+	site_id = :site and hour >= :start and hour <= :end
+	{{:filter and path_id in (:filter)}}
+group by channel
+order by count desc, channel
+limit :limit offset :offset
@@ -4,7 +4,7 @@ with x as (
 		sum(count) as count
 	from language_stats
 	where
-		site_id = :site and day >= :start and day <= :end
+		site_id = :site and hour >= :start and hour <= :end
 		{{:filter and path_id in (:filter)}}
 	group by language
 	order by count desc, language
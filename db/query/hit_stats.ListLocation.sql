@@ -4,7 +4,7 @@ select
 from location_stats
 join locations on location = iso_3166_2
 where
-	site_id = :site and day >= :start and day <= :end and
+	site_id = :site and hour >= :start and hour <= :end and
 	{{:filter path_id in (:filter) and}}
 	country = :country
 group by iso_3166_2, name
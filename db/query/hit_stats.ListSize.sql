@@ -3,7 +3,7 @@ select
 	sum(count)     as count
 from size_stats
 where
-	site_id = :site and day >= :start and day <= :end
+	site_id = :site and hour >= :start and hour <= :end
 	{{:filter   and path_id in (:filter)}}
 	{{:max_size and width != 0 and width > :min_size and width <= :max_size}}
 	{{:empty    and width = 0}}
@@ -3,7 +3,7 @@ select
 	sum(count) as count
 from size_stats
 where
-	site_id = :site and day >= :start and day <= :end
+	site_id = :site and hour >= :start and hour <= :end
 	{{:filter and path_id in (:filter)}}
 group by width
 order by count desc, name asc
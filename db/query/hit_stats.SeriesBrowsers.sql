@@ -0,0 +1,12 @@
+select
+	hour,
+	browsers.name as id,
+	sum(count)    as count
+from browser_stats
+join browsers using (browser_id)
+where
+	site_id = :site and hour >= :start and hour <= :end
+	{{:filter and path_id in (:filter)}}
+	and browsers.name in (:ids)
+group by hour, browsers.name
+order by hour asc
@@ -0,0 +1,11 @@
+select
+	hour,
+	campaign_id as id,
+	sum(count)  as count
+from campaign_stats
+where
+	site_id = :site and hour >= :start and hour <= :end
+	{{:filter and path_id in (:filter)}}
+	and campaign_id in (:ids)
+group by hour, campaign_id
+order by hour asc
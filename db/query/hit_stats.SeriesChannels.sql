@@ -0,0 +1,11 @@
+select
+	hour,
+	channel    as id,
+	sum(count) as count
+from channel_stats
+where
+	site_id = :site and hour >= :start and hour <= :end
+	{{:filter and path_id in (:filter)}}
+	and channel in (:ids)
+group by hour, channel
+order by hour asc
@@ -0,0 +1,11 @@
+select
+	hour,
+	language   as id,
+	sum(count) as count
+from language_stats
+where
+	site_id = :site and hour >= :start and hour <= :end
+	{{:filter and path_id in (:filter)}}
+	and language in (:ids)
+group by hour, language
+order by hour asc
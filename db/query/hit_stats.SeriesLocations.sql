@@ -0,0 +1,11 @@
+select
+	hour,
+	substr(location, 0, 3) as id,
+	sum(count)             as count
+from location_stats
+where
+	site_id = :site and hour >= :start and hour <= :end
+	{{:filter and path_id in (:filter)}}
+	and substr(location, 0, 3) in (:ids)
+group by hour, substr(location, 0, 3)
+order by hour asc
@@ -0,0 +1,10 @@
+select
+	hour,
+	width      as id,
+	sum(count) as count
+from size_stats
+where
+	site_id = :site and hour >= :start and hour <= :end
+	{{:filter and path_id in (:filter)}}
+group by hour, width
+order by hour asc
@@ -0,0 +1,12 @@
+select
+	hour,
+	systems.name as id,
+	sum(count)   as count
+from system_stats
+join systems using (system_id)
+where
+	site_id = :site and hour >= :start and hour <= :end
+	{{:filter and path_id in (:filter)}}
+	and systems.name in (:ids)
+group by hour, systems.name
+order by hour asc
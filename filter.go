@@ -0,0 +1,285 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"zgo.at/errors"
+	"zgo.at/zdb"
+)
+
+// BotFilter is the value for Hit.Bot for pageviews marked as a bot by a filter
+// rule.
+const BotFilter = 200
+
+// Fields filter rules can match on.
+const (
+	FilterIP        = "ip"        // IP address or CIDR range.
+	FilterUserAgent = "useragent" // Regular expression on the User-Agent header.
+	FilterPath      = "path"      // Glob pattern on the path.
+	FilterReferrer  = "referrer"  // Referrer host, including subdomains.
+	FilterLocation  = "location"  // Country or region code.
+	FilterQuery     = "query"     // Query parameter name, or name=value.
+)
+
+// Actions for filter rules.
+const (
+	FilterDrop     = "drop"      // Don't count the pageview at all.
+	FilterBot      = "bot"       // Count as a bot.
+	FilterStripRef = "strip-ref" // Remove the referrer.
+)
+
+var (
+	FilterFields  = []string{FilterIP, FilterUserAgent, FilterPath, FilterReferrer, FilterLocation, FilterQuery}
+	FilterActions = []string{FilterDrop, FilterBot, FilterStripRef}
+)
+
+type (
+	// FilterRule is a single rule to filter incoming pageviews.
+	FilterRule struct {
+		Action string `json:"action"`
+		Field  string `json:"field"`
+		Value  string `json:"value"`
+	}
+
+	// FilterRules is a list of filter rules, which are applied in order.
+	//
+	// In text form this is one rule per line as "action field value".
+	FilterRules []FilterRule
+)
+
+var regexpCache sync.Map
+
+// cachedRegexp compiles the regexp, or returns it from the cache if it was
+// compiled before.
+func cachedRegexp(expr string) (*regexp.Regexp, error) {
+	re, ok := regexpCache.Load(expr)
+	if !ok {
+		r, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		re, _ = regexpCache.LoadOrStore(expr, r)
+	}
+	return re.(*regexp.Regexp), nil
+}
+
+func (f FilterRule) String() string { return f.Action + " " + f.Field + " " + f.Value }
+
+// Match reports if this rule matches the hit.
+func (f FilterRule) Match(h *Hit) bool {
+	switch f.Field {
+	case FilterIP:
+		if h.RemoteAddr == "" {
+			return false
+		}
+		addr, err := netip.ParseAddr(h.RemoteAddr)
+		if err != nil {
+			return false
+		}
+		if !strings.Contains(f.Value, "/") {
+			ip, err := netip.ParseAddr(f.Value)
+			return err == nil && ip == addr.Unmap()
+		}
+		p, err := netip.ParsePrefix(f.Value)
+		return err == nil && p.Contains(addr.Unmap())
+	case FilterUserAgent:
+		if h.UserAgentHeader == "" {
+			return false
+		}
+		re, err := cachedRegexp(f.Value)
+		if err != nil {
+			return false
+		}
+		return re.MatchString(h.UserAgentHeader)
+	case FilterPath:
+		p, _, _ := strings.Cut(h.Path, "?")
+		ok, _ := path.Match(f.Value, p)
+		return ok
+	case FilterReferrer:
+		host := ""
+		if h.RefURL != nil {
+			host = h.RefURL.Host
+		} else if u, err := url.Parse(h.Ref); err == nil {
+			host = u.Host
+		}
+		host = strings.ToLower(host)
+		v := strings.ToLower(f.Value)
+		return host != "" && (host == v || strings.HasSuffix(host, "."+v))
+	case FilterLocation:
+		if h.Location == "" {
+			return false
+		}
+		if strings.Contains(f.Value, "-") {
+			return strings.EqualFold(h.Location, f.Value)
+		}
+		c, _, _ := strings.Cut(h.Location, "-")
+		return strings.EqualFold(c, f.Value)
+	case FilterQuery:
+		name, val, hasVal := strings.Cut(f.Value, "=")
+		for _, q := range []string{h.Query, queryOf(h.Path)} {
+			qs, err := url.ParseQuery(strings.TrimPrefix(q, "?"))
+			if err != nil || !qs.Has(name) {
+				continue
+			}
+			if !hasVal || qs.Get(name) == val {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Previewable reports if this rule can be tested with Preview().
+func (f FilterRule) Previewable() bool {
+	return f.Field == FilterPath || f.Field == FilterReferrer || f.Field == FilterLocation
+}
+
+func queryOf(p string) string {
+	_, q, _ := strings.Cut(p, "?")
+	return q
+}
+
+// Apply the filter rules to the hit, modifying it as needed.
+//
+// Returns false if the hit should be dropped.
+func (r FilterRules) Apply(h *Hit) bool {
+	for _, f := range r {
+		if !f.Match(h) {
+			continue
+		}
+		switch f.Action {
+		case FilterDrop:
+			return false
+		case FilterBot:
+			h.Bot = BotFilter
+		case FilterStripRef:
+			h.Ref, h.RefURL, h.RefScheme = "", nil, nil
+		}
+	}
+	return true
+}
+
+// Validate the rules.
+func (r FilterRules) Validate(ctx context.Context) error {
+	v := NewValidate(ctx)
+	for i, f := range r {
+		k := fmt.Sprintf("%d", i+1)
+		v.Include(k+".action", f.Action, FilterActions)
+		v.Include(k+".field", f.Field, FilterFields)
+		v.Required(k+".value", f.Value)
+		if f.Value == "" {
+			continue
+		}
+
+		switch f.Field {
+		case FilterIP:
+			if strings.Contains(f.Value, "/") {
+				if _, err := netip.ParsePrefix(f.Value); err != nil {
+					v.Append(k+".value", "not a valid CIDR range")
+				}
+			} else {
+				v.IP(k+".value", f.Value)
+			}
+		case FilterUserAgent:
+			if _, err := regexp.Compile(f.Value); err != nil {
+				v.Append(k+".value", err.Error())
+			}
+		case FilterPath:
+			if _, err := path.Match(f.Value, ""); err != nil {
+				v.Append(k+".value", err.Error())
+			}
+		}
+	}
+	return v.ErrorOrNil()
+}
+
+func (r FilterRules) String() string { return textLines(r) }
+
+// ParseFilterRules parses the text form of the rules, as returned by
+// FilterRules.String().
+func ParseFilterRules(text string) (FilterRules, error) {
+	rules := FilterRules{}
+	err := parseTextLines(text, func(line string) error {
+		f := strings.Fields(line)
+		if len(f) < 3 {
+			return fmt.Errorf("need \"action field value\": %q", line)
+		}
+		// The value may contain spaces, for User-Agent regexps.
+		val := strings.TrimSpace(line[len(f[0]):])
+		val = strings.TrimSpace(val[len(f[1]):])
+		rules = append(rules, FilterRule{
+			Action: strings.ToLower(f[0]),
+			Field:  strings.ToLower(f[1]),
+			Value:  val,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// FilterPreview is a pageview that matched a filter rule in Preview().
+type FilterPreview struct {
+	Rule      FilterRule `db:"-"`
+	Path      string     `db:"path"`
+	Ref       string     `db:"ref"`
+	Location  string     `db:"location"`
+	Bot       int        `db:"bot"`
+	CreatedAt time.Time  `db:"created_at"`
+}
+
+// Preview the filter rules against the last n stored pageviews.
+//
+// Only the path, referrer, and location are stored, so rules on other fields
+// are never matched; the returned list has the first matching rule for every
+// pageview.
+func (r FilterRules) Preview(ctx context.Context, n int) ([]FilterPreview, int, error) {
+	var hits []FilterPreview
+	err := zdb.Select(ctx, &hits, `/* FilterRules.Preview */
+		select
+			paths.path,
+			coalesce(refs.ref, '') as ref,
+			hits.location,
+			hits.bot,
+			hits.created_at
+		from hits
+		join paths using (path_id)
+		left join refs using (ref_id)
+		where hits.site_id = :site
+		order by hits.created_at desc
+		limit :n`,
+		map[string]any{"site": MustGetSite(ctx).ID, "n": n})
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "FilterRules.Preview")
+	}
+
+	matched := make([]FilterPreview, 0, 16)
+	for _, h := range hits {
+		hit := Hit{Path: h.Path, Ref: h.Ref, Location: h.Location}
+		if h.Ref != "" {
+			hit.Ref = "https://" + h.Ref
+		}
+		for _, f := range r {
+			if f.Match(&hit) {
+				h.Rule = f
+				matched = append(matched, h)
+				break
+			}
+		}
+	}
+	return matched, len(hits), nil
+}
@@ -0,0 +1,167 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter_test
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	. "zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zdb"
+)
+
+func TestFilterRuleMatch(t *testing.T) {
+	ref, _ := url.Parse("https://news.example.com/x")
+	hit := Hit{
+		RemoteAddr:      "192.0.2.42",
+		UserAgentHeader: "curl/8.1",
+		Path:            "/admin/users?page=2",
+		Query:           "?utm_source=spam",
+		Ref:             "https://news.example.com/x",
+		RefURL:          ref,
+		Location:        "US-TX",
+	}
+
+	tests := []struct {
+		field, value string
+		want         bool
+	}{
+		{FilterIP, "192.0.2.42", true},
+		{FilterIP, "192.0.2.0/24", true},
+		{FilterIP, "192.0.3.0/24", false},
+		{FilterIP, "::ffff:192.0.2.42", false},
+
+		{FilterUserAgent, "^curl/", true},
+		{FilterUserAgent, "(?i)firefox", false},
+
+		{FilterPath, "/admin/*", true},
+		{FilterPath, "/admin", false},
+		{FilterPath, "/admin/users", true},
+
+		{FilterReferrer, "example.com", true},
+		{FilterReferrer, "NEWS.example.com", true},
+		{FilterReferrer, "ample.com", false},
+
+		{FilterLocation, "us", true},
+		{FilterLocation, "US-TX", true},
+		{FilterLocation, "US-CA", false},
+		{FilterLocation, "NL", false},
+
+		{FilterQuery, "utm_source", true},
+		{FilterQuery, "utm_source=spam", true},
+		{FilterQuery, "utm_source=other", false},
+		{FilterQuery, "page=2", true},
+		{FilterQuery, "ref", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %s", tt.field, tt.value), func(t *testing.T) {
+			have := FilterRule{Action: FilterDrop, Field: tt.field, Value: tt.value}.Match(&hit)
+			if have != tt.want {
+				t.Errorf("have %t, want %t", have, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFilterRules(t *testing.T) {
+	have, err := ParseFilterRules(`
+		# Comment
+		drop  ip   192.0.2.0/24
+		BOT useragent Mozilla/5.0 \(X11;
+		strip-ref referrer example.com
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := FilterRules{
+		{Action: "drop", Field: "ip", Value: "192.0.2.0/24"},
+		{Action: "bot", Field: "useragent", Value: `Mozilla/5.0 \(X11;`},
+		{Action: "strip-ref", Field: "referrer", Value: "example.com"},
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("\nhave: %#v\nwant: %#v", have, want)
+	}
+
+	again, err := ParseFilterRules(have.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again, want) {
+		t.Errorf("round-trip\nhave: %#v\nwant: %#v", again, want)
+	}
+
+	_, err = ParseFilterRules("drop ip")
+	if err == nil {
+		t.Error("no error for missing value")
+	}
+
+	err = FilterRules{
+		{Action: "delete", Field: "ip", Value: "1.2.3.4"},
+		{Action: "drop", Field: "ip", Value: "1.2.3.4/99"},
+		{Action: "drop", Field: "useragent", Value: "("},
+		{Action: "drop", Field: "host", Value: "x"},
+	}.Validate(gctest.Context(nil))
+	if err == nil {
+		t.Fatal("no validation error")
+	}
+	for _, k := range []string{"1.action", "2.value", "3.value", "4.field"} {
+		if !strings.Contains(err.Error(), k) {
+			t.Errorf("no error for %s:\n%s", k, err)
+		}
+	}
+}
+
+func TestFilterRulesApply(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	var site Site
+	site.Defaults(ctx)
+	site.Settings.Collect.Set(CollectHits)
+	site.Settings.Filters = FilterRules{
+		{Action: FilterDrop, Field: FilterPath, Value: "/drop*"},
+		{Action: FilterBot, Field: FilterUserAgent, Value: "^curl/"},
+		{Action: FilterStripRef, Field: FilterReferrer, Value: "example.com"},
+	}
+	ctx = gctest.Site(ctx, t, &site, nil)
+
+	gctest.StoreHits(ctx, t, false,
+		Hit{Site: site.ID, Path: "/drop-me", UserAgentHeader: "Mozilla/5.0"},
+		Hit{Site: site.ID, Path: "/bot", UserAgentHeader: "curl/8.1"},
+		Hit{Site: site.ID, Path: "/ref", Ref: "https://www.example.com/x", UserAgentHeader: "Mozilla/5.0"},
+		Hit{Site: site.ID, Path: "/keep", Ref: "https://example.org/x", UserAgentHeader: "Mozilla/5.0"},
+	)
+
+	var have []struct {
+		Path string `db:"path"`
+		Ref  string `db:"ref"`
+		Bot  int    `db:"bot"`
+	}
+	err := zdb.Select(ctx, &have, `
+		select path, coalesce(ref, '') as ref, bot from hits
+		join paths using (path_id) left join refs using (ref_id)
+		order by path`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := fmt.Sprintf("%v", have); s != "[{/bot  200} {/keep example.org/x 0} {/ref  0}]" {
+		t.Errorf("wrong hits:\n%s", s)
+	}
+
+	matched, total, err := FilterRules{
+		{Action: FilterDrop, Field: FilterReferrer, Value: "example.org"},
+		{Action: FilterDrop, Field: FilterUserAgent, Value: "."},
+	}.Preview(WithSite(ctx, &site), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 || len(matched) != 1 || matched[0].Path != "/keep" {
+		t.Errorf("total: %d; matched: %#v", total, matched)
+	}
+}
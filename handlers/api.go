@@ -9,11 +9,12 @@ import (
 	"crypto/subtle"
 	"encoding/base64"
 	"fmt"
+	"maps"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"slices"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -23,6 +24,7 @@ import (
 	"zgo.at/bgrun"
 	"zgo.at/errors"
 	"zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/count"
 	"zgo.at/goatcounter/v2/cron"
 	"zgo.at/goatcounter/v2/metrics"
 	"zgo.at/guru"
@@ -64,6 +66,7 @@ func newAPI(apiMax int) api {
 	return api{apiMax: apiMax, dec: zhttp.NewDecoder(false, true)}
 }
 
+// Routes added here must also be documented in apiDocs.
 func (h api) mount(r chi.Router, db zdb.DB) {
 	h.apiMaxPaths = h.apiMax
 	if h.apiMax == 0 {
@@ -95,6 +98,7 @@ func (h api) mount(r chi.Router, db zdb.DB) {
 	a.Post("/api/v0/test", zhttp.Wrap(h.test))
 
 	a.Get("/api/v0/me", zhttp.Wrap(h.me))
+	a.Get("/api/v0/openapi.json", zhttp.Wrap(h.openAPI))
 
 	a.Post("/api/v0/export", zhttp.Wrap(h.export))
 	a.Get("/api/v0/export/{id}", zhttp.Wrap(h.exportGet))
@@ -103,19 +107,43 @@ func (h api) mount(r chi.Router, db zdb.DB) {
 	a.Post("/api/v0/count", zhttp.Wrap(h.count))
 
 	a.Get("/api/v0/paths", zhttp.Wrap(h.paths))
+	a.Post("/api/v0/paths/merge", zhttp.Wrap(h.pathsMerge))
+	a.Post("/api/v0/paths/purge", zhttp.Wrap(h.pathsPurge))
+	a.Get("/api/v0/paths/{id}", zhttp.Wrap(h.pathGet))
+	a.Post("/api/v0/paths/{id}", zhttp.Wrap(h.pathUpdate))  // Update all
+	a.Patch("/api/v0/paths/{id}", zhttp.Wrap(h.pathUpdate)) // Update just fields given
+	a.Get("/api/v0/campaigns", zhttp.Wrap(h.campaigns))
+	a.Post("/api/v0/campaigns/merge", zhttp.Wrap(h.campaignsMerge))
+	a.Get("/api/v0/campaigns/{id}", zhttp.Wrap(h.campaignGet))
+	a.Post("/api/v0/campaigns/{id}", zhttp.Wrap(h.campaignUpdate))  // Update all
+	a.Patch("/api/v0/campaigns/{id}", zhttp.Wrap(h.campaignUpdate)) // Update just fields given
 	a.Get("/api/v0/stats/total", zhttp.Wrap(h.countTotal))
 	a.Get("/api/v0/stats/hits", zhttp.Wrap(h.hits))
 	a.Get("/api/v0/stats/hits/{path_id}", zhttp.Wrap(h.refs))
+	a.Get("/api/v0/stats/series/{page}", zhttp.Wrap(h.statsSeries))
+	a.Get("/api/v0/stats/groups", zhttp.Wrap(h.statsGroups))
 	a.Get("/api/v0/stats/{page}", zhttp.Wrap(h.stats))
 	a.Get("/api/v0/stats/{page}/{id}", zhttp.Wrap(h.statsDetail))
 
-	// Note: DELETE not supported for sites and users intentionally, since it's
-	// such a dangerous operation.
+	// Note: DELETE not supported for sites intentionally, since it's such a
+	// dangerous operation.
 	a.Get("/api/v0/sites", zhttp.Wrap(h.siteList))
 	a.Put("/api/v0/sites", zhttp.Wrap(h.siteCreate))
 	a.Get("/api/v0/sites/{id}", zhttp.Wrap(h.siteGet))
 	a.Post("/api/v0/sites/{id}", zhttp.Wrap(h.siteUpdate))  // Update all
 	a.Patch("/api/v0/sites/{id}", zhttp.Wrap(h.siteUpdate)) // Update just fields given
+
+	a.Get("/api/v0/users", zhttp.Wrap(h.userList))
+	a.Put("/api/v0/users", zhttp.Wrap(h.userCreate))
+	a.Get("/api/v0/users/{id}", zhttp.Wrap(h.userGet))
+	a.Post("/api/v0/users/{id}", zhttp.Wrap(h.userUpdate))  // Update all
+	a.Patch("/api/v0/users/{id}", zhttp.Wrap(h.userUpdate)) // Update just fields given
+	a.Delete("/api/v0/users/{id}", zhttp.Wrap(h.userDelete))
+	a.Get("/api/v0/users/{id}/access", zhttp.Wrap(h.userAccessGet))
+	a.Post("/api/v0/users/{id}/access", zhttp.Wrap(h.userAccessUpdate))  // Update all
+	a.Patch("/api/v0/users/{id}/access", zhttp.Wrap(h.userAccessUpdate)) // Update just sites given
+
+	a.Get("/api/v0/audit-log", zhttp.Wrap(h.auditLog))
 }
 
 func tokenFromHeader(r *http.Request, w http.ResponseWriter) (string, error) {
@@ -157,7 +185,25 @@ func ResetBufferKey() {
 	bufferKey = []byte{}
 }
 
+const (
+	// Permissions that apply to the entire account, rather than one site.
+	apiPermAccount = goatcounter.APIPermSiteCreate | goatcounter.APIPermUsers | goatcounter.APIPermAuditLog
+)
+
+// auth checks the API token, and if it's allowed for the site the request is
+// made on.
 func (h api) auth(r *http.Request, w http.ResponseWriter, require zint.Bitflag64) error {
+	return h.authToken(r, w, require, true)
+}
+
+// authSites is like auth, but doesn't check if the token is allowed for the
+// site the request is made on. This is for the site management endpoints, which
+// check the site they operate on.
+func (h api) authSites(r *http.Request, w http.ResponseWriter, require zint.Bitflag64) error {
+	return h.authToken(r, w, require, false)
+}
+
+func (h api) authToken(r *http.Request, w http.ResponseWriter, require zint.Bitflag64, checkSite bool) error {
 	key, err := tokenFromHeader(r, w)
 	if err != nil {
 		w.Header().Set("WWW-Authenticate", "Basic realm=GoatCounter")
@@ -188,6 +234,13 @@ func (h api) auth(r *http.Request, w http.ResponseWriter, require zint.Bitflag64
 	if err != nil {
 		return err
 	}
+	if token.Expired() {
+		w.Header().Set("WWW-Authenticate", "Basic realm=GoatCounter")
+		return guru.New(http.StatusUnauthorized, "token expired")
+	}
+	if !token.AllowIP(r.RemoteAddr) {
+		return guru.New(http.StatusForbidden, "token can't be used from this IP address")
+	}
 
 	// Update once a day at the most.
 	if token.LastUsedAt == nil || token.LastUsedAt.Before(ztime.Now().Add(-24*time.Hour)) {
@@ -205,11 +258,23 @@ func (h api) auth(r *http.Request, w http.ResponseWriter, require zint.Bitflag64
 
 	// API is only for admins at the moment; other users shouldn't be able to
 	// create an API key, but just in case.
-	if !user.AccessAdmin() {
+	if !user.HasAccess(Site(r.Context()).ID, goatcounter.AccessAdmin) {
 		return guru.New(401, "only admins can create and use API keys")
 	}
+	// Managing users and the audit log affects all sites in the account.
+	if require&apiPermAccount != 0 && !user.AccessAdmin() {
+		return guru.New(http.StatusForbidden, "requires full access to all sites")
+	}
+	if len(token.Sites) > 0 {
+		if require&apiPermAccount != 0 {
+			return guru.New(http.StatusForbidden, "token is limited to specific sites")
+		}
+		if checkSite && !token.AllowSite(Site(r.Context()).ID) {
+			return guru.New(http.StatusForbidden, "token can't be used for this site")
+		}
+	}
 
-	*r = *r.WithContext(goatcounter.WithUser(r.Context(), &user))
+	*r = *r.WithContext(context.WithValue(goatcounter.WithUser(r.Context(), &user), keyAPIToken, &token))
 
 	if require == 0 {
 		return nil
@@ -370,7 +435,7 @@ func (h api) exportGet(w http.ResponseWriter, r *http.Request) error {
 // Export files are kept for 24 hours, after which they're deleted. This will
 // return a 400 Gone status code if the export has been deleted.
 //
-// Response 200 (text/csv): {data}
+// Response 200 (application/gzip): {data}
 // Response 202: zgo.at/goatcounter/v2/handlers.apiError
 // Response 400: zgo.at/goatcounter/v2/handlers.apiError
 func (h api) exportDownload(w http.ResponseWriter, r *http.Request) error {
@@ -419,96 +484,10 @@ func (h api) exportDownload(w http.ResponseWriter, r *http.Request) error {
 	return zhttp.Stream(w, fp)
 }
 
-type APICountRequest struct {
-	// By default it's an error to send pageviews that don't have either a
-	// Session or UserAgent and IP set. This avoids accidental errors.
-	//
-	// When this is set it will just continue without recording sessions for
-	// pageviews that don't have these parameters set.
-	NoSessions bool `json:"no_sessions"`
-
-	// Filter pageviews; accepted values:
-	//
-	//   ip     Ignore requests coming from IP addresses listed in "Settings → Ignore IP". Requires the IP field to be set.
-	//
-	// ["ip"] is used if this field isn't sent; send an empty array ([]) to not
-	// filter anything.
-	//
-	// The X-Goatcounter-Filter header will be set to a list of indexes if any
-	// pageviews are filtered; for example:
-	//
-	//    X-Goatcounter-Filter: 5, 10
-	//
-	// This header will be omitted if nothing is filtered.
-	Filter []string `json:"filter"`
-
-	// Hits is the list of pageviews.
-	Hits []APICountRequestHit `json:"hits"`
-}
-
-type APICountRequestHit struct {
-	// Path of the pageview, or the event name. {required}
-	Path string `json:"path" query:"p"`
-
-	// Page title, or some descriptive event title.
-	Title string `json:"title" query:"t"`
-
-	// Is this an event?
-	Event zbool.Bool `json:"event" query:"e"`
-
-	// Referrer value, can be an URL (i.e. the Referal: header) or any
-	// string.
-	Ref string `json:"ref" query:"r"`
-
-	// Screen size as "x,y,scaling"
-	Size goatcounter.Floats `json:"size" query:"s"`
-
-	// Query parameters for this pageview, used to get campaign parameters.
-	Query string `json:"query" query:"q"`
-
-	// Hint if this should be considered a bot; should be one of the JSBot*`
-	// constants from isbot; note the backend may override this if it
-	// detects a bot using another method.
-	// https://github.com/zgoat/isbot/blob/master/isbot.go#L28
-	Bot int `json:"bot" query:"b"`
-
-	// User-Agent header.
-	UserAgent string `json:"user_agent"`
-
-	// Location as ISO-3166-1 alpha2 string (e.g. NL, ID, etc.)
-	Location string `json:"location"`
-
-	// IP to get location from; not used if location is set. Also used for
-	// session generation.
-	IP string `json:"ip"`
-
-	// Time this pageview should be recorded at; this can be in the past,
-	// but not in the future.
-	CreatedAt time.Time `json:"created_at"`
-
-	// Normally a session is based on hash(User-Agent+IP+salt), but if you don't
-	// send the IP address then we can't determine the session.
-	//
-	// In those cases, you can store your own session identifiers and send them
-	// along. Note these will not be stored in the database as the sessionID
-	// (just as the hashes aren't), they're just used as a unique grouping
-	// identifier.
-	Session string `json:"session"`
-
-	// {omitdoc}
-	Host string `json:"-"`
-
-	// {omitdoc} Line when importing, for displaying errors.
-	Line string `json:"-"`
-	// {omitdoc} Line when importing, for displaying errors.
-	LineNo uint64 `json:"-"`
-}
-
-func (h APICountRequestHit) String() string {
-	return fmt.Sprintf(
-		`{Path: %q, Title: %q, Event: %t, Ref: %q, Size: "%s", Query: %q, Bot: %d, UserAgent: %q, Location: %q, IP: %q, CreatedAt: %q, Session: %q, Host: %q}`,
-		h.Path, h.Title, h.Event, h.Ref, h.Size, h.Query, h.Bot, h.UserAgent, h.Location, h.IP, h.CreatedAt, h.Session, h.Host)
-}
+type (
+	APICountRequest    = count.Request
+	APICountRequestHit = count.Hit
+)
 
 // POST /api/v0/count count
 // Count pageviews.
@@ -542,9 +521,9 @@ func (h api) count(w http.ResponseWriter, r *http.Request) error {
 		w.WriteHeader(400)
 		return zhttp.JSON(w, apiError{Error: "no hits"})
 	}
-	if len(args.Hits) > 500 {
+	if len(args.Hits) > count.MaxHits {
 		w.WriteHeader(400)
-		return zhttp.JSON(w, apiError{Error: "maximum amount of pageviews in one batch is 500"})
+		return zhttp.JSON(w, apiError{Error: fmt.Sprintf("maximum amount of pageviews in one batch is %d", count.MaxHits)})
 	}
 	if args.Filter == nil {
 		args.Filter = []string{"ip"}
@@ -575,7 +554,7 @@ func (h api) count(w http.ResponseWriter, r *http.Request) error {
 			Title:           a.Title,
 			Ref:             a.Ref,
 			Event:           a.Event,
-			Size:            a.Size,
+			Size:            goatcounter.Floats(a.Size),
 			Query:           a.Query,
 			Bot:             a.Bot,
 			CreatedAt:       a.CreatedAt.UTC(),
@@ -654,7 +633,7 @@ type apiSitesResponse struct {
 //
 // Response 200: apiSitesResponse
 func (h api) siteList(w http.ResponseWriter, r *http.Request) error {
-	err := h.auth(r, w, goatcounter.APIPermSiteRead)
+	err := h.authSites(r, w, goatcounter.APIPermSiteRead)
 	if err != nil {
 		return err
 	}
@@ -665,6 +644,10 @@ func (h api) siteList(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
+	u, token := User(r.Context()), apiToken(r.Context())
+	sites = slices.DeleteFunc(sites, func(s goatcounter.Site) bool {
+		return !u.HasAccess(s.ID, goatcounter.AccessReadOnly) || !token.AllowSite(s.ID)
+	})
 	return zhttp.JSON(w, apiSitesResponse{sites})
 }
 
@@ -685,6 +668,12 @@ func (h api) siteFind(r *http.Request) (*goatcounter.Site, error) {
 	if !(site.ID == siteID || (site.Parent != nil && *site.Parent == siteID)) {
 		return nil, guru.New(404, "")
 	}
+	if !User(r.Context()).HasAccess(site.ID, goatcounter.AccessReadOnly) {
+		return nil, guru.New(404, "")
+	}
+	if !apiToken(r.Context()).AllowSite(site.ID) {
+		return nil, guru.New(http.StatusForbidden, "token can't be used for this site")
+	}
 
 	return &site, nil
 }
@@ -696,7 +685,7 @@ func (h api) siteFind(r *http.Request) (*goatcounter.Site, error) {
 //
 // Response 200: goatcounter.Site
 func (h api) siteGet(w http.ResponseWriter, r *http.Request) error {
-	err := h.auth(r, w, goatcounter.APIPermSiteRead)
+	err := h.authSites(r, w, goatcounter.APIPermSiteRead)
 	if err != nil {
 		return err
 	}
@@ -725,11 +714,16 @@ func (h api) siteCreate(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
+	if !User(r.Context()).AccessAdmin() {
+		return guru.New(http.StatusForbidden, "creating sites requires full access to all sites")
+	}
+
 	site.Parent = &Site(r.Context()).ID
 	err = site.Insert(r.Context())
 	if err != nil {
 		return err
 	}
+	audit(r, goatcounter.AuditSiteCreate, site.ID, goatcounter.NewAuditDiff(nil, auditSite(site)))
 
 	return zhttp.JSON(w, site)
 }
@@ -751,7 +745,7 @@ type apiSiteUpdateRequest struct {
 // Request body: apiSiteUpdateRequest
 // Response 200: goatcounter.Site
 func (h api) siteUpdate(w http.ResponseWriter, r *http.Request) error {
-	err := h.auth(r, w, goatcounter.APIPermSiteUpdate)
+	err := h.authSites(r, w, goatcounter.APIPermSiteUpdate)
 	if err != nil {
 		return err
 	}
@@ -760,6 +754,9 @@ func (h api) siteUpdate(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	if !User(r.Context()).HasAccess(site.ID, goatcounter.AccessAdmin) {
+		return guru.New(http.StatusForbidden, "no access to update this site")
+	}
 
 	var args apiSiteUpdateRequest
 	if r.Method == http.MethodPatch {
@@ -773,6 +770,7 @@ func (h api) siteUpdate(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
+	before := auditSite(*site)
 	site.LinkDomain = args.LinkDomain
 	site.Cname = args.Cname
 	site.Settings = args.Settings
@@ -780,200 +778,944 @@ func (h api) siteUpdate(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	audit(r, goatcounter.AuditSiteSettings, site.ID, goatcounter.NewAuditDiff(before, auditSite(*site)))
 
 	return zhttp.JSON(w, site)
 }
 
-type (
-	apiPathsRequest struct {
-		// Limit number of returned results {range: 1-200, default: 20}
-		Limit int `json:"limit"`
+func (h api) userFind(r *http.Request) (*goatcounter.User, error) {
+	v := goatcounter.NewValidate(r.Context())
+	id := v.Integer("id", chi.URLParam(r, "id"))
+	if v.HasErrors() {
+		return nil, v
+	}
 
-		// Only select paths after this ID, for pagination.
-		After int64 `json:"after"`
+	var user goatcounter.User
+	err := user.ByID(r.Context(), id)
+	if err != nil {
+		return nil, err
 	}
-	apiPathsResponse struct {
-		// List of paths, sorted by ID.
-		Paths goatcounter.Paths `json:"paths"`
+	return &user, nil
+}
 
-		// True if there are more paths.
-		More bool `json:"more"`
+type (
+	apiUsersResponse struct {
+		Users goatcounter.Users `json:"users"`
+	}
+	apiUserRequest struct {
+		// Email address; required to log in.
+		Email string `json:"email"`
+
+		// Password to log in with. If this is empty when creating a user then
+		// an invitation email is sent to set a password. It's never changed
+		// on updates if it's empty.
+		Password string `json:"password"`
+
+		// Access for this user; this uses the same format as
+		// /api/v0/users/{id}/access.
+		Access goatcounter.UserAccesses `json:"access"`
 	}
 )
 
-// GET /api/v0/paths paths
-// Get an overview of paths on this site (without statistics).
+// GET /api/v0/users users
+// List all users.
 //
-// Query: apiPathsRequest
-// Response 200: apiPathsResponse
-func (h api) paths(w http.ResponseWriter, r *http.Request) error {
-	m := metrics.Start("/api/v0/stats/*")
-	defer m.Done()
-
-	err := h.auth(r, w, goatcounter.APIPermStats)
+// Response 200: apiUsersResponse
+func (h api) userList(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermUsers)
 	if err != nil {
 		return err
 	}
 
-	args := apiPathsRequest{Limit: 20}
-	if _, err := h.dec.Decode(r, &args); err != nil {
+	var users goatcounter.Users
+	err = users.List(r.Context(), Account(r.Context()).ID)
+	if err != nil {
 		return err
 	}
+	return zhttp.JSON(w, apiUsersResponse{users})
+}
 
-	if h.apiMaxPaths > 0 && args.Limit > h.apiMaxPaths {
-		args.Limit = h.apiMaxPaths
-	}
-	if args.Limit < 1 {
-		args.Limit = 1
+// GET /api/v0/users/{id} users
+// Get information about a user.
+//
+// Response 200: goatcounter.User
+func (h api) userGet(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermUsers)
+	if err != nil {
+		return err
 	}
 
-	var p goatcounter.Paths
-	more, err := p.List(r.Context(), goatcounter.MustGetSite(r.Context()).ID, args.After, args.Limit)
+	user, err := h.userFind(r)
 	if err != nil {
 		return err
 	}
-	return zhttp.JSON(w, apiPathsResponse{Paths: p, More: more})
+	return zhttp.JSON(w, user)
 }
 
-type (
-	apiHitsRequest struct {
-		// Start time, should be rounded to the hour {datetime, default: one week ago}.
-		Start time.Time `json:"start" query:"start"`
-
-		// End time, should be rounded to the hour {datetime, default: current time}.
-		End time.Time `json:"end" query:"end"`
-
-		// Group by day, rather than by hour. This only affects the Hits.Max
-		// value: if enabled it's set to the highest value for that day, rather
-		// than the highest value for the hour.
-		Daily bool `json:"daily" query:"daily"`
-
-		// Include only these paths; default is to include everything.
-		IncludePaths goatcounter.Ints `json:"include_paths" query:"include_paths"`
+// PUT /api/v0/users users
+// Add a new user.
+//
+// The user will get an email to set their password if no password is given.
+//
+// Request body: apiUserRequest
+// Response 200: goatcounter.User
+func (h api) userCreate(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermUsers)
+	if err != nil {
+		return err
+	}
 
-		// Exclude these paths, for pagination.
-		ExcludePaths goatcounter.Ints `json:"exclude_paths" query:"exclude_paths"`
+	var args apiUserRequest
+	_, err = h.dec.Decode(r, &args)
+	if err != nil {
+		return err
+	}
 
-		// Maximum number of pages to get {range: 1-100, default: 20}.
-		Limit int `json:"limit" query:"limit"`
+	account := Account(r.Context())
+	access, err := siteAccess(r.Context(), args.Access)
+	if err != nil {
+		return err
+	}
+	if access["all"] == goatcounter.AccessSuperuser && !User(r.Context()).AccessSuperuser() {
+		return guru.New(http.StatusForbidden, "can't set superuser if you're not a superuser yourself")
 	}
-	apiHitsResponse struct {
-		// Sorted list of paths with their visitor and pageview count.
-		Hits goatcounter.HitLists `json:"hits"`
 
-		// Total number of visitors in the returned result.
-		Total int `json:"total"`
+	newUser := goatcounter.User{
+		Email:  args.Email,
+		Site:   account.ID,
+		Access: access,
+	}
+	if args.Password != "" {
+		newUser.Password = []byte(args.Password)
+	}
+	if !goatcounter.Config(r.Context()).GoatcounterCom {
+		newUser.EmailVerified = true
+	}
 
-		// More hits after this?
-		More bool `json:"more"`
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		err := newUser.Insert(ctx, args.Password == "")
+		if err != nil {
+			return err
+		}
+		if args.Password == "" {
+			return newUser.InviteToken(ctx)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
-)
+	audit(r, goatcounter.AuditUserCreate, account.ID, goatcounter.NewAuditDiff(nil, auditUser(newUser)))
 
-// GET /api/v0/stats/hits stats
-// Get an overview of pageviews.
-//
-// Query: apiHitsRequest
-// Response 200: apiHitsResponse
-func (h api) hits(w http.ResponseWriter, r *http.Request) error {
-	m := metrics.Start("/api/v0/stats/*")
-	defer m.Done()
+	sendAddUserEmail(r.Context(), account, newUser)
+	return zhttp.JSON(w, newUser)
+}
 
-	err := h.auth(r, w, goatcounter.APIPermStats)
+// POST /api/v0/users/{id} users
+// PATCH /api/v0/users/{id} users
+// Update a user.
+//
+// A POST request will *replace* the email and access with what's sent. A PATCH
+// request will only update the fields that are sent. The password is only
+// changed if it's sent.
+//
+// Request body: apiUserRequest
+// Response 200: goatcounter.User
+func (h api) userUpdate(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermUsers)
 	if err != nil {
 		return err
 	}
 
-	args := apiHitsRequest{Limit: 20}
-	if _, err := h.dec.Decode(r, &args); err != nil {
+	user, err := h.userFind(r)
+	if err != nil {
 		return err
 	}
-	if h.apiMax > 0 && args.Limit > h.apiMax {
-		args.Limit = h.apiMax
+
+	var args apiUserRequest
+	if r.Method == http.MethodPatch {
+		args.Email = user.Email
+		args.Access = user.Access
 	}
-	if args.Limit < 1 {
-		args.Limit = 1
+	_, err = h.dec.Decode(r, &args)
+	if err != nil {
+		return err
 	}
-	if args.Start.IsZero() {
-		args.Start = ztime.AddPeriod(ztime.Now(), -7, ztime.Day)
+
+	access, err := siteAccess(r.Context(), args.Access)
+	if err != nil {
+		return err
 	}
-	if args.End.IsZero() {
-		args.End = ztime.Now()
+	if access["all"] == goatcounter.AccessSuperuser && user.Access["all"] != goatcounter.AccessSuperuser &&
+		!User(r.Context()).AccessSuperuser() {
+		return guru.New(http.StatusForbidden, "can't set superuser if you're not a superuser yourself")
 	}
 
-	var pages goatcounter.HitLists
-	tdu, more, err := pages.List(r.Context(), ztime.NewRange(args.Start).To(args.End),
-		args.IncludePaths, args.ExcludePaths, args.Limit, args.Daily)
+	before := auditUser(*user)
+	emailChanged := user.Email != args.Email
+	user.Email = args.Email
+	user.Access = access
+
+	err = zdb.TX(r.Context(), func(ctx context.Context) error {
+		err := user.Update(ctx, emailChanged)
+		if err != nil {
+			return err
+		}
+		if args.Password != "" {
+			return user.UpdatePassword(ctx, args.Password)
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
+	diff := goatcounter.NewAuditDiff(before, auditUser(*user))
+	if args.Password != "" {
+		diff["password"] = [2]any{nil, "changed"}
+	}
+	audit(r, goatcounter.AuditUserUpdate, Account(r.Context()).ID, diff)
 
-	return zhttp.JSON(w, apiHitsResponse{
-		Total: tdu,
-		Hits:  pages,
-		More:  more,
-	})
+	return zhttp.JSON(w, user)
 }
 
-type (
-	apiRefsRequest struct {
-		// Start time, should be rounded to the hour {datetime, default: one week ago}.
-		Start time.Time `json:"start" query:"start"`
-
-		// End time, should be rounded to the hour {datetime, default: current time}.
-		End time.Time `json:"end" query:"end"`
-
-		// Maximum number of pages to get {range: 1-100, default: 20}.
-		Limit int `json:"limit" query:"limit"`
+// DELETE /api/v0/users/{id} users
+// Remove a user.
+//
+// The last admin user can't be removed.
+//
+// Response 200: goatcounter.User
+func (h api) userDelete(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermUsers)
+	if err != nil {
+		return err
+	}
 
-		// Offset for pagination.
-		Offset int `json:"offset" query:"offset"`
+	user, err := h.userFind(r)
+	if err != nil {
+		return err
 	}
-	apiRefsResponse struct {
-		Refs []goatcounter.HitStat `json:"refs"`
-		More bool                  `json:"more"`
+
+	err = user.Delete(r.Context(), false)
+	if err != nil {
+		return err
 	}
-)
+	audit(r, goatcounter.AuditUserDelete, Account(r.Context()).ID, goatcounter.NewAuditDiff(auditUser(*user), nil))
 
-// GET /api/v0/stats/hits/{path_id} stats
-// Get an overview of referral information for a path.
+	return zhttp.JSON(w, user)
+}
+
+// GET /api/v0/users/{id}/access users
+// Get a user's access.
 //
-// Query: apiRefsRequest
-// Response 200: apiRefsResponse
-func (h api) refs(w http.ResponseWriter, r *http.Request) error {
-	m := metrics.Start("/api/v0/stats/*")
-	defer m.Done()
+// The "all" key is the access for all sites in the account; this can be
+// overridden per site by using the site ID as the key. The values are "r" (read
+// only), "s" (settings), "a" (admin), "*" (superuser; only for "all"), or "-"
+// (no access; only for sites).
+//
+// Response 200: goatcounter.UserAccesses
+func (h api) userAccessGet(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermUsers)
+	if err != nil {
+		return err
+	}
 
-	err := h.auth(r, w, goatcounter.APIPermStats)
+	user, err := h.userFind(r)
 	if err != nil {
 		return err
 	}
+	return zhttp.JSON(w, user.Access)
+}
 
-	v := zvalidate.New()
-	path := v.Integer("path_id", chi.URLParam(r, "path_id"))
-	if v.HasErrors() {
-		return v
+// POST /api/v0/users/{id}/access users
+// PATCH /api/v0/users/{id}/access users
+// Update a user's access.
+//
+// A POST request will *replace* the access with what's sent, removing the
+// access for any sites that aren't sent. A PATCH request will only update the
+// keys that are sent; use an empty string to remove the access for a site.
+//
+// Request body: goatcounter.UserAccesses
+// Response 200: goatcounter.UserAccesses
+func (h api) userAccessUpdate(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermUsers)
+	if err != nil {
+		return err
 	}
 
-	args := apiRefsRequest{Limit: 20}
-	if _, err := h.dec.Decode(r, &args); err != nil {
+	user, err := h.userFind(r)
+	if err != nil {
 		return err
 	}
-	if h.apiMax > 0 && args.Limit > h.apiMax {
-		args.Limit = h.apiMax
+
+	args := make(goatcounter.UserAccesses)
+	if r.Method == http.MethodPatch {
+		maps.Copy(args, user.Access)
 	}
-	if args.Limit < 1 {
-		args.Limit = 1
+	_, err = h.dec.Decode(r, &args)
+	if err != nil {
+		return err
 	}
-	if args.Start.IsZero() {
-		args.Start = ztime.AddPeriod(ztime.Now(), -7, ztime.Day)
+
+	access, err := siteAccess(r.Context(), args)
+	if err != nil {
+		return err
 	}
-	if args.End.IsZero() {
-		args.End = ztime.Now()
+	if access["all"] == goatcounter.AccessSuperuser && user.Access["all"] != goatcounter.AccessSuperuser &&
+		!User(r.Context()).AccessSuperuser() {
+		return guru.New(http.StatusForbidden, "can't set superuser if you're not a superuser yourself")
 	}
 
-	var refs goatcounter.HitStats
-	err = refs.ListRefsByPathID(r.Context(), path, ztime.NewRange(args.Start).To(args.End),
-		args.Limit, args.Offset)
+	before := auditUser(*user)
+	user.Access = access
+	err = user.Update(r.Context(), false)
+	if err != nil {
+		return err
+	}
+	audit(r, goatcounter.AuditUserUpdate, Account(r.Context()).ID, goatcounter.NewAuditDiff(before, auditUser(*user)))
+	return zhttp.JSON(w, user.Access)
+}
+
+type (
+	apiAuditLogRequest struct {
+		// Only select entries for this site.
+		SiteID int64 `json:"site_id" query:"site_id"`
+
+		// Only select entries for this user.
+		UserID int64 `json:"user_id" query:"user_id"`
+
+		// Only select entries with this action, for example "site.settings".
+		Action string `json:"action" query:"action"`
+
+		// Limit number of returned results {range: 1-100, default: 20}
+		Limit int `json:"limit" query:"limit"`
+
+		// Only select entries before this ID, for pagination.
+		Before int64 `json:"before" query:"before"`
+	}
+	apiAuditLogResponse struct {
+		// List of entries, newest first.
+		AuditLog goatcounter.AuditLogs `json:"audit_log"`
+
+		// True if there are more entries.
+		More bool `json:"more"`
+	}
+)
+
+// GET /api/v0/audit-log audit-log
+// Get the audit log for all sites in this account.
+//
+// Query: apiAuditLogRequest
+// Response 200: apiAuditLogResponse
+func (h api) auditLog(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermAuditLog)
+	if err != nil {
+		return err
+	}
+
+	args := apiAuditLogRequest{Limit: 20}
+	if _, err := h.dec.Decode(r, &args); err != nil {
+		return err
+	}
+
+	if h.apiMax > 0 && args.Limit > h.apiMax {
+		args.Limit = h.apiMax
+	}
+	if args.Limit < 1 {
+		args.Limit = 1
+	}
+
+	var l goatcounter.AuditLogs
+	more, err := l.List(r.Context(), goatcounter.AuditLogFilter{
+		SiteID: args.SiteID,
+		UserID: args.UserID,
+		Action: args.Action,
+	}, args.Before, args.Limit)
+	if err != nil {
+		return err
+	}
+	return zhttp.JSON(w, apiAuditLogResponse{AuditLog: l, More: more})
+}
+
+type (
+	apiPathsRequest struct {
+		// Limit number of returned results {range: 1-200, default: 20}
+		Limit int `json:"limit"`
+
+		// Only select paths after this ID, for pagination.
+		After int64 `json:"after"`
+	}
+	apiPathsResponse struct {
+		// List of paths, sorted by ID.
+		Paths goatcounter.Paths `json:"paths"`
+
+		// True if there are more paths.
+		More bool `json:"more"`
+	}
+)
+
+// GET /api/v0/paths paths
+// Get an overview of paths on this site (without statistics).
+//
+// Query: apiPathsRequest
+// Response 200: apiPathsResponse
+func (h api) paths(w http.ResponseWriter, r *http.Request) error {
+	m := metrics.Start("/api/v0/stats/*")
+	defer m.Done()
+
+	err := h.auth(r, w, goatcounter.APIPermStats)
+	if err != nil {
+		return err
+	}
+
+	args := apiPathsRequest{Limit: 20}
+	if _, err := h.dec.Decode(r, &args); err != nil {
+		return err
+	}
+
+	if h.apiMaxPaths > 0 && args.Limit > h.apiMaxPaths {
+		args.Limit = h.apiMaxPaths
+	}
+	if args.Limit < 1 {
+		args.Limit = 1
+	}
+
+	var p goatcounter.Paths
+	more, err := p.List(r.Context(), goatcounter.MustGetSite(r.Context()).ID, args.After, args.Limit)
+	if err != nil {
+		return err
+	}
+	return zhttp.JSON(w, apiPathsResponse{Paths: p, More: more})
+}
+
+func (h api) pathFind(r *http.Request) (*goatcounter.Path, error) {
+	v := goatcounter.NewValidate(r.Context())
+	id := v.Integer("id", chi.URLParam(r, "id"))
+	if v.HasErrors() {
+		return nil, v
+	}
+
+	var path goatcounter.Path
+	err := path.ByID(r.Context(), id)
+	if err != nil {
+		return nil, err
+	}
+	return &path, nil
+}
+
+// GET /api/v0/paths/{id} paths
+// Get information about a path.
+//
+// Response 200: goatcounter.Path
+func (h api) pathGet(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermStats)
+	if err != nil {
+		return err
+	}
+
+	path, err := h.pathFind(r)
+	if err != nil {
+		return err
+	}
+	return zhttp.JSON(w, path)
+}
+
+type apiPathUpdateRequest struct {
+	// Path name; this must not already exist, use /api/v0/paths/merge to
+	// merge paths.
+	Path string `json:"path"`
+
+	// Page title.
+	Title string `json:"title"`
+
+	// Hide this path from the list of pages on the dashboard. The pageviews
+	// are still counted and included in the totals.
+	Hidden bool `json:"hidden"`
+}
+
+// POST /api/v0/paths/{id} paths
+// PATCH /api/v0/paths/{id} paths
+// Rename a path, or change its title or visibility.
+//
+// A POST request will *replace* all fields with what's sent. A PATCH request
+// will only update the fields that are sent.
+//
+// Request body: apiPathUpdateRequest
+// Response 200: goatcounter.Path
+func (h api) pathUpdate(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermSiteUpdate)
+	if err != nil {
+		return err
+	}
+
+	path, err := h.pathFind(r)
+	if err != nil {
+		return err
+	}
+
+	var args apiPathUpdateRequest
+	if r.Method == http.MethodPatch {
+		args.Path = path.Path
+		args.Title = path.Title
+		args.Hidden = bool(path.Hidden)
+	}
+	_, err = h.dec.Decode(r, &args)
+	if err != nil {
+		return err
+	}
+
+	before := *path
+	path.Path = args.Path
+	path.Title = args.Title
+	path.Hidden = zbool.Bool(args.Hidden)
+	err = path.Update(r.Context())
+	if err != nil {
+		return err
+	}
+	audit(r, goatcounter.AuditPathUpdate, Site(r.Context()).ID, goatcounter.NewAuditDiff(before, *path))
+
+	return zhttp.JSON(w, path)
+}
+
+type apiPathsMergeRequest struct {
+	// Paths to merge; these paths will be removed {required}.
+	Paths []int64 `json:"paths"`
+
+	// Path to merge the pageviews in to {required}.
+	MergeWith int64 `json:"merge_with"`
+}
+
+// POST /api/v0/paths/merge paths
+// Merge paths.
+//
+// All pageviews for the paths are moved to merge_with, and the paths are
+// removed. This is done in the background and may take a minute.
+//
+// Request body: apiPathsMergeRequest
+// Response 202: {empty}
+func (h api) pathsMerge(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermPathsDelete)
+	if err != nil {
+		return err
+	}
+
+	var args apiPathsMergeRequest
+	_, err = h.dec.Decode(r, &args)
+	if err != nil {
+		return err
+	}
+
+	v := goatcounter.NewValidate(r.Context())
+	v.Required("paths", args.Paths)
+	v.Required("merge_with", args.MergeWith)
+	if v.HasErrors() {
+		return v
+	}
+
+	err = (&goatcounter.Path{}).ByID(r.Context(), args.MergeWith)
+	if err != nil {
+		return err
+	}
+
+	paths := slices.DeleteFunc(args.Paths, func(p int64) bool { return p == args.MergeWith })
+	audit(r, goatcounter.AuditPathsMerge, Site(r.Context()).ID,
+		goatcounter.NewAuditDiff(nil, map[string]any{"paths": paths, "merge_with": args.MergeWith}))
+
+	ctx := goatcounter.CopyContextValues(r.Context())
+	bgrun.RunFunction(fmt.Sprintf("merge:%d", Site(ctx).ID), func() {
+		var list goatcounter.Hits
+		err := list.Merge(ctx, args.MergeWith, paths)
+		if err != nil {
+			zlog.Error(err)
+		}
+	})
+
+	w.WriteHeader(http.StatusAccepted)
+	return zhttp.JSON(w, struct{}{})
+}
+
+type apiPathsPurgeRequest struct {
+	// Paths to remove, including all their pageviews {required}.
+	Paths []int64 `json:"paths"`
+}
+
+// POST /api/v0/paths/purge paths
+// Purge paths.
+//
+// Permanently remove the paths and all their pageviews. This is done in the
+// background and may take a minute.
+//
+// Request body: apiPathsPurgeRequest
+// Response 202: {empty}
+func (h api) pathsPurge(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermPathsDelete)
+	if err != nil {
+		return err
+	}
+
+	var args apiPathsPurgeRequest
+	_, err = h.dec.Decode(r, &args)
+	if err != nil {
+		return err
+	}
+
+	v := goatcounter.NewValidate(r.Context())
+	v.Required("paths", args.Paths)
+	if v.HasErrors() {
+		return v
+	}
+
+	audit(r, goatcounter.AuditPathsPurge, Site(r.Context()).ID,
+		goatcounter.NewAuditDiff(nil, map[string]any{"paths": args.Paths}))
+
+	ctx := goatcounter.CopyContextValues(r.Context())
+	bgrun.RunFunction(fmt.Sprintf("purge:%d", Site(ctx).ID), func() {
+		var list goatcounter.Hits
+		err := list.Purge(ctx, args.Paths)
+		if err != nil {
+			zlog.Error(err)
+		}
+	})
+
+	w.WriteHeader(http.StatusAccepted)
+	return zhttp.JSON(w, struct{}{})
+}
+
+type apiCampaignsResponse struct {
+	Campaigns goatcounter.Campaigns `json:"campaigns"`
+}
+
+// GET /api/v0/campaigns campaigns
+// Get a list of all campaigns for this site.
+//
+// Response 200: apiCampaignsResponse
+func (h api) campaigns(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermStats)
+	if err != nil {
+		return err
+	}
+
+	var c goatcounter.Campaigns
+	err = c.List(r.Context())
+	if err != nil {
+		return err
+	}
+	return zhttp.JSON(w, apiCampaignsResponse{Campaigns: c})
+}
+
+func (h api) campaignFind(r *http.Request) (*goatcounter.Campaign, error) {
+	v := goatcounter.NewValidate(r.Context())
+	id := v.Integer("id", chi.URLParam(r, "id"))
+	if v.HasErrors() {
+		return nil, v
+	}
+
+	var c goatcounter.Campaign
+	err := c.ByID(r.Context(), id)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// GET /api/v0/campaigns/{id} campaigns
+// Get information about a campaign.
+//
+// Response 200: goatcounter.Campaign
+func (h api) campaignGet(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermStats)
+	if err != nil {
+		return err
+	}
+
+	c, err := h.campaignFind(r)
+	if err != nil {
+		return err
+	}
+	return zhttp.JSON(w, c)
+}
+
+type apiCampaignUpdateRequest struct {
+	// Campaign name; this must not already exist, use
+	// /api/v0/campaigns/merge to merge campaigns.
+	Name string `json:"name"`
+
+	// Hide this campaign from the dashboard. The pageviews are still counted.
+	Hidden bool `json:"hidden"`
+}
+
+// POST /api/v0/campaigns/{id} campaigns
+// PATCH /api/v0/campaigns/{id} campaigns
+// Rename a campaign, or change its visibility.
+//
+// A POST request will *replace* all fields with what's sent. A PATCH request
+// will only update the fields that are sent.
+//
+// Request body: apiCampaignUpdateRequest
+// Response 200: goatcounter.Campaign
+func (h api) campaignUpdate(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermSiteUpdate)
+	if err != nil {
+		return err
+	}
+
+	c, err := h.campaignFind(r)
+	if err != nil {
+		return err
+	}
+
+	var args apiCampaignUpdateRequest
+	if r.Method == http.MethodPatch {
+		args.Name = c.Name
+		args.Hidden = bool(c.Hidden)
+	}
+	_, err = h.dec.Decode(r, &args)
+	if err != nil {
+		return err
+	}
+
+	before := *c
+	c.Name = strings.TrimSpace(args.Name)
+	c.Hidden = zbool.Bool(args.Hidden)
+	err = c.Update(r.Context())
+	if err != nil {
+		return err
+	}
+	audit(r, goatcounter.AuditCampaignUpdate, Site(r.Context()).ID, goatcounter.NewAuditDiff(before, *c))
+
+	return zhttp.JSON(w, c)
+}
+
+type apiCampaignsMergeRequest struct {
+	// Campaigns to merge; these campaigns will be removed {required}.
+	Campaigns []int64 `json:"campaigns"`
+
+	// Campaign to merge the stats in to {required}.
+	MergeWith int64 `json:"merge_with"`
+}
+
+// POST /api/v0/campaigns/merge campaigns
+// Merge campaigns.
+//
+// All stats for the campaigns are moved to merge_with, and the campaigns are
+// removed.
+//
+// Request body: apiCampaignsMergeRequest
+// Response 200: goatcounter.Campaign
+func (h api) campaignsMerge(w http.ResponseWriter, r *http.Request) error {
+	err := h.auth(r, w, goatcounter.APIPermSiteUpdate)
+	if err != nil {
+		return err
+	}
+
+	var args apiCampaignsMergeRequest
+	_, err = h.dec.Decode(r, &args)
+	if err != nil {
+		return err
+	}
+
+	v := goatcounter.NewValidate(r.Context())
+	v.Required("campaigns", args.Campaigns)
+	v.Required("merge_with", args.MergeWith)
+	if v.HasErrors() {
+		return v
+	}
+
+	var dst goatcounter.Campaign
+	err = dst.ByID(r.Context(), args.MergeWith)
+	if err != nil {
+		return err
+	}
+
+	campaigns := slices.DeleteFunc(args.Campaigns, func(c int64) bool { return c == args.MergeWith })
+	var list goatcounter.Campaigns
+	err = list.Merge(r.Context(), args.MergeWith, campaigns)
+	if err != nil {
+		return err
+	}
+	audit(r, goatcounter.AuditCampaignsMerge, Site(r.Context()).ID,
+		goatcounter.NewAuditDiff(nil, map[string]any{"campaigns": campaigns, "merge_with": args.MergeWith}))
+
+	return zhttp.JSON(w, dst)
+}
+
+type (
+	apiHitsRequest struct {
+		// Start time, should be rounded to the hour {datetime, default: one week ago}.
+		Start time.Time `json:"start" query:"start"`
+
+		// End time, should be rounded to the hour {datetime, default: current time}.
+		End time.Time `json:"end" query:"end"`
+
+		// Group by day, rather than by hour. This only affects the Hits.Max
+		// value: if enabled it's set to the highest value for that day, rather
+		// than the highest value for the hour.
+		//
+		// This is the same as group=day, and is ignored if group is set.
+		Daily bool `json:"daily" query:"daily"`
+
+		// Group the stats by hour, day, week, or month. For weeks and months
+		// the stats are per week or month rather than per day, and Hits.Max is
+		// the highest value for the week or month. Weeks start on Sunday or
+		// Monday depending on the user's settings {enum: hour day week month, default: hour}.
+		Group goatcounter.Group `json:"group" query:"group"`
+
+		// Include only these paths; default is to include everything.
+		IncludePaths goatcounter.Ints `json:"include_paths" query:"include_paths"`
+
+		// Exclude these paths, for pagination.
+		ExcludePaths goatcounter.Ints `json:"exclude_paths" query:"exclude_paths"`
+
+		// Maximum number of pages to get {range: 1-100, default: 20}.
+		Limit int `json:"limit" query:"limit"`
+
+		// Group the paths by directory below this prefix (e.g. "/" or
+		// "/docs/"), rather than listing every path. The result is in Tree
+		// rather than Hits. ExcludePaths and Group are ignored.
+		GroupByPrefix string `json:"group_by_prefix" query:"group_by_prefix"`
+	}
+	apiHitsResponse struct {
+		// Sorted list of paths with their visitor and pageview count.
+		Hits goatcounter.HitLists `json:"hits"`
+
+		// Directories and paths directly below the prefix; only set if
+		// group_by_prefix is used.
+		Tree goatcounter.PathNodes `json:"tree,omitempty"`
+
+		// Total number of visitors in the returned result.
+		Total int `json:"total"`
+
+		// More hits after this?
+		More bool `json:"more"`
+	}
+)
+
+// GET /api/v0/stats/hits stats
+// Get an overview of pageviews.
+//
+// Query: apiHitsRequest
+// Response 200: apiHitsResponse
+func (h api) hits(w http.ResponseWriter, r *http.Request) error {
+	m := metrics.Start("/api/v0/stats/*")
+	defer m.Done()
+
+	err := h.auth(r, w, goatcounter.APIPermStats)
+	if err != nil {
+		return err
+	}
+
+	args := apiHitsRequest{Limit: 20}
+	if _, err := h.dec.Decode(r, &args); err != nil {
+		return err
+	}
+	if h.apiMax > 0 && args.Limit > h.apiMax {
+		args.Limit = h.apiMax
+	}
+	if args.Limit < 1 {
+		args.Limit = 1
+	}
+	if args.Start.IsZero() {
+		args.Start = ztime.AddPeriod(ztime.Now(), -7, ztime.Day)
+	}
+	if args.End.IsZero() {
+		args.End = ztime.Now()
+	}
+	if args.Daily && args.Group == goatcounter.GroupHourly {
+		args.Group = goatcounter.GroupDaily
+	}
+
+	if args.GroupByPrefix != "" {
+		var tree goatcounter.PathNodes
+		more, err := tree.List(r.Context(), ztime.NewRange(args.Start).To(args.End),
+			args.GroupByPrefix, args.IncludePaths, args.Limit)
+		if err != nil {
+			return err
+		}
+		var total int
+		for _, n := range tree {
+			total += n.Count
+		}
+		return zhttp.JSON(w, apiHitsResponse{
+			Total: total,
+			Hits:  goatcounter.HitLists{},
+			Tree:  tree,
+			More:  more,
+		})
+	}
+
+	var pages goatcounter.HitLists
+	tdu, more, err := pages.List(r.Context(), ztime.NewRange(args.Start).To(args.End),
+		args.IncludePaths, args.ExcludePaths, args.Limit, args.Group)
+	if err != nil {
+		return err
+	}
+
+	return zhttp.JSON(w, apiHitsResponse{
+		Total: tdu,
+		Hits:  pages,
+		More:  more,
+	})
+}
+
+type (
+	apiRefsRequest struct {
+		// Start time, should be rounded to the hour {datetime, default: one week ago}.
+		Start time.Time `json:"start" query:"start"`
+
+		// End time, should be rounded to the hour {datetime, default: current time}.
+		End time.Time `json:"end" query:"end"`
+
+		// Maximum number of pages to get {range: 1-100, default: 20}.
+		Limit int `json:"limit" query:"limit"`
+
+		// Offset for pagination.
+		Offset int `json:"offset" query:"offset"`
+	}
+	apiRefsResponse struct {
+		Refs []goatcounter.HitStat `json:"refs"`
+		More bool                  `json:"more"`
+	}
+)
+
+// GET /api/v0/stats/hits/{path_id} stats
+// Get an overview of referral information for a path.
+//
+// Query: apiRefsRequest
+// Response 200: apiRefsResponse
+func (h api) refs(w http.ResponseWriter, r *http.Request) error {
+	m := metrics.Start("/api/v0/stats/*")
+	defer m.Done()
+
+	err := h.auth(r, w, goatcounter.APIPermStats)
+	if err != nil {
+		return err
+	}
+
+	v := zvalidate.New()
+	path := v.Integer("path_id", chi.URLParam(r, "path_id"))
+	if v.HasErrors() {
+		return v
+	}
+
+	args := apiRefsRequest{Limit: 20}
+	if _, err := h.dec.Decode(r, &args); err != nil {
+		return err
+	}
+	if h.apiMax > 0 && args.Limit > h.apiMax {
+		args.Limit = h.apiMax
+	}
+	if args.Limit < 1 {
+		args.Limit = 1
+	}
+	if args.Start.IsZero() {
+		args.Start = ztime.AddPeriod(ztime.Now(), -7, ztime.Day)
+	}
+	if args.End.IsZero() {
+		args.End = ztime.Now()
+	}
+
+	var refs goatcounter.HitStats
+	err = refs.ListRefsByPathID(r.Context(), path, ztime.NewRange(args.Start).To(args.End),
+		args.Limit, args.Offset)
 	if err != nil {
 		return err
 	}
@@ -1063,8 +1805,9 @@ type (
 // Get browser/system/etc. stats.
 //
 // Page can be: browsers, systems, locations, languages, sizes, campaigns,
-// toprefs.
+// channels, toprefs.
 //
+// Path parameter page: string
 // Query: apiStatsRequest
 // Response 200: apiStatsResponse
 func (h api) stats(w http.ResponseWriter, r *http.Request) error {
@@ -1073,7 +1816,7 @@ func (h api) stats(w http.ResponseWriter, r *http.Request) error {
 
 	v := goatcounter.NewValidate(r.Context())
 	page := v.Include("page", chi.URLParam(r, "page"), []string{
-		"browsers", "systems", "locations", "languages", "sizes", "campaigns", "toprefs"})
+		"browsers", "systems", "locations", "languages", "sizes", "campaigns", "channels", "toprefs"})
 	if v.HasErrors() {
 		return v
 	}
@@ -1119,6 +1862,8 @@ func (h api) stats(w http.ResponseWriter, r *http.Request) error {
 		}
 	case "campaigns":
 		f = stats.ListCampaigns
+	case "channels":
+		f = stats.ListChannels
 	case "toprefs":
 		f = stats.ListTopRefs
 	}
@@ -1144,8 +1889,17 @@ func (h api) stats(w http.ResponseWriter, r *http.Request) error {
 // GET /api/v0/stats/{page}/{id} stats
 // Get detailed stats for an ID.
 //
-// Page can be: browsers, systems, locations, sizes, campaigns, toprefs.
+// Page can be: browsers, systems, locations, sizes, campaigns, toprefs,
+// groups.
 //
+// For groups this lists the paths in the content group with that name.
+//
+// For campaigns this lists the utm_source and utm_medium for a campaign ID,
+// and the utm_content or utm_term if the ID from that is used; the IDs for
+// these contain a "?", which needs to be escaped as %3F.
+//
+// Path parameter page: string
+// Path parameter id: string
 // Query: apiStatsRequest
 // Response 200: apiStatsResponse
 func (h api) statsDetail(w http.ResponseWriter, r *http.Request) error {
@@ -1154,7 +1908,7 @@ func (h api) statsDetail(w http.ResponseWriter, r *http.Request) error {
 
 	v := goatcounter.NewValidate(r.Context())
 	page := v.Include("page", chi.URLParam(r, "page"), []string{
-		"browsers", "systems", "locations", "sizes", "campaigns", "toprefs"})
+		"browsers", "systems", "locations", "sizes", "campaigns", "toprefs", "groups"})
 	if v.HasErrors() {
 		return v
 	}
@@ -1196,16 +1950,26 @@ func (h api) statsDetail(w http.ResponseWriter, r *http.Request) error {
 		f = stats.ListSize
 	case "toprefs":
 		f = stats.ListTopRef
+	case "groups":
+		f = stats.ListGroup
 	case "campaigns":
 		f = func(ctx context.Context, id string, rng ztime.Range, pathFilter []int64, limit, offset int) error {
-			n, err := strconv.ParseInt(id, 0, 64)
+			k, err := goatcounter.ParseCampaignKey(id)
 			if err != nil {
-				return err
+				return guru.Errorf(http.StatusBadRequest, "invalid campaign ID: %q", id)
 			}
-			return stats.ListCampaign(ctx, n, rng, pathFilter, limit, offset)
+			return stats.ListCampaign(ctx, k, rng, pathFilter, limit, offset)
+		}
+	}
+	// chi uses the escaped path if it's set, so we need to unescape it.
+	id := chi.URLParam(r, "id")
+	if r.URL.RawPath != "" {
+		id, err = url.PathUnescape(id)
+		if err != nil {
+			return guru.Wrap(http.StatusBadRequest, err, "invalid ID")
 		}
 	}
-	err = f(r.Context(), chi.URLParam(r, "id"), ztime.NewRange(args.Start).To(args.End),
+	err = f(r.Context(), id, ztime.NewRange(args.Start).To(args.End),
 		args.IncludePaths, args.Limit, args.Offset)
 	if err != nil {
 		return err
@@ -1216,3 +1980,154 @@ func (h api) statsDetail(w http.ResponseWriter, r *http.Request) error {
 		More:  stats.More,
 	})
 }
+
+type (
+	apiStatsSeriesRequest struct {
+		// Start time, should be rounded to the hour {datetime, default: one week ago}.
+		Start time.Time `json:"start" query:"start"`
+
+		// End time, should be rounded to the hour {datetime, default: current time}.
+		End time.Time `json:"end" query:"end"`
+
+		// Include only these paths; default is to include everything.
+		IncludePaths goatcounter.Ints `json:"include_paths" query:"include_paths"`
+
+		// Number of items to get a time series for {range: 1-100, default: 5}.
+		Limit int `json:"limit" query:"limit"`
+
+		// Group the counts per day, week, or month; weeks start on Sunday or
+		// Monday depending on the user's settings {enum: day week month, default: day}.
+		Group string `json:"group" query:"group"`
+	}
+	apiStatsSeriesResponse struct {
+		// Top items sorted by the visitor count over the entire period, with
+		// the number of visitors for every day, week, or month.
+		Series []goatcounter.HitStatSeries `json:"series"`
+	}
+)
+
+// GET /api/v0/stats/series/{page} stats
+// Get the number of visitors over time for the top browsers/systems/etc.
+//
+// Page can be: browsers, systems, locations, languages, sizes, campaigns,
+// channels, groups.
+//
+// Path parameter page: string
+// Query: apiStatsSeriesRequest
+// Response 200: apiStatsSeriesResponse
+func (h api) statsSeries(w http.ResponseWriter, r *http.Request) error {
+	m := metrics.Start("/api/v0/stats/*")
+	defer m.Done()
+
+	err := h.auth(r, w, goatcounter.APIPermStats)
+	if err != nil {
+		return err
+	}
+
+	args := apiStatsSeriesRequest{Limit: 5, Group: "day"}
+	if _, err := h.dec.Decode(r, &args); err != nil {
+		return err
+	}
+
+	v := goatcounter.NewValidate(r.Context())
+	page := v.Include("page", chi.URLParam(r, "page"), []string{
+		"browsers", "systems", "locations", "languages", "sizes", "campaigns", "channels", "groups"})
+	v.Include("group", args.Group, []string{"day", "week", "month"})
+	if v.HasErrors() {
+		return v
+	}
+
+	if h.apiMax > 0 && args.Limit > h.apiMax {
+		args.Limit = h.apiMax
+	}
+	if args.Limit < 1 {
+		args.Limit = 1
+	}
+	if args.Start.IsZero() {
+		args.Start = ztime.AddPeriod(ztime.Now(), -7, ztime.Day)
+	}
+	if args.End.IsZero() {
+		args.End = ztime.Now()
+	}
+
+	group := ztime.Day
+	switch args.Group {
+	case "week":
+		group = ztime.Week(goatcounter.MustGetUser(r.Context()).Settings.SundayStartsWeek)
+	case "month":
+		group = ztime.Month
+	}
+
+	var series goatcounter.HitStatsSeries
+	err = series.List(r.Context(), page, ztime.NewRange(args.Start).To(args.End),
+		args.IncludePaths, group, args.Limit)
+	if err != nil {
+		return err
+	}
+	return zhttp.JSON(w, apiStatsSeriesResponse{Series: series.Series})
+}
+
+type (
+	apiStatsGroupsRequest struct {
+		// Start time, should be rounded to the hour {datetime, default: one week ago}.
+		Start time.Time `json:"start" query:"start"`
+
+		// End time, should be rounded to the hour {datetime, default: current time}.
+		End time.Time `json:"end" query:"end"`
+
+		// Include only these paths; default is to include everything.
+		IncludePaths goatcounter.Ints `json:"include_paths" query:"include_paths"`
+
+		// Include the path_ids for every group.
+		PathIDs bool `json:"path_ids" query:"path_ids"`
+	}
+	apiStatsGroupsResponse struct {
+		// All content groups, sorted by the number of visitors.
+		Groups goatcounter.PathGroupStats `json:"groups"`
+	}
+)
+
+// GET /api/v0/stats/groups stats
+// Get the number of visitors for all content groups.
+//
+// The path_ids for a group are only included if path_ids is set; these can be
+// used as include_paths for the other stats endpoints to get the referrers,
+// locations, etc. for just that group. Use
+// /api/v0/stats/series/groups for the number of visitors over time, and
+// /api/v0/stats/groups/{name} for the paths in a group.
+//
+// Query: apiStatsGroupsRequest
+// Response 200: apiStatsGroupsResponse
+func (h api) statsGroups(w http.ResponseWriter, r *http.Request) error {
+	m := metrics.Start("/api/v0/stats/*")
+	defer m.Done()
+
+	err := h.auth(r, w, goatcounter.APIPermStats)
+	if err != nil {
+		return err
+	}
+
+	var args apiStatsGroupsRequest
+	if _, err := h.dec.Decode(r, &args); err != nil {
+		return err
+	}
+	if args.Start.IsZero() {
+		args.Start = ztime.AddPeriod(ztime.Now(), -7, ztime.Day)
+	}
+	if args.End.IsZero() {
+		args.End = ztime.Now()
+	}
+
+	var groups goatcounter.PathGroupStats
+	err = groups.List(r.Context(), ztime.NewRange(args.Start).To(args.End), args.IncludePaths)
+	if err != nil {
+		return err
+	}
+	if args.PathIDs {
+		err = groups.ListPathIDs(r.Context(), args.IncludePaths)
+		if err != nil {
+			return err
+		}
+	}
+	return zhttp.JSON(w, apiStatsGroupsResponse{Groups: groups})
+}
@@ -11,12 +11,15 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"zgo.at/bgrun"
 	"zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/count"
 	"zgo.at/goatcounter/v2/gctest"
 	"zgo.at/json"
 	"zgo.at/zdb"
@@ -278,7 +281,7 @@ func TestAPICount(t *testing.T) {
 		// Fill in most fields.
 		{
 			APICountRequest{NoSessions: true, Hits: []APICountRequestHit{
-				{Path: "/foo", Title: "A", Ref: "y", UserAgent: "Mozilla/5.0 (Linux) Firefox/1", Location: "ET", Size: goatcounter.Floats{42, 666, 2}},
+				{Path: "/foo", Title: "A", Ref: "y", UserAgent: "Mozilla/5.0 (Linux) Firefox/1", Location: "ET", Size: count.Floats{42, 666, 2}},
 			}},
 			202, respOK, `
 			hit_id  site_id  path  title  event  browser    system  session                           bot  ref  ref_s  size        loc  first  created_at
@@ -289,7 +292,7 @@ func TestAPICount(t *testing.T) {
 		// Event
 		{
 			APICountRequest{NoSessions: true, Hits: []APICountRequestHit{
-				{Event: zbool.Bool(true), Path: "/foo", Title: "A", Ref: "y", UserAgent: "Mozilla/5.0 (Linux) Firefox/1", Location: "ET", Size: goatcounter.Floats{42, 666, 2}},
+				{Event: zbool.Bool(true), Path: "/foo", Title: "A", Ref: "y", UserAgent: "Mozilla/5.0 (Linux) Firefox/1", Location: "ET", Size: count.Floats{42, 666, 2}},
 			}},
 			202, respOK, `
 			hit_id  site_id  path  title  event  browser    system  session                           bot  ref  ref_s  size        loc  first  created_at
@@ -572,6 +575,326 @@ func TestAPISitesUpdate(t *testing.T) {
 	}
 }
 
+func TestAPIUserAccess(t *testing.T) {
+	tests := []struct {
+		method, body string
+		wantCode     int
+		want         string
+	}{
+		{"GET", ``, 200, `{"all":"a"}`},
+		{"PATCH", `{"2": "r"}`, 200, `{"2":"r","all":"a"}`},
+		{"PATCH", `{"2": ""}`, 200, `{"all":"a"}`},
+		{"POST", `{"all": "r"}`, 200, `{"all":"r"}`},
+		{"PATCH", `{"all": "x"}`, 400, `{"errors":{"access.all":["invalid value: \"x\""]}}`},
+		{"PATCH", `{"99": "a"}`, 400, ``},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			ctx := gctest.DB(t)
+			sub := goatcounter.Site{Code: "sub", Parent: ztype.Ptr(Site(ctx).ID)}
+			err := sub.Insert(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r, rr := newAPITest(ctx, t, tt.method, fmt.Sprintf("/api/v0/users/%d/access", User(ctx).ID),
+				strings.NewReader(tt.body), goatcounter.APIPermUsers)
+			newBackend(zdb.MustGetDB(ctx)).ServeHTTP(rr, r)
+			ztest.Code(t, rr, tt.wantCode)
+
+			if tt.want != "" {
+				if d := ztest.Diff(rr.Body.String(), tt.want, ztest.DiffJSON); d != "" {
+					t.Error(d)
+				}
+			}
+		})
+	}
+
+	t.Run("no perm", func(t *testing.T) {
+		ctx := gctest.DB(t)
+		r, rr := newAPITest(ctx, t, "GET", fmt.Sprintf("/api/v0/users/%d/access", User(ctx).ID),
+			nil, goatcounter.APIPermSiteRead)
+		newBackend(zdb.MustGetDB(ctx)).ServeHTTP(rr, r)
+		ztest.Code(t, rr, 403)
+	})
+}
+
+func TestAPIUsers(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	do := func(method, path, body string, wantCode int) *httptest.ResponseRecorder {
+		t.Helper()
+		r, rr := newAPITest(ctx, t, method, path, strings.NewReader(body), goatcounter.APIPermUsers)
+		newBackend(zdb.MustGetDB(ctx)).ServeHTTP(rr, r)
+		ztest.Code(t, rr, wantCode)
+		return rr
+	}
+
+	{ // Create
+		rr := do("PUT", "/api/v0/users", `{"email": "new@example.com", "access": {"all": "r"}}`, 200)
+		var u goatcounter.User
+		err := u.ByEmail(ctx, "new@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Access["all"] != goatcounter.AccessReadOnly || u.LoginRequest == nil || len(u.Password) > 0 {
+			t.Errorf("%#v", u)
+		}
+		if !strings.Contains(rr.Body.String(), `"email": "new@example.com"`) {
+			t.Error(rr.Body.String())
+		}
+
+		do("PUT", "/api/v0/users", `{"email": "new@example.com", "access": {"all": "r"}}`, 400)
+		do("PUT", "/api/v0/users", `{"email": "super@example.com", "access": {"all": "*"}}`, 403)
+	}
+
+	var newUser goatcounter.User
+	err := newUser.ByEmail(ctx, "new@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newID := newUser.ID
+
+	{ // List and get
+		rr := do("GET", "/api/v0/users", ``, 200)
+		var have apiUsersResponse
+		d := json.NewDecoder(rr.Body)
+		d.AllowReadonlyFields()
+		err := d.Decode(&have)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(have.Users) != 2 {
+			t.Errorf("%#v", have)
+		}
+
+		rr = do("GET", fmt.Sprintf("/api/v0/users/%d", newID), ``, 200)
+		if !strings.Contains(rr.Body.String(), `"email": "new@example.com"`) {
+			t.Error(rr.Body.String())
+		}
+		do("GET", "/api/v0/users/9999", ``, 404)
+	}
+
+	{ // Update
+		do("PATCH", fmt.Sprintf("/api/v0/users/%d", newID), `{"email": "changed@example.com"}`, 200)
+		var u goatcounter.User
+		err := u.ByID(ctx, newID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Email != "changed@example.com" || u.Access["all"] != goatcounter.AccessReadOnly {
+			t.Errorf("%#v", u)
+		}
+
+		do("POST", fmt.Sprintf("/api/v0/users/%d", newID),
+			`{"email": "changed@example.com", "password": "hunter2hunter2", "access": {"all": "s"}}`, 200)
+		u = goatcounter.User{}
+		err = u.ByID(ctx, newID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, _ := u.CorrectPassword("hunter2hunter2"); !ok || u.Access["all"] != goatcounter.AccessSettings {
+			t.Errorf("%#v", u)
+		}
+	}
+
+	{ // Delete
+		do("DELETE", fmt.Sprintf("/api/v0/users/%d", newID), ``, 200)
+		var u goatcounter.User
+		err := u.ByID(ctx, newID)
+		if !zdb.ErrNoRows(err) {
+			t.Fatalf("wrong error: %v", err)
+		}
+
+		do("DELETE", fmt.Sprintf("/api/v0/users/%d", User(ctx).ID), ``, 400)
+	}
+
+	var l goatcounter.AuditLogs
+	_, err = l.List(ctx, goatcounter.AuditLogFilter{}, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, e := range l {
+		actions = append(actions, e.Action)
+	}
+	want := []string{goatcounter.AuditUserDelete, goatcounter.AuditUserUpdate, goatcounter.AuditUserUpdate, goatcounter.AuditUserCreate}
+	if !slices.Equal(actions, want) {
+		t.Errorf("\nhave: %v\nwant: %v", actions, want)
+	}
+}
+
+func TestAPIAuditLog(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	r, rr := newAPITest(ctx, t, "PATCH", fmt.Sprintf("/api/v0/sites/%d", Site(ctx).ID),
+		strings.NewReader(`{"link_domain": "example.com"}`), goatcounter.APIPermSiteUpdate)
+	newBackend(zdb.MustGetDB(ctx)).ServeHTTP(rr, r)
+	ztest.Code(t, rr, 200)
+
+	r, rr = newAPITest(ctx, t, "GET", "/api/v0/audit-log?action=site.settings", nil, goatcounter.APIPermAuditLog)
+	newBackend(zdb.MustGetDB(ctx)).ServeHTTP(rr, r)
+	ztest.Code(t, rr, 200)
+
+	var have struct {
+		AuditLog goatcounter.AuditLogs `json:"audit_log"`
+		More     bool                  `json:"more"`
+	}
+	d := json.NewDecoder(rr.Body)
+	d.AllowReadonlyFields()
+	err := d.Decode(&have)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(have.AuditLog) != 1 || have.More {
+		t.Fatalf("%#v", have)
+	}
+	e := have.AuditLog[0]
+	if e.APITokenID == nil || e.Action != goatcounter.AuditSiteSettings {
+		t.Errorf("%#v", e)
+	}
+	if d := e.Diff["link_domain"]; d[0] != "" || d[1] != "example.com" {
+		t.Errorf("%#v", e.Diff)
+	}
+}
+
+func TestAPITokenRestrict(t *testing.T) {
+	tests := []struct {
+		name     string
+		token    func(sub int64) goatcounter.APIToken
+		now      string
+		remote   string
+		path     string
+		wantCode int
+	}{
+		{"no restrictions", func(int64) goatcounter.APIToken { return goatcounter.APIToken{} },
+			"", "", "/api/v0/test", 200},
+
+		{"not expired", func(int64) goatcounter.APIToken {
+			return goatcounter.APIToken{ExpiresAt: ztype.Ptr(ztime.FromString("2020-06-19"))}
+		}, "2020-06-18 23:59:59", "", "/api/v0/test", 200},
+		{"expired", func(int64) goatcounter.APIToken {
+			return goatcounter.APIToken{ExpiresAt: ztype.Ptr(ztime.FromString("2020-06-19"))}
+		}, "2020-06-19 00:00:00", "", "/api/v0/test", 401},
+
+		{"ip allowed", func(int64) goatcounter.APIToken {
+			return goatcounter.APIToken{AllowedIPs: goatcounter.Strings{"192.0.2.0/24", "2001:db8::1"}}
+		}, "", "192.0.2.42:1234", "/api/v0/test", 200},
+		{"ip allowed single", func(int64) goatcounter.APIToken {
+			return goatcounter.APIToken{AllowedIPs: goatcounter.Strings{"192.0.2.0/24", "2001:db8::1"}}
+		}, "", "[2001:db8::1]:1234", "/api/v0/test", 200},
+		{"ip denied", func(int64) goatcounter.APIToken {
+			return goatcounter.APIToken{AllowedIPs: goatcounter.Strings{"192.0.2.0/24", "2001:db8::1"}}
+		}, "", "198.51.100.1:1234", "/api/v0/test", 403},
+
+		{"site allowed", func(int64) goatcounter.APIToken {
+			return goatcounter.APIToken{Sites: goatcounter.Ints{1}}
+		}, "", "", "/api/v0/test", 200},
+		{"site denied", func(sub int64) goatcounter.APIToken {
+			return goatcounter.APIToken{Sites: goatcounter.Ints{sub}}
+		}, "", "", "/api/v0/test", 403},
+		{"site get denied", func(sub int64) goatcounter.APIToken {
+			return goatcounter.APIToken{Sites: goatcounter.Ints{sub}}
+		}, "", "", "/api/v0/sites/1", 403},
+		{"site get sub", func(sub int64) goatcounter.APIToken {
+			return goatcounter.APIToken{Sites: goatcounter.Ints{sub}}
+		}, "", "", "/api/v0/sites/2", 200},
+		{"site account-wide", func(sub int64) goatcounter.APIToken {
+			return goatcounter.APIToken{Sites: goatcounter.Ints{1, sub}}
+		}, "", "", "/api/v0/audit-log", 403},
+		{"path update allowed", func(int64) goatcounter.APIToken {
+			return goatcounter.APIToken{Sites: goatcounter.Ints{1}}
+		}, "", "", "/api/v0/paths/1", 200},
+		{"path update other site", func(sub int64) goatcounter.APIToken {
+			return goatcounter.APIToken{Sites: goatcounter.Ints{sub}}
+		}, "", "", "/api/v0/paths/1", 403},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := gctest.DB(t)
+			ztime.SetNow(t, "2020-06-18 12:00:00")
+			sub := goatcounter.Site{Code: "sub", Parent: ztype.Ptr(Site(ctx).ID)}
+			err := sub.Insert(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			gctest.StoreHits(ctx, t, false, goatcounter.Hit{Path: "/a"})
+
+			token := tt.token(sub.ID)
+			token.Name = "test"
+			token.Permissions = goatcounter.APIPermCount | goatcounter.APIPermSiteRead |
+				goatcounter.APIPermSiteUpdate | goatcounter.APIPermAuditLog
+			err = token.Insert(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.now != "" {
+				ztime.SetNow(t, tt.now)
+			}
+
+			var body io.Reader
+			method := "GET"
+			switch {
+			case tt.path == "/api/v0/test":
+				method, body = "POST", strings.NewReader(`{"perm": 2}`)
+			case strings.HasPrefix(tt.path, "/api/v0/paths/"):
+				method, body = "PATCH", strings.NewReader(`{"title": "changed"}`)
+			}
+			r, rr := newTest(ctx, method, tt.path, body)
+			r.Header.Set("Authorization", "Bearer "+token.Token)
+			if tt.remote != "" {
+				r.RemoteAddr = tt.remote
+			}
+			newBackend(zdb.MustGetDB(ctx)).ServeHTTP(rr, r)
+			ztest.Code(t, rr, tt.wantCode)
+
+			if method == "PATCH" {
+				var title string
+				err := zdb.Get(ctx, &title, `select title from paths where path_id = 1`)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if changed := title == "changed"; changed != (tt.wantCode == 200) {
+					t.Errorf("title: %q", title)
+				}
+			}
+		})
+	}
+
+	t.Run("rotate", func(t *testing.T) {
+		ctx := gctest.DB(t)
+		ztime.SetNow(t, "2020-06-18 12:00:00")
+
+		token := goatcounter.APIToken{Name: "test", Permissions: goatcounter.APIPermCount}
+		err := token.Insert(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		old := token.Token
+		err = token.Rotate(ctx, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		try := func(tok string, want int) {
+			t.Helper()
+			r, rr := newTest(ctx, "POST", "/api/v0/test", strings.NewReader(`{"perm": 2}`))
+			r.Header.Set("Authorization", "Bearer "+tok)
+			newBackend(zdb.MustGetDB(ctx)).ServeHTTP(rr, r)
+			ztest.Code(t, rr, want)
+		}
+
+		try(token.Token, 200)
+		try(old, 200)
+		ztime.SetNow(t, "2020-06-18 13:00:00")
+		try(token.Token, 200)
+		try(old, 401)
+	})
+}
+
 func TestAPIPaths(t *testing.T) {
 	ztime.SetNow(t, "2020-06-18 12:13:14")
 
@@ -612,7 +935,7 @@ func TestAPIPaths(t *testing.T) {
 			}, "", 200, `{
             "more": false,
             "paths": [
-                {"event": false, "id": 1, "path": "/a", "title": "Hello"}
+                {"event": false, "hidden": false, "id": 1, "path": "/a", "title": "Hello"}
             ]}`,
 		},
 
@@ -622,26 +945,26 @@ func TestAPIPaths(t *testing.T) {
 			}, "", 200, `{
 			"more": true,
 			"paths": [
-				{"event": false, "id": 1, "path": "/1", "title": "1 - 1"},
-				{"event": false, "id": 2, "path": "/2", "title": "2 - 2"},
-				{"event": false, "id": 3, "path": "/3", "title": "3 - 3"},
-				{"event": false, "id": 4, "path": "/4", "title": "4 - 4"},
-				{"event": false, "id": 5, "path": "/5", "title": "5 - 5"},
-				{"event": false, "id": 6, "path": "/6", "title": "6 - 6"},
-				{"event": false, "id": 7, "path": "/7", "title": "7 - 7"},
-				{"event": false, "id": 8, "path": "/8", "title": "8 - 8"},
-				{"event": false, "id": 9, "path": "/9", "title": "9 - 9"},
-				{"event": false, "id": 10, "path": "/10", "title": "10 - 10"},
-				{"event": false, "id": 11, "path": "/11", "title": "11 - 11"},
-				{"event": false, "id": 12, "path": "/12", "title": "12 - 12"},
-				{"event": false, "id": 13, "path": "/13", "title": "13 - 13"},
-				{"event": false, "id": 14, "path": "/14", "title": "14 - 14"},
-				{"event": false, "id": 15, "path": "/15", "title": "15 - 15"},
-				{"event": false, "id": 16, "path": "/16", "title": "16 - 16"},
-				{"event": false, "id": 17, "path": "/17", "title": "17 - 17"},
-				{"event": false, "id": 18, "path": "/18", "title": "18 - 18"},
-				{"event": false, "id": 19, "path": "/19", "title": "19 - 19"},
-				{"event": false, "id": 20, "path": "/20", "title": "20 - 20"}
+				{"event": false, "hidden": false, "id": 1, "path": "/1", "title": "1 - 1"},
+				{"event": false, "hidden": false, "id": 2, "path": "/2", "title": "2 - 2"},
+				{"event": false, "hidden": false, "id": 3, "path": "/3", "title": "3 - 3"},
+				{"event": false, "hidden": false, "id": 4, "path": "/4", "title": "4 - 4"},
+				{"event": false, "hidden": false, "id": 5, "path": "/5", "title": "5 - 5"},
+				{"event": false, "hidden": false, "id": 6, "path": "/6", "title": "6 - 6"},
+				{"event": false, "hidden": false, "id": 7, "path": "/7", "title": "7 - 7"},
+				{"event": false, "hidden": false, "id": 8, "path": "/8", "title": "8 - 8"},
+				{"event": false, "hidden": false, "id": 9, "path": "/9", "title": "9 - 9"},
+				{"event": false, "hidden": false, "id": 10, "path": "/10", "title": "10 - 10"},
+				{"event": false, "hidden": false, "id": 11, "path": "/11", "title": "11 - 11"},
+				{"event": false, "hidden": false, "id": 12, "path": "/12", "title": "12 - 12"},
+				{"event": false, "hidden": false, "id": 13, "path": "/13", "title": "13 - 13"},
+				{"event": false, "hidden": false, "id": 14, "path": "/14", "title": "14 - 14"},
+				{"event": false, "hidden": false, "id": 15, "path": "/15", "title": "15 - 15"},
+				{"event": false, "hidden": false, "id": 16, "path": "/16", "title": "16 - 16"},
+				{"event": false, "hidden": false, "id": 17, "path": "/17", "title": "17 - 17"},
+				{"event": false, "hidden": false, "id": 18, "path": "/18", "title": "18 - 18"},
+				{"event": false, "hidden": false, "id": 19, "path": "/19", "title": "19 - 19"},
+				{"event": false, "hidden": false, "id": 20, "path": "/20", "title": "20 - 20"}
 			]}`,
 		},
 
@@ -651,11 +974,11 @@ func TestAPIPaths(t *testing.T) {
 			}, "after=19&limit=5", 200, `{
 			"more": true,
 			"paths": [
-				{"event": false, "id": 20, "path": "/20", "title": "20 - 20"},
-				{"event": false, "id": 21, "path": "/21", "title": "21 - 21"},
-				{"event": false, "id": 22, "path": "/22", "title": "22 - 22"},
-				{"event": false, "id": 23, "path": "/23", "title": "23 - 23"},
-				{"event": false, "id": 24, "path": "/24", "title": "24 - 24"}
+				{"event": false, "hidden": false, "id": 20, "path": "/20", "title": "20 - 20"},
+				{"event": false, "hidden": false, "id": 21, "path": "/21", "title": "21 - 21"},
+				{"event": false, "hidden": false, "id": 22, "path": "/22", "title": "22 - 22"},
+				{"event": false, "hidden": false, "id": 23, "path": "/23", "title": "23 - 23"},
+				{"event": false, "hidden": false, "id": 24, "path": "/24", "title": "24 - 24"}
 			]}`,
 		},
 
@@ -665,11 +988,11 @@ func TestAPIPaths(t *testing.T) {
 			}, "after=45&limit=5", 200, `{
 			"more": false,
 			"paths": [
-				{"event": false, "id": 46, "path": "/46", "title": "46 - 46"},
-				{"event": false, "id": 47, "path": "/47", "title": "47 - 47"},
-				{"event": false, "id": 48, "path": "/48", "title": "48 - 48"},
-				{"event": false, "id": 49, "path": "/49", "title": "49 - 49"},
-				{"event": false, "id": 50, "path": "/50", "title": "50 - 50"}
+				{"event": false, "hidden": false, "id": 46, "path": "/46", "title": "46 - 46"},
+				{"event": false, "hidden": false, "id": 47, "path": "/47", "title": "47 - 47"},
+				{"event": false, "hidden": false, "id": 48, "path": "/48", "title": "48 - 48"},
+				{"event": false, "hidden": false, "id": 49, "path": "/49", "title": "49 - 49"},
+				{"event": false, "hidden": false, "id": 50, "path": "/50", "title": "50 - 50"}
 			]}`,
 		},
 
@@ -701,6 +1024,123 @@ func TestAPIPaths(t *testing.T) {
 	}
 }
 
+func TestAPIPathsManage(t *testing.T) {
+	ctx := gctest.DB(t)
+	now := ztime.Now()
+	gctest.StoreHits(ctx, t, false, []goatcounter.Hit{
+		{Site: 1, Path: "/a", CreatedAt: now},
+		{Site: 1, Path: "/b", CreatedAt: now},
+		{Site: 1, Path: "/c", CreatedAt: now},
+		{Site: 1, Path: "/d", CreatedAt: now},
+	}...)
+
+	do := func(method, path, body string, perm zint.Bitflag64, wantCode int) *httptest.ResponseRecorder {
+		t.Helper()
+		r, rr := newAPITest(ctx, t, method, path, strings.NewReader(body), perm)
+		newBackend(zdb.MustGetDB(ctx)).ServeHTTP(rr, r)
+		ztest.Code(t, rr, wantCode)
+		return rr
+	}
+	paths := func() string {
+		t.Helper()
+		var p []string
+		err := zdb.Select(ctx, &p, `select path || ':' || title || ':' || hidden from paths order by path_id`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Join(p, " ")
+	}
+
+	{ // Update
+		do("GET", "/api/v0/paths/1", ``, goatcounter.APIPermStats, 200)
+		do("GET", "/api/v0/paths/99", ``, goatcounter.APIPermStats, 404)
+		do("PATCH", "/api/v0/paths/1", `{"title": "x"}`, goatcounter.APIPermStats, 403)
+
+		rr := do("PATCH", "/api/v0/paths/1", `{"path": "/new", "hidden": true}`, goatcounter.APIPermSiteUpdate, 200)
+		if !strings.Contains(rr.Body.String(), `"path": "/new"`) {
+			t.Error(rr.Body.String())
+		}
+		do("POST", "/api/v0/paths/2", `{"path": "/B", "title": "B"}`, goatcounter.APIPermSiteUpdate, 200)
+		do("PATCH", "/api/v0/paths/3", `{"path": "/new"}`, goatcounter.APIPermSiteUpdate, 400)
+
+		want := "/new::1 /B:B:0 /c::0 /d::0"
+		if have := paths(); have != want {
+			t.Errorf("\nhave: %s\nwant: %s", have, want)
+		}
+	}
+
+	{ // Merge and purge
+		do("POST", "/api/v0/paths/merge", `{"paths": [3], "merge_with": 2}`, goatcounter.APIPermSiteUpdate, 403)
+		do("POST", "/api/v0/paths/merge", `{"paths": [3], "merge_with": 99}`, goatcounter.APIPermPathsDelete, 404)
+		do("POST", "/api/v0/paths/merge", `{"paths": [3], "merge_with": 2}`, goatcounter.APIPermPathsDelete, 202)
+		do("POST", "/api/v0/paths/purge", `{"paths": [4]}`, goatcounter.APIPermPathsDelete, 202)
+		bgrun.Wait("")
+
+		want := "/new::1 /B:B:0"
+		if have := paths(); have != want {
+			t.Errorf("\nhave: %s\nwant: %s", have, want)
+		}
+	}
+}
+
+func TestAPICampaignsManage(t *testing.T) {
+	ctx := gctest.DB(t)
+	gctest.StoreHits(ctx, t, false, []goatcounter.Hit{
+		{Site: 1, FirstVisit: true, Query: "utm_campaign=news"},
+		{Site: 1, FirstVisit: true, Query: "utm_campaign=News-Letter&utm_source=x"},
+		{Site: 1, FirstVisit: true, Query: "utm_campaign=other"},
+	}...)
+
+	do := func(method, path, body string, perm zint.Bitflag64, wantCode int) *httptest.ResponseRecorder {
+		t.Helper()
+		r, rr := newAPITest(ctx, t, method, path, strings.NewReader(body), perm)
+		newBackend(zdb.MustGetDB(ctx)).ServeHTTP(rr, r)
+		ztest.Code(t, rr, wantCode)
+		return rr
+	}
+	campaigns := func() string {
+		t.Helper()
+		return zdb.DumpString(ctx, `
+			select campaign_id, name, hidden,
+				(select sum(count) from campaign_stats s where s.campaign_id=campaigns.campaign_id) as count
+			from campaigns order by campaign_id`)
+	}
+
+	{ // List and update
+		rr := do("GET", "/api/v0/campaigns", ``, goatcounter.APIPermStats, 200)
+		if !strings.Contains(rr.Body.String(), `"visitors": 1`) {
+			t.Error(rr.Body.String())
+		}
+		do("GET", "/api/v0/campaigns/99", ``, goatcounter.APIPermStats, 404)
+		do("PATCH", "/api/v0/campaigns/1", `{"hidden": true}`, goatcounter.APIPermStats, 403)
+
+		do("PATCH", "/api/v0/campaigns/1", `{"name": "Newsletter", "hidden": true}`, goatcounter.APIPermSiteUpdate, 200)
+		do("PATCH", "/api/v0/campaigns/2", `{"name": "newsletter"}`, goatcounter.APIPermSiteUpdate, 400)
+
+		want := `
+			campaign_id  name         hidden  count
+			1            Newsletter   1       1
+			2            News-Letter  0       1
+			3            other        0       1`
+		if d := zdb.Diff(campaigns(), want); d != "" {
+			t.Error(d)
+		}
+	}
+
+	{ // Merge
+		do("POST", "/api/v0/campaigns/merge", `{"campaigns": [2], "merge_with": 99}`, goatcounter.APIPermSiteUpdate, 404)
+		do("POST", "/api/v0/campaigns/merge", `{"campaigns": [2], "merge_with": 1}`, goatcounter.APIPermSiteUpdate, 200)
+
+		want := `
+			campaign_id  name        hidden  count
+			1            Newsletter  1       2
+			3            other       0       1`
+		if d := zdb.Diff(campaigns(), want); d != "" {
+			t.Error(d)
+		}
+	}
+}
+
 func TestAPIHits(t *testing.T) {
 	ztime.SetNow(t, "2020-06-18 12:13:14")
 
@@ -732,6 +1172,7 @@ func TestAPIHits(t *testing.T) {
 			"total": 3,
 			"hits": [{
 				"count":  1,
+				"count_unique":  1,
 				"event":         false,
 				"max":           1,
 				"path":          "/50",
@@ -772,6 +1213,7 @@ func TestAPIHits(t *testing.T) {
 				}]
 			}, {
 				"count": 1,
+				"count_unique": 1,
 				"event": false,
 				"max": 1,
 				"path": "/49",
@@ -812,6 +1254,7 @@ func TestAPIHits(t *testing.T) {
 				}]
 			}, {
 				"count": 1,
+				"count_unique": 1,
 				"event": false,
 				"max": 1,
 				"path": "/48",
@@ -859,6 +1302,7 @@ func TestAPIHits(t *testing.T) {
 			"total": 1,
 			"hits": [{
 				"count": 1,
+				"count_unique": 1,
 				"event": false,
 				"max": 1,
 				"path": "/48",
@@ -886,6 +1330,7 @@ func TestAPIHits(t *testing.T) {
 			"total": 1,
 			"hits": [{
 				"count": 1,
+				"count_unique": 1,
 				"event": false,
 				"max": 1,
 				"path": "/10",
@@ -906,6 +1351,49 @@ func TestAPIHits(t *testing.T) {
 				}]
 			}]
 		}`},
+
+		{"week", "limit=1&include_paths=10&group=week&start=2020-06-10&end=2020-06-19", 200,
+			func(ctx context.Context, t *testing.T) { many(ctx, t) }, `{
+			"more": false,
+			"total": 1,
+			"hits": [{
+				"count": 1,
+				"count_unique": 1,
+				"event": false,
+				"max": 1,
+				"path": "/10",
+				"path_id": 10,
+				"title": "title - 10",
+				"stats": [{
+					"daily": 0,
+					"day": "2020-06-08",
+					"hourly": [0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0]
+				}, {
+					"daily": 1,
+					"day": "2020-06-15",
+					"hourly": [0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0]
+				}]
+			}]
+		}`},
+		{"invalid group", "group=year", 400, nil,
+			`{"error": "invalid query parameters: invalid group: \"year\"; must be one of hour, day, week, month"}`},
+
+		{"group_by_prefix", "group_by_prefix=/", 200,
+			func(ctx context.Context, t *testing.T) {
+				gctest.StoreHits(ctx, t, false,
+					goatcounter.Hit{Path: "/docs", FirstVisit: true},
+					goatcounter.Hit{Path: "/docs/api", FirstVisit: true},
+					goatcounter.Hit{Path: "/docs/faq", FirstVisit: true},
+					goatcounter.Hit{Path: "/about", Title: "About", FirstVisit: true})
+			}, `{
+			"more": false,
+			"total": 4,
+			"hits": [],
+			"tree": [
+				{"path": "/docs/", "dir": true, "paths": 3, "count": 3, "count_unique": 1},
+				{"path": "/about", "dir": false, "path_id": 4, "title": "About", "paths": 1, "count": 1, "count_unique": 1}
+			]
+		}`},
 	}
 
 	perm := goatcounter.APIPermStats
@@ -990,6 +1478,162 @@ func TestAPIStats(t *testing.T) {
 	}
 }
 
+func TestAPIStatsSeries(t *testing.T) {
+	ztime.SetNow(t, "2020-06-18 12:13:14")
+
+	setup := func(ctx context.Context, t *testing.T) {
+		ff := "Mozilla/5.0 (X11; Linux x86_64; rv:81.0) Gecko/20100101 Firefox/81.0"
+		gctest.StoreHits(ctx, t, false,
+			goatcounter.Hit{CreatedAt: ztime.FromString("2020-06-16 10:00:00"), UserAgentHeader: ff, FirstVisit: true},
+			goatcounter.Hit{CreatedAt: ztime.FromString("2020-06-18 10:00:00"), UserAgentHeader: ff, FirstVisit: true},
+			goatcounter.Hit{CreatedAt: ztime.FromString("2020-06-18 11:00:00"), UserAgentHeader: ff, FirstVisit: true},
+		)
+	}
+
+	tests := []struct {
+		name     string
+		page     string
+		query    string
+		wantCode int
+		setup    func(context.Context, *testing.T)
+		want     string
+	}{
+		{"no hits", "browsers", "", 200, nil, `{"series": []}`},
+		{"invalid page", "toprefs", "", 400, nil, `{"errors": {"page": ["must be one of ‘browsers, systems, locations, languages, sizes, campaigns, channels, groups’"]}}`},
+		{"invalid group", "browsers", "group=year", 400, nil, `{"errors": {"group": ["must be one of ‘day, week, month’"]}}`},
+
+		{"day", "browsers", "start=2020-06-15T00:00:00Z", 200, setup,
+			`{"series": [{
+				"count": 3, "id": "Firefox", "name": "Firefox",
+				"stats": [
+					{"count": 0, "day": "2020-06-15"},
+					{"count": 1, "day": "2020-06-16"},
+					{"count": 0, "day": "2020-06-17"},
+					{"count": 2, "day": "2020-06-18"}
+				]
+			}]}`},
+		{"week", "browsers", "group=week", 200, setup,
+			`{"series": [{
+				"count": 3, "id": "Firefox", "name": "Firefox",
+				"stats": [
+					{"count": 0, "day": "2020-06-08"},
+					{"count": 3, "day": "2020-06-15"}
+				]
+			}]}`},
+	}
+
+	perm := goatcounter.APIPermStats
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := gctest.DB(t)
+			if tt.setup != nil {
+				tt.setup(ctx, t)
+			}
+
+			r, rr := newAPITest(ctx, t, "GET", "/api/v0/stats/series/"+tt.page+"?"+tt.query, nil, perm)
+			newBackend(zdb.MustGetDB(ctx)).ServeHTTP(rr, r)
+			ztest.Code(t, rr, tt.wantCode)
+
+			if d := ztest.Diff(rr.Body.String(), tt.want, ztest.DiffJSON); d != "" {
+				t.Error(d)
+			}
+		})
+	}
+}
+
+func TestAPIStatsGroups(t *testing.T) {
+	ztime.SetNow(t, "2020-06-18 12:13:14")
+
+	setup := func(ctx context.Context, t *testing.T) {
+		site := goatcounter.MustGetSite(ctx)
+		site.Settings.PathGroups = goatcounter.PathGroups{
+			{Name: "Docs", Match: []string{"/docs/*"}},
+			{Name: "Pricing", Match: []string{"/pricing"}},
+			{Name: "Empty", Match: []string{"/nothing/*"}},
+		}
+		err := site.Update(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gctest.StoreHits(ctx, t, false,
+			goatcounter.Hit{Site: 1, FirstVisit: true, Path: "/docs/a"},
+			goatcounter.Hit{Site: 1, FirstVisit: true, Path: "/docs/b"},
+			goatcounter.Hit{Site: 1, FirstVisit: true, Path: "/docs/b"},
+			goatcounter.Hit{Site: 1, FirstVisit: true, Path: "/pricing"},
+			goatcounter.Hit{Site: 1, FirstVisit: true, Path: "/other"})
+	}
+
+	tests := []struct {
+		name     string
+		page     string
+		query    string
+		wantCode int
+		setup    func(context.Context, *testing.T)
+		want     string
+	}{
+		{"no groups", "groups", "", 200, nil, `{"groups": []}`},
+
+		{"groups", "groups", "", 200, setup, `{
+			"groups": [
+				{"name": "Docs", "count": 3, "count_unique": 1},
+				{"name": "Pricing", "count": 1, "count_unique": 1},
+				{"name": "Empty", "count": 0, "count_unique": 0}
+			]
+		}`},
+		{"path_ids", "groups", "path_ids=true", 200, setup, `{
+			"groups": [
+				{"name": "Docs", "path_ids": [1, 2], "count": 3, "count_unique": 1},
+				{"name": "Pricing", "path_ids": [3], "count": 1, "count_unique": 1},
+				{"name": "Empty", "count": 0, "count_unique": 0}
+			]
+		}`},
+		{"include", "groups", "include_paths=2,3&path_ids=true", 200, setup, `{
+			"groups": [
+				{"name": "Docs", "path_ids": [2], "count": 2, "count_unique": 1},
+				{"name": "Pricing", "path_ids": [3], "count": 1, "count_unique": 1},
+				{"name": "Empty", "count": 0, "count_unique": 0}
+			]
+		}`},
+
+		{"detail", "groups/Docs", "", 200, setup, `{
+			"more": false,
+			"stats": [
+				{"name": "/docs/b", "count": 2},
+				{"name": "/docs/a", "count": 1}
+			]
+		}`},
+
+		{"series", "series/groups", "start=2020-06-17T00:00:00Z", 200, setup, `{
+			"series": [
+				{"id": "Docs", "name": "Docs", "count": 3, "stats": [
+					{"day": "2020-06-17", "count": 0}, {"day": "2020-06-18", "count": 3}]},
+				{"id": "Pricing", "name": "Pricing", "count": 1, "stats": [
+					{"day": "2020-06-17", "count": 0}, {"day": "2020-06-18", "count": 1}]},
+				{"id": "Empty", "name": "Empty", "count": 0, "stats": [
+					{"day": "2020-06-17", "count": 0}, {"day": "2020-06-18", "count": 0}]}
+			]
+		}`},
+	}
+
+	perm := goatcounter.APIPermStats
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := gctest.DB(t)
+			if tt.setup != nil {
+				tt.setup(ctx, t)
+			}
+
+			r, rr := newAPITest(ctx, t, "GET", "/api/v0/stats/"+tt.page+"?"+tt.query, nil, perm)
+			newBackend(zdb.MustGetDB(ctx)).ServeHTTP(rr, r)
+			ztest.Code(t, rr, tt.wantCode)
+
+			if d := ztest.Diff(rr.Body.String(), tt.want, ztest.DiffJSON); d != "" {
+				t.Error(d)
+			}
+		})
+	}
+}
+
 func TestAPIStatsDetail(t *testing.T) {
 	ztime.SetNow(t, "2020-06-18 12:13:14")
 
@@ -1012,6 +1656,12 @@ func TestAPIStatsDetail(t *testing.T) {
 
 		gctest.StoreHits(ctx, t, false, h...)
 	}
+	utm := func(ctx context.Context, t *testing.T) {
+		gctest.StoreHits(ctx, t, false,
+			goatcounter.Hit{Site: 1, FirstVisit: true, Query: "utm_campaign=c&utm_source=news&utm_medium=email&utm_content=a&utm_term=shoes"},
+			goatcounter.Hit{Site: 1, FirstVisit: true, Query: "utm_campaign=c&utm_source=news&utm_medium=email&utm_content=b"},
+			goatcounter.Hit{Site: 1, FirstVisit: true, Query: "utm_campaign=c&utm_source=ad"})
+	}
 
 	tests := []struct {
 		name     string
@@ -1033,6 +1683,35 @@ func TestAPIStatsDetail(t *testing.T) {
 					{"count": 1, "name": "Firefox 10"}
 				]
 			}`},
+
+		{"campaign", "campaigns/1", "", 200,
+			func(ctx context.Context, t *testing.T) { utm(ctx, t) },
+			`{
+				"more": false,
+				"stats": [
+					{"count": 2, "id": "1?medium=email&source=news", "name": "news / email"},
+					{"count": 1, "id": "1?medium=&source=ad", "name": "ad"}
+				]
+			}`},
+		{"campaign content", "campaigns/1%3Fmedium=email&source=news", "", 200,
+			func(ctx context.Context, t *testing.T) { utm(ctx, t) },
+			`{
+				"more": false,
+				"stats": [
+					{"count": 1, "id": "1?content=a&medium=email&source=news", "name": "a"},
+					{"count": 1, "id": "1?content=b&medium=email&source=news", "name": "b"}
+				]
+			}`},
+		{"campaign term", "campaigns/1%3Fcontent=a&medium=email&source=news", "", 200,
+			func(ctx context.Context, t *testing.T) { utm(ctx, t) },
+			`{
+				"more": false,
+				"stats": [
+					{"count": 1, "name": "shoes"}
+				]
+			}`},
+		{"campaign invalid", "campaigns/x", "", 400, nil,
+			`{"error": "invalid campaign ID: \"x\""}`},
 	}
 
 	perm := goatcounter.APIPermStats
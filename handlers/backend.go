@@ -54,6 +54,7 @@ func (h backend) Mount(r chi.Router, db zdb.DB, dev bool, domainStatic string, d
 	}
 
 	r.Use(
+		addpeer(),
 		mware.RealIP(),
 		mware.WrapWriter(),
 		mware.Unpanic("zgo.at/goatcounter/v2/handlers.add"),
@@ -130,6 +130,60 @@ func TestBackendPagesMore(t *testing.T) {
 	}
 }
 
+func TestBackendPagesTree(t *testing.T) {
+	ctx := gctest.DB(t)
+	site := Site(ctx)
+	now := ztime.Now()
+
+	u := goatcounter.MustGetUser(ctx)
+	err := u.Settings.Widgets[0].SetSetting(ctx, "pages", "style", "tree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = u.Update(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gctest.StoreHits(ctx, t, false,
+		goatcounter.Hit{FirstVisit: true, Path: "/about"},
+		goatcounter.Hit{FirstVisit: true, Path: "/docs/api"},
+		goatcounter.Hit{FirstVisit: true, Path: "/docs/faq"},
+	)
+
+	period := fmt.Sprintf("period-start=%[1]s&period-end=%[1]s", now.Format("2006-01-02"))
+	get := func(url string) string {
+		r, rr := newTest(ctx, "GET", url, nil)
+		r.Host = site.Code + "." + goatcounter.Config(ctx).Domain
+		login(t, r)
+		newBackend(zdb.MustGetDB(ctx)).ServeHTTP(rr, r)
+		ztest.Code(t, rr, 200)
+		return rr.Body.String()
+	}
+
+	{
+		have := grep("data-prefix=|data-id=", get("/?"+period))
+		want := `
+			<tr data-prefix="/docs/"
+			<tr id="/about" data-id="1"`
+		if d := ztest.Diff(have, want, ztest.DiffNormalizeWhitespace); d != "" {
+			t.Error(d)
+		}
+	}
+
+	{
+		var body map[string]any
+		zjson.MustUnmarshal([]byte(get("/load-widget?widget=0&prefix=/docs/&"+period)), &body)
+		have := grep("data-id=", body["html"].(string))
+		want := `
+			<tr id="/docs/api" data-id="2"
+			<tr id="/docs/faq" data-id="3"`
+		if d := ztest.Diff(have, want, ztest.DiffNormalizeWhitespace); d != "" {
+			t.Error(d)
+		}
+	}
+}
+
 func TestServeNewSite(t *testing.T) {
 	emptySite := func(t *testing.T) context.Context {
 		ctx := gctest.DB(t)
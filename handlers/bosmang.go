@@ -40,6 +40,7 @@ func (h bosmang) mount(r chi.Router, db zdb.DB) {
 	a.Get("/bosmang/bgrun", zhttp.Wrap(h.bgrun))
 	a.Post("/bosmang/bgrun/{task}", zhttp.Wrap(h.runTask))
 	a.Get("/bosmang/metrics", zhttp.Wrap(h.metrics))
+	a.Get("/bosmang/refspam", zhttp.Wrap(h.refspam))
 	a.Handle("/bosmang/profile*", zprof.NewHandler(zprof.Prefix("/bosmang/profile")))
 
 	a.Get("/bosmang/sites", zhttp.Wrap(h.sites))
@@ -113,6 +114,13 @@ func (h bosmang) metrics(w http.ResponseWriter, r *http.Request) error {
 	}{newGlobals(w, r), metrics.List().Sort(by), by})
 }
 
+func (h bosmang) refspam(w http.ResponseWriter, r *http.Request) error {
+	return zhttp.Template(w, "bosmang_refspam.gohtml", struct {
+		Globals
+		Refspam goatcounter.RefspamStats
+	}{newGlobals(w, r), goatcounter.ListRefspam()})
+}
+
 func (h bosmang) sites(w http.ResponseWriter, r *http.Request) error {
 	var a goatcounter.BosmangStats
 	err := a.List(r.Context())
@@ -150,6 +158,11 @@ func (h bosmang) login(w http.ResponseWriter, r *http.Request) error {
 		return guru.New(403, "AllowBosmang not enabled")
 	}
 
+	err = user.Login(goatcounter.WithSite(r.Context(), &site), r.UserAgent(), r.RemoteAddr)
+	if err != nil {
+		return err
+	}
+
 	domain := cookieDomain(&site, r)
 	auth.SetCookie(w, *user.LoginToken, domain)
 	http.SetCookie(w, &http.Cookie{
@@ -69,12 +69,11 @@ func (h backend) dashboard(w http.ResponseWriter, r *http.Request) error {
 	if _, ok := q["filter"]; ok {
 		view.Filter = q.Get("filter")
 	}
-	if _, ok := q["daily"]; ok {
-		view.Daily = q.Get("daily") == "on" || q.Get("daily") == "true"
-	}
-	_, forcedDaily := getDaily(r, rng)
-	if forcedDaily {
-		view.Daily = true
+	group, forcedDaily := getGroup(r, rng)
+	if q.Has("group") || q.Has("daily") {
+		view.Group = group
+	} else if forcedDaily && view.Group == goatcounter.GroupHourly {
+		view.Group = goatcounter.GroupDaily
 	}
 
 	// Get path IDs to filter first, as they're used by the widgets.
@@ -115,7 +114,7 @@ func (h backend) dashboard(w http.ResponseWriter, r *http.Request) error {
 
 	args := widgets.Args{
 		Rng:         rng,
-		Daily:       view.Daily,
+		Group:       view.Group,
 		ForcedDaily: forcedDaily,
 		ShowRefs:    showRefs,
 	}
@@ -189,7 +188,7 @@ func (h backend) dashboard(w http.ResponseWriter, r *http.Request) error {
 
 	// Set shared params.
 	tc := wid.GetOne("totalcount").(*widgets.TotalCount)
-	shared.Total, shared.TotalUTC, shared.TotalEvents = tc.Total, tc.TotalUTC, tc.TotalEvents
+	shared.Total, shared.TotalUTC, shared.TotalEvents, shared.TotalUnique = tc.Total, tc.TotalUTC, tc.TotalEvents, tc.TotalUnique
 
 	// Render widget templates.
 	func() {
@@ -277,10 +276,11 @@ func (h backend) dashboard(w http.ResponseWriter, r *http.Request) error {
 		View        goatcounter.View
 		Total       int
 		TotalUTC    int
+		TotalUnique int
 		ConnectID   zint.Uint128
 	}{newGlobals(w, r), cd, subs, showRefs, rng,
 		args.PathFilter, forcedDaily, wid, view, shared.Total, shared.TotalUTC,
-		connectID})
+		shared.TotalUnique, connectID})
 }
 
 func (h backend) loadWidget(w http.ResponseWriter, r *http.Request) error {
@@ -295,7 +295,9 @@ func (h backend) loadWidget(w http.ResponseWriter, r *http.Request) error {
 		widget     = int(v.Integer("widget", r.URL.Query().Get("widget")))
 		key        = r.URL.Query().Get("key")
 		total      = int(v.Integer("total", r.URL.Query().Get("total")))
+		unique     = int(v.Integer("total_unique", r.URL.Query().Get("total_unique")))
 		offset     = int(v.Integer("offset", r.URL.Query().Get("offset")))
+		prefix     = r.URL.Query().Get("prefix")
 		pathFilter = getPathFilter(&v, r)
 	)
 	if v.HasErrors() {
@@ -303,11 +305,12 @@ func (h backend) loadWidget(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	args := widgets.SharedData{
-		Site:     Site(r.Context()),
-		User:     User(r.Context()),
-		TotalUTC: total,
-		Total:    total,
-		RowsOnly: key != "" || offset > 0,
+		Site:        Site(r.Context()),
+		User:        User(r.Context()),
+		TotalUTC:    total,
+		Total:       total,
+		TotalUnique: unique,
+		RowsOnly:    key != "" || offset > 0 || prefix != "",
 		Args: widgets.Args{
 			Rng:        rng,
 			PathFilter: pathFilter,
@@ -328,10 +331,12 @@ func (h backend) loadWidget(w http.ResponseWriter, r *http.Request) error {
 		p := wid.(*widgets.Pages)
 
 		args.RowsOnly = true
-		args.Args.Daily, args.Args.ForcedDaily = getDaily(r, rng)
+		args.Args.Group, args.Args.ForcedDaily = getGroup(r, rng)
 
 		if key != "" {
 			p.RefsForPath, _ = strconv.ParseInt(key, 10, 64)
+		} else if p.Style == "tree" {
+			p.Prefix = prefix
 		} else {
 			p.Max, err = strconv.Atoi(r.URL.Query().Get("max"))
 			if err != nil {
@@ -439,12 +444,25 @@ func getPeriod(w http.ResponseWriter, r *http.Request, site *goatcounter.Site, u
 	return rng.From(rng.Start).To(rng.End).UTC(), nil
 }
 
-func getDaily(r *http.Request, rng ztime.Range) (daily bool, forced bool) {
+// getGroup gets the grouping from the "group" query parameter, or the "daily"
+// parameter for older URLs.
+//
+// The hourly view is forced to daily for long time ranges.
+func getGroup(r *http.Request, rng ztime.Range) (group goatcounter.Group, forcedDaily bool) {
+	q := r.URL.Query()
+	if g := q.Get("group"); g != "" {
+		_ = group.UnmarshalText([]byte(strings.ToLower(g)))
+	} else if d := strings.ToLower(q.Get("daily")); d == "on" || d == "true" {
+		group = goatcounter.GroupDaily
+	}
+
 	if rng.End.Sub(rng.Start).Hours()/24 >= DailyView {
-		return true, true
+		forcedDaily = true
+		if group == goatcounter.GroupHourly {
+			group = goatcounter.GroupDaily
+		}
 	}
-	d := strings.ToLower(r.URL.Query().Get("daily"))
-	return d == "on" || d == "true", false
+	return group, forcedDaily
 }
 
 func getPathFilter(v *zvalidate.Validator, r *http.Request) []int64 {
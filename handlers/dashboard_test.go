@@ -5,9 +5,13 @@
 package handlers
 
 import (
+	"context"
+	"strconv"
 	"testing"
 	"time"
 
+	"zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
 	"zgo.at/zstd/ztime"
 )
 
@@ -20,6 +24,47 @@ func TestDashboard(t *testing.T) {
 			wantCode: 200,
 			wantBody: "<strong>No data received</strong>",
 		},
+		{
+			name:   "no-site-access",
+			router: newBackend,
+			auth:   true,
+			setup: func(ctx context.Context, t *testing.T) {
+				u := goatcounter.MustGetUser(ctx)
+				u.Access = goatcounter.UserAccesses{"all": "a", strconv.FormatInt(Site(ctx).ID, 10): "-"}
+				err := u.Update(ctx, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantCode: 403,
+			wantBody: "have access to this site",
+		},
+		{
+			name:   "group",
+			router: newBackend,
+			path:   "/?group=week",
+			auth:   true,
+			setup: func(ctx context.Context, t *testing.T) {
+				gctest.StoreHits(ctx, t, false, goatcounter.Hit{Path: "/a", FirstVisit: true})
+			},
+			wantCode: 200,
+			wantBody: `<option value="week" selected>`,
+		},
+		{
+			name:   "readonly-settings",
+			router: newBackend,
+			path:   "/settings/main",
+			auth:   true,
+			setup: func(ctx context.Context, t *testing.T) {
+				u := goatcounter.MustGetUser(ctx)
+				u.Access = goatcounter.UserAccesses{"all": "a", strconv.FormatInt(Site(ctx).ID, 10): "r"}
+				err := u.Update(ctx, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantCode: 401,
+		},
 	}
 
 	for _, tt := range tests {
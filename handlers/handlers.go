@@ -16,6 +16,7 @@ import (
 	"zgo.at/z18n"
 	"zgo.at/zhttp"
 	"zgo.at/zhttp/mware"
+	"zgo.at/zlog"
 	"zgo.at/zstd/zfs"
 )
 
@@ -55,6 +56,52 @@ func User(ctx context.Context) *goatcounter.User    { return goatcounter.MustGet
 
 var T = z18n.T
 
+var keyAPIToken = &struct{ n string }{""}
+
+// apiToken gets the API token used for this request; this is the zero value if
+// the request wasn't made with an API token.
+func apiToken(ctx context.Context) goatcounter.APIToken {
+	if t, ok := ctx.Value(keyAPIToken).(*goatcounter.APIToken); ok {
+		return *t
+	}
+	return goatcounter.APIToken{}
+}
+
+// audit adds an entry to the audit log. Errors are logged but not returned, as
+// the action will already have been done.
+func audit(r *http.Request, action string, siteID int64, diff goatcounter.AuditDiff) {
+	a := goatcounter.AuditLog{
+		SiteID: siteID,
+		Action: action,
+		Diff:   diff,
+		IP:     r.RemoteAddr,
+	}
+	if t, ok := r.Context().Value(keyAPIToken).(*goatcounter.APIToken); ok {
+		a.APITokenID = &t.ID
+	}
+	err := a.Insert(r.Context())
+	if err != nil {
+		zlog.FieldsRequest(r).Error(err)
+	}
+}
+
+// Fields to record in the audit log for sites, users, and API tokens; we don't
+// want to record the entire object, as most of it isn't interesting.
+func auditSite(s goatcounter.Site) map[string]any {
+	return map[string]any{"id": s.ID, "code": s.Code, "cname": s.Cname,
+		"link_domain": s.LinkDomain, "settings": s.Settings}
+}
+func auditUser(u goatcounter.User) map[string]any {
+	return map[string]any{"id": u.ID, "email": u.Email, "access": u.Access}
+}
+func auditSession(s goatcounter.UserSession) map[string]any {
+	return map[string]any{"id": s.ID, "device": s.Device(), "ip": s.IP}
+}
+func auditToken(t goatcounter.APIToken) map[string]any {
+	return map[string]any{"id": t.ID, "name": t.Name, "permissions": t.FormatPermissions(),
+		"expires_at": t.ExpiresAt, "sites": t.Sites, "allowed_ips": t.AllowedIPs}
+}
+
 type Globals struct {
 	Context        context.Context
 	User           *goatcounter.User
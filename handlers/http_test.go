@@ -70,13 +70,15 @@ func TestMain(m *testing.M) {
 		// Don't need tests.
 		"", "bosmang.gohtml", "bosmang_site.gohtml", "bosmang_cache.gohtml",
 		"bosmang_bgrun.gohtml", "bosmang_metrics.gohtml", "bosmang_sites.gohtml",
+		"bosmang_refspam.gohtml",
 		"i18n_list.gohtml", "i18n_show.gohtml", "i18n_manage.gohtml",
 
 		// Tested in tpl_test.go
 		"email_export_done.gotxt", "email_forgot_site.gotxt",
 		"email_import_done.gotxt", "email_import_error.gotxt",
 		"email_password_reset.gotxt", "email_verify.gotxt",
-		"email_adduser.gotxt", "_email_bottom.gohtml", "email_report.gohtml",
+		"email_adduser.gotxt", "email_locked.gotxt", "email_new_device.gotxt",
+		"_email_bottom.gohtml", "email_report.gohtml",
 		"email_report.gotxt",
 
 		// TODO
@@ -175,7 +177,7 @@ func login(t *testing.T, r *http.Request) {
 
 	// Login user
 	u := User(r.Context())
-	err := u.Login(r.Context())
+	err := u.Login(r.Context(), "", "")
 	if err != nil {
 		t.Fatal(err)
 	}
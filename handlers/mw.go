@@ -8,6 +8,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"net/netip"
 	"os"
 	"runtime"
 	"strings"
@@ -41,27 +42,50 @@ var (
 	}
 
 	loggedIn = auth.Filter(func(w http.ResponseWriter, r *http.Request) error {
+		if err := proxyLogin(w, r); err != nil {
+			return err
+		}
+
 		u := goatcounter.GetUser(r.Context())
 		if u != nil && u.ID > 0 {
+			if !u.HasAccess(Site(r.Context()).ID, goatcounter.AccessReadOnly) {
+				return guru.New(403, T(r.Context(), "error/no-site-access|You don’t have access to this site"))
+			}
 			err := u.UpdateOpenAt(r.Context())
 			if err != nil {
 				zlog.Error(err)
 			}
+			if u.Session != nil {
+				err := u.Session.UpdateLastUsed(r.Context())
+				if err != nil {
+					zlog.Error(err)
+				}
+			}
 			return nil
 		}
 		return redirect(w, r)
 	})
 
 	loggedInOrPublic = auth.Filter(func(w http.ResponseWriter, r *http.Request) error {
+		if err := proxyLogin(w, r); err != nil {
+			return err
+		}
+
+		s := Site(r.Context())
 		u := goatcounter.GetUser(r.Context())
-		if u != nil && u.ID > 0 {
+		if u != nil && u.ID > 0 && u.HasAccess(s.ID, goatcounter.AccessReadOnly) {
 			err := u.UpdateOpenAt(r.Context())
 			if err != nil {
 				zlog.Error(err)
 			}
+			if u.Session != nil {
+				err := u.Session.UpdateLastUsed(r.Context())
+				if err != nil {
+					zlog.Error(err)
+				}
+			}
 			return nil
 		}
-		s := Site(r.Context())
 		if s.Settings.IsPublic() {
 			return nil
 		}
@@ -82,13 +106,33 @@ var (
 			return nil
 		}
 
+		// Logged in, but no access to this site; redirecting to the login page
+		// would just redirect back here.
+		if u != nil && u.ID > 0 {
+			return guru.New(403, T(r.Context(), "error/no-site-access|You don’t have access to this site"))
+		}
 		return redirect(w, r)
 	})
 
+	// requireAccess requires that the user has at least this access for the
+	// current site.
 	requireAccess = func(atLeast goatcounter.UserAccess) func(http.Handler) http.Handler {
 		return auth.Filter(func(w http.ResponseWriter, r *http.Request) error {
 			u := goatcounter.GetUser(r.Context())
-			if u != nil && u.ID > 0 && u.HasAccess(atLeast) {
+			if u != nil && u.ID > 0 && u.HasAccess(Site(r.Context()).ID, atLeast) {
+				return nil
+			}
+			return guru.Errorf(401, "Not allowed to view this page")
+		})
+	}
+
+	// requireAccountAccess requires that the user has at least this access for
+	// all sites in the account; this is used for managing the sites and users in
+	// the account.
+	requireAccountAccess = func(atLeast goatcounter.UserAccess) func(http.Handler) http.Handler {
+		return auth.Filter(func(w http.ResponseWriter, r *http.Request) error {
+			u := goatcounter.GetUser(r.Context())
+			if u != nil && u.ID > 0 && u.Access["all"].AtLeast(atLeast) {
 				return nil
 			}
 			return guru.Errorf(401, "Not allowed to view this page")
@@ -102,6 +146,97 @@ var (
 	}, "/bosmang/profile/setrate")
 )
 
+var keyPeerAddr = &struct{ n string }{""}
+
+// addpeer stores the address of the peer that's connecting to us, before
+// mware.RealIP() replaces the RemoteAddr with the value from the proxy headers.
+func addpeer() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*r = *r.WithContext(context.WithValue(r.Context(), keyPeerAddr, r.RemoteAddr))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// trustedProxy reports if the peer connecting to us is in the list of trusted
+// proxies.
+func trustedProxy(r *http.Request, trusted []netip.Prefix) bool {
+	peer, _ := r.Context().Value(keyPeerAddr).(string)
+	addr, err := netip.ParseAddrPort(peer)
+	ip := addr.Addr()
+	if err != nil {
+		ip, err = netip.ParseAddr(peer)
+		if err != nil {
+			return false
+		}
+	}
+	ip = ip.Unmap()
+	for _, t := range trusted {
+		if t.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyLogin logs in the user from the header set by an authenticating reverse
+// proxy, if this is enabled with "serve -auth-proxy" and the request comes from
+// a trusted proxy.
+//
+// This sets the regular login cookie and redirects to the same page, so that
+// the next request is handled as any other logged in request (including the
+// CSRF checks).
+func proxyLogin(w http.ResponseWriter, r *http.Request) error {
+	c := goatcounter.Config(r.Context())
+	if c.AuthProxyHeader == "" {
+		return nil
+	}
+	email := strings.TrimSpace(r.Header.Get(c.AuthProxyHeader))
+	if email == "" || !trustedProxy(r, c.AuthProxyTrusted) {
+		return nil
+	}
+	if u := goatcounter.GetUser(r.Context()); u != nil && u.ID > 0 && strings.EqualFold(u.Email, email) {
+		return nil
+	}
+
+	var u goatcounter.User
+	err := u.ByEmail(r.Context(), email)
+	if err != nil {
+		if !zdb.ErrNoRows(err) {
+			return err
+		}
+		if c.AuthProxyCreate == "" {
+			zlog.Module("auth-proxy").Debugf("no user %q; not logging in", email)
+			return nil
+		}
+
+		account, err := goatcounter.GetAccount(r.Context())
+		if err != nil {
+			return err
+		}
+		u = goatcounter.User{
+			Site:          account.ID,
+			Email:         email,
+			EmailVerified: true,
+			Settings:      account.UserDefaults,
+			Access:        goatcounter.UserAccesses{"all": c.AuthProxyCreate},
+		}
+		err = u.Insert(r.Context(), true)
+		if err != nil {
+			return err
+		}
+		zlog.Module("auth-proxy").Printf("created user %q for site %d", email, account.ID)
+	}
+
+	err = u.Login(r.Context(), r.UserAgent(), r.RemoteAddr)
+	if err != nil {
+		return err
+	}
+	auth.SetCookie(w, *u.LoginToken, cookieDomain(Site(r.Context()), r))
+	return guru.New(303, r.URL.RequestURI())
+}
+
 type statusWriter interface{ Status() int }
 
 func addctx(db zdb.DB, loadSite bool, dashTimeout int) func(http.Handler) http.Handler {
@@ -278,7 +413,7 @@ func noSites(db zdb.DB, w http.ResponseWriter, r *http.Request) {
 				return err
 			}
 
-			err = u.Login(ctx)
+			err = u.Login(ctx, r.UserAgent(), r.RemoteAddr)
 			if err != nil {
 				return err
 			}
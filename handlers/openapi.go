@@ -0,0 +1,310 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package handlers
+
+import (
+	"encoding"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"zgo.at/goatcounter/v2"
+	"zgo.at/json"
+	"zgo.at/zhttp"
+	"zgo.at/zstd/zbool"
+	"zgo.at/zstd/zint"
+)
+
+// apiDoc documents an API route for the OpenAPI document served at
+// /api/v0/openapi.json.
+//
+// The list of routes in apiDocs is generated from the handler's doc comments
+// and h.auth() calls by TestOpenAPIDocs; run "go test -run TestOpenAPIDocs
+// -update" after changing them. Every route mounted in api.mount() must be
+// documented; this is checked in TestOpenAPI.
+type apiDoc struct {
+	method, path string
+	tag, summary string
+	perm         zint.Bitflag64 // Required permissions.
+	public       bool           // Doesn't require authentication.
+
+	query  any // Query parameters; zero value of the type.
+	body   any // Request body; zero value of the type.
+	status int // Success status; default is 200.
+	resp   any // Response body; nil for a {"status":"ok"} response.
+
+	// Content type of the response, if it's not JSON.
+	respType string
+
+	// Types of the path parameters, documented as "Path parameter id: string";
+	// the default is integer.
+	params map[string]string
+}
+
+// Routes that are intentionally not documented.
+var apiUndocumented = []string{
+	"GET /api/v0/test",
+	"POST /api/v0/test",
+}
+
+// GET /api/v0/openapi.json docs
+// Get the OpenAPI 3.1 document for this API.
+//
+// Response 200: {data}
+func (h api) openAPI(w http.ResponseWriter, r *http.Request) error {
+	url := "https://www.goatcounter.com"
+	if s := goatcounter.GetSite(r.Context()); s != nil {
+		url = s.URL(r.Context())
+	}
+	return zhttp.JSON(w, newOpenAPI(url, apiDocs))
+}
+
+var reOpenAPIParam = regexp.MustCompile(`{(\w+)}`)
+
+// newOpenAPI creates an OpenAPI 3.1 document for the routes in docs.
+func newOpenAPI(serverURL string, docs []apiDoc) map[string]any {
+	var (
+		g     = openAPIGen{schemas: make(map[string]any)}
+		paths = make(map[string]map[string]any)
+		tags  = make(map[string]struct{})
+	)
+
+	g.schemas["apiError"] = g.schema(reflect.TypeOf(apiError{}))
+	g.schemas["authError"] = g.schema(reflect.TypeOf(authError{}))
+	errResp := func(desc, name string) map[string]any {
+		return map[string]any{"description": desc, "content": map[string]any{
+			"application/json": map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/" + name}}}}
+	}
+
+	for _, d := range docs {
+		tags[d.tag] = struct{}{}
+
+		var params []any
+		for _, m := range reOpenAPIParam.FindAllStringSubmatch(d.path, -1) {
+			typ := d.params[m[1]]
+			if typ == "" {
+				typ = "integer"
+			}
+			params = append(params, map[string]any{
+				"name": m[1], "in": "path", "required": true, "schema": map[string]any{"type": typ}})
+		}
+		if d.query != nil {
+			for _, f := range g.fields(reflect.TypeOf(d.query)) {
+				params = append(params, map[string]any{
+					"name": f.name, "in": "query", "schema": f.schema})
+			}
+		}
+
+		status := d.status
+		if status == 0 {
+			status = 200
+		}
+		var content map[string]any
+		switch {
+		case d.respType != "":
+			content = map[string]any{d.respType: map[string]any{}}
+		case d.resp != nil:
+			content = map[string]any{"application/json": map[string]any{"schema": g.schema(reflect.TypeOf(d.resp))}}
+		default:
+			content = map[string]any{"application/json": map[string]any{"schema": map[string]any{"type": "object"}}}
+		}
+
+		op := map[string]any{
+			"tags":        []string{d.tag},
+			"summary":     d.summary,
+			"operationId": strings.ToLower(d.method) + openAPIOpID(d.path),
+			"responses": map[string]any{
+				strconv.Itoa(status): map[string]any{"description": http.StatusText(status), "content": content},
+				"400":                errResp("Invalid request", "apiError"),
+				"401":                errResp("Not authenticated", "authError"),
+				"403":                errResp("Not allowed", "authError"),
+			},
+		}
+		if d.public {
+			op["security"] = []any{}
+		}
+		if d.perm != 0 {
+			perms := goatcounter.APIToken{Permissions: d.perm}.PermissionNames()
+			op["description"] = "Requires the " + strings.Join(perms, ", ") + " permission."
+			op["x-permissions"] = perms
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		if d.body != nil {
+			op["requestBody"] = map[string]any{"required": true, "content": map[string]any{
+				"application/json": map[string]any{"schema": g.schema(reflect.TypeOf(d.body))}}}
+		}
+
+		if paths[d.path] == nil {
+			paths[d.path] = make(map[string]any)
+		}
+		paths[d.path][strings.ToLower(d.method)] = op
+	}
+
+	tagList := make([]map[string]any, 0, len(tags))
+	for t := range tags {
+		tagList = append(tagList, map[string]any{"name": t})
+	}
+	sort.Slice(tagList, func(i, j int) bool { return tagList[i]["name"].(string) < tagList[j]["name"].(string) })
+
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":       "GoatCounter",
+			"version":     "0.1",
+			"description": "Reference documentation for the GoatCounter API; see https://www.goatcounter.com/help/api for a more general introduction.",
+			"contact": map[string]any{
+				"name":  "Martin Tournoij",
+				"url":   "https://www.goatcounter.com/help/api",
+				"email": "support@goatcounter.com",
+			},
+		},
+		"servers":  []any{map[string]any{"url": serverURL}},
+		"security": []any{map[string]any{"bearerAuth": []string{}}},
+		"tags":     tagList,
+		"paths":    paths,
+		"components": map[string]any{
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+			"schemas": g.schemas,
+		},
+	}
+}
+
+// Create an operation ID from the path, e.g. /api/v0/sites/{id} → SitesID
+func openAPIOpID(p string) string {
+	var b strings.Builder
+	for _, s := range strings.FieldsFunc(strings.TrimPrefix(p, "/api/v0/"), func(r rune) bool {
+		return r == '/' || r == '-' || r == '.' || r == '_' || r == '{' || r == '}'
+	}) {
+		if s == "id" {
+			s = "ID"
+		}
+		b.WriteString(strings.ToUpper(s[:1]) + s[1:])
+	}
+	return b.String()
+}
+
+// Generate JSON schemas from Go types.
+type openAPIGen struct {
+	schemas map[string]any
+}
+
+type openAPIField struct {
+	name   string
+	schema map[string]any
+}
+
+var (
+	typeTime            = reflect.TypeOf(time.Time{})
+	typeZBool           = reflect.TypeOf(zbool.Bool(false))
+	typeJSONMarshaler   = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	typeTextMarshaler   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	typeBitflag64       = reflect.TypeOf(zint.Bitflag64(0))
+	openAPIPrimitiveMap = map[reflect.Kind]string{
+		reflect.Bool: "boolean", reflect.String: "string",
+		reflect.Int: "integer", reflect.Int8: "integer", reflect.Int16: "integer",
+		reflect.Int32: "integer", reflect.Int64: "integer", reflect.Uint: "integer",
+		reflect.Uint8: "integer", reflect.Uint16: "integer", reflect.Uint32: "integer",
+		reflect.Uint64: "integer", reflect.Float32: "number", reflect.Float64: "number",
+	}
+)
+
+func (g *openAPIGen) schema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == typeTime:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == typeZBool:
+		return map[string]any{"type": "boolean"}
+	case t == typeBitflag64:
+		return map[string]any{"type": "integer"}
+	case t.Implements(typeJSONMarshaler) || reflect.PointerTo(t).Implements(typeJSONMarshaler):
+		return map[string]any{}
+	case t.Implements(typeTextMarshaler) || reflect.PointerTo(t).Implements(typeTextMarshaler):
+		return map[string]any{"type": "string"}
+	}
+
+	if p, ok := openAPIPrimitiveMap[t.Kind()]; ok {
+		return map[string]any{"type": p}
+	}
+
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string"}
+		}
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		name := t.Name()
+		if _, ok := g.schemas[name]; !ok {
+			g.schemas[name] = nil // Prevent infinite recursion.
+			g.schemas[name] = g.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{}
+}
+
+func (g *openAPIGen) object(t reflect.Type) map[string]any {
+	props := make(map[string]any)
+	for _, f := range g.fields(t) {
+		props[f.name] = f.schema
+	}
+	return map[string]any{"type": "object", "properties": props}
+}
+
+func (g *openAPIGen) fields(t reflect.Type) []openAPIField {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	fields := make([]openAPIField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			for ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				fields = append(fields, g.fields(ft)...)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		s := g.schema(f.Type)
+		if strings.Contains(","+opts+",", ",readonly,") {
+			s["readOnly"] = true
+		}
+		fields = append(fields, openAPIField{name: name, schema: s})
+	}
+	return fields
+}
@@ -0,0 +1,293 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package handlers
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/json"
+	"zgo.at/zdb"
+	"zgo.at/zstd/ztest"
+)
+
+func TestOpenAPI(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	t.Run("documented", func(t *testing.T) {
+		documented := make(map[string]struct{})
+		for _, d := range apiDocs {
+			documented[d.method+" "+d.path] = struct{}{}
+		}
+
+		mounted := make(map[string]struct{})
+		err := chi.Walk(newBackend(zdb.MustGetDB(ctx)), func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+			if !strings.HasPrefix(route, "/api/v0/") {
+				return nil
+			}
+			k := method + " " + route
+			mounted[k] = struct{}{}
+			if _, ok := documented[k]; !ok && !slices.Contains(apiUndocumented, k) {
+				t.Errorf("route %q has no entry in apiDocs", k)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for k := range documented {
+			if _, ok := mounted[k]; !ok {
+				t.Errorf("route %q is in apiDocs but not mounted", k)
+			}
+		}
+	})
+
+	t.Run("serve", func(t *testing.T) {
+		r, rr := newTest(ctx, "GET", "/api/v0/openapi.json", nil)
+		newBackend(zdb.MustGetDB(ctx)).ServeHTTP(rr, r)
+		ztest.Code(t, rr, 200)
+
+		var doc struct {
+			OpenAPI    string                               `json:"openapi"`
+			Paths      map[string]map[string]map[string]any `json:"paths"`
+			Components struct {
+				Schemas map[string]any `json:"schemas"`
+			} `json:"components"`
+		}
+		err := json.Unmarshal(rr.Body.Bytes(), &doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if doc.OpenAPI != "3.1.0" {
+			t.Errorf("openapi: %q", doc.OpenAPI)
+		}
+
+		op := doc.Paths["/api/v0/paths/merge"]["post"]
+		if p := op["x-permissions"]; !slices.Equal(p.([]any), []any{"paths-delete"}) {
+			t.Errorf("x-permissions: %v", p)
+		}
+		if _, ok := doc.Components.Schemas["apiPathsMergeRequest"]; !ok {
+			t.Error("no schema for apiPathsMergeRequest")
+		}
+
+		for _, tt := range []struct {
+			path, want string
+		}{
+			{"/api/v0/stats/{page}/{id}", "page=string id=string"},
+			{"/api/v0/stats/series/{page}", "page=string"},
+			{"/api/v0/paths/{id}", "id=integer"},
+		} {
+			var have []string
+			for _, p := range doc.Paths[tt.path]["get"]["parameters"].([]any) {
+				p := p.(map[string]any)
+				if p["in"] == "path" {
+					have = append(have, fmt.Sprintf("%s=%s", p["name"], p["schema"].(map[string]any)["type"]))
+				}
+			}
+			if h := strings.Join(have, " "); h != tt.want {
+				t.Errorf("path parameters for %s\nhave: %s\nwant: %s", tt.path, h, tt.want)
+			}
+		}
+
+		// Make sure all references resolve.
+		refs := regexp.MustCompile(`"\$ref":\s*"#/components/schemas/(\w+)"`).FindAllStringSubmatch(rr.Body.String(), -1)
+		if len(refs) == 0 {
+			t.Fatal("no references")
+		}
+		for _, ref := range refs {
+			if s, ok := doc.Components.Schemas[ref[1]]; !ok || s == nil {
+				t.Errorf("unresolved reference to %q", ref[1])
+			}
+		}
+	})
+}
+
+var updateAPIDocs = flag.Bool("update", false, "update the generated apiDocs in openapi_docs.go")
+
+// Generate apiDocs from the doc comments and h.auth() calls of the handlers,
+// and make sure openapi_docs.go is up to date.
+func TestOpenAPIDocs(t *testing.T) {
+	have, err := os.ReadFile("openapi_docs.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := genAPIDocs(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *updateAPIDocs {
+		err := os.WriteFile("openapi_docs.go", want, 0o666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	if d := ztest.Diff(string(have), string(want)); d != "" {
+		t.Errorf("openapi_docs.go is out of date; run \"go test -run TestOpenAPIDocs -update\"\n%s", d)
+	}
+}
+
+var (
+	reAPIDocRoute = regexp.MustCompile(`^(GET|POST|PUT|PATCH|DELETE) (/api/v0/\S+) (\S+)$`)
+	reAPIDocParam = regexp.MustCompile(`^Path parameter (\w+): (\w+)$`)
+	reAPIDocResp  = regexp.MustCompile(`^Response (\d+)(?: \(([^)]+)\))?: (\S+)$`)
+)
+
+func genAPIDocs(dir string) ([]byte, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+
+	typ := func(t string) string {
+		t = strings.TrimPrefix(t, "zgo.at/goatcounter/v2/handlers.")
+		t = strings.Replace(t, "zgo.at/goatcounter/v2.", "goatcounter.", 1)
+		return t + "{}"
+	}
+
+	var (
+		fset = token.NewFileSet()
+		b    = new(bytes.Buffer)
+	)
+	b.WriteString("// Code generated by TestOpenAPIDocs; DO NOT EDIT.\n\n")
+	b.WriteString("package handlers\n\nimport \"zgo.at/goatcounter/v2\"\n\nvar apiDocs = []apiDoc{\n")
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") || filepath.Base(file) == "openapi_docs.go" {
+			continue
+		}
+		f, err := parser.ParseFile(fset, file, nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Doc == nil || fn.Recv == nil {
+				continue
+			}
+
+			var (
+				routes  [][]string
+				summary string
+				fields  []string
+				params  []string
+			)
+			for _, line := range strings.Split(fn.Doc.Text(), "\n") {
+				line = strings.TrimSpace(line)
+				if m := reAPIDocRoute.FindStringSubmatch(line); m != nil {
+					routes = append(routes, m[1:])
+					continue
+				}
+				if len(routes) == 0 {
+					break
+				}
+				if summary == "" {
+					summary = line
+					continue
+				}
+
+				if m := reAPIDocParam.FindStringSubmatch(line); m != nil {
+					params = append(params, fmt.Sprintf("%q: %q", m[1], m[2]))
+				}
+				if q, ok := strings.CutPrefix(line, "Query: "); ok {
+					fields = append(fields, "query: "+typ(q))
+				}
+				if q, ok := strings.CutPrefix(line, "Request body: "); ok {
+					fields = append(fields, "body: "+typ(q))
+				}
+				// Only the first response is the success response.
+				if m := reAPIDocResp.FindStringSubmatch(line); m != nil && !slices.ContainsFunc(fields, func(f string) bool {
+					return strings.HasPrefix(f, "resp")
+				}) {
+					if m[1] != "200" {
+						fields = append(fields, "status: "+m[1])
+					}
+					switch {
+					case m[2] != "" && m[2] != "application/json":
+						fields = append(fields, "respType: "+strconv.Quote(m[2]))
+					case m[3] == "{data}":
+						fields = append(fields, "resp: map[string]any{}")
+					case m[3] == "{empty}":
+						fields = append(fields, "resp: nil")
+					default:
+						fields = append(fields, "resp: "+typ(m[3]))
+					}
+				}
+			}
+			if len(routes) == 0 {
+				continue
+			}
+			fields = slices.DeleteFunc(fields, func(f string) bool { return f == "resp: nil" })
+			if len(params) > 0 {
+				fields = append([]string{"params: map[string]string{" + strings.Join(params, ", ") + "}"}, fields...)
+			}
+
+			perm, err := apiDocPerm(fset, fn)
+			if err != nil {
+				return nil, err
+			}
+			if perm != "" {
+				fields = append([]string{perm}, fields...)
+			}
+			for _, r := range routes {
+				fmt.Fprintf(b, "\t{method: %q, path: %q, tag: %q,\n\t\tsummary: %q,\n", r[0], r[1], r[2], summary)
+				fmt.Fprintf(b, "\t\t%s},\n", strings.Join(fields, ", "))
+			}
+		}
+	}
+	b.WriteString("}\n")
+
+	return format.Source(b.Bytes())
+}
+
+// Get the permissions from the h.auth() call, or "public: true" if there isn't
+// one.
+func apiDocPerm(fset *token.FileSet, fn *ast.FuncDecl) (string, error) {
+	var (
+		perm  = "public: true"
+		found bool
+		err   error
+	)
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || found {
+			return !found
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || (sel.Sel.Name != "auth" && sel.Sel.Name != "authSites") {
+			return true
+		}
+		if len(call.Args) != 3 {
+			err = fmt.Errorf("%s: wrong number of arguments to %s()", fset.Position(call.Pos()), sel.Sel.Name)
+			return false
+		}
+		found = true
+		p := new(bytes.Buffer)
+		err = printer.Fprint(p, fset, call.Args[2])
+		perm = "perm: " + p.String()
+		if p.String() == "0" {
+			perm = ""
+		}
+		return false
+	})
+	return perm, err
+}
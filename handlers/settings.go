@@ -14,6 +14,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -31,6 +32,7 @@ import (
 	"zgo.at/zhttp/header"
 	"zgo.at/zhttp/mware"
 	"zgo.at/zlog"
+	"zgo.at/zstd/zbool"
 	"zgo.at/zstd/zint"
 	"zgo.at/zstd/zruntime"
 	"zgo.at/zstd/ztime"
@@ -76,6 +78,20 @@ func (h settings) mount(r chi.Router) {
 		set.Get("/settings/purge", zhttp.Wrap(h.purge))
 		set.Post("/settings/purge", zhttp.Wrap(h.purgeDo))
 		set.Post("/settings/merge", zhttp.Wrap(h.merge))
+		set.Post("/settings/rewrite", zhttp.Wrap(h.rewrite))
+
+		set.Get("/settings/filters", zhttp.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+			return h.filters(nil, nil)(w, r)
+		}))
+		set.Post("/settings/filters", zhttp.Wrap(h.filtersSave))
+		set.Get("/settings/paths", zhttp.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+			return h.paths(nil, nil)(w, r)
+		}))
+		set.Post("/settings/paths", zhttp.Wrap(h.pathsSave))
+
+		set.Get("/settings/campaigns", zhttp.Wrap(h.campaigns))
+		set.Post("/settings/campaigns/merge", zhttp.Wrap(h.campaignsMerge))
+		set.Post("/settings/campaigns/{id}", zhttp.Wrap(h.campaignUpdate))
 
 		set.Get("/settings/export", zhttp.Wrap(func(w http.ResponseWriter, r *http.Request) error {
 			return h.export(nil)(w, r)
@@ -98,6 +114,10 @@ func (h settings) mount(r chi.Router) {
 		admin.Get("/user/api", zhttp.Wrap(func(w http.ResponseWriter, r *http.Request) error {
 			return h.userAPI(nil)(w, r)
 		}))
+	}
+
+	{ // Account admin settings
+		admin := r.With(requireAccountAccess(goatcounter.AccessAdmin))
 
 		admin.Get("/settings/sites", zhttp.Wrap(func(w http.ResponseWriter, r *http.Request) error {
 			return h.sites(nil)(w, r)
@@ -121,6 +141,10 @@ func (h settings) mount(r chi.Router) {
 		admin.Post("/settings/users/add", zhttp.Wrap(h.usersAdd))
 		admin.Post("/settings/users/{id}", zhttp.Wrap(h.usersEdit))
 		admin.Post("/settings/users/remove/{id}", zhttp.Wrap(h.usersRemove))
+		admin.Post("/settings/users/logout/{id}", zhttp.Wrap(h.usersLogout))
+		admin.Post("/settings/users/unlock/{id}", zhttp.Wrap(h.usersUnlock))
+
+		admin.Get("/settings/audit-log", zhttp.Wrap(h.auditLog))
 
 		admin.Get("/settings/delete-account", zhttp.Wrap(func(w http.ResponseWriter, r *http.Request) error {
 			return h.delete(nil)(w, r)
@@ -166,6 +190,9 @@ func (h settings) mainSave(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	site := Site(r.Context())
+	before := auditSite(*site)
+	args.Settings.Filters = site.Settings.Filters     // Set in /settings/filters
+	args.Settings.PathRules = site.Settings.PathRules // Set in /settings/paths
 	site.Settings = args.Settings
 	site.LinkDomain = args.LinkDomain
 
@@ -191,6 +218,7 @@ func (h settings) mainSave(w http.ResponseWriter, r *http.Request) error {
 	if v.HasErrors() {
 		return h.main(&v)(w, r)
 	}
+	audit(r, goatcounter.AuditSiteSettings, site.ID, goatcounter.NewAuditDiff(before, auditSite(*site)))
 
 	if makecert {
 		ctx := goatcounter.CopyContextValues(r.Context())
@@ -228,10 +256,13 @@ func (h settings) changeCode(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	site := Site(r.Context())
+	oldCode := site.Code
 	err = site.UpdateCode(r.Context(), args.Code)
 	if err != nil {
 		return err
 	}
+	audit(r, goatcounter.AuditSiteCode, site.ID,
+		goatcounter.NewAuditDiff(map[string]any{"code": oldCode}, map[string]any{"code": site.Code}))
 
 	zhttp.Flash(w, T(r.Context(), "notify/saved|Saved!"))
 	return zhttp.SeeOther(w, site.URL(r.Context())+"/settings/main")
@@ -297,6 +328,7 @@ func (h settings) sitesAdd(w http.ResponseWriter, r *http.Request) error {
 		if err != nil {
 			return err
 		}
+		audit(r, goatcounter.AuditSiteRestore, newSite.ID, goatcounter.NewAuditDiff(nil, auditSite(newSite)))
 
 		zhttp.Flash(w, T(r.Context(), "notify/restored-previously-deleted-site|Site ‘%(url)’ was previously deleted; restored site with all data.", newSite.URL(r.Context())))
 		return zhttp.SeeOther(w, "/settings/sites")
@@ -319,6 +351,7 @@ func (h settings) sitesAdd(w http.ResponseWriter, r *http.Request) error {
 		zhttp.FlashError(w, err.Error())
 		return zhttp.SeeOther(w, "/settings/sites")
 	}
+	audit(r, goatcounter.AuditSiteCreate, newSite.ID, goatcounter.NewAuditDiff(nil, auditSite(newSite)))
 
 	zhttp.Flash(w, T(r.Context(), "notify/site-added|Site ‘%(url)’ added.", newSite.URL(r.Context())))
 	return zhttp.SeeOther(w, "/settings/sites")
@@ -375,10 +408,12 @@ func (h settings) sitesRemove(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	sID := s.ID
+	before := auditSite(*s)
 	err = s.Delete(r.Context(), false)
 	if err != nil {
 		return err
 	}
+	audit(r, goatcounter.AuditSiteDelete, sID, goatcounter.NewAuditDiff(before, nil))
 
 	zhttp.Flash(w, T(r.Context(), "notify/site-removed|Site ‘%(url)’ removed.", s.URL(r.Context())))
 
@@ -428,17 +463,321 @@ func (h settings) sitesCopySettings(w http.ResponseWriter, r *http.Request) erro
 	}
 
 	for _, c := range copies {
+		before := auditSite(c)
 		c.Settings = master.Settings
 		err := c.Update(r.Context())
 		if err != nil {
 			return err
 		}
+		audit(r, goatcounter.AuditSiteCopySettings, c.ID, goatcounter.NewAuditDiff(before, auditSite(c)))
 	}
 
 	zhttp.Flash(w, T(r.Context(), "notify/settings-copied-to-site|Settings copied to the selected sites."))
 	return zhttp.SeeOther(w, "/settings/sites")
 }
 
+// previewArgs are the parameters for the preview on settings pages that test
+// rules against stored pageviews before saving them.
+type previewArgs struct {
+	Preview bool `json:"preview"`
+	N       int  `json:"n"` // Number of pageviews to test.
+}
+
+// run the preview if one was requested.
+//
+// The rules are parsed and validated with validate; errors from this are
+// returned as a validator with the errors under key, and preview isn't run.
+func (a *previewArgs) run(ctx context.Context, key string, validate func() error, preview func(n int) error) (*zvalidate.Validator, error) {
+	if a.N <= 0 || a.N > 10_000 {
+		a.N = 500
+	}
+	if !a.Preview {
+		return nil, nil
+	}
+
+	if err := validate(); err != nil {
+		v := goatcounter.NewValidate(ctx)
+		v.Sub(key, "", err)
+		return &v, nil
+	}
+	return nil, preview(a.N)
+}
+
+func (h settings) filters(verr *zvalidate.Validator, rules *string) zhttp.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		var (
+			site = Site(r.Context())
+			text = site.Settings.Filters.String()
+			args struct {
+				previewArgs
+				Rules string `json:"rules"`
+			}
+		)
+		if rules != nil {
+			text = *rules
+		}
+
+		_, err := zhttp.Decode(r, &args)
+		if err != nil {
+			return err
+		}
+		if args.Preview {
+			text = args.Rules
+		}
+
+		var (
+			f       goatcounter.FilterRules
+			matched []goatcounter.FilterPreview
+			total   int
+			noTest  goatcounter.FilterRules
+		)
+		previewV, err := args.run(r.Context(), "filters", func() (err error) {
+			f, err = goatcounter.ParseFilterRules(args.Rules)
+			if err != nil {
+				return err
+			}
+			return f.Validate(r.Context())
+		}, func(n int) (err error) {
+			matched, total, err = f.Preview(r.Context(), n)
+			for _, ff := range f {
+				if !ff.Previewable() {
+					noTest = append(noTest, ff)
+				}
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if verr == nil {
+			verr = previewV
+		}
+
+		return zhttp.Template(w, "settings_filters.gohtml", struct {
+			Globals
+			Validate    *zvalidate.Validator
+			Rules       string
+			Preview     bool
+			N           int
+			Total       int
+			Matched     []goatcounter.FilterPreview
+			NoTest      goatcounter.FilterRules
+			CollectHits bool
+		}{newGlobals(w, r), verr, text, args.Preview && previewV == nil, args.N, total, matched, noTest,
+			site.Settings.Collect.Has(goatcounter.CollectHits)})
+	}
+}
+
+func (h settings) filtersSave(w http.ResponseWriter, r *http.Request) error {
+	var args struct {
+		Rules string `json:"rules"`
+	}
+	_, err := zhttp.Decode(r, &args)
+	if err != nil {
+		return err
+	}
+
+	f, err := goatcounter.ParseFilterRules(args.Rules)
+	if err != nil {
+		v := goatcounter.NewValidate(r.Context())
+		v.Append("filters", err.Error())
+		return h.filters(&v, &args.Rules)(w, r)
+	}
+
+	site := Site(r.Context())
+	before := auditSite(*site)
+	site.Settings.Filters = f
+	err = site.Update(r.Context())
+	if err != nil {
+		var vErr *zvalidate.Validator
+		if !errors.As(err, &vErr) {
+			return err
+		}
+		return h.filters(vErr, &args.Rules)(w, r)
+	}
+	audit(r, goatcounter.AuditSiteSettings, site.ID, goatcounter.NewAuditDiff(before, auditSite(*site)))
+
+	zhttp.Flash(w, T(r.Context(), "notify/saved|Saved!"))
+	return zhttp.SeeOther(w, "/settings/filters")
+}
+
+type pathRulesArgs struct {
+	Lowercase     bool                `json:"lowercase"`
+	TrailingSlash string              `json:"trailing_slash"`
+	StripQuery    bool                `json:"strip_query"`
+	KeepQuery     goatcounter.Strings `json:"keep_query"`
+	Rewrites      string              `json:"rewrites"`
+}
+
+func (a pathRulesArgs) rules() (goatcounter.PathRules, error) {
+	rw, err := goatcounter.ParsePathRewrites(a.Rewrites)
+	return goatcounter.PathRules{
+		Lowercase:     a.Lowercase,
+		TrailingSlash: a.TrailingSlash,
+		StripQuery:    a.StripQuery,
+		KeepQuery:     a.KeepQuery,
+		Rewrites:      rw,
+	}, err
+}
+
+func (h settings) paths(verr *zvalidate.Validator, form *pathRulesArgs) zhttp.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		var (
+			site = Site(r.Context())
+			pr   = site.Settings.PathRules
+			args struct {
+				pathRulesArgs
+				previewArgs
+			}
+		)
+		args.pathRulesArgs = pathRulesArgs{pr.Lowercase, pr.TrailingSlash, pr.StripQuery, pr.KeepQuery, pr.Rewrites.String()}
+
+		_, err := zhttp.Decode(r, &args)
+		if err != nil {
+			return err
+		}
+		if form != nil {
+			args.pathRulesArgs = *form
+		}
+		var (
+			rules           goatcounter.PathRules
+			changed         []goatcounter.PathPreview
+			total, distinct int
+		)
+		previewV, err := args.run(r.Context(), "path_rules", func() (err error) {
+			rules, err = args.rules()
+			if err != nil {
+				return err
+			}
+			return rules.Validate(r.Context())
+		}, func(n int) (err error) {
+			changed, total, distinct, err = rules.Preview(r.Context(), n)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if verr == nil {
+			verr = previewV
+		}
+
+		return zhttp.Template(w, "settings_paths.gohtml", struct {
+			Globals
+			Validate *zvalidate.Validator
+			Form     pathRulesArgs
+			Preview  bool
+			N        int
+			Total    int
+			Distinct int
+			Changed  []goatcounter.PathPreview
+		}{newGlobals(w, r), verr, args.pathRulesArgs, args.Preview && previewV == nil,
+			args.N, total, distinct, changed})
+	}
+}
+
+func (h settings) pathsSave(w http.ResponseWriter, r *http.Request) error {
+	var args pathRulesArgs
+	_, err := zhttp.Decode(r, &args)
+	if err != nil {
+		return err
+	}
+
+	rules, err := args.rules()
+	if err != nil {
+		v := goatcounter.NewValidate(r.Context())
+		v.Append("path_rules.rewrites", err.Error())
+		return h.paths(&v, &args)(w, r)
+	}
+
+	site := Site(r.Context())
+	before := auditSite(*site)
+	site.Settings.PathRules = rules
+	err = site.Update(r.Context())
+	if err != nil {
+		var vErr *zvalidate.Validator
+		if !errors.As(err, &vErr) {
+			return err
+		}
+		return h.paths(vErr, &args)(w, r)
+	}
+	audit(r, goatcounter.AuditSiteSettings, site.ID, goatcounter.NewAuditDiff(before, auditSite(*site)))
+
+	zhttp.Flash(w, T(r.Context(), "notify/saved|Saved!"))
+	return zhttp.SeeOther(w, "/settings/paths")
+}
+
+func (h settings) campaigns(w http.ResponseWriter, r *http.Request) error {
+	var campaigns goatcounter.Campaigns
+	err := campaigns.List(r.Context())
+	if err != nil {
+		return err
+	}
+	return zhttp.Template(w, "settings_campaigns.gohtml", struct {
+		Globals
+		Campaigns goatcounter.Campaigns
+	}{newGlobals(w, r), campaigns})
+}
+
+func (h settings) campaignUpdate(w http.ResponseWriter, r *http.Request) error {
+	v := goatcounter.NewValidate(r.Context())
+	id := v.Integer("id", chi.URLParam(r, "id"))
+	if v.HasErrors() {
+		return v
+	}
+
+	var args struct {
+		Name   string `json:"name"`
+		Hidden bool   `json:"hidden"`
+	}
+	_, err := zhttp.Decode(r, &args)
+	if err != nil {
+		return err
+	}
+
+	var c goatcounter.Campaign
+	err = c.ByID(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	before := c
+	c.Name, c.Hidden = strings.TrimSpace(args.Name), zbool.Bool(args.Hidden)
+	err = c.Update(r.Context())
+	if err != nil {
+		zhttp.FlashError(w, err.Error())
+		return zhttp.SeeOther(w, "/settings/campaigns")
+	}
+	audit(r, goatcounter.AuditCampaignUpdate, Site(r.Context()).ID, goatcounter.NewAuditDiff(before, c))
+
+	zhttp.Flash(w, T(r.Context(), "notify/saved|Saved!"))
+	return zhttp.SeeOther(w, "/settings/campaigns")
+}
+
+func (h settings) campaignsMerge(w http.ResponseWriter, r *http.Request) error {
+	v := goatcounter.NewValidate(r.Context())
+	dst := v.Integer("merge_with", r.Form.Get("merge_with"))
+	campaigns := make([]int64, 0, len(r.Form["campaigns"]))
+	for _, c := range r.Form["campaigns"] {
+		campaigns = append(campaigns, v.Integer("campaigns", c))
+	}
+	campaigns = slices.DeleteFunc(campaigns, func(c int64) bool { return c == dst })
+	v.Required("campaigns", campaigns)
+	if v.HasErrors() {
+		zhttp.FlashError(w, v.Error())
+		return zhttp.SeeOther(w, "/settings/campaigns")
+	}
+
+	var list goatcounter.Campaigns
+	err := list.Merge(r.Context(), dst, campaigns)
+	if err != nil {
+		return err
+	}
+	audit(r, goatcounter.AuditCampaignsMerge, Site(r.Context()).ID,
+		goatcounter.NewAuditDiff(nil, map[string]any{"campaigns": campaigns, "merge_with": dst}))
+
+	zhttp.Flash(w, T(r.Context(), "notify/campaigns-merged|Campaigns merged"))
+	return zhttp.SeeOther(w, "/settings/campaigns")
+}
+
 func (h settings) purge(w http.ResponseWriter, r *http.Request) error {
 	var (
 		path       = strings.TrimSpace(r.URL.Query().Get("path"))
@@ -446,6 +785,10 @@ func (h settings) purge(w http.ResponseWriter, r *http.Request) error {
 		matchCase  = r.URL.Query().Get("match-case") == "on"
 		list       goatcounter.HitLists
 		paths      goatcounter.Paths
+		rwMatch    = r.URL.Query().Get("rewrite-match")
+		rwReplace  = r.URL.Query().Get("rewrite-replace")
+		changes    goatcounter.PathChanges
+		rwErr      error
 	)
 
 	if path != "" {
@@ -460,14 +803,26 @@ func (h settings) purge(w http.ResponseWriter, r *http.Request) error {
 		}
 	}
 
+	if rwMatch != "" {
+		rwErr = changes.Rewrite(r.Context(), rwMatch, rwReplace)
+		if rwErr != nil && guru.Code(rwErr) != 400 {
+			return rwErr
+		}
+	}
+
 	return zhttp.Template(w, "settings_purge.gohtml", struct {
 		Globals
-		PurgePath  string
-		MatchTitle bool
-		MatchCase  bool
-		List       goatcounter.HitLists
-		AllPaths   goatcounter.Paths
-	}{newGlobals(w, r), path, matchTitle, matchCase, list, paths})
+		PurgePath      string
+		MatchTitle     bool
+		MatchCase      bool
+		List           goatcounter.HitLists
+		AllPaths       goatcounter.Paths
+		RewriteMatch   string
+		RewriteReplace string
+		RewriteErr     error
+		Changes        goatcounter.PathChanges
+	}{newGlobals(w, r), path, matchTitle, matchCase, list, paths,
+		rwMatch, rwReplace, rwErr, changes})
 }
 
 func (h settings) purgeDo(w http.ResponseWriter, r *http.Request) error {
@@ -476,6 +831,9 @@ func (h settings) purgeDo(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
+	audit(r, goatcounter.AuditPathsPurge, Site(r.Context()).ID,
+		goatcounter.NewAuditDiff(nil, map[string]any{"paths": paths}))
+
 	ctx := goatcounter.CopyContextValues(r.Context())
 	bgrun.RunFunction(fmt.Sprintf("purge:%d", Site(ctx).ID), func() {
 		var list goatcounter.Hits
@@ -501,6 +859,8 @@ func (h settings) merge(w http.ResponseWriter, r *http.Request) error {
 		return v
 	}
 	paths = slices.DeleteFunc(paths, func(p int64) bool { return p == dst })
+	audit(r, goatcounter.AuditPathsMerge, Site(r.Context()).ID,
+		goatcounter.NewAuditDiff(nil, map[string]any{"paths": paths, "merge_with": dst}))
 
 	ctx := goatcounter.CopyContextValues(r.Context())
 	bgrun.RunFunction(fmt.Sprintf("merge:%d", Site(ctx).ID), func() {
@@ -515,6 +875,36 @@ func (h settings) merge(w http.ResponseWriter, r *http.Request) error {
 	return zhttp.SeeOther(w, "/settings/purge")
 }
 
+func (h settings) rewrite(w http.ResponseWriter, r *http.Request) error {
+	var args struct {
+		Match   string `json:"match"`
+		Replace string `json:"replace"`
+	}
+	_, err := zhttp.Decode(r, &args)
+	if err != nil {
+		return err
+	}
+
+	var changes goatcounter.PathChanges
+	err = changes.Rewrite(r.Context(), args.Match, args.Replace)
+	if err != nil {
+		return err
+	}
+	audit(r, goatcounter.AuditPathsRewrite, Site(r.Context()).ID,
+		goatcounter.NewAuditDiff(nil, map[string]any{"match": args.Match, "replace": args.Replace, "paths": len(changes)}))
+
+	ctx := goatcounter.CopyContextValues(r.Context())
+	bgrun.RunFunction(fmt.Sprintf("rewrite:%d", Site(ctx).ID), func() {
+		err := changes.Apply(ctx)
+		if err != nil {
+			zlog.Error(err)
+		}
+	})
+
+	zhttp.Flash(w, T(r.Context(), "notify/started-background-process|Started in the background; may take about 10-20 seconds to fully process."))
+	return zhttp.SeeOther(w, "/settings/purge")
+}
+
 func (h settings) export(verr *zvalidate.Validator) zhttp.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) error {
 		var exports goatcounter.Exports
@@ -591,6 +981,9 @@ func (h settings) exportImport(w http.ResponseWriter, r *http.Request) error {
 	}
 	defer fp.Close()
 
+	audit(r, goatcounter.AuditImport, Site(r.Context()).ID,
+		goatcounter.NewAuditDiff(nil, map[string]any{"file": head.Filename, "replace": replace}))
+
 	user := User(r.Context())
 	ctx := goatcounter.CopyContextValues(r.Context())
 	n := 0
@@ -711,10 +1104,12 @@ func (h settings) deleteDo(w http.ResponseWriter, r *http.Request) error {
 		})
 	}
 
+	accountID, before := account.ID, auditSite(*account)
 	err = account.Delete(r.Context(), true)
 	if err != nil {
 		return err
 	}
+	audit(r, goatcounter.AuditAccountDelete, accountID, goatcounter.NewAuditDiff(before, nil))
 	return zhttp.SeeOther(w, "https://"+goatcounter.Config(r.Context()).Domain)
 }
 
@@ -728,11 +1123,18 @@ func (h settings) users(verr *zvalidate.Validator) zhttp.HandlerFunc {
 			return err
 		}
 
+		var sites goatcounter.Sites
+		err = sites.ForThisAccount(r.Context(), false)
+		if err != nil {
+			return err
+		}
+
 		return zhttp.Template(w, "settings_users.gohtml", struct {
 			Globals
 			Users    goatcounter.Users
+			Sites    goatcounter.Sites
 			Validate *zvalidate.Validator
-		}{newGlobals(w, r), users, verr})
+		}{newGlobals(w, r), users, sites, verr})
 	}
 }
 
@@ -758,6 +1160,12 @@ func (h settings) usersForm(newUser *goatcounter.User, pErr error) zhttp.Handler
 			}
 		}
 
+		var sites goatcounter.Sites
+		err := sites.ForThisAccount(r.Context(), false)
+		if err != nil {
+			return err
+		}
+
 		var vErr *zvalidate.Validator
 		if errors.As(pErr, &vErr) {
 			pErr = nil
@@ -772,10 +1180,11 @@ func (h settings) usersForm(newUser *goatcounter.User, pErr error) zhttp.Handler
 		return zhttp.Template(w, "settings_users_form.gohtml", struct {
 			Globals
 			NewUser  goatcounter.User
+			Sites    goatcounter.Sites
 			Validate *zvalidate.Validator
 			Error    error
 			Edit     bool
-		}{newGlobals(w, r), *newUser, vErr, pErr, edit})
+		}{newGlobals(w, r), *newUser, sites, vErr, pErr, edit})
 	}
 }
 
@@ -791,11 +1200,15 @@ func (h settings) usersAdd(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	account := Account(r.Context())
+	access, err := siteAccess(r.Context(), args.Access)
+	if err != nil {
+		return err
+	}
 
 	newUser := goatcounter.User{
 		Email:  args.Email,
 		Site:   account.ID,
-		Access: args.Access,
+		Access: access,
 	}
 	if args.Password != "" {
 		newUser.Password = []byte(args.Password)
@@ -817,11 +1230,20 @@ func (h settings) usersAdd(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return h.usersForm(&newUser, err)(w, r)
 	}
+	audit(r, goatcounter.AuditUserCreate, account.ID, goatcounter.NewAuditDiff(nil, auditUser(newUser)))
 
-	ctx := goatcounter.CopyContextValues(r.Context())
+	sendAddUserEmail(r.Context(), account, newUser)
+	zhttp.Flash(w, T(r.Context(), "notify/user-added|User ‘%(email)’ added.", newUser.Email))
+	return zhttp.SeeOther(w, "/settings/users")
+}
+
+// sendAddUserEmail tells the new user that an account was created for them,
+// with a link to set their password if they don't have one.
+func sendAddUserEmail(ctx context.Context, account *goatcounter.Site, newUser goatcounter.User) {
+	ctx = goatcounter.CopyContextValues(ctx)
 	bgrun.RunFunction(fmt.Sprintf("adduser:%d", newUser.ID), func() {
 		err := blackmail.Send(fmt.Sprintf("A GoatCounter account was created for you at %s", account.Display(ctx)),
-			blackmail.From("GoatCounter", goatcounter.Config(r.Context()).EmailFrom),
+			blackmail.From("GoatCounter", goatcounter.Config(ctx).EmailFrom),
 			blackmail.To(newUser.Email),
 			blackmail.BodyMustText(goatcounter.TplEmailAddUser{ctx, *account, newUser, goatcounter.GetUser(ctx).Email}.Render),
 		)
@@ -829,9 +1251,6 @@ func (h settings) usersAdd(w http.ResponseWriter, r *http.Request) error {
 			zlog.Errorf(": %s", err)
 		}
 	})
-
-	zhttp.Flash(w, T(r.Context(), "notify/user-added|User ‘%(email)’ added.", newUser.Email))
-	return zhttp.SeeOther(w, "/settings/users")
 }
 
 func (h settings) usersEdit(w http.ResponseWriter, r *http.Request) error {
@@ -866,9 +1285,15 @@ func (h settings) usersEdit(w http.ResponseWriter, r *http.Request) error {
 		return guru.New(404, T(r.Context(), "notify/not-found|Not Found"))
 	}
 
+	access, err := siteAccess(r.Context(), args.Access)
+	if err != nil {
+		return err
+	}
+
+	before := auditUser(editUser)
 	emailChanged := editUser.Email != args.Email
 	editUser.Email = args.Email
-	editUser.Access = args.Access
+	editUser.Access = access
 
 	err = zdb.TX(r.Context(), func(ctx context.Context) error {
 		err = editUser.Update(ctx, emailChanged)
@@ -887,11 +1312,43 @@ func (h settings) usersEdit(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return h.usersForm(&editUser, err)(w, r)
 	}
+	diff := goatcounter.NewAuditDiff(before, auditUser(editUser))
+	if args.Password != "" {
+		diff["password"] = [2]any{nil, "changed"}
+	}
+	audit(r, goatcounter.AuditUserUpdate, account.ID, diff)
 
 	zhttp.Flash(w, T(r.Context(), "notify/users-edited|User ‘%(email)’ edited.", editUser.Email))
 	return zhttp.SeeOther(w, "/settings/users")
 }
 
+// siteAccess cleans up the access from the user form: the per-site access is
+// removed if it's blank (meaning "same as all sites"), and it's an error to set
+// access for sites that aren't in this account.
+func siteAccess(ctx context.Context, access goatcounter.UserAccesses) (goatcounter.UserAccesses, error) {
+	var sites goatcounter.Sites
+	err := sites.ForThisAccount(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := sites.IDs()
+	clean := make(goatcounter.UserAccesses, len(access))
+	for k, a := range access {
+		if a == "" && k != "all" {
+			continue
+		}
+		if k != "all" {
+			id, err := strconv.ParseInt(k, 10, 64)
+			if err != nil || !slices.Contains(ids, id) {
+				return nil, guru.Errorf(400, "site %q is not in this account", k)
+			}
+		}
+		clean[k] = a
+	}
+	return clean, nil
+}
+
 func (h settings) usersRemove(w http.ResponseWriter, r *http.Request) error {
 	v := goatcounter.NewValidate(r.Context())
 	id := v.Integer("id", chi.URLParam(r, "id"))
@@ -915,11 +1372,122 @@ func (h settings) usersRemove(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	audit(r, goatcounter.AuditUserDelete, account.ID, goatcounter.NewAuditDiff(auditUser(user), nil))
 
 	zhttp.Flash(w, T(r.Context(), "notify/user-removed|User ‘%(email)’ removed.", user.Email))
 	return zhttp.SeeOther(w, "/settings/users")
 }
 
+func (h settings) usersLogout(w http.ResponseWriter, r *http.Request) error {
+	v := goatcounter.NewValidate(r.Context())
+	id := v.Integer("id", chi.URLParam(r, "id"))
+	if v.HasErrors() {
+		return v
+	}
+
+	account := Account(r.Context())
+
+	var user goatcounter.User
+	err := user.ByID(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	if user.Site != account.ID {
+		return guru.New(404, T(r.Context(), "error/not-found|Not Found"))
+	}
+
+	err = user.LogoutAll(r.Context(), false)
+	if err != nil {
+		return err
+	}
+	audit(r, goatcounter.AuditUserLogout, account.ID, goatcounter.NewAuditDiff(auditUser(user), nil))
+
+	zhttp.Flash(w, T(r.Context(), "notify/user-logged-out|User ‘%(email)’ signed out of all sessions.", user.Email))
+	return zhttp.SeeOther(w, "/settings/users")
+}
+
+func (h settings) usersUnlock(w http.ResponseWriter, r *http.Request) error {
+	v := goatcounter.NewValidate(r.Context())
+	id := v.Integer("id", chi.URLParam(r, "id"))
+	if v.HasErrors() {
+		return v
+	}
+
+	account := Account(r.Context())
+
+	var user goatcounter.User
+	err := user.ByID(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	if user.Site != account.ID {
+		return guru.New(404, T(r.Context(), "error/not-found|Not Found"))
+	}
+
+	before := map[string]any{"email": user.Email, "login_failures": user.LoginFailures, "locked_until": user.LockedUntil}
+	err = user.ClearLoginFailures(r.Context())
+	if err != nil {
+		return err
+	}
+	audit(r, goatcounter.AuditUserUnlock, account.ID, goatcounter.NewAuditDiff(before,
+		map[string]any{"email": user.Email, "login_failures": user.LoginFailures, "locked_until": user.LockedUntil}))
+
+	zhttp.Flash(w, T(r.Context(), "notify/user-unlocked|Cleared failed sign-in attempts for ‘%(email)’.", user.Email))
+	return zhttp.SeeOther(w, "/settings/users")
+}
+
+func (h settings) auditLog(w http.ResponseWriter, r *http.Request) error {
+	v := goatcounter.NewValidate(r.Context())
+	filter := goatcounter.AuditLogFilter{
+		SiteID: v.Integer("site", r.URL.Query().Get("site")),
+		UserID: v.Integer("user", r.URL.Query().Get("user")),
+		Action: r.URL.Query().Get("action"),
+	}
+	before := v.Integer("before", r.URL.Query().Get("before"))
+	if v.HasErrors() {
+		return v
+	}
+
+	var l goatcounter.AuditLogs
+	more, err := l.List(r.Context(), filter, before, 100)
+	if err != nil {
+		return err
+	}
+	if len(l) > 0 {
+		before = l[len(l)-1].ID
+	}
+
+	var sites goatcounter.Sites
+	err = sites.ForThisAccount(r.Context(), false)
+	if err != nil {
+		return err
+	}
+	names := make(map[int64]string, len(sites))
+	for _, s := range sites {
+		names[s.ID] = s.Display(r.Context())
+	}
+
+	var users goatcounter.Users
+	err = users.List(r.Context(), Account(r.Context()).ID)
+	if err != nil {
+		return err
+	}
+
+	return zhttp.Template(w, "settings_audit_log.gohtml", struct {
+		Globals
+		AuditLog  goatcounter.AuditLogs
+		More      bool
+		Before    int64
+		Filter    goatcounter.AuditLogFilter
+		Sites     goatcounter.Sites
+		SiteNames map[int64]string
+		Users     goatcounter.Users
+		Actions   []string
+	}{newGlobals(w, r), l, more, before, filter, sites, names, users, goatcounter.AuditActions})
+}
+
 func (h settings) bosmang(w http.ResponseWriter, r *http.Request) error {
 	info, _ := zdb.Info(r.Context())
 	return zhttp.Template(w, "settings_server.gohtml", struct {
@@ -6,6 +6,7 @@ package handlers
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -15,6 +16,7 @@ import (
 	"zgo.at/goatcounter/v2"
 	"zgo.at/goatcounter/v2/gctest"
 	"zgo.at/zdb"
+	"zgo.at/zstd/zjson"
 	"zgo.at/zstd/ztype"
 )
 
@@ -35,6 +37,73 @@ func TestSettingsTpl(t *testing.T) {
 			wantCode: 200,
 			wantBody: "<tr><td>2</td><td>/asd</td><td>AAA</td></tr>",
 		},
+		{
+			setup: func(ctx context.Context, t *testing.T) {
+				gctest.StoreHits(ctx, t, false, []goatcounter.Hit{
+					{Site: 1, Path: "/blog/asd"},
+					{Site: 1, Path: "/zxc"},
+				}...)
+			},
+			router:   newBackend,
+			path:     "/settings/purge?rewrite-match=/blog/&rewrite-replace=/posts/",
+			auth:     true,
+			wantCode: 200,
+			wantBody: "<tr><td>/blog/asd</td><td>/posts/asd</td>",
+		},
+
+		{
+			setup: func(ctx context.Context, t *testing.T) {
+				site := goatcounter.MustGetSite(ctx)
+				site.Settings.Collect.Set(goatcounter.CollectHits)
+				err := site.Update(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				now := time.Date(2019, 8, 31, 14, 42, 0, 0, time.UTC)
+				gctest.StoreHits(ctx, t, false, []goatcounter.Hit{
+					{Site: 1, Path: "/asd", CreatedAt: now},
+					{Site: 1, Path: "/asd", CreatedAt: now},
+					{Site: 1, Path: "/zxc", CreatedAt: now},
+				}...)
+			},
+			router:   newBackend,
+			path:     "/settings/filters?preview=true&n=100&rules=drop+path+/a*%0Abot+ip+1.2.3.4",
+			auth:     true,
+			wantCode: 200,
+			wantBody: "2 out of 3 pageviews match.",
+		},
+
+		{
+			setup: func(ctx context.Context, t *testing.T) {
+				gctest.StoreHits(ctx, t, false, []goatcounter.Hit{
+					{Site: 1, Path: "/user/1/profile"},
+					{Site: 1, Path: "/user/2/profile"},
+					{Site: 1, Path: "/zxc"},
+				}...)
+			},
+			router:   newBackend,
+			path:     `/settings/paths?preview=true&n=100&rewrites=^/user/\d%2B/profile%24+%3D>+/user/:id/profile`,
+			auth:     true,
+			wantCode: 200,
+			wantBody: "2 out of 3 paths are changed; 2 distinct paths remain.",
+		},
+
+		{
+			setup: func(ctx context.Context, t *testing.T) {
+				site := goatcounter.MustGetSite(ctx)
+				site.Settings.PathGroups = goatcounter.PathGroups{{Name: "Docs", Match: []string{"/docs", "/docs/*"}}}
+				err := site.Update(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			router:   newBackend,
+			path:     "/settings/main",
+			auth:     true,
+			wantCode: 200,
+			wantBody: "/docs = Docs\n/docs/* = Docs\n</textarea>",
+		},
 
 		{
 			setup: func(ctx context.Context, t *testing.T) {
@@ -54,6 +123,24 @@ func TestSettingsTpl(t *testing.T) {
 			wantCode: 200,
 			wantBody: "Are you sure you want to remove the site",
 		},
+
+		{
+			setup: func(ctx context.Context, t *testing.T) {
+				a := goatcounter.AuditLog{
+					Action: goatcounter.AuditSiteCode,
+					Diff:   goatcounter.NewAuditDiff(map[string]any{"code": "old"}, map[string]any{"code": "new"}),
+				}
+				err := a.Insert(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			router:   newBackend,
+			path:     "/settings/audit-log",
+			auth:     true,
+			wantCode: 200,
+			wantBody: "<code>code</code>: &#34;old&#34; → &#34;new&#34;",
+		},
 	}
 
 	for _, tt := range tests {
@@ -61,6 +148,184 @@ func TestSettingsTpl(t *testing.T) {
 	}
 }
 
+func TestSettingsAuditLog(t *testing.T) {
+	tests := []handlerTest{
+		{
+			router:       newBackend,
+			path:         "/settings/users/add",
+			body:         map[string]string{"email": "new@example.com", "password": "coconuts", "access[all]": "r"},
+			method:       "POST",
+			auth:         true,
+			wantFormCode: 303,
+		},
+	}
+
+	for _, tt := range tests {
+		runTest(t, tt, func(t *testing.T, rr *httptest.ResponseRecorder, r *http.Request) {
+			var l goatcounter.AuditLogs
+			_, err := l.List(r.Context(), goatcounter.AuditLogFilter{}, 0, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(l) != 1 {
+				t.Fatalf("len = %d: %v", len(l), l)
+			}
+
+			have := l[0]
+			if have.Action != goatcounter.AuditUserCreate || have.Actor != "test@gctest.localhost" {
+				t.Errorf("wrong action or actor: %q %q", have.Action, have.Actor)
+			}
+			if d := have.Diff["email"]; d[0] != nil || d[1] != "new@example.com" {
+				t.Errorf("wrong diff: %v", have.Diff)
+			}
+			if d := have.Diff["access"]; d[0] != nil || zjson.MustMarshalString(d[1]) != `{"all":"r"}` {
+				t.Errorf("wrong diff: %v", have.Diff)
+			}
+		})
+	}
+}
+
+func TestSettingsFilters(t *testing.T) {
+	tests := []handlerTest{
+		{
+			router:       newBackend,
+			path:         "/settings/filters",
+			body:         map[string]string{"rules": "drop path /admin/*\nbot useragent ^curl/"},
+			method:       "POST",
+			auth:         true,
+			wantFormCode: 303,
+		},
+		{
+			router:       newBackend,
+			path:         "/settings/filters",
+			body:         map[string]string{"rules": "drop ip not-an-ip"},
+			method:       "POST",
+			auth:         true,
+			wantFormCode: 200,
+			wantFormBody: "settings.filters.1.value",
+		},
+	}
+
+	for _, tt := range tests {
+		runTest(t, tt, func(t *testing.T, rr *httptest.ResponseRecorder, r *http.Request) {
+			if rr.Code != 303 {
+				return
+			}
+			var site goatcounter.Site
+			err := site.ByID(r.Context(), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if have := site.Settings.Filters.String(); have != "drop path /admin/*\nbot useragent ^curl/\n" {
+				t.Errorf("wrong filters: %q", have)
+			}
+		})
+	}
+}
+
+func TestSettingsPaths(t *testing.T) {
+	tests := []handlerTest{
+		{
+			router: newBackend,
+			path:   "/settings/paths",
+			body: map[string]string{
+				"lowercase":      "on",
+				"trailing_slash": "remove",
+				"strip_query":    "on",
+				"keep_query":     "page, lang",
+				"rewrites":       `^/user/\d+/profile$ => /user/:id/profile`,
+			},
+			method:       "POST",
+			auth:         true,
+			wantFormCode: 303,
+		},
+		{
+			router:       newBackend,
+			path:         "/settings/paths",
+			body:         map[string]string{"rewrites": "(( => /x"},
+			method:       "POST",
+			auth:         true,
+			wantFormCode: 200,
+			wantFormBody: "settings.path_rules.rewrites.1.match",
+		},
+	}
+
+	for _, tt := range tests {
+		runTest(t, tt, func(t *testing.T, rr *httptest.ResponseRecorder, r *http.Request) {
+			if rr.Code != 303 {
+				return
+			}
+			var site goatcounter.Site
+			err := site.ByID(r.Context(), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pr := site.Settings.PathRules
+			if !pr.Lowercase || pr.TrailingSlash != goatcounter.TrailingSlashRemove || !pr.StripQuery || pr.KeepQuery.String() != "page, lang" ||
+				pr.Rewrites.String() != "^/user/\\d+/profile$ => /user/:id/profile\n" {
+				t.Errorf("wrong path rules: %#v", pr)
+			}
+		})
+	}
+}
+
+func TestSettingsCampaigns(t *testing.T) {
+	setup := func(ctx context.Context, t *testing.T) {
+		gctest.StoreHits(ctx, t, false,
+			goatcounter.Hit{Site: 1, FirstVisit: true, Query: "utm_campaign=one"},
+			goatcounter.Hit{Site: 1, FirstVisit: true, Query: "utm_campaign=two"})
+	}
+	tests := []handlerTest{
+		{
+			setup:    setup,
+			router:   newBackend,
+			path:     "/settings/campaigns",
+			auth:     true,
+			wantCode: 200,
+			wantBody: `<input type="text" name="name" value="two" required>`,
+		},
+		{
+			setup:        setup,
+			router:       newBackend,
+			path:         "/settings/campaigns/2",
+			body:         map[string]string{"name": "Two", "hidden": "on"},
+			method:       "POST",
+			auth:         true,
+			wantFormCode: 303,
+		},
+		{
+			setup:        setup,
+			router:       newBackend,
+			path:         "/settings/campaigns/merge",
+			body:         map[string]string{"campaigns": "2", "merge_with": "1"},
+			method:       "POST",
+			auth:         true,
+			wantFormCode: 303,
+		},
+	}
+
+	for _, tt := range tests {
+		runTest(t, tt, func(t *testing.T, rr *httptest.ResponseRecorder, r *http.Request) {
+			if rr.Code != 303 {
+				return
+			}
+			var c goatcounter.Campaigns
+			err := c.List(r.Context())
+			if err != nil {
+				t.Fatal(err)
+			}
+			have := fmt.Sprintf("%v", c)
+			want := map[string]string{
+				"/settings/campaigns/2":     "[{1 1 one false 1} {2 1 Two true 1}]",
+				"/settings/campaigns/merge": "[{1 1 one false 2}]",
+			}[tt.path]
+			if have != want {
+				t.Errorf("\nhave: %s\nwant: %s", have, want)
+			}
+		})
+	}
+}
+
 func TestSettingsPurge(t *testing.T) {
 	t.Skip() // Fails after we stopped storing hits.
 
@@ -99,6 +364,41 @@ func TestSettingsPurge(t *testing.T) {
 	}
 }
 
+func TestSettingsRewrite(t *testing.T) {
+	tests := []handlerTest{
+		{
+			setup: func(ctx context.Context, t *testing.T) {
+				gctest.StoreHits(ctx, t, false, []goatcounter.Hit{
+					{Site: 1, Path: "/blog/asd"},
+					{Site: 1, Path: "/posts/asd"},
+					{Site: 1, Path: "/zxc"},
+				}...)
+			},
+			router:       newBackend,
+			path:         "/settings/rewrite",
+			body:         map[string]string{"match": "/blog/", "replace": "/posts/"},
+			method:       "POST",
+			auth:         true,
+			wantFormCode: 303,
+		},
+	}
+
+	for _, tt := range tests {
+		runTest(t, tt, func(t *testing.T, rr *httptest.ResponseRecorder, r *http.Request) {
+			bgrun.Wait("")
+
+			have := zdb.DumpString(r.Context(), `select path_id, path from paths order by path_id`)
+			want := `
+				path_id  path
+				2        /posts/asd
+				3        /zxc`
+			if d := zdb.Diff(have, want); d != "" {
+				t.Error(d)
+			}
+		})
+	}
+}
+
 func TestSettingsSitesAdd(t *testing.T) {
 	t.Skip()
 
@@ -308,3 +608,25 @@ func TestSettingsSitesRemove(t *testing.T) {
 		})
 	}
 }
+
+func TestUserViewSave(t *testing.T) {
+	runTest(t, handlerTest{
+		router:       newBackend,
+		path:         "/user/view",
+		method:       "POST",
+		auth:         true,
+		body:         map[string]string{"name": "default", "filter": "x", "group": "week", "period": "30"},
+		wantCode:     200,
+		wantFormCode: 200,
+	}, func(t *testing.T, rr *httptest.ResponseRecorder, r *http.Request) {
+		var u goatcounter.User
+		err := u.ByID(r.Context(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, _ := u.Settings.Views.Get("default")
+		if v.Group != goatcounter.GroupWeekly || v.Filter != "x" || v.Period != "30" {
+			t.Errorf("%#v", v)
+		}
+	})
+}
@@ -79,7 +79,7 @@ func (h settings) userPrefSave(w http.ResponseWriter, r *http.Request) error {
 		if err != nil {
 			return err
 		}
-		if args.User.AccessSettings() && args.SetSite {
+		if args.User.HasAccess(Site(ctx).ID, goatcounter.AccessSettings) && args.SetSite {
 			s := Site(ctx)
 			s.UserDefaults = args.User.Settings
 			return s.Update(ctx)
@@ -241,7 +241,7 @@ func (h settings) userDashboardSave(w http.ResponseWriter, r *http.Request) erro
 		if err != nil {
 			return err
 		}
-		if user.AccessSettings() && args.SetSite {
+		if user.HasAccess(Site(ctx).ID, goatcounter.AccessSettings) && args.SetSite {
 			s := Site(ctx)
 			s.UserDefaults = user.Settings
 			return s.Update(ctx)
@@ -262,10 +262,17 @@ func (h settings) userDashboardSave(w http.ResponseWriter, r *http.Request) erro
 
 func (h settings) userAuth(verr *zvalidate.Validator) zhttp.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) error {
+		var sessions goatcounter.UserSessions
+		err := sessions.List(r.Context(), User(r.Context()).ID)
+		if err != nil {
+			return err
+		}
+
 		return zhttp.Template(w, "user_auth.gohtml", struct {
 			Globals
 			Validate *zvalidate.Validator
-		}{newGlobals(w, r), verr})
+			Sessions goatcounter.UserSessions
+		}{newGlobals(w, r), verr, sessions})
 	}
 }
 
@@ -277,12 +284,19 @@ func (h settings) userAPI(verr *zvalidate.Validator) zhttp.HandlerFunc {
 			return err
 		}
 
+		var sites goatcounter.Sites
+		err = sites.ForThisAccount(r.Context(), false)
+		if err != nil {
+			return err
+		}
+
 		return zhttp.Template(w, "user_api.gohtml", struct {
 			Globals
 			Validate  *zvalidate.Validator
 			APITokens goatcounter.APITokens
+			Sites     goatcounter.Sites
 			Empty     goatcounter.APIToken
-		}{newGlobals(w, r), verr, tokens, goatcounter.APIToken{}})
+		}{newGlobals(w, r), verr, tokens, sites, goatcounter.APIToken{}})
 	}
 }
 
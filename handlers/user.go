@@ -12,6 +12,7 @@ import (
 	"net/http"
 	"net/mail"
 	"net/url"
+	"slices"
 	"strconv"
 	"time"
 
@@ -28,6 +29,7 @@ import (
 	"zgo.at/zhttp/auth"
 	"zgo.at/zhttp/mware"
 	"zgo.at/zlog"
+	"zgo.at/zstd/znet"
 	"zgo.at/zvalidate"
 )
 
@@ -67,10 +69,13 @@ func (h user) mount(r chi.Router) {
 	auth.Post("/user/disable-totp", zhttp.Wrap(h.disableTOTP))
 	auth.Post("/user/enable-totp", zhttp.Wrap(h.enableTOTP))
 	auth.Post("/user/resend-verify", zhttp.Wrap(h.resendVerify))
+	auth.Post("/user/sessions/remove/{id}", zhttp.Wrap(h.removeSession))
+	auth.Post("/user/sessions/remove-all", zhttp.Wrap(h.removeAllSessions))
 
 	admin := auth.With(requireAccess(goatcounter.AccessAdmin))
 	admin.Post("/user/api-token", zhttp.Wrap(h.newAPIToken))
 	admin.Post("/user/api-token/remove/{id}", zhttp.Wrap(h.deleteAPIToken))
+	admin.Post("/user/api-token/rotate/{id}", zhttp.Wrap(h.rotateAPIToken))
 }
 
 func (h user) login(w http.ResponseWriter, r *http.Request) error {
@@ -175,10 +180,17 @@ func (h user) requestLogin(w http.ResponseWriter, r *http.Request) error {
 		return zhttp.SeeOther(w, "/user/forgot?email="+url.QueryEscape(args.Email))
 	}
 
+	if d := user.LoginDelay(); d > 0 {
+		zhttp.FlashError(w, T(r.Context(), "error/login-delay|Too many failed login attempts for %(email); try again in %(duration)",
+			args.Email, d.Round(time.Second)))
+		return zhttp.SeeOther(w, "/user/new?email="+url.QueryEscape(args.Email))
+	}
+
 	err = bcrypt.CompareHashAndPassword(user.Password, []byte(args.Password))
 	if err != nil {
 		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
 			zhttp.FlashError(w, T(r.Context(), "error/login-wrong-pwd|Wrong password for %(email)", args.Email))
+			h.loginFailed(r, &user)
 		} else {
 			zhttp.FlashError(w, "Something went wrong :-( An error has been logged for investigation.") // TODO: should be more generic
 			zlog.FieldsRequest(r).Error(err)
@@ -186,25 +198,25 @@ func (h user) requestLogin(w http.ResponseWriter, r *http.Request) error {
 		return zhttp.SeeOther(w, "/user/new?email="+url.QueryEscape(args.Email))
 	}
 
-	err = user.Login(r.Context())
-	if err != nil {
-		return err
+	if !user.HasAccess(Site(r.Context()).ID, goatcounter.AccessReadOnly) {
+		zhttp.FlashError(w, T(r.Context(), "error/no-site-access|You don’t have access to this site"))
+		return zhttp.SeeOther(w, "/user/new?email="+url.QueryEscape(args.Email))
 	}
 
+	// The session is only created in finishLogin(), after the second factor is
+	// verified.
 	if user.TOTPEnabled {
-		return h.totpForm(w, r, *user.LoginToken,
-			xsrftoken.Generate(*user.LoginToken, strconv.FormatInt(user.ID, 10), actionTOTP))
+		return h.totpForm(w, r, user.ID, totpMAC(user))
 	}
 
-	auth.SetCookie(w, *user.LoginToken, cookieDomain(Site(r.Context()), r))
-	return zhttp.SeeOther(w, "/")
+	return h.finishLogin(w, r, &user)
 }
 
 func (h user) totpLogin(w http.ResponseWriter, r *http.Request) error {
 	args := struct {
-		LoginMAC       string `json:"loginmac"`
-		UserLoginToken string `json:"user_logintoken"`
-		Token          string `json:"totp_token"`
+		LoginMAC string `json:"loginmac"`
+		UserID   int64  `json:"user_id"`
+		Token    string `json:"totp_token"`
 	}{}
 	_, err := zhttp.Decode(r, &args)
 	if err != nil {
@@ -212,19 +224,25 @@ func (h user) totpLogin(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	var u goatcounter.User
-	err = u.ByTokenAndSite(r.Context(), args.UserLoginToken)
-	if err != nil {
+	err = u.ByID(r.Context(), args.UserID)
+	if err != nil && !zdb.ErrNoRows(err) {
 		return err
 	}
 
-	valid := xsrftoken.Valid(args.LoginMAC, *u.LoginToken, strconv.FormatInt(u.ID, 10), actionTOTP)
-	if testTOTP {
+	valid := err == nil && len(u.Password) > 0 &&
+		xsrftoken.Valid(args.LoginMAC, string(u.Password), strconv.FormatInt(u.ID, 10), actionTOTP)
+	if testTOTP && err == nil {
 		valid = true
 	}
 	if !valid {
 		zhttp.Flash(w, T(r.Context(), "error/login-invalid|Invalid login"))
 		return zhttp.SeeOther(w, "/user/new")
 	}
+	if d := u.LoginDelay(); d > 0 {
+		zhttp.FlashError(w, T(r.Context(), "error/login-delay|Too many failed login attempts for %(email); try again in %(duration)",
+			u.Email, d.Round(time.Second)))
+		return h.totpForm(w, r, u.ID, args.LoginMAC)
+	}
 
 	tokInt, err := strconv.ParseInt(args.Token, 10, 32)
 	if err != nil {
@@ -238,20 +256,99 @@ func (h user) totpLogin(w http.ResponseWriter, r *http.Request) error {
 		tokGen := otp.NewOTP(u.TOTPSecret, 6, sha1.New, otp.TOTP(30*time.Second, time.Now))
 		if tokGen(0, nil) != int32(tokInt) && tokGen(-1, nil) != int32(tokInt) && tokGen(1, nil) != int32(tokInt) {
 			zhttp.FlashError(w, mfaError)
-			return h.totpForm(w, r, *u.LoginToken, args.LoginMAC)
+			h.loginFailed(r, &u)
+			return h.totpForm(w, r, u.ID, args.LoginMAC)
 		}
 	}
 
-	auth.SetCookie(w, *u.LoginToken, cookieDomain(Site(r.Context()), r))
+	return h.finishLogin(w, r, &u)
+}
+
+// loginFailed records a failed login attempt, and sends an email to the user
+// if this locked their account.
+func (h user) loginFailed(r *http.Request, u *goatcounter.User) {
+	locked, err := u.LoginFailed(r.Context())
+	if err != nil {
+		zlog.FieldsRequest(r).Error(err)
+		return
+	}
+	if !locked {
+		return
+	}
+
+	site := Site(r.Context())
+	ctx := goatcounter.CopyContextValues(r.Context())
+	user := *u
+	bgrun.RunFunction("email:locked", func() {
+		err := blackmail.Send(
+			T(ctx, "email/locked-subject|Too many failed sign-in attempts for %(domain)", site.Domain(ctx)),
+			blackmail.From("GoatCounter login", goatcounter.Config(ctx).EmailFrom),
+			blackmail.To(user.Email),
+			blackmail.BodyMustText(goatcounter.TplEmailLocked{ctx, *site, user}.Render))
+		if err != nil {
+			zlog.Errorf("locked: %s", err)
+		}
+	})
+}
+
+// finishLogin completes a login after the password and second factor (if
+// enabled) are verified: failed attempts are reset, a new session is created,
+// the user gets an email if they logged in from a device we haven't seen
+// before, and the cookie is set.
+func (h user) finishLogin(w http.ResponseWriter, r *http.Request, u *goatcounter.User) error {
+	err := u.ClearLoginFailures(r.Context())
+	if err != nil {
+		return err
+	}
+	err = u.Login(r.Context(), r.UserAgent(), r.RemoteAddr)
+	if err != nil {
+		return err
+	}
+
+	site := Site(r.Context())
+	domain := cookieDomain(site, r)
+	deviceCookie := "known-device-" + strconv.FormatInt(u.ID, 10)
+	if _, err := r.Cookie(deviceCookie); err != nil && u.Session != nil {
+		ctx := goatcounter.CopyContextValues(r.Context())
+		user, sess := *u, *u.Session
+		bgrun.RunFunction("email:new-device", func() {
+			err := blackmail.Send(
+				T(ctx, "email/new-device-subject|New sign-in to %(domain)", site.Domain(ctx)),
+				blackmail.From("GoatCounter login", goatcounter.Config(ctx).EmailFrom),
+				blackmail.To(user.Email),
+				blackmail.BodyMustText(goatcounter.TplEmailNewDevice{ctx, *site, user, sess}.Render))
+			if err != nil {
+				zlog.Errorf("new device: %s", err)
+			}
+		})
+	}
+	auth.SetCookie(w, *u.LoginToken, domain)
+	http.SetCookie(w, &http.Cookie{
+		Domain:   znet.RemovePort(domain),
+		Name:     deviceCookie,
+		Value:    "1",
+		Path:     "/",
+		Expires:  time.Now().Add(365 * 24 * time.Hour),
+		HttpOnly: true,
+		Secure:   zhttp.CookieSecure,
+		SameSite: zhttp.CookieSameSite,
+	})
 	return zhttp.SeeOther(w, "/")
 }
 
-func (h user) totpForm(w http.ResponseWriter, r *http.Request, loginToken, loginMAC string) error {
+func (h user) totpForm(w http.ResponseWriter, r *http.Request, userID int64, loginMAC string) error {
 	return zhttp.Template(w, "totp.gohtml", struct {
 		Globals
-		LoginToken string
-		LoginMAC   string
-	}{newGlobals(w, r), loginToken, loginMAC})
+		UserID   int64
+		LoginMAC string
+	}{newGlobals(w, r), userID, loginMAC})
+}
+
+// totpMAC gets the MAC for the TOTP form, which proves the password was
+// verified. This is keyed on the password hash, so it's invalidated if the
+// password is changed.
+func totpMAC(u goatcounter.User) string {
+	return xsrftoken.Generate(string(u.Password), strconv.FormatInt(u.ID, 10), actionTOTP)
 }
 
 func (h user) reset(w http.ResponseWriter, r *http.Request) error {
@@ -321,6 +418,8 @@ func (h user) doReset(w http.ResponseWriter, r *http.Request) error {
 		}
 		return err
 	}
+	audit(r.WithContext(goatcounter.WithUser(r.Context(), &user)), goatcounter.AuditUserPassword, user.Site,
+		goatcounter.NewAuditDiff(nil, map[string]any{"reset": true}))
 
 	zhttp.Flash(w, T(r.Context(), "notify/login-after-password-reset|Password reset; use your new password to login."))
 	return zhttp.SeeOther(w, "/user/new")
@@ -357,6 +456,8 @@ func (h user) disableTOTP(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	audit(r, goatcounter.AuditUserTOTP, u.Site,
+		goatcounter.NewAuditDiff(map[string]any{"totp_enabled": true}, map[string]any{"totp_enabled": false}))
 
 	zhttp.Flash(w, T(r.Context(), "notify/disabled-multi-factor-auth|Multi-factor authentication disabled."))
 	return zhttp.SeeOther(w, "/user/auth")
@@ -390,6 +491,8 @@ func (h user) enableTOTP(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	audit(r, goatcounter.AuditUserTOTP, u.Site,
+		goatcounter.NewAuditDiff(map[string]any{"totp_enabled": false}, map[string]any{"totp_enabled": true}))
 
 	zhttp.Flash(w, T(r.Context(), "notify/multi-factor-auth-enabled|Multi-factor authentication enabled."))
 	return zhttp.SeeOther(w, "/user/auth")
@@ -432,11 +535,57 @@ func (h user) changePassword(w http.ResponseWriter, r *http.Request) error {
 		}
 		return err
 	}
+	audit(r, goatcounter.AuditUserPassword, u.Site, goatcounter.NewAuditDiff(nil, map[string]any{"reset": false}))
 
 	zhttp.Flash(w, T(r.Context(), "notify/password-changed|Password changed."))
 	return zhttp.SeeOther(w, "/user/auth")
 }
 
+func (h user) removeSession(w http.ResponseWriter, r *http.Request) error {
+	v := goatcounter.NewValidate(r.Context())
+	id := v.Integer("id", chi.URLParam(r, "id"))
+	if v.HasErrors() {
+		return v
+	}
+
+	u := User(r.Context())
+	var sessions goatcounter.UserSessions
+	err := sessions.List(r.Context(), u.ID)
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(sessions, func(s goatcounter.UserSession) bool { return s.ID == id })
+	if i == -1 {
+		return guru.New(404, T(r.Context(), "error/not-found|Not Found"))
+	}
+
+	err = sessions[i].Delete(r.Context())
+	if err != nil {
+		return err
+	}
+	audit(r, goatcounter.AuditUserLogout, u.Site,
+		goatcounter.NewAuditDiff(map[string]any{"session": auditSession(sessions[i])}, nil))
+
+	if u.Session != nil && u.Session.ID == id {
+		auth.ClearCookie(w, Site(r.Context()).Domain(r.Context()))
+		return zhttp.SeeOther(w, "/")
+	}
+	zhttp.Flash(w, T(r.Context(), "notify/session-removed|Signed out of the session."))
+	return zhttp.SeeOther(w, "/user/auth")
+}
+
+func (h user) removeAllSessions(w http.ResponseWriter, r *http.Request) error {
+	u := User(r.Context())
+	err := u.LogoutAll(r.Context(), true)
+	if err != nil {
+		return err
+	}
+	audit(r, goatcounter.AuditUserLogout, u.Site, nil)
+
+	zhttp.Flash(w, T(r.Context(), "notify/sessions-removed|Signed out of all other sessions."))
+	return zhttp.SeeOther(w, "/user/auth")
+}
+
 func (h user) resendVerify(w http.ResponseWriter, r *http.Request) error {
 	user := User(r.Context())
 	if user.EmailVerified {
@@ -466,6 +615,7 @@ func (h user) newAPIToken(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	audit(r, goatcounter.AuditAPITokenCreate, token.SiteID, goatcounter.NewAuditDiff(nil, auditToken(token)))
 
 	zhttp.Flash(w, T(r.Context(), "notify/api-token-created|API token created."))
 	return zhttp.SeeOther(w, "/user/api")
@@ -488,11 +638,43 @@ func (h user) deleteAPIToken(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	audit(r, goatcounter.AuditAPITokenDelete, token.SiteID, goatcounter.NewAuditDiff(auditToken(token), nil))
 
 	zhttp.Flash(w, T(r.Context(), "notify/api-token-removed|API token removed."))
 	return zhttp.SeeOther(w, "/user/api")
 }
 
+func (h user) rotateAPIToken(w http.ResponseWriter, r *http.Request) error {
+	v := goatcounter.NewValidate(r.Context())
+	id := v.Integer("id", chi.URLParam(r, "id"))
+	grace, err := time.ParseDuration(r.FormValue("grace"))
+	if err != nil || grace < 0 {
+		v.Append("grace", "invalid duration")
+	}
+	if v.HasErrors() {
+		return v
+	}
+
+	var token goatcounter.APIToken
+	err = token.ByID(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	if token.UserID != User(r.Context()).ID {
+		return guru.New(404, T(r.Context(), "error/not-found|Not Found"))
+	}
+
+	err = token.Rotate(r.Context(), grace)
+	if err != nil {
+		return err
+	}
+	audit(r, goatcounter.AuditAPITokenRotate, token.SiteID, goatcounter.NewAuditDiff(nil,
+		map[string]any{"id": token.ID, "name": token.Name, "grace": grace.String()}))
+
+	zhttp.Flash(w, T(r.Context(), "notify/api-token-rotated|New secret generated for API token ‘%(name)’.", token.Name))
+	return zhttp.SeeOther(w, "/user/api")
+}
+
 func sendEmailVerify(ctx context.Context, site *goatcounter.Site, user *goatcounter.User, emailFrom string) {
 	ctx = goatcounter.CopyContextValues(ctx)
 	bgrun.RunFunction("email:verify", func() {
@@ -6,12 +6,19 @@ package handlers
 
 import (
 	"context"
+	"crypto/sha1"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"code.soquee.net/otp"
 	"github.com/PuerkitoBio/goquery"
 	"zgo.at/goatcounter/v2"
 	"zgo.at/goatcounter/v2/gctest"
@@ -78,6 +85,69 @@ func TestUserLogin(t *testing.T) {
 	}
 }
 
+func TestUserLoginLockout(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	try := func(pwd string) *httptest.ResponseRecorder {
+		t.Helper()
+		r, rr := newTest(ctx, "POST", "/user/requestlogin", nil)
+		body, ct, err := ztest.MultipartForm(map[string]string{
+			"email":    "test@gctest.localhost",
+			"password": pwd,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", ct)
+		r.Body = io.NopCloser(body)
+		r.Host = Site(ctx).Code + "." + goatcounter.Config(ctx).Domain
+		newBackend(zdb.MustGetDB(ctx)).ServeHTTP(rr, r)
+		ztest.Code(t, rr, 303)
+		return rr
+	}
+
+	// Move the time forward for every attempt so we don't run in to the
+	// delay after a few failures.
+	for i := range 10 {
+		ztime.SetNow(t, fmt.Sprintf("2020-06-18 14:%02d:00", i*5))
+		try("wrong")
+	}
+
+	var u goatcounter.User
+	err := u.ByEmail(ctx, "test@gctest.localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !u.Locked() {
+		t.Fatalf("not locked; failures=%d", u.LoginFailures)
+	}
+
+	// Correct password doesn't work while locked.
+	rr := try("coconuts")
+	if l := rr.Header().Get("Location"); l == "/" {
+		t.Fatal("logged in while locked")
+	}
+
+	// Admin clears the lockout.
+	r, rr := newTest(ctx, "POST", "/settings/users/unlock/"+strconv.FormatInt(u.ID, 10), strings.NewReader(""))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	login(t, r)
+	newBackend(zdb.MustGetDB(ctx)).ServeHTTP(rr, r)
+	ztest.Code(t, rr, 303)
+
+	rr = try("coconuts")
+	if l := rr.Header().Get("Location"); l != "/" {
+		t.Fatalf("not logged in: %q", l)
+	}
+	var cookies []string
+	for _, c := range rr.Result().Cookies() {
+		cookies = append(cookies, c.Name)
+	}
+	if !slices.Contains(cookies, "known-device-"+strconv.FormatInt(u.ID, 10)) {
+		t.Errorf("no device cookie: %v", cookies)
+	}
+}
+
 func TestUserForgot(t *testing.T) {
 	ctx := gctest.DB(t)
 
@@ -165,6 +235,17 @@ func TestUserLoginMFA(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	// No session should be created until the TOTP token is verified.
+	sessions := func() int {
+		var n int
+		err := zdb.Get(ctx, &n, `select count(*) from user_sessions where user_id=?`, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	haveSessions := sessions()
+
 	r, rr := newTest(ctx, "POST", "/user/requestlogin", nil)
 	body, ct, err := ztest.MultipartForm(map[string]string{
 		"email":    "test@gctest.localhost",
@@ -194,23 +275,53 @@ func TestUserLoginMFA(t *testing.T) {
 		t.Errorf("no value on %v", f)
 	}
 
-	f = doc.Find(`input[name="user_logintoken"]`)
+	f = doc.Find(`input[name="user_id"]`)
 	if f.Length() != 1 {
-		t.Fatalf("no user_logintoken in %v", f)
+		t.Fatalf("no user_id in %v", f)
 	}
-	logintoken, ok := f.Attr("value")
+	userID, ok := f.Attr("value")
 	if !ok {
 		t.Errorf("no value on %v", f)
 	}
 
+	{ // Wrong token.
+		tokGen := otp.NewOTP(user.TOTPSecret, 6, sha1.New, otp.TOTP(30*time.Second, time.Now))
+		wrong := int32(0)
+		for slices.Contains([]int32{tokGen(-1, nil), tokGen(0, nil), tokGen(1, nil)}, wrong) {
+			wrong++
+		}
+
+		r, rr := newTest(ctx, "POST", "/user/totplogin", nil)
+		body, ct, err := ztest.MultipartForm(map[string]string{
+			"loginmac":   mac,
+			"user_id":    userID,
+			"totp_token": strconv.Itoa(int(wrong)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", ct)
+		r.Body = io.NopCloser(body)
+
+		r.Host = Site(ctx).Code + "." + goatcounter.Config(ctx).Domain
+		newBackend(zdb.MustGetDB(ctx)).ServeHTTP(rr, r)
+		ztest.Code(t, rr, 200)
+		if c := rr.Header().Get("Set-Cookie"); strings.HasPrefix(c, "key=") {
+			t.Error(c)
+		}
+		if n := sessions(); n != haveSessions {
+			t.Errorf("session created with wrong TOTP token: %d sessions", n)
+		}
+	}
+
 	testTOTP = true
 	defer func() { testTOTP = false }()
 
 	r, rr = newTest(ctx, "POST", "/user/totplogin", nil)
 	body, ct, err = ztest.MultipartForm(map[string]string{
-		"loginmac":        mac,
-		"user_logintoken": logintoken,
-		"totp_token":      "123456",
+		"loginmac":   mac,
+		"user_id":    userID,
+		"totp_token": "123456",
 	})
 	if err != nil {
 		t.Fatal(err)
@@ -230,6 +341,9 @@ func TestUserLoginMFA(t *testing.T) {
 	if c := rr.Header().Get("Set-Cookie"); !strings.HasPrefix(c, "key="+ztime.Now().Format("20060102")+"-") {
 		t.Error(c)
 	}
+	if n := sessions(); n != haveSessions+1 {
+		t.Errorf("%d sessions", n)
+	}
 }
 
 func TestUserLogout(t *testing.T) {
@@ -251,3 +365,175 @@ func TestUserLogout(t *testing.T) {
 		})
 	}
 }
+
+func TestUserSessions(t *testing.T) {
+	other := func(ctx context.Context, t *testing.T) {
+		u := *User(ctx)
+		err := u.Login(ctx, "curl/8.0", "192.0.2.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []handlerTest{
+		{
+			name:     "list",
+			setup:    other,
+			router:   newBackend,
+			path:     "/user/auth",
+			auth:     true,
+			wantCode: 200,
+			wantBody: "192.0.2.1",
+		},
+		{
+			name:         "remove-all",
+			setup:        other,
+			method:       "POST",
+			router:       newBackend,
+			path:         "/user/sessions/remove-all",
+			auth:         true,
+			wantFormCode: 303,
+		},
+	}
+
+	for _, tt := range tests {
+		runTest(t, tt, func(t *testing.T, rr *httptest.ResponseRecorder, r *http.Request) {
+			var sessions goatcounter.UserSessions
+			err := sessions.List(r.Context(), User(r.Context()).ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := 2
+			if tt.method == "POST" {
+				want = 1
+			}
+			if len(sessions) != want {
+				t.Errorf("%d sessions; want %d", len(sessions), want)
+			}
+		})
+	}
+}
+
+func TestUserAPIToken(t *testing.T) {
+	tests := []handlerTest{
+		{
+			name:   "create",
+			method: "POST",
+			router: newBackend,
+			path:   "/user/api-token",
+			auth:   true,
+			body: map[string]string{
+				"name":          "test",
+				"permissions[]": "2",
+				"expires_at":    "2099-01-01",
+				"sites[]":       "1",
+				"allowed_ips":   "192.0.2.0/24, 2001:db8::1",
+			},
+			wantFormCode: 303,
+		},
+	}
+
+	for _, tt := range tests {
+		runTest(t, tt, func(t *testing.T, rr *httptest.ResponseRecorder, r *http.Request) {
+			var tokens goatcounter.APITokens
+			err := tokens.List(r.Context())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(tokens) != 1 {
+				t.Fatalf("len = %d; flash: %v", len(tokens), zhttp.ReadFlash(rr, r))
+			}
+			tok := tokens[0]
+			if tok.ExpiresAt == nil || tok.ExpiresAt.Format("2006-01-02") != "2099-01-01" {
+				t.Errorf("ExpiresAt: %v", tok.ExpiresAt)
+			}
+			if !slices.Equal(tok.Sites, goatcounter.Ints{1}) {
+				t.Errorf("Sites: %v", tok.Sites)
+			}
+			if !slices.Equal(tok.AllowedIPs, goatcounter.Strings{"192.0.2.0/24", "2001:db8::1"}) {
+				t.Errorf("AllowedIPs: %v", tok.AllowedIPs)
+			}
+
+			// Rotate.
+			old := tok.Token
+			r2, rr2 := newTest(r.Context(), "POST", "/user/api-token/rotate/"+strconv.FormatInt(tok.ID, 10),
+				strings.NewReader("grace=1h"))
+			r2.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			login(t, r2)
+			newBackend(zdb.MustGetDB(r.Context())).ServeHTTP(rr2, r2)
+			ztest.Code(t, rr2, 303)
+
+			err = tok.ByID(r.Context(), tok.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tok.Token == old || tok.PreviousToken == nil || *tok.PreviousToken != old || !tok.PreviousValid() {
+				t.Errorf("not rotated: %q %v %v", tok.Token, tok.PreviousToken, tok.PreviousExpiresAt)
+			}
+		})
+	}
+}
+
+func TestUserProxyLogin(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		email      string
+		create     goatcounter.UserAccess
+		wantCode   int
+		wantLoc    string
+		wantAccess goatcounter.UserAccess
+	}{
+		{"untrusted", "192.0.2.1:1234", "test@gctest.localhost", "", 303, "/user/new", ""},
+		{"no header", "127.0.0.1:1234", "", "", 303, "/user/new", ""},
+		{"existing user", "127.0.0.1:1234", "TEST@gctest.localhost", "", 303, "/user/pref", goatcounter.AccessAdmin},
+		{"unknown user", "127.0.0.1:1234", "new@example.com", "", 303, "/user/new", ""},
+		{"create user", "[::1]:1234", "new@example.com", goatcounter.AccessReadOnly, 303, "/user/pref", goatcounter.AccessReadOnly},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := gctest.DB(t)
+			c := goatcounter.Config(ctx)
+			c.AuthProxyHeader = "X-Forwarded-Email"
+			c.AuthProxyTrusted = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+			c.AuthProxyCreate = tt.create
+
+			r, rr := newTest(ctx, "GET", "/user/pref", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.email != "" {
+				r.Header.Set("X-Forwarded-Email", tt.email)
+			}
+			newBackend(zdb.MustGetDB(ctx)).ServeHTTP(rr, r)
+			ztest.Code(t, rr, tt.wantCode)
+			if l := rr.Header().Get("Location"); l != tt.wantLoc {
+				t.Errorf("Location: %q", l)
+			}
+
+			if tt.wantAccess == "" {
+				if c := rr.Header().Get("Set-Cookie"); strings.HasPrefix(c, "key=") {
+					t.Errorf("cookie set: %s", c)
+				}
+				return
+			}
+
+			var cookie string
+			for _, c := range rr.Result().Cookies() {
+				if c.Name == "key" {
+					cookie = c.Value
+				}
+			}
+			var u goatcounter.User
+			err := u.ByTokenAndSite(ctx, cookie)
+			if err != nil {
+				t.Fatalf("wrong cookie %q: %s", cookie, err)
+			}
+			if !strings.EqualFold(u.Email, tt.email) {
+				t.Errorf("wrong user: %s", u.Email)
+			}
+			if u.Access["all"] != tt.wantAccess {
+				t.Errorf("wrong access: %s", u.Access)
+			}
+		})
+	}
+}
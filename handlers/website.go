@@ -375,7 +375,7 @@ func (h website) doSignup(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	err = user.Login(goatcounter.WithSite(r.Context(), &site))
+	err = user.Login(goatcounter.WithSite(r.Context(), &site), r.UserAgent(), r.RemoteAddr)
 	if err != nil {
 		zlog.Errorf("login during account creation: %w", err)
 	} else {
@@ -12,6 +12,7 @@ import (
 	"fmt"
 	"math"
 	"strconv"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/mattn/go-sqlite3"
@@ -158,6 +159,33 @@ func splitIntStr(ident []string) ([]int64, []string) {
 	return ids, strs
 }
 
+// textLines formats a list of rules in the line-based text form used in the
+// settings, with one rule per line.
+func textLines[T fmt.Stringer](rules []T) string {
+	b := new(strings.Builder)
+	for _, r := range rules {
+		b.WriteString(r.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// parseTextLines parses the line-based text form used in the settings, calling
+// parse for every line. Lines are trimmed, and blank lines and lines starting
+// with # are skipped. Errors are prefixed with the line number.
+func parseTextLines(text string, parse func(line string) error) error {
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if err := parse(line); err != nil {
+			return fmt.Errorf("line %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
 func NewValidate(ctx context.Context) zvalidate.Validator {
 	v := zvalidate.New()
 	v.Messages(zvalidate.Messages{
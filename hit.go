@@ -46,14 +46,16 @@ type Hit struct {
 	CreatedAt       time.Time  `db:"created_at" json:"-"`
 
 	RefURL *url.URL `db:"-" json:"-"`   // Parsed Ref
+	UTM    UTM      `db:"-" json:"-"`   // Campaign parameters other than the campaign name and source.
 	Random string   `db:"-" json:"rnd"` // Browser cache buster, as they don't always listen to Cache-Control
 
 	// Some values we need to pass from the HTTP handler to memstore
 	RemoteAddr    string `db:"-" json:"-"`
 	UserSessionID string `db:"-" json:"-"`
 
-	NoStore   bool `db:"-" json:"-"` // Don't store in hits (still store in stats).
-	noProcess bool `db:"-" json:"-"` // Don't process in memstore; for merging paths.
+	NoStore     bool   `db:"-" json:"-"` // Don't store in hits (still store in stats).
+	VisitorHash uint64 `db:"-" json:"-"` // Hash of the session key, for counting unique visitors.
+	noProcess   bool   `db:"-" json:"-"` // Don't process in memstore; for merging paths.
 }
 
 func (h *Hit) Ignore() bool {
@@ -187,6 +189,7 @@ func (h *Hit) Defaults(ctx context.Context, initial bool) error {
 		}
 	} else {
 		h.cleanPath(ctx)
+		h.Path = site.Settings.PathRules.Apply(h.Path)
 	}
 
 	// Set campaign.
@@ -213,6 +216,12 @@ func (h *Hit) Defaults(ctx context.Context, initial bool) error {
 			break
 		}
 
+		h.UTM = UTM{
+			Medium:  strings.TrimSpace(q.Get("utm_medium")),
+			Content: strings.TrimSpace(q.Get("utm_content")),
+			Term:    strings.TrimSpace(q.Get("utm_term")),
+		}
+
 		// Get campaign.
 		for _, c := range []string{"utm_campaign", "campaign"} {
 			v := strings.TrimSpace(q.Get(c))
@@ -245,7 +254,7 @@ func (h *Hit) Defaults(ctx context.Context, initial bool) error {
 		}
 
 		var generated bool
-		h.Ref, generated = cleanRefURL(h.Ref, h.RefURL)
+		h.Ref, generated = cleanRefURL(h.Ref, h.RefURL, site.Settings.RefGroups)
 		if generated {
 			h.RefScheme = RefSchemeGenerated
 		}
@@ -395,7 +404,7 @@ func (h *Hits) Purge(ctx context.Context, pathIDs []int64) error {
 	return zdb.TX(ctx, func(ctx context.Context) error {
 		site := MustGetSite(ctx).ID
 
-		for _, t := range append(statTables, "hit_counts", "ref_counts", "hits", "paths") {
+		for _, t := range append(statTables, "campaign_stats", "hit_counts", "ref_counts", "hits", "paths") {
 			err := zdb.Exec(ctx, fmt.Sprintf(query, t), site, pathIDs)
 			if err != nil {
 				return errors.Wrapf(err, "Hits.Purge %s", t)
@@ -407,36 +416,30 @@ func (h *Hits) Purge(ctx context.Context, pathIDs []int64) error {
 	})
 }
 
-// Merge the given paths.
+// Merge the given paths in to dst.
+//
+// All pageviews and stats are moved to dst, and the paths are removed. This is
+// done in one transaction.
 func (h *Hits) Merge(ctx context.Context, dst int64, pathIDs []int64) error {
 	// Shouldn't happen, but just in case.
 	pathIDs = slices.DeleteFunc(pathIDs, func(p int64) bool { return p == dst })
-
-	site := MustGetSite(ctx).ID
-
-	err := (&Path{}).ByID(ctx, dst) // Ensure this site owns the path.
-	if err != nil {
-		return errors.Wrap(err, "Hits.Merge")
+	if len(pathIDs) == 0 {
+		return nil
 	}
 
-	// Push back to lot to memstore to re-add it again, and then just call
-	// Purge() to delete the old ones.
-	err = zdb.Select(ctx, h, `select * from hits where site_id=? and path_id in (?)`, site, pathIDs)
+	site := MustGetSite(ctx)
+	err := (&Path{}).ByID(ctx, dst) // Ensure this site owns the path.
 	if err != nil {
 		return errors.Wrap(err, "Hits.Merge")
 	}
-	hh := *h
-	for i := range hh {
-		hh[i].PathID = dst
-		hh[i].noProcess = true
-	}
 
-	err = errors.Wrap(h.Purge(ctx, pathIDs), "Hits.Merge")
+	err = zdb.TX(ctx, func(ctx context.Context) error {
+		return mergePaths(ctx, site.ID, dst, pathIDs)
+	})
 	if err != nil {
 		return errors.Wrap(err, "Hits.Merge")
 	}
 
-	// Only push back if delete worked.
-	Memstore.Append(hh...)
+	site.ClearCache(ctx, true)
 	return nil
 }
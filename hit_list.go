@@ -6,11 +6,14 @@ package goatcounter
 
 import (
 	"context"
+	"fmt"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"zgo.at/errors"
+	"zgo.at/goatcounter/v2/hll"
 	"zgo.at/tz"
 	"zgo.at/zdb"
 	"zgo.at/zstd/zbool"
@@ -22,6 +25,14 @@ type HitList struct {
 	// Number of visitors for the selected date range.
 	Count int `db:"count" json:"count"`
 
+	// Estimated number of unique visitors for the selected date range; unlike
+	// Count this doesn't count the same visitor more than once if they visit
+	// again in a new session.
+	//
+	// This is counted per whole day in UTC, so the start and end of the date
+	// range are approximate for timezones other than UTC.
+	CountUnique int `db:"-" json:"count_unique,omitempty"`
+
 	// Path ID
 	PathID int64 `db:"path_id" json:"path_id"`
 
@@ -34,10 +45,11 @@ type HitList struct {
 	// Page title.
 	Title string `db:"title" json:"title"`
 
-	// Highest visitors per hour or day (depending on daily being set).
+	// Highest visitors per hour, day, week, or month (depending on the
+	// grouping).
 	Max int `json:"max"`
 
-	// Statistics by day and hour.
+	// Statistics by day and hour, or by week or month.
 	Stats []HitListStat `json:"stats"`
 
 	// What kind of referral this is; only set when retrieving referrals {enum: h g c o}.
@@ -51,9 +63,62 @@ type HitList struct {
 }
 
 type HitListStat struct {
-	Day    string `json:"day"`    // Day these statistics are for {date}.
-	Hourly []int  `json:"hourly"` // Visitors per hour.
-	Daily  int    `json:"daily"`  // Total visitors for this day.
+	// Day these statistics are for; this is the first day of the week or month
+	// when grouping by week or month {date}.
+	Day string `json:"day"`
+
+	// Visitors per hour; this is the sum for all days when grouping by week or
+	// month.
+	Hourly []int `json:"hourly"`
+
+	// Total visitors for this day, week, or month.
+	Daily int `json:"daily"`
+}
+
+// Group is the time period to group chart statistics by.
+type Group uint8
+
+const (
+	GroupHourly Group = iota
+	GroupDaily
+	GroupWeekly
+	GroupMonthly
+)
+
+var groupNames = []string{"hour", "day", "week", "month"}
+
+func (g Group) String() string {
+	if int(g) >= len(groupNames) {
+		return "Group(" + strconv.Itoa(int(g)) + ")"
+	}
+	return groupNames[g]
+}
+
+// Daily reports if the statistics are for a day or longer, rather than by
+// hour.
+func (g Group) Daily() bool { return g != GroupHourly }
+
+func (g Group) MarshalText() ([]byte, error) { return []byte(g.String()), nil }
+
+func (g *Group) UnmarshalText(v []byte) error {
+	for i, n := range groupNames {
+		if string(v) == n {
+			*g = Group(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid group: %q; must be one of %s", v, strings.Join(groupNames, ", "))
+}
+
+func (g Group) period(sundayStartsWeek bool) ztime.Period {
+	switch g {
+	case GroupWeekly:
+		return ztime.Week(sundayStartsWeek)
+	case GroupMonthly:
+		return ztime.Month
+	default:
+		return ztime.Day
+	}
 }
 
 // PathCount gets the visit count for one path.
@@ -101,7 +166,7 @@ var allDays = []int{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
 
 // List the top paths for this site in the given time period.
 func (h *HitLists) List(
-	ctx context.Context, rng ztime.Range, pathFilter, exclude []int64, limit int, daily bool,
+	ctx context.Context, rng ztime.Range, pathFilter, exclude []int64, limit int, group Group,
 ) (int, bool, error) {
 	site := MustGetSite(ctx)
 	user := MustGetUser(ctx)
@@ -179,7 +244,25 @@ func (h *HitLists) List(
 
 	// Add total and max.
 	var totalDisplay int
-	addTotals(hh, daily, &totalDisplay)
+	addTotals(hh, group.Daily(), &totalDisplay)
+	if group > GroupDaily {
+		groupStats(hh, group.period(user.Settings.SundayStartsWeek))
+	}
+
+	// Add unique visitors.
+	{
+		paths := make([]int64, len(hh))
+		for i := range hh {
+			paths[i] = hh[i].PathID
+		}
+		unique, err := countUnique(ctx, rng, paths, false)
+		if err != nil {
+			return 0, false, errors.Wrap(err, "HitLists.List")
+		}
+		for i := range hh {
+			hh[i].CountUnique = clampUnique(unique[hh[i].PathID], hh[i].Count)
+		}
+	}
 
 	return totalDisplay, more, nil
 }
@@ -190,7 +273,7 @@ func (h *HitLists) List(
 const PathTotals = "TOTAL "
 
 // Totals gets the data for the "Totals" chart/widget.
-func (h *HitList) Totals(ctx context.Context, rng ztime.Range, pathFilter []int64, daily, noEvents bool) (int, error) {
+func (h *HitList) Totals(ctx context.Context, rng ztime.Range, pathFilter []int64, group Group, noEvents bool) (int, error) {
 	site := MustGetSite(ctx)
 	user := MustGetUser(ctx)
 
@@ -234,7 +317,7 @@ func (h *HitList) Totals(ctx context.Context, rng ztime.Range, pathFilter []int6
 	max := 0
 	for _, v := range stats {
 		totalst.Stats = append(totalst.Stats, v)
-		if !daily {
+		if !group.Daily() {
 			for _, x := range v.Hourly {
 				if x > max {
 					max = x
@@ -251,16 +334,20 @@ func (h *HitList) Totals(ctx context.Context, rng ztime.Range, pathFilter []int6
 	fillBlankDays(hh, rng)
 	applyOffset(hh, user.Settings.Timezone)
 
-	if daily {
+	if group.Daily() {
 		for i := range hh[0].Stats {
 			for _, n := range hh[0].Stats[i].Hourly {
 				hh[0].Stats[i].Daily += n
 			}
-			if daily && hh[0].Stats[i].Daily > max {
+			if hh[0].Stats[i].Daily > max {
 				max = hh[0].Stats[i].Daily
 			}
 		}
 	}
+	if group > GroupDaily {
+		groupStats(hh, group.period(user.Settings.SundayStartsWeek))
+		max = hh[0].Max
+	}
 
 	if max < 10 {
 		max = 10
@@ -377,6 +464,40 @@ func fillBlankDays(hh HitLists, rng ztime.Range) {
 	}
 }
 
+// groupStats merges the daily statistics in to weeks or months, and sets Max to
+// the highest value for the period.
+//
+// This should be run after applyOffset() and addTotals(), as the days need to
+// be in the user's timezone.
+func groupStats(hh HitLists, p ztime.Period) {
+	for i := range hh {
+		var (
+			stats []HitListStat
+			max   int
+		)
+		for _, s := range hh[i].Stats {
+			d, err := time.Parse("2006-01-02", s.Day)
+			if err != nil {
+				continue
+			}
+			day := ztime.StartOf(d, p).Format("2006-01-02")
+			if len(stats) == 0 || stats[len(stats)-1].Day != day {
+				stats = append(stats, HitListStat{Day: day, Hourly: make([]int, 24)})
+			}
+
+			st := &stats[len(stats)-1]
+			for j, n := range s.Hourly {
+				st.Hourly[j] += n
+			}
+			st.Daily += s.Daily
+			if st.Daily > max {
+				max = st.Daily
+			}
+		}
+		hh[i].Stats, hh[i].Max = stats, max
+	}
+}
+
 func addTotals(hh HitLists, daily bool, totalDisplay *int) {
 	for i := range hh {
 		for j := range hh[i].Stats {
@@ -412,36 +533,112 @@ func addTotals(hh HitLists, daily bool, totalDisplay *int) {
 type TotalCount struct {
 	Total       int `db:"total" json:"total"`               // Total number of visitors (including events).
 	TotalEvents int `db:"total_events" json:"total_events"` // Total number of visitors for events.
-	// Total number of visitors in UTC. The browser, system, etc, stats are
-	// always in UTC.
+	// Total number of visitors for the browser, system, etc. stats. This used
+	// to differ from Total as these were stored per UTC day, but is now always
+	// the same. Kept for compatibility.
 	TotalUTC int `db:"total_utc" json:"total_utc"`
+	// Estimated number of unique visitors (including events); unlike Total
+	// this doesn't count the same visitor more than once if they visit more
+	// than one page or visit again in a new session.
+	//
+	// This is counted per whole day in UTC, so the start and end of the date
+	// range are approximate for timezones other than UTC.
+	TotalUnique int `db:"-" json:"total_unique"`
 }
 
 // GetTotalCount gets the total number of pageviews for the selected timeview in
 // the timezone the user configured.
-//
-// This also gets the total number of pageviews for the selected time period in
-// UTC. This is needed since the _stats tables are per day, rather than
-// per-hour, so we need to use the correct totals to make sure the percentage
-// calculations are accurate.
 func GetTotalCount(ctx context.Context, rng ztime.Range, pathFilter []int64, noEvents bool) (TotalCount, error) {
-	site := MustGetSite(ctx)
-	user := MustGetUser(ctx)
-
 	var t TotalCount
 	err := zdb.Get(ctx, &t, "load:hit_list.GetTotalCount", map[string]any{
-		"site":      site.ID,
+		"site":      MustGetSite(ctx).ID,
 		"start":     rng.Start,
 		"end":       rng.End,
-		"start_utc": rng.Start.In(user.Settings.Timezone.Location),
-		"end_utc":   rng.End.In(user.Settings.Timezone.Location),
 		"filter":    pathFilter,
 		"no_events": noEvents,
-		"tz":        user.Settings.Timezone.Offset(),
 	})
+	if err != nil {
+		return t, errors.Wrap(err, "GetTotalCount")
+	}
+
+	paths := pathFilter
+	if len(paths) == 0 {
+		paths = []int64{0}
+	}
+	unique, err := countUnique(ctx, rng, paths, true)
+	t.TotalUnique = clampUnique(unique[0], t.Total)
 	return t, errors.Wrap(err, "GetTotalCount")
 }
 
+// countUnique estimates the number of unique visitors for the paths from the
+// visitor_stats sketches; the path_id 0 has the sketches for all paths. If
+// merge is true, all the paths are merged and returned as path_id 0.
+//
+// The sketches are stored per day in UTC, so this is an approximation for
+// users in other timezones: the days are selected by the date in the user's
+// timezone, same as the daily totals.
+func countUnique(ctx context.Context, rng ztime.Range, pathIDs []int64, merge bool) (map[int64]int, error) {
+	sketches, err := visitorSketches(ctx, rng, pathIDs, merge)
+	if err != nil {
+		return nil, errors.Wrap(err, "countUnique")
+	}
+
+	counts := make(map[int64]int, len(sketches))
+	for p, s := range sketches {
+		counts[p] = s.Count()
+	}
+	return counts, nil
+}
+
+// visitorSketches gets the visitor_stats sketches for the paths, merged for
+// all days in rng. If merge is true all the paths are merged and returned as
+// path_id 0.
+func visitorSketches(ctx context.Context, rng ztime.Range, pathIDs []int64, merge bool) (map[int64]*hll.Sketch, error) {
+	user := MustGetUser(ctx)
+	var rows []struct {
+		PathID int64  `db:"path_id"`
+		Sketch []byte `db:"sketch"`
+	}
+	err := zdb.Select(ctx, &rows, `/* visitorSketches */
+		select path_id, sketch from visitor_stats
+		where site_id = :site and day >= :start and day <= :end and path_id in (:paths)`,
+		map[string]any{
+			"site":  MustGetSite(ctx).ID,
+			"start": asUTCDate(user, rng.Start),
+			"end":   asUTCDate(user, rng.End),
+			"paths": pathIDs,
+		})
+	if err != nil {
+		return nil, errors.Wrap(err, "visitorSketches")
+	}
+
+	sketches := make(map[int64]*hll.Sketch)
+	for _, r := range rows {
+		var s hll.Sketch
+		err := s.UnmarshalBinary(r.Sketch)
+		if err != nil {
+			return nil, errors.Wrap(err, "visitorSketches")
+		}
+		if merge {
+			r.PathID = 0
+		}
+		if sketches[r.PathID] == nil {
+			sketches[r.PathID] = hll.New()
+		}
+		sketches[r.PathID].Merge(&s)
+	}
+	return sketches, nil
+}
+
+// clampUnique limits the estimate of unique visitors to the total. Without any
+// sketches (e.g. when sessions aren't collected) this is just the total.
+func clampUnique(unique, total int) int {
+	if unique == 0 || unique > total {
+		return total
+	}
+	return unique
+}
+
 // Diff gets the difference in percentage of all paths in this HitList.
 //
 // e.g. if called with start=2020-01-20; end=2020-01-2020-01-27, then it will
@@ -14,7 +14,9 @@ import (
 
 	. "zgo.at/goatcounter/v2"
 	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/tz"
 	"zgo.at/zdb"
+	"zgo.at/zstd/zint"
 	"zgo.at/zstd/zjson"
 	"zgo.at/zstd/ztest"
 	"zgo.at/zstd/ztime"
@@ -168,7 +170,7 @@ func TestHitListsList(t *testing.T) {
 			}
 
 			var stats HitLists
-			uniqueDisplay, more, err := stats.List(ctx, rng, pathsFilter, tt.inExclude, 2, false)
+			uniqueDisplay, more, err := stats.List(ctx, rng, pathsFilter, tt.inExclude, 2, GroupHourly)
 
 			have := fmt.Sprintf("%d %t %v", uniqueDisplay, more, err)
 			if have != tt.wantReturn {
@@ -209,6 +211,7 @@ func TestGetTotalCount(t *testing.T) {
 		want := `{
 			"total": 3,
 			"total_events": 1,
+			"total_unique": 1,
 			"total_utc": 3
 		}`
 		if d := ztest.Diff(zjson.MustMarshalString(have), want, ztest.DiffJSON); d != "" {
@@ -217,6 +220,81 @@ func TestGetTotalCount(t *testing.T) {
 	}
 }
 
+func TestUniqueVisitors(t *testing.T) {
+	ztime.SetNow(t, "2020-06-18 12:00:00")
+	ctx := gctest.DB(t)
+
+	var (
+		day1 = ztime.FromString("2020-06-17 12:00:00")
+		day2 = ztime.FromString("2020-06-18 12:00:00")
+		a    = uint64(0x1a2b3c4d5e6f7081)
+		b    = uint64(0x9f8e7d6c5b4a3921)
+		c    = uint64(0xd1e2f3a4b5c6d7e8)
+		sa   = TestSession
+		sb   = zint.Uint128{1, 2}
+		sc   = zint.Uint128{3, 4}
+		sa2  = zint.Uint128{5, 6} // New session for a on the next day.
+	)
+	gctest.StoreHits(ctx, t, false,
+		Hit{Path: "/a", CreatedAt: day1, Session: sa, VisitorHash: a, FirstVisit: true},
+		Hit{Path: "/a", CreatedAt: day1, Session: sb, VisitorHash: b, FirstVisit: true},
+		Hit{Path: "/a", CreatedAt: day1, Session: sc, VisitorHash: c, FirstVisit: true},
+		Hit{Path: "/b", CreatedAt: day1, Session: sa, VisitorHash: a, FirstVisit: true},
+		Hit{Path: "/b", CreatedAt: day1, Session: sb, VisitorHash: b, FirstVisit: true},
+	)
+	gctest.StoreHits(ctx, t, false,
+		Hit{Path: "/a", CreatedAt: day2, Session: sa2, VisitorHash: a, FirstVisit: true})
+
+	rng := ztime.NewRange(day1).Current(ztime.Day).To(ztime.EndOf(day2, ztime.Day))
+
+	tc, err := GetTotalCount(ctx, rng, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tc.Total != 6 || tc.TotalUnique != 3 {
+		t.Errorf("total: %d; unique: %d", tc.Total, tc.TotalUnique)
+	}
+
+	var hl HitLists
+	_, _, err = hl.List(ctx, rng, nil, nil, 10, GroupDaily)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var have []string
+	for _, h := range hl {
+		have = append(have, fmt.Sprintf("%s %d %d", h.Path, h.Count, h.CountUnique))
+	}
+	if h := strings.Join(have, ", "); h != "/a 4 3, /b 2 2" {
+		t.Error(h)
+	}
+
+	// Only the second day.
+	tc, err = GetTotalCount(ctx, ztime.NewRange(day2).Current(ztime.Day), nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tc.Total != 1 || tc.TotalUnique != 1 {
+		t.Errorf("total: %d; unique: %d", tc.Total, tc.TotalUnique)
+	}
+
+	// The second day in JST starts at 15:00 UTC on the first day, but the
+	// sketches for the first day shouldn't be included.
+	user := MustGetUser(ctx)
+	user.Settings.Timezone = tz.MustNew("", "Asia/Tokyo")
+	jst := user.Settings.Timezone.Loc()
+	gctest.StoreHits(ctx, t, false,
+		Hit{Path: "/b", CreatedAt: day2, Session: zint.Uint128{7, 8}, VisitorHash: a, FirstVisit: true},
+		Hit{Path: "/c", CreatedAt: day2, Session: zint.Uint128{9, 10}, VisitorHash: a, FirstVisit: true})
+	tc, err = GetTotalCount(ctx, ztime.NewRange(time.Date(2020, 6, 18, 0, 0, 0, 0, jst)).
+		To(time.Date(2020, 6, 18, 23, 59, 59, 0, jst)).UTC(), nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tc.Total != 3 || tc.TotalUnique != 1 {
+		t.Errorf("JST total: %d; unique: %d", tc.Total, tc.TotalUnique)
+	}
+}
+
 func TestHitListTotals(t *testing.T) {
 	ztime.SetNow(t, "2020-06-18 12:00:00")
 	ctx := gctest.DB(t)
@@ -295,7 +373,7 @@ func TestHitListTotals(t *testing.T) {
 		for i, filter := range [][]int64{nil, []int64{1}, []int64{2}, []int64{1, 2}} {
 			t.Run("", func(t *testing.T) {
 				var hs HitList
-				count, err := hs.Totals(ctx, rng, filter, false, false)
+				count, err := hs.Totals(ctx, rng, filter, GroupHourly, false)
 				if err != nil {
 					t.Fatal(err)
 				}
@@ -370,7 +448,7 @@ func TestHitListTotals(t *testing.T) {
 		for i, filter := range [][]int64{nil, []int64{1}, []int64{2}, []int64{1, 2}} {
 			t.Run("", func(t *testing.T) {
 				var hs HitList
-				count, err := hs.Totals(ctx, rng, filter, true, false)
+				count, err := hs.Totals(ctx, rng, filter, GroupDaily, false)
 				if err != nil {
 					t.Fatal(err)
 				}
@@ -386,6 +464,72 @@ func TestHitListTotals(t *testing.T) {
 	})
 }
 
+func TestHitListsGroup(t *testing.T) {
+	ztime.SetNow(t, "2020-06-30 12:00:00")
+	ctx := gctest.DB(t)
+
+	user := MustGetUser(ctx)
+	user.Settings.Timezone = tz.MustNew("", "Asia/Tokyo")
+
+	gctest.StoreHits(ctx, t, false,
+		Hit{Path: "/a", FirstVisit: true, CreatedAt: ztime.FromString("2020-06-06 10:00:00")}, // Sat
+		Hit{Path: "/a", FirstVisit: true, CreatedAt: ztime.FromString("2020-06-07 20:00:00")}, // Mon in JST
+		Hit{Path: "/a", FirstVisit: true, CreatedAt: ztime.FromString("2020-06-14 10:00:00")}, // Sun
+		Hit{Path: "/a", FirstVisit: true, CreatedAt: ztime.FromString("2020-06-29 10:00:00")}, // Mon
+	)
+
+	loc := user.Settings.Timezone.Loc()
+	rng := ztime.NewRange(time.Date(2020, 6, 1, 0, 0, 0, 0, loc)).
+		To(time.Date(2020, 6, 30, 23, 59, 59, 0, loc)).UTC()
+
+	tests := []struct {
+		group  Group
+		sunday bool
+		want   string
+	}{
+		{GroupWeekly, false, "max=2 2020-06-01=1 2020-06-08=2 2020-06-15=0 2020-06-22=0 2020-06-29=1"},
+		{GroupWeekly, true, "max=1 2020-05-31=1 2020-06-07=1 2020-06-14=1 2020-06-21=0 2020-06-28=1"},
+		{GroupMonthly, false, "max=4 2020-06-01=4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.group.String(), func(t *testing.T) {
+			user.Settings.SundayStartsWeek = tt.sunday
+
+			show := func(h HitList, max int) string {
+				l := "max=" + strconv.Itoa(max)
+				for _, s := range h.Stats {
+					l += " " + s.Day + "=" + strconv.Itoa(s.Daily)
+				}
+				return l
+			}
+
+			var hl HitLists
+			total, _, err := hl.List(ctx, rng, nil, nil, 10, tt.group)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if total != 4 || len(hl) != 1 {
+				t.Fatalf("total=%d; len=%d", total, len(hl))
+			}
+			if have := show(hl[0], hl[0].Max); have != tt.want {
+				t.Errorf("List\nhave: %s\nwant: %s", have, tt.want)
+			}
+
+			var tot HitList
+			max, err := tot.Totals(ctx, rng, nil, tt.group, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Max is at least 10 for the totals.
+			want := strings.Replace(tt.want, "max="+strconv.Itoa(hl[0].Max), "max=10", 1)
+			if have := show(tot, max); have != want {
+				t.Errorf("Totals\nhave: %s\nwant: %s", have, want)
+			}
+		})
+	}
+}
+
 func TestHitListsPathCount(t *testing.T) {
 	ztime.SetNow(t, "2020-06-18")
 	ctx := gctest.DB(t)
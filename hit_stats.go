@@ -6,6 +6,8 @@ package goatcounter
 
 import (
 	"context"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -13,10 +15,12 @@ import (
 	"zgo.at/errors"
 	"zgo.at/zdb"
 	"zgo.at/zstd/ztime"
+	"zgo.at/zstd/ztype"
 )
 
 type HitStat struct {
-	// ID for selecting more details; not present in the detail view.
+	// ID for selecting more details; not present in the detail view, except
+	// for campaigns where it can be used to get the next level of details.
 	ID    string `db:"id" json:"id,omitempty"`
 	Name  string `db:"name" json:"name"`   // Display name.
 	Count int    `db:"count" json:"count"` // Number of visitors.
@@ -89,11 +93,10 @@ func (h *HitStats) ListTopRef(ctx context.Context, ref string, rng ztime.Range,
 
 // ListBrowsers lists all browser statistics for the given time period.
 func (h *HitStats) ListBrowsers(ctx context.Context, rng ztime.Range, pathFilter []int64, limit, offset int) error {
-	user := MustGetUser(ctx)
 	err := zdb.Select(ctx, &h.Stats, "load:hit_stats.ListBrowsers", map[string]any{
 		"site":   MustGetSite(ctx).ID,
-		"start":  asUTCDate(user, rng.Start),
-		"end":    asUTCDate(user, rng.End),
+		"start":  rng.Start,
+		"end":    rng.End,
 		"filter": pathFilter,
 		"limit":  limit + 1,
 		"offset": offset,
@@ -107,11 +110,10 @@ func (h *HitStats) ListBrowsers(ctx context.Context, rng ztime.Range, pathFilter
 
 // ListBrowser lists all the versions for one browser.
 func (h *HitStats) ListBrowser(ctx context.Context, browser string, rng ztime.Range, pathFilter []int64, limit, offset int) error {
-	user := MustGetUser(ctx)
 	err := zdb.Select(ctx, &h.Stats, "load:hit_stats.ListBrowser", map[string]any{
 		"site":    MustGetSite(ctx).ID,
-		"start":   asUTCDate(user, rng.Start),
-		"end":     asUTCDate(user, rng.End),
+		"start":   rng.Start,
+		"end":     rng.End,
 		"filter":  pathFilter,
 		"browser": browser,
 		"limit":   limit + 1,
@@ -126,11 +128,10 @@ func (h *HitStats) ListBrowser(ctx context.Context, browser string, rng ztime.Ra
 
 // ListSystems lists OS statistics for the given time period.
 func (h *HitStats) ListSystems(ctx context.Context, rng ztime.Range, pathFilter []int64, limit, offset int) error {
-	user := MustGetUser(ctx)
 	err := zdb.Select(ctx, &h.Stats, "load:hit_stats.ListSystems", map[string]any{
 		"site":   MustGetSite(ctx).ID,
-		"start":  asUTCDate(user, rng.Start),
-		"end":    asUTCDate(user, rng.End),
+		"start":  rng.Start,
+		"end":    rng.End,
 		"filter": pathFilter,
 		"limit":  limit + 1,
 		"offset": offset,
@@ -144,11 +145,10 @@ func (h *HitStats) ListSystems(ctx context.Context, rng ztime.Range, pathFilter
 
 // ListSystem lists all the versions for one system.
 func (h *HitStats) ListSystem(ctx context.Context, system string, rng ztime.Range, pathFilter []int64, limit, offset int) error {
-	user := MustGetUser(ctx)
 	err := zdb.Select(ctx, &h.Stats, "load:hit_stats.ListSystem", map[string]any{
 		"site":   MustGetSite(ctx).ID,
-		"start":  asUTCDate(user, rng.Start),
-		"end":    asUTCDate(user, rng.End),
+		"start":  rng.Start,
+		"end":    rng.End,
 		"filter": pathFilter,
 		"system": system,
 		"limit":  limit + 1,
@@ -170,13 +170,15 @@ const (
 	sizeUnknown     = "unknown"
 )
 
+// sizeGroups are the groups as returned by ListSizes, in order.
+var sizeGroups = []string{sizePhones, sizeLargePhones, sizeTablets, sizeDesktop, sizeDesktopHD, sizeUnknown}
+
 // ListSizes lists all device sizes.
 func (h *HitStats) ListSizes(ctx context.Context, rng ztime.Range, pathFilter []int64) error {
-	user := MustGetUser(ctx)
 	err := zdb.Select(ctx, &h.Stats, "load:hit_stats.ListSizes", map[string]any{
 		"site":   MustGetSite(ctx).ID,
-		"start":  asUTCDate(user, rng.Start),
-		"end":    asUTCDate(user, rng.End),
+		"start":  rng.Start,
+		"end":    rng.End,
 		"filter": pathFilter,
 	})
 	if err != nil {
@@ -184,36 +186,37 @@ func (h *HitStats) ListSizes(ctx context.Context, rng ztime.Range, pathFilter []
 	}
 
 	// Group a bit more user-friendly.
-	ns := []HitStat{
-		{ID: sizePhones, Count: 0},
-		{ID: sizeLargePhones, Count: 0},
-		{ID: sizeTablets, Count: 0},
-		{ID: sizeDesktop, Count: 0},
-		{ID: sizeDesktopHD, Count: 0},
-		{ID: sizeUnknown, Count: 0},
+	ns := make([]HitStat, 0, len(sizeGroups))
+	for _, g := range sizeGroups {
+		ns = append(ns, HitStat{ID: g})
 	}
 	for i := range h.Stats {
-		x, _ := strconv.ParseInt(h.Stats[i].Name, 10, 16)
-		switch {
-		case x == 0:
-			ns[5].Count += h.Stats[i].Count
-		case x <= 384:
-			ns[0].Count += h.Stats[i].Count
-		case x <= 1024:
-			ns[1].Count += h.Stats[i].Count
-		case x <= 1440:
-			ns[2].Count += h.Stats[i].Count
-		case x <= 1920:
-			ns[3].Count += h.Stats[i].Count
-		default:
-			ns[4].Count += h.Stats[i].Count
-		}
+		ns[sizeGroup(h.Stats[i].Name)].Count += h.Stats[i].Count
 	}
 	h.Stats = ns
 
 	return nil
 }
 
+// sizeGroup gets the index in sizeGroups for a width.
+func sizeGroup(width string) int {
+	x, _ := strconv.ParseInt(width, 10, 16)
+	switch {
+	case x == 0:
+		return 5
+	case x <= 384:
+		return 0
+	case x <= 1024:
+		return 1
+	case x <= 1440:
+		return 2
+	case x <= 1920:
+		return 3
+	default:
+		return 4
+	}
+}
+
 // ListSize lists all sizes for one grouping.
 func (h *HitStats) ListSize(ctx context.Context, id string, rng ztime.Range, pathFilter []int64, limit, offset int) error {
 	var (
@@ -237,11 +240,10 @@ func (h *HitStats) ListSize(ctx context.Context, id string, rng ztime.Range, pat
 		return errors.Errorf("HitStats.ListSizes: invalid value for name: %#v", id)
 	}
 
-	user := MustGetUser(ctx)
 	err := zdb.Select(ctx, &h.Stats, "load:hit_stats.ListSize", map[string]any{
 		"site":     MustGetSite(ctx).ID,
-		"start":    asUTCDate(user, rng.Start),
-		"end":      asUTCDate(user, rng.End),
+		"start":    rng.Start,
+		"end":      rng.End,
 		"filter":   pathFilter,
 		"min_size": minSize,
 		"max_size": maxSize,
@@ -264,11 +266,10 @@ func (h *HitStats) ListSize(ctx context.Context, id string, rng ztime.Range, pat
 
 // ListLocations lists all location statistics for the given time period.
 func (h *HitStats) ListLocations(ctx context.Context, rng ztime.Range, pathFilter []int64, limit, offset int) error {
-	user := MustGetUser(ctx)
 	err := zdb.Select(ctx, &h.Stats, "load:hit_stats.ListLocations", map[string]any{
 		"site":   MustGetSite(ctx).ID,
-		"start":  asUTCDate(user, rng.Start),
-		"end":    asUTCDate(user, rng.End),
+		"start":  rng.Start,
+		"end":    rng.End,
 		"filter": pathFilter,
 		"limit":  limit + 1,
 		"offset": offset,
@@ -282,11 +283,10 @@ func (h *HitStats) ListLocations(ctx context.Context, rng ztime.Range, pathFilte
 
 // ListLocation lists all divisions for a location
 func (h *HitStats) ListLocation(ctx context.Context, country string, rng ztime.Range, pathFilter []int64, limit, offset int) error {
-	user := MustGetUser(ctx)
 	err := zdb.Select(ctx, &h.Stats, "load:hit_stats.ListLocation", map[string]any{
 		"site":    MustGetSite(ctx).ID,
-		"start":   asUTCDate(user, rng.Start),
-		"end":     asUTCDate(user, rng.End),
+		"start":   rng.Start,
+		"end":     rng.End,
 		"filter":  pathFilter,
 		"country": country,
 		"limit":   limit + 1,
@@ -301,11 +301,10 @@ func (h *HitStats) ListLocation(ctx context.Context, country string, rng ztime.R
 
 // ListLanguages lists all language statistics for the given time period.
 func (h *HitStats) ListLanguages(ctx context.Context, rng ztime.Range, pathFilter []int64, limit, offset int) error {
-	user := MustGetUser(ctx)
 	err := zdb.Select(ctx, &h.Stats, "load:hit_stats.ListLanguages", map[string]any{
 		"site":   MustGetSite(ctx).ID,
-		"start":  asUTCDate(user, rng.Start),
-		"end":    asUTCDate(user, rng.End),
+		"start":  rng.Start,
+		"end":    rng.End,
 		"filter": pathFilter,
 		"limit":  limit + 1,
 		"offset": offset,
@@ -317,13 +316,33 @@ func (h *HitStats) ListLanguages(ctx context.Context, rng ztime.Range, pathFilte
 	return errors.Wrap(err, "HitStats.ListLanguages")
 }
 
+// ListChannels lists the statistics for all traffic channels for the given
+// time period.
+func (h *HitStats) ListChannels(ctx context.Context, rng ztime.Range, pathFilter []int64, limit, offset int) error {
+	err := zdb.Select(ctx, &h.Stats, "load:hit_stats.ListChannels", map[string]any{
+		"site":   MustGetSite(ctx).ID,
+		"start":  rng.Start,
+		"end":    rng.End,
+		"filter": pathFilter,
+		"limit":  limit + 1,
+		"offset": offset,
+	})
+	if len(h.Stats) > limit {
+		h.More = true
+		h.Stats = h.Stats[:len(h.Stats)-1]
+	}
+	for i := range h.Stats {
+		h.Stats[i].Name = ChannelName(ctx, h.Stats[i].ID)
+	}
+	return errors.Wrap(err, "HitStats.ListChannels")
+}
+
 // ListCampaigns lists all campaigns statistics for the given time period.
 func (h *HitStats) ListCampaigns(ctx context.Context, rng ztime.Range, pathFilter []int64, limit, offset int) error {
-	user := MustGetUser(ctx)
 	err := zdb.Select(ctx, &h.Stats, "load:hit_stats.ListCampaigns", map[string]any{
 		"site":   MustGetSite(ctx).ID,
-		"start":  asUTCDate(user, rng.Start),
-		"end":    asUTCDate(user, rng.End),
+		"start":  rng.Start,
+		"end":    rng.End,
 		"filter": pathFilter,
 		"limit":  limit + 1,
 		"offset": offset,
@@ -335,21 +354,304 @@ func (h *HitStats) ListCampaigns(ctx context.Context, rng ztime.Range, pathFilte
 	return errors.Wrap(err, "HitStats.ListCampaigns")
 }
 
-// ListCampaign lists all statistics for a campaign.
-func (h *HitStats) ListCampaign(ctx context.Context, campaign int64, rng ztime.Range, pathFilter []int64, limit, offset int) error {
-	user := MustGetUser(ctx)
-	err := zdb.Select(ctx, &h.Stats, "load:hit_stats.ListCampaign", map[string]any{
+// ListCampaign lists the statistics for a campaign.
+//
+// This lists the source and medium if only key.Campaign is set, the content
+// for a source and medium if key.Source and key.Medium are set, and the terms
+// if key.Content is also set.
+func (h *HitStats) ListCampaign(ctx context.Context, key CampaignKey, rng ztime.Range, pathFilter []int64, limit, offset int) error {
+	query := "load:hit_stats.ListCampaign"
+	switch {
+	case key.Content != nil:
+		query = "load:hit_stats.ListCampaignTerm"
+	case key.Source != nil:
+		query = "load:hit_stats.ListCampaignContent"
+	}
+
+	var st []struct {
+		Source  string `db:"source"`
+		Medium  string `db:"medium"`
+		Content string `db:"content"`
+		Term    string `db:"term"`
+		Count   int    `db:"count"`
+	}
+	err := zdb.Select(ctx, &st, query, map[string]any{
 		"site":     MustGetSite(ctx).ID,
-		"start":    asUTCDate(user, rng.Start),
-		"end":      asUTCDate(user, rng.End),
+		"start":    rng.Start,
+		"end":      rng.End,
 		"filter":   pathFilter,
-		"campaign": campaign,
+		"campaign": key.Campaign,
+		"source":   ztype.Deref(key.Source, ""),
+		"medium":   ztype.Deref(key.Medium, ""),
+		"content":  ztype.Deref(key.Content, ""),
 		"limit":    limit + 1,
 		"offset":   offset,
 	})
+	if err != nil {
+		return errors.Wrap(err, "HitStats.ListCampaign")
+	}
+	if len(st) > limit {
+		h.More = true
+		st = st[:len(st)-1]
+	}
+
+	h.Stats = make([]HitStat, 0, len(st))
+	for _, s := range st {
+		hs := HitStat{Count: s.Count}
+		switch {
+		case key.Content != nil:
+			hs.Name = s.Term
+		case key.Source != nil:
+			k := key
+			k.Content = &s.Content
+			hs.ID, hs.Name = k.String(), s.Content
+		default:
+			k := CampaignKey{Campaign: key.Campaign, Source: &s.Source, Medium: &s.Medium}
+			hs.ID = k.String()
+			switch {
+			case s.Source != "" && s.Medium != "":
+				hs.Name = s.Source + " / " + s.Medium
+			case s.Source != "":
+				hs.Name = s.Source
+			default:
+				hs.Name = s.Medium
+			}
+		}
+		h.Stats = append(h.Stats, hs)
+	}
+	return nil
+}
+
+type (
+	// HitStatsSeries is a time series for the top items of a dimension.
+	HitStatsSeries struct {
+		Series []HitStatSeries `json:"series"`
+	}
+
+	HitStatSeries struct {
+		ID    string              `json:"id"`    // ID for selecting more details.
+		Name  string              `json:"name"`  // Display name.
+		Count int                 `json:"count"` // Number of visitors over the entire period.
+		Stats []HitStatSeriesStat `json:"stats"`
+	}
+
+	HitStatSeriesStat struct {
+		Day   string `json:"day"`   // Start of the day, week, or month {date}.
+		Count int    `json:"count"` // Number of visitors.
+	}
+)
+
+// List the number of visitors per day, week, or month for the top items of
+// page.
+//
+// Page can be browsers, systems, locations, languages, sizes, campaigns,
+// channels, or groups. The group should be ztime.Day, ztime.WeekMonday, ztime.WeekSunday,
+// or ztime.Month. Periods without any visitors are included with a count of 0.
+func (h *HitStatsSeries) List(ctx context.Context, page string, rng ztime.Range, pathFilter []int64, group ztime.Period, limit int) error {
+	var (
+		top   HitStats
+		query string
+		err   error
+	)
+	switch page {
+	case "browsers":
+		query, err = "load:hit_stats.SeriesBrowsers", top.ListBrowsers(ctx, rng, pathFilter, limit, 0)
+	case "systems":
+		query, err = "load:hit_stats.SeriesSystems", top.ListSystems(ctx, rng, pathFilter, limit, 0)
+	case "locations":
+		query, err = "load:hit_stats.SeriesLocations", top.ListLocations(ctx, rng, pathFilter, limit, 0)
+	case "languages":
+		query, err = "load:hit_stats.SeriesLanguages", top.ListLanguages(ctx, rng, pathFilter, limit, 0)
+	case "campaigns":
+		query, err = "load:hit_stats.SeriesCampaigns", top.ListCampaigns(ctx, rng, pathFilter, limit, 0)
+	case "channels":
+		query, err = "load:hit_stats.SeriesChannels", top.ListChannels(ctx, rng, pathFilter, limit, 0)
+	case "groups":
+		err = top.ListGroups(ctx, rng, pathFilter, limit, 0)
+	case "sizes":
+		query, err = "load:hit_stats.SeriesSizes", top.ListSizes(ctx, rng, pathFilter)
+		top.Stats = slices.DeleteFunc(top.Stats, func(s HitStat) bool { return s.Count == 0 })
+		sort.SliceStable(top.Stats, func(i, j int) bool { return top.Stats[i].Count > top.Stats[j].Count })
+		top.Stats = top.Stats[:min(limit, len(top.Stats))]
+	default:
+		return errors.Errorf("HitStatsSeries.List: invalid page: %q", page)
+	}
+	if err != nil {
+		return errors.Wrap(err, "HitStatsSeries.List")
+	}
+
+	h.Series = make([]HitStatSeries, 0, len(top.Stats))
+	if len(top.Stats) == 0 {
+		return nil
+	}
+
+	var (
+		ids    = make([]string, 0, len(top.Stats))
+		series = make(map[string]int)
+	)
+	for i, s := range top.Stats {
+		key := s.ID
+		if page == "browsers" || page == "systems" { // Grouped by name.
+			key = s.Name
+		}
+		ids = append(ids, key)
+		series[key] = i
+
+		// Same as the /api/v0/stats/{page} endpoint.
+		if s.ID == "" {
+			s.ID = s.Name
+		}
+		h.Series = append(h.Series, HitStatSeries{ID: s.ID, Name: s.Name, Count: s.Count})
+	}
+
+	var qids any = ids
+	if page == "campaigns" {
+		cids := make([]int64, 0, len(ids))
+		for _, id := range ids {
+			n, _ := strconv.ParseInt(id, 10, 64)
+			cids = append(cids, n)
+		}
+		qids = cids
+	}
+
+	type row struct {
+		Hour  time.Time `db:"hour"`
+		ID    string    `db:"id"`
+		Count int       `db:"count"`
+	}
+	var (
+		user = MustGetUser(ctx)
+		site = MustGetSite(ctx)
+		rows []row
+	)
+	if page == "groups" {
+		// Paths can be in more than one group, so get every group separately.
+		for _, id := range ids {
+			args := map[string]any{
+				"site":   site.ID,
+				"start":  rng.Start,
+				"end":    rng.End,
+				"filter": pathFilter,
+			}
+			var grows []row
+			err = zdb.Select(ctx, &grows, `/* HitStatsSeries.List groups */
+				select hour, sum(total) as count
+				from hit_counts
+				where
+					site_id = :site and hour >= :start and hour <= :end and
+					{{:filter path_id in (:filter) and}}
+					path_id in (`+site.Settings.PathGroups.Find(id).pathsQuery(args)+`)
+				group by hour
+				order by hour asc`, args)
+			if err != nil {
+				return errors.Wrap(err, "HitStatsSeries.List")
+			}
+			for _, r := range grows {
+				r.ID = id
+				rows = append(rows, r)
+			}
+		}
+	} else {
+		err = zdb.Select(ctx, &rows, query, map[string]any{
+			"site":   site.ID,
+			"start":  rng.Start,
+			"end":    rng.End,
+			"filter": pathFilter,
+			"ids":    qids,
+		})
+		if err != nil {
+			return errors.Wrap(err, "HitStatsSeries.List")
+		}
+	}
+
+	// The stats are stored per hour in UTC; group by the date in the user's
+	// timezone.
+	var (
+		start, _ = time.Parse("2006-01-02", asUTCDate(user, rng.Start))
+		end, _   = time.Parse("2006-01-02", asUTCDate(user, rng.End))
+		periods  []string
+		period   = make(map[string]int)
+	)
+	for d := ztime.StartOf(start, group); !d.After(end); d = ztime.AddPeriod(d, 1, group) {
+		period[d.Format("2006-01-02")] = len(periods)
+		periods = append(periods, d.Format("2006-01-02"))
+	}
+	for i := range h.Series {
+		h.Series[i].Stats = make([]HitStatSeriesStat, 0, len(periods))
+		for _, p := range periods {
+			h.Series[i].Stats = append(h.Series[i].Stats, HitStatSeriesStat{Day: p})
+		}
+	}
+
+	for _, r := range rows {
+		id := r.ID
+		if page == "sizes" {
+			id = sizeGroups[sizeGroup(id)]
+		}
+		i, ok := series[id]
+		if !ok {
+			continue
+		}
+		d, _ := time.Parse("2006-01-02", asUTCDate(user, r.Hour))
+		p, ok := period[ztime.StartOf(d, group).Format("2006-01-02")]
+		if !ok {
+			continue
+		}
+		h.Series[i].Stats[p].Count += r.Count
+	}
+	return nil
+}
+
+// ListGroups lists the statistics for all content groups.
+func (h *HitStats) ListGroups(ctx context.Context, rng ztime.Range, pathFilter []int64, limit, offset int) error {
+	var groups PathGroupStats
+	err := groups.List(ctx, rng, pathFilter)
+	if err != nil {
+		return errors.Wrap(err, "HitStats.ListGroups")
+	}
+
+	groups = groups[min(offset, len(groups)):]
+	if len(groups) > limit {
+		h.More = true
+		groups = groups[:limit]
+	}
+	h.Stats = make([]HitStat, 0, len(groups))
+	for _, g := range groups {
+		h.Stats = append(h.Stats, HitStat{ID: g.Name, Name: g.Name, Count: g.Count})
+	}
+	return nil
+}
+
+// ListGroup lists all the paths in a content group.
+func (h *HitStats) ListGroup(ctx context.Context, name string, rng ztime.Range, pathFilter []int64, limit, offset int) error {
+	site := MustGetSite(ctx)
+	g := site.Settings.PathGroups.Find(name)
+	if g == nil {
+		h.Stats = []HitStat{}
+		return nil
+	}
+	args := map[string]any{
+		"site":   site.ID,
+		"start":  rng.Start,
+		"end":    rng.End,
+		"filter": pathFilter,
+		"limit":  limit + 1,
+		"offset": offset,
+	}
+	err := zdb.Select(ctx, &h.Stats, `/* HitStats.ListGroup */
+		select path as name, sum(total) as count
+		from hit_counts
+		join paths using (path_id)
+		where
+			hit_counts.site_id = :site and hour >= :start and hour <= :end and
+			{{:filter hit_counts.path_id in (:filter) and}}
+			hit_counts.path_id in (`+g.pathsQuery(args)+`)
+		group by path
+		order by count desc, path
+		limit :limit offset :offset`, args)
 	if len(h.Stats) > limit {
 		h.More = true
 		h.Stats = h.Stats[:len(h.Stats)-1]
 	}
-	return errors.Wrap(err, "HitStats.ListCampaign")
+	return errors.Wrap(err, "HitStats.ListGroup")
 }
@@ -5,12 +5,14 @@
 package goatcounter_test
 
 import (
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	. "zgo.at/goatcounter/v2"
 	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/tz"
 	"zgo.at/zdb"
 	"zgo.at/zstd/zjson"
 	"zgo.at/zstd/ztest"
@@ -33,7 +35,7 @@ func TestHitStats(t *testing.T) {
 		Hit{Path: "/y", Location: "ID-BA", Size: []float64{800, 600, 2}, UserAgentHeader: "Mozilla/5.0 (X11; Linux x86_64; Ubuntu; rv:79.0) Gecko/20100101 Firefox/79.0", FirstVisit: true},
 	)
 
-	rng := ztime.NewRange(ztime.Now()).To(ztime.Now())
+	rng := ztime.NewRange(ztime.Now()).Current(ztime.Day)
 
 	cmp := func(t *testing.T, want string, stats ...HitStats) {
 		t.Helper()
@@ -277,7 +279,7 @@ func TestListSizes(t *testing.T) {
 
 	t.Run("ListSizes", func(t *testing.T) {
 		var s HitStats
-		err := s.ListSizes(ctx, ztime.NewRange(now).To(now), nil)
+		err := s.ListSizes(ctx, ztime.NewRange(now).Current(ztime.Day), nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -327,7 +329,7 @@ func TestListSizes(t *testing.T) {
 		var got string
 		for _, w := range widths {
 			var s HitStats
-			err := s.ListSize(ctx, w.id, ztime.NewRange(now).To(now), nil, 10, 0)
+			err := s.ListSize(ctx, w.id, ztime.NewRange(now).Current(ztime.Day), nil, 10, 0)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -424,3 +426,143 @@ func TestStatsByRef(t *testing.T) {
 		t.Error(d)
 	}
 }
+
+func TestHitStatsSeries(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	var (
+		ff     = "Mozilla/5.0 (X11; Linux x86_64; rv:81.0) Gecko/20100101 Firefox/81.0"
+		chrome = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/106.0.0.0 Safari/537.36"
+		day    = func(d int) time.Time { return time.Date(2020, 6, d, 14, 0, 0, 0, time.UTC) }
+	)
+	gctest.StoreHits(ctx, t, false,
+		Hit{CreatedAt: day(1), UserAgentHeader: ff, Size: []float64{300, 0, 1}, FirstVisit: true},
+		Hit{CreatedAt: day(1), UserAgentHeader: ff, Size: []float64{1920, 0, 1}, FirstVisit: true},
+		Hit{CreatedAt: day(3), UserAgentHeader: chrome, Size: []float64{1920, 0, 1}, FirstVisit: true},
+		Hit{CreatedAt: day(9), UserAgentHeader: ff, Size: []float64{1920, 0, 1}, FirstVisit: true},
+		Hit{CreatedAt: day(9), UserAgentHeader: chrome, Size: []float64{1920, 0, 1}, FirstVisit: true},
+		Hit{CreatedAt: day(10), UserAgentHeader: ff, Size: []float64{1920, 0, 1}, FirstVisit: true},
+	)
+
+	rng := ztime.NewRange(day(1)).To(day(10))
+	tests := []struct {
+		page  string
+		group ztime.Period
+		limit int
+		want  string
+	}{
+		{"browsers", ztime.Day, 5, `
+			Firefox 4: 2020-06-01=2 2020-06-02=0 2020-06-03=0 2020-06-04=0 2020-06-05=0 2020-06-06=0 2020-06-07=0 2020-06-08=0 2020-06-09=1 2020-06-10=1
+			Chrome 2: 2020-06-01=0 2020-06-02=0 2020-06-03=1 2020-06-04=0 2020-06-05=0 2020-06-06=0 2020-06-07=0 2020-06-08=0 2020-06-09=1 2020-06-10=0`},
+		{"browsers", ztime.WeekMonday, 1, `
+			Firefox 4: 2020-06-01=2 2020-06-08=2`},
+		{"browsers", ztime.WeekSunday, 5, `
+			Firefox 4: 2020-05-31=2 2020-06-07=2
+			Chrome 2: 2020-05-31=1 2020-06-07=1`},
+		{"sizes", ztime.Month, 5, `
+			desktop 5: 2020-06-01=5
+			phone 1: 2020-06-01=1`},
+		{"locations", ztime.Month, 5, `
+			(unknown) 6: 2020-06-01=6`},
+	}
+
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			var s HitStatsSeries
+			err := s.List(ctx, tt.page, rng, nil, tt.group, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var have []string
+			for _, ss := range s.Series {
+				l := ss.ID + " " + strconv.Itoa(ss.Count) + ":"
+				for _, st := range ss.Stats {
+					l += " " + st.Day + "=" + strconv.Itoa(st.Count)
+				}
+				have = append(have, l)
+			}
+			want := strings.ReplaceAll(strings.TrimSpace(tt.want), "\t", "")
+			if h := strings.Join(have, "\n"); h != want {
+				t.Errorf("\nhave:\n%s\nwant:\n%s", h, want)
+			}
+		})
+	}
+
+	t.Run("invalid page", func(t *testing.T) {
+		var s HitStatsSeries
+		err := s.List(ctx, "toprefs", rng, nil, ztime.Day, 5)
+		if err == nil {
+			t.Fatal("err is nil")
+		}
+	})
+}
+
+// The browser, system, etc. stats should use the same day boundaries as the
+// pageviews in the user's timezone.
+func TestHitStatsTimezone(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	user := MustGetUser(ctx)
+	user.Settings.Timezone = tz.MustNew("", "Asia/Tokyo")
+
+	var (
+		ff     = "Mozilla/5.0 (X11; Linux x86_64; rv:81.0) Gecko/20100101 Firefox/81.0"
+		chrome = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/106.0.0.0 Safari/537.36"
+	)
+	gctest.StoreHits(ctx, t, false,
+		Hit{CreatedAt: ztime.FromString("2020-06-01 20:00:00"), UserAgentHeader: ff, FirstVisit: true},     // 06-02 05:00 JST
+		Hit{CreatedAt: ztime.FromString("2020-06-02 10:00:00"), UserAgentHeader: chrome, FirstVisit: true}, // 06-02 19:00 JST
+		Hit{CreatedAt: ztime.FromString("2020-06-02 16:00:00"), UserAgentHeader: ff, FirstVisit: true},     // 06-03 01:00 JST
+	)
+
+	jst := user.Settings.Timezone.Loc()
+	rng := ztime.NewRange(time.Date(2020, 6, 2, 0, 0, 0, 0, jst)).Current(ztime.Day).UTC()
+
+	tc, err := GetTotalCount(ctx, rng, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tc.Total != 2 || tc.TotalUTC != 2 {
+		t.Errorf("%#v", tc)
+	}
+
+	var stats HitStats
+	err = stats.ListBrowsers(ctx, rng, nil, 5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		have []string
+		sum  int
+	)
+	for _, s := range stats.Stats {
+		have = append(have, s.Name+"="+strconv.Itoa(s.Count))
+		sum += s.Count
+	}
+	if h := strings.Join(have, " "); h != "Chrome=1 Firefox=1" && h != "Firefox=1 Chrome=1" {
+		t.Errorf("have: %s", h)
+	}
+	if sum != tc.TotalUTC {
+		t.Errorf("sum of browsers %d != total %d", sum, tc.TotalUTC)
+	}
+
+	var series HitStatsSeries
+	err = series.List(ctx, "browsers", ztime.NewRange(time.Date(2020, 6, 1, 0, 0, 0, 0, jst)).
+		To(time.Date(2020, 6, 3, 23, 59, 59, 0, jst)).UTC(), nil, ztime.Day, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	have = nil
+	for _, ss := range series.Series {
+		l := ss.ID + ":"
+		for _, st := range ss.Stats {
+			l += " " + st.Day + "=" + strconv.Itoa(st.Count)
+		}
+		have = append(have, l)
+	}
+	want := "Firefox: 2020-06-01=0 2020-06-02=1 2020-06-03=1\nChrome: 2020-06-01=0 2020-06-02=1 2020-06-03=0"
+	if h := strings.Join(have, "\n"); h != want {
+		t.Errorf("\nhave:\n%s\nwant:\n%s", h, want)
+	}
+}
@@ -10,6 +10,8 @@ import (
 
 	. "zgo.at/goatcounter/v2"
 	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zdb"
+	"zgo.at/zstd/ztime"
 	"zgo.at/zstd/ztype"
 )
 
@@ -132,3 +134,61 @@ func TestHitDefaultsPath(t *testing.T) {
 		})
 	}
 }
+
+func TestHitDefaultsPathRules(t *testing.T) {
+	ctx := gctest.DB(t)
+	site := MustGetSite(ctx)
+	site.Settings.PathRules = PathRules{
+		Lowercase:     true,
+		TrailingSlash: TrailingSlashRemove,
+		StripQuery:    true,
+		Rewrites:      PathRewrites{{`^/user/\d+/profile$`, "/user/:id/profile"}},
+	}
+
+	h := Hit{Path: "/User/1234/Profile/?q=x"}
+	h.Defaults(ctx, false)
+	if h.Path != "/user/:id/profile" {
+		t.Errorf("wrong path: %q", h.Path)
+	}
+
+	h = Hit{Path: "/User", Event: true}
+	h.Defaults(ctx, false)
+	if h.Path != "User" {
+		t.Errorf("event path changed: %q", h.Path)
+	}
+}
+
+func TestHitsMerge(t *testing.T) {
+	ztime.SetNow(t, "2020-06-18 14:42:00")
+	ctx := gctest.DB(t)
+
+	gctest.StoreHits(ctx, t, false,
+		Hit{Path: "/a", FirstVisit: true},
+		Hit{Path: "/b", FirstVisit: true},
+		Hit{Path: "/b"},
+		Hit{Path: "/c", FirstVisit: true},
+	)
+
+	var hits Hits
+	err := hits.Merge(ctx, 1, []int64{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	have := zdb.DumpString(ctx, `select path_id, path from paths order by path_id`) +
+		zdb.DumpString(ctx, `select path_id, sum(total) as total from hit_counts group by path_id order by path_id`) +
+		zdb.DumpString(ctx, `select path_id, stats from hit_stats order by path_id`)
+	want := `
+		path_id  path
+		1        /a
+		3        /c
+		path_id  total
+		1        2
+		3        1
+		path_id  stats
+		1        [0,0,0,0,0,0,0,0,0,0,0,0,0,0,2,0,0,0,0,0,0,0,0,0]
+		3        [0,0,0,0,0,0,0,0,0,0,0,0,0,0,1,0,0,0,0,0,0,0,0,0]`
+	if d := zdb.Diff(have, want); d != "" {
+		t.Error(d)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package hll
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// splitmix64 to get well-distributed hashes for sequential numbers.
+func hash(n uint64) uint64 {
+	n += 0x9e3779b97f4a7c15
+	n = (n ^ (n >> 30)) * 0xbf58476d1ce4e5b9
+	n = (n ^ (n >> 27)) * 0x94d049bb133111eb
+	return n ^ (n >> 31)
+}
+
+func TestCount(t *testing.T) {
+	tests := []struct {
+		n      int
+		maxErr float64
+	}{
+		{0, 0},
+		{1, 0},
+		{10, 0},
+		{100, 0.03},
+		{1_000, 0.02},
+		{10_000, 0.04},
+		{100_000, 0.04},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d", tt.n), func(t *testing.T) {
+			s := New()
+			for i := 0; i < tt.n; i++ {
+				s.Add(hash(uint64(i)))
+				s.Add(hash(uint64(i))) // Duplicates shouldn't matter.
+			}
+
+			have := s.Count()
+			if tt.n == 0 {
+				if have != 0 || !s.IsEmpty() {
+					t.Fatalf("have %d; IsEmpty: %t", have, s.IsEmpty())
+				}
+				return
+			}
+			if e := math.Abs(float64(have-tt.n)) / float64(tt.n); e > tt.maxErr {
+				t.Errorf("have %d, want %d (error %.3f > %.3f)", have, tt.n, e, tt.maxErr)
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	for _, n := range []int{50, 5_000} {
+		t.Run(fmt.Sprintf("%d", n), func(t *testing.T) {
+			var (
+				a, b, all Sketch
+			)
+			for i := 0; i < n; i++ {
+				// Overlap in the middle third.
+				if i < n/3*2 {
+					a.Add(hash(uint64(i)))
+				}
+				if i >= n/3 {
+					b.Add(hash(uint64(i)))
+				}
+				all.Add(hash(uint64(i)))
+			}
+
+			a.Merge(&b)
+			if have, want := a.Count(), all.Count(); have != want {
+				t.Errorf("have %d, want %d", have, want)
+			}
+		})
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	for _, n := range []int{0, 10, 5_000} {
+		t.Run(fmt.Sprintf("%d", n), func(t *testing.T) {
+			var s Sketch
+			for i := 0; i < n; i++ {
+				s.Add(hash(uint64(i)))
+			}
+
+			b, err := s.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n <= 10 && len(b) != 3+n*3 {
+				t.Errorf("wrong length for sparse: %d", len(b))
+			}
+			if n > 10 && len(b) != 3+registers {
+				t.Errorf("wrong length for dense: %d", len(b))
+			}
+
+			var have Sketch
+			err = have.UnmarshalBinary(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if have.Count() != s.Count() {
+				t.Errorf("have %d, want %d", have.Count(), s.Count())
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, b := range [][]byte{nil, {1}, {2, 12, 0}, {1, 12, 0, 1}, {1, 12, 1, 0}, {1, 12, 9}} {
+			var s Sketch
+			if err := s.UnmarshalBinary(b); err == nil {
+				t.Errorf("no error for %v", b)
+			}
+		}
+	})
+}
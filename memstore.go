@@ -6,6 +6,11 @@ package goatcounter
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"net/url"
@@ -14,7 +19,6 @@ import (
 	"sync"
 	"time"
 
-	"zgo.at/json"
 	"zgo.at/zdb"
 	"zgo.at/zlog"
 	"zgo.at/zstd/zbool"
@@ -30,8 +34,16 @@ var (
 	TestSeqSession = zint.Uint128{TestSession[0], TestSession[1] + 1}
 )
 
+// sessionKey is a keyed hash of the User-Agent, IP address, and site ID, or the
+// user-provided session ID.
 type sessionKey string
 
+// salt for the session keys; this is rotated every SaltTime.
+type salt struct {
+	Key     []byte `json:"key"`
+	Created int64  `json:"created"`
+}
+
 type ms struct {
 	hitMu sync.RWMutex
 	hits  []Hit
@@ -41,19 +53,14 @@ type ms struct {
 	sessionHashes map[zint.Uint128]sessionKey         // sessionID → sessionKey
 	sessionPaths  map[zint.Uint128]map[int64]struct{} // SessionID → path_id
 	sessionSeen   map[zint.Uint128]int64              // SessionID → lastseen
+	salt          salt                                // Current salt.
+	prevSalt      salt                                // Previous salt, until all sessions created with it expired.
 
 	testHook bool
 }
 
 var Memstore ms
 
-type storedSession struct {
-	Sessions map[sessionKey]zint.Uint128         `json:"sessions"`
-	Hashes   map[zint.Uint128]sessionKey         `json:"hashes"`
-	Paths    map[zint.Uint128]map[int64]struct{} `json:"paths"`
-	Seen     map[zint.Uint128]int64              `json:"seen"`
-}
-
 func (m *ms) Reset() {
 	m.sessionMu.Lock()
 	defer m.sessionMu.Unlock()
@@ -62,6 +69,7 @@ func (m *ms) Reset() {
 	m.sessionHashes = make(map[zint.Uint128]sessionKey)
 	m.sessionPaths = make(map[zint.Uint128]map[int64]struct{})
 	m.sessionSeen = make(map[zint.Uint128]int64)
+	m.salt, m.prevSalt = salt{}, salt{}
 	TestSeqSession = zint.Uint128{TestSession[0], TestSession[1] + 1}
 }
 
@@ -72,74 +80,24 @@ func (m *ms) TestInit(db zdb.DB) error {
 	return m.Init(db)
 }
 
+// Init the memstore.
+//
+// Sessions aren't persisted across restarts, as that would mean writing the
+// salts to disk, which would allow recovering the IP and User-Agent from the
+// session keys by trying all IP addresses. Older versions stored the sessions
+// in the store table; this is removed here.
 func (m *ms) Init(db zdb.DB) error {
 	m.hitMu.Lock()
 	defer m.hitMu.Unlock()
 
 	m.Reset()
-	m.sessionMu.Lock()
-	defer m.sessionMu.Unlock()
-	defer func() {
-		err := db.Exec(context.Background(), `delete from store where key='session'`)
-		if err != nil {
-			zlog.Errorf("Memstore.Init: delete DB store: %w", err)
-		}
-	}()
-
-	var s []byte
-	err := db.Get(context.Background(), &s, `select value from store where key='session'`)
+	err := db.Exec(context.Background(), `delete from store where key='session'`)
 	if err != nil {
-		if zdb.ErrNoRows(err) {
-			return nil
-		}
-		zlog.Errorf("Memstore.Init: load from DB store: %w", err)
-		return nil
-	}
-
-	var stored storedSession
-	err = json.Unmarshal(s, &stored)
-	if err != nil {
-		zlog.Errorf("Memstore.Init: %w", err)
-		return nil
-	}
-
-	if stored.Sessions != nil {
-		m.sessions = stored.Sessions
-	}
-	if stored.Hashes != nil {
-		m.sessionHashes = stored.Hashes
-	}
-	if stored.Paths != nil {
-		m.sessionPaths = stored.Paths
-	}
-	if stored.Seen != nil {
-		m.sessionSeen = stored.Seen
+		zlog.Errorf("Memstore.Init: delete DB store: %w", err)
 	}
 	return nil
 }
 
-func (m *ms) StoreSessions(db zdb.DB) {
-	m.sessionMu.Lock()
-	defer m.sessionMu.Unlock()
-
-	d, err := json.Marshal(storedSession{
-		Sessions: m.sessions,
-		Paths:    m.sessionPaths,
-		Seen:     m.sessionSeen,
-		Hashes:   m.sessionHashes,
-	})
-	if err != nil {
-		zlog.Error(err)
-		return
-	}
-
-	err = db.Exec(context.Background(),
-		`insert into store (key, value) values ('session', $1)`, d)
-	if err != nil {
-		zlog.Error(err)
-	}
-}
-
 func (m *ms) Append(hits ...Hit) {
 	m.hitMu.Lock()
 	m.hits = append(m.hits, hits...)
@@ -158,31 +116,6 @@ func (m *ms) Len() int {
 	return len(m.hits)
 }
 
-var (
-	refspamSubdomains []string
-	refspamOnce       sync.Once
-)
-
-func isRefspam(host string) bool {
-	if _, ok := refspam[host]; ok {
-		return true
-	}
-
-	refspamOnce.Do(func() {
-		refspamSubdomains = make([]string, 0, len(refspam))
-		for v := range refspam {
-			refspamSubdomains = append(refspamSubdomains, "."+v)
-		}
-	})
-
-	for _, v := range refspamSubdomains {
-		if strings.HasSuffix(host, v) {
-			return true
-		}
-	}
-	return false
-}
-
 func (m *ms) Persist(ctx context.Context) ([]Hit, error) {
 	if m.Len() == 0 {
 		return nil, nil
@@ -223,22 +156,27 @@ func (m *ms) processHit(ctx context.Context, h *Hit) bool {
 		return true
 	}
 
+	var site Site
+	err := site.ByID(ctx, h.Site)
+	if err != nil {
+		l.Field("hit", fmt.Sprintf("%#v", h)).Error(err)
+		return false
+	}
+	ctx = WithSite(ctx, &site)
+
 	// Ignore spammers.
 	h.RefURL, _ = url.Parse(h.Ref)
-	if h.RefURL != nil {
-		if isRefspam(h.RefURL.Host) {
+	if h.RefURL != nil && h.RefURL.Host != "" {
+		if e := refspamEntry(h.RefURL.Host, &site.Settings); e != "" {
 			l.Debugf("refspam ignored: %q", h.RefURL.Host)
+			countRefspam(e)
 			return false
 		}
 	}
-
-	var site Site
-	err := site.ByID(ctx, h.Site)
-	if err != nil {
-		l.Field("hit", fmt.Sprintf("%#v", h)).Error(err)
+	if !site.Settings.Filters.Apply(h) {
+		l.Debugf("dropped by filter rule: %q", h.Path)
 		return false
 	}
-	ctx = WithSite(ctx, &site)
 	if !site.Settings.Collect.Has(CollectHits) && h.Bot == 0 {
 		h.NoStore = true
 	}
@@ -262,6 +200,7 @@ func (m *ms) processHit(ctx context.Context, h *Hit) bool {
 
 	if h.Session.IsZero() && site.Settings.Collect.Has(CollectSession) {
 		h.Session, h.FirstVisit = m.session(ctx, site.ID, h.PathID, h.UserSessionID, h.UserAgentHeader, h.RemoteAddr)
+		h.VisitorHash = visitorHash(site.ID, h.UserSessionID, h.UserAgentHeader, h.RemoteAddr)
 	}
 
 	if !site.Settings.Collect.Has(CollectSession) {
@@ -314,6 +253,10 @@ func (m *ms) processHit(ctx context.Context, h *Hit) bool {
 // SessionTime is the maximum length of sessions; exported here for tests.
 var SessionTime = 8 * time.Hour
 
+// SaltTime is how often the salt for the session keys is rotated; exported here
+// for tests.
+var SaltTime = 24 * time.Hour
+
 // For 10k sessions this takes about 5ms on my laptop; that's a small enough
 // delay to not overly worry about (there are rarely more than a few hundred
 // sessions at a time).
@@ -321,6 +264,7 @@ func (m *ms) EvictSessions() {
 	m.sessionMu.Lock()
 	defer m.sessionMu.Unlock()
 
+	m.rotateSalt()
 	ev := ztime.Now().Add(-SessionTime).Unix()
 	for id, seen := range m.sessionSeen {
 		if seen > ev {
@@ -353,16 +297,69 @@ func (m *ms) SessionID() zint.Uint128 {
 
 var sessLog = zlog.Module("session")
 
-func (m *ms) session(ctx context.Context, siteID, pathID int64, userSessionID, ua, remoteAddr string) (zint.Uint128, zbool.Bool) {
-	sk := sessionKey(userSessionID)
-	if userSessionID == "" {
-		sk = sessionKey(fmt.Sprintf("%s-%s-%d", ua, remoteAddr, siteID))
+// rotateSalt creates a new salt if the current one is older than SaltTime, and
+// discards the previous salt once all sessions that could have been created
+// with it have expired.
+//
+// Must hold sessionMu.
+func (m *ms) rotateSalt() {
+	now := ztime.Now()
+	if m.salt.Key == nil || now.Sub(time.Unix(m.salt.Created, 0)) >= SaltTime {
+		key := make([]byte, 32)
+		_, err := rand.Read(key)
+		if err != nil {
+			panic(fmt.Sprintf("Memstore.rotateSalt: %s", err))
+		}
+		m.prevSalt, m.salt = m.salt, salt{Key: key, Created: now.Unix()}
+		sessLog.Debug("rotated salt")
+	}
+	if m.prevSalt.Key != nil && now.Sub(time.Unix(m.salt.Created, 0)) >= SessionTime {
+		m.prevSalt = salt{}
 	}
+}
 
+func (s salt) sessionKey(siteID int64, userSessionID, ua, remoteAddr string) sessionKey {
+	h := hmac.New(sha256.New, s.Key)
+	if userSessionID != "" {
+		h.Write([]byte(userSessionID))
+	} else {
+		fmt.Fprintf(h, "%s-%s-%d", ua, remoteAddr, siteID)
+	}
+	return sessionKey(base64.RawURLEncoding.EncodeToString(h.Sum(nil)))
+}
+
+// visitorHash gets a hash for the HyperLogLog sketches of unique visitors.
+//
+// This isn't salted, as it needs to be the same across days. It's never stored:
+// the sketches only store the register index and the number of leading zeros.
+// For sketches with few visitors this can be brute-forced to test if an IP and
+// User-Agent are likely to be in it; see tpl/help/sessions.md.
+func visitorHash(siteID int64, userSessionID, ua, remoteAddr string) uint64 {
+	k := userSessionID
+	if k == "" {
+		k = fmt.Sprintf("%s-%s-%d", ua, remoteAddr, siteID)
+	}
+	h := sha256.Sum256([]byte(k))
+	return binary.LittleEndian.Uint64(h[:8])
+}
+
+func (m *ms) session(ctx context.Context, siteID, pathID int64, userSessionID, ua, remoteAddr string) (zint.Uint128, zbool.Bool) {
 	m.sessionMu.Lock()
 	defer m.sessionMu.Unlock()
 
+	m.rotateSalt()
+	sk := m.salt.sessionKey(siteID, userSessionID, ua, remoteAddr)
 	id, ok := m.sessions[sk]
+	if !ok && m.prevSalt.Key != nil {
+		// Session started before the salt was rotated; use the new key from
+		// now on.
+		prev := m.prevSalt.sessionKey(siteID, userSessionID, ua, remoteAddr)
+		if id, ok = m.sessions[prev]; ok {
+			delete(m.sessions, prev)
+			m.sessions[sk] = id
+			m.sessionHashes[id] = sk
+		}
+	}
 	if ok { // Existing session
 		m.sessionSeen[id] = ztime.Now().Unix()
 		_, seenPath := m.sessionPaths[id][pathID]
@@ -7,6 +7,7 @@ package goatcounter_test
 import (
 	"context"
 	"testing"
+	"time"
 
 	. "zgo.at/goatcounter/v2"
 	"zgo.at/goatcounter/v2/gctest"
@@ -164,3 +165,68 @@ func TestMemstoreCollect(t *testing.T) {
 		})
 	}
 }
+
+func TestMemstoreSessionSalt(t *testing.T) {
+	ztime.SetNow(t, "2020-06-18 12:00:00")
+	ctx := gctest.DB(t)
+	site := MustGetSite(ctx)
+
+	defer func(d time.Duration) { SaltTime = d }(SaltTime)
+	SaltTime = time.Hour
+
+	send := func(t *testing.T, ua string) Hit {
+		t.Helper()
+		Memstore.Append(Hit{Site: site.ID, Path: "/", UserAgentHeader: ua, RemoteAddr: "1.2.3.4"})
+		hits, err := Memstore.Persist(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hits) != 1 {
+			t.Fatalf("len(hits) = %d", len(hits))
+		}
+		return hits[0]
+	}
+
+	first := send(t, "Mozilla/5.0")
+	if !first.FirstVisit {
+		t.Error("not first visit")
+	}
+
+	// Keep the session after the salt is rotated.
+	ztime.SetNow(t, "2020-06-18 13:00:00")
+	Memstore.EvictSessions()
+	if h := send(t, "Mozilla/5.0"); h.Session != first.Session || h.FirstVisit {
+		t.Errorf("new session after rotating salt: %s %t", h.Session, h.FirstVisit)
+	}
+	if h := send(t, "other"); h.Session == first.Session || !h.FirstVisit {
+		t.Errorf("same session for other UA: %s %t", h.Session, h.FirstVisit)
+	}
+
+	// Sessions are evicted as usual, and the previous salt is discarded.
+	ztime.SetNow(t, "2020-06-18 21:30:00")
+	Memstore.EvictSessions()
+	if n := Memstore.SessionsLen(); n != 0 {
+		t.Errorf("SessionsLen() = %d", n)
+	}
+	last := send(t, "Mozilla/5.0")
+	if last.Session == first.Session || !last.FirstVisit {
+		t.Errorf("same session after eviction: %s %t", last.Session, last.FirstVisit)
+	}
+
+	// Sessions aren't kept after a restart, and nothing is written to the DB.
+	err := Memstore.TestInit(zdb.MustGetDB(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := send(t, "Mozilla/5.0"); h.Session == last.Session || !h.FirstVisit {
+		t.Errorf("same session after restart: %s %t", h.Session, h.FirstVisit)
+	}
+	var n int
+	err = zdb.Get(ctx, &n, `select count(*) from store where key='session'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("sessions stored in DB")
+	}
+}
@@ -7,8 +7,10 @@ package goatcounter
 import (
 	"context"
 	"strconv"
+	"strings"
 
 	"zgo.at/errors"
+	"zgo.at/guru"
 	"zgo.at/zcache"
 	"zgo.at/zdb"
 	"zgo.at/zlog"
@@ -16,11 +18,12 @@ import (
 )
 
 type Path struct {
-	ID    int64      `db:"path_id" json:"id"` // Path ID
-	Site  int64      `db:"site_id" json:"-"`
-	Path  string     `db:"path" json:"path"`   // Path name
-	Title string     `db:"title" json:"title"` // Page title
-	Event zbool.Bool `db:"event" json:"event"` // Is this an event?
+	ID     int64      `db:"path_id" json:"id"` // Path ID
+	Site   int64      `db:"site_id" json:"-"`
+	Path   string     `db:"path" json:"path"`     // Path name
+	Title  string     `db:"title" json:"title"`   // Page title
+	Event  zbool.Bool `db:"event" json:"event"`   // Is this an event?
+	Hidden zbool.Bool `db:"hidden" json:"hidden"` // Hidden from the dashboard?
 }
 
 func (p *Path) Defaults(ctx context.Context) {}
@@ -98,6 +101,38 @@ func (p *Path) GetOrInsert(ctx context.Context) error {
 	return nil
 }
 
+// Update the path name, title, and hidden flag.
+func (p *Path) Update(ctx context.Context) error {
+	if p.ID == 0 {
+		return errors.New("ID == 0")
+	}
+
+	var old Path
+	err := old.ByID(ctx, p.ID)
+	if err != nil {
+		return errors.Wrap(err, "Path.Update")
+	}
+
+	p.Defaults(ctx)
+	err = p.Validate(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = zdb.Exec(ctx, `update paths set path=?, title=?, hidden=? where path_id=? and site_id=?`,
+		p.Path, p.Title, p.Hidden, p.ID, MustGetSite(ctx).ID)
+	if err != nil {
+		if zdb.ErrUnique(err) {
+			return guru.Errorf(400, "path %q already exists; merge the paths instead", p.Path)
+		}
+		return errors.Wrap(err, "Path.Update")
+	}
+
+	cachePaths(ctx).Delete(strconv.FormatInt(MustGetSite(ctx).ID, 10) + old.Path)
+	cacheChangedTitles(ctx).Delete(strconv.FormatInt(p.ID, 10))
+	return nil
+}
+
 func (p Path) updateTitle(ctx context.Context, currentTitle, newTitle string) error {
 	if newTitle == currentTitle {
 		return nil
@@ -162,7 +197,23 @@ func (p *Paths) List(ctx context.Context, siteID, after int64, limit int) (bool,
 // PathFilter returns a list of IDs matching the path name.
 //
 // if matchTitle is true it will match the title as well.
+//
+// A filter of "group:name" returns all paths in the content group with that
+// name, if it exists.
 func PathFilter(ctx context.Context, filter string, matchTitle bool) ([]int64, error) {
+	if name, ok := strings.CutPrefix(filter, "group:"); ok {
+		if g := MustGetSite(ctx).Settings.PathGroups.Find(strings.TrimSpace(name)); g != nil {
+			paths, err := g.PathIDs(ctx)
+			if err != nil {
+				return nil, errors.Wrap(err, "PathFilter")
+			}
+			if len(paths) == 0 {
+				paths = []int64{-1}
+			}
+			return paths, nil
+		}
+	}
+
 	var paths []int64
 	err := zdb.Select(ctx, &paths, "load:paths.PathFilter", map[string]any{
 		"site":        MustGetSite(ctx).ID,
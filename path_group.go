@@ -0,0 +1,270 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"zgo.at/errors"
+	"zgo.at/goatcounter/v2/hll"
+	"zgo.at/zdb"
+	"zgo.at/zstd/ztime"
+)
+
+type (
+	// PathGroup is a named group of paths, such as "Docs" or "Blog posts".
+	PathGroup struct {
+		Name string `json:"name"`
+
+		// Paths in this group; a "*" matches any number of characters, so
+		// "/docs/*" matches all paths starting with "/docs/". Paths without a
+		// "*" are matched exactly. Matching is case-insensitive.
+		Match []string `json:"match"`
+	}
+
+	// PathGroups is a list of content groups; a path can be in more than one
+	// group.
+	//
+	// In text form this is one path per line as "path = name"; lines with
+	// the same name are added to the same group.
+	PathGroups []PathGroup
+)
+
+// String gets the text form of the group, with one line for every path.
+func (g PathGroup) String() string {
+	lines := make([]string, 0, len(g.Match))
+	for _, m := range g.Match {
+		lines = append(lines, m+" = "+g.Name)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (p PathGroups) String() string { return textLines(p) }
+
+// Find the group by name, or nil if there is no group with this name.
+func (p PathGroups) Find(name string) *PathGroup {
+	for i := range p {
+		if p[i].Name == name {
+			return &p[i]
+		}
+	}
+	return nil
+}
+
+// Validate the groups.
+func (p PathGroups) Validate(ctx context.Context) error {
+	v := NewValidate(ctx)
+	for i, g := range p {
+		k := fmt.Sprintf("%d", i+1)
+		v.Required(k+".name", g.Name)
+		v.Len(k+".name", g.Name, 0, 250)
+		if len(g.Match) == 0 {
+			v.Append(k+".match", "must have at least one path")
+		}
+		for _, m := range g.Match {
+			v.Len(k+".match", m, 1, 2048)
+		}
+	}
+	return v.ErrorOrNil()
+}
+
+// UnmarshalJSON decodes the JSON form, which is a list of objects.
+//
+// This is implemented explicitly because UnmarshalText would otherwise be used.
+func (p *PathGroups) UnmarshalJSON(b []byte) error {
+	var g []PathGroup
+	err := json.Unmarshal(b, &g)
+	*p = g
+	return err
+}
+
+// UnmarshalText decodes the text form, for forms.
+func (p *PathGroups) UnmarshalText(b []byte) error {
+	g, err := ParsePathGroups(string(b))
+	if err != nil {
+		return err
+	}
+	*p = g
+	return nil
+}
+
+// ParsePathGroups parses the text form of the groups, as returned by
+// PathGroups.String().
+//
+// The name is everything after the last "=", so paths can contain a "=".
+func ParsePathGroups(text string) (PathGroups, error) {
+	groups := PathGroups{}
+	err := parseTextLines(text, func(line string) error {
+		j := strings.LastIndexByte(line, '=')
+		if j == -1 {
+			return fmt.Errorf("need \"path = name\": %q", line)
+		}
+		match, name := strings.TrimSpace(line[:j]), strings.TrimSpace(line[j+1:])
+
+		if g := groups.Find(name); g != nil {
+			g.Match = append(g.Match, match)
+		} else {
+			groups = append(groups, PathGroup{Name: name, Match: []string{match}})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// pathsQuery gets a query to select the path_id of all paths in this group,
+// for use in "path_id in (…)". Parameters for the query are added to args,
+// which must have the site as "site".
+func (g PathGroup) pathsQuery(args map[string]any) string {
+	var (
+		like  = strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`, `*`, `%`)
+		where = make([]string, 0, len(g.Match))
+	)
+	for i, m := range g.Match {
+		k := fmt.Sprintf("group_match%d", i)
+		args[k] = like.Replace(m)
+		where = append(where, `lower(path) like lower(:`+k+`) escape '!'`)
+	}
+	if len(where) == 0 {
+		where = append(where, "0=1")
+	}
+	return `select path_id from paths where site_id = :site and (` + strings.Join(where, " or ") + `)`
+}
+
+// PathIDs gets the IDs of all paths in this group.
+//
+// Like PathFilter() this returns at most 65,500 paths, as the result is
+// usually passed as query parameters.
+func (g PathGroup) PathIDs(ctx context.Context) ([]int64, error) {
+	args := map[string]any{"site": MustGetSite(ctx).ID}
+	paths := []int64{}
+	err := zdb.Select(ctx, &paths, `/* PathGroup.PathIDs */
+		`+g.pathsQuery(args)+` limit 65500`, args)
+	return paths, errors.Wrap(err, "PathGroup.PathIDs")
+}
+
+type (
+	// PathGroupStat is the number of visitors for a content group.
+	PathGroupStat struct {
+		Name string `json:"name"`
+
+		// Paths in this group, up to 65,500 paths; this can be used as
+		// include_paths for the other stats endpoints to get the referrers,
+		// locations, etc. for the group.
+		//
+		// This is only set by ListPathIDs(), and omitted if it's empty.
+		PathIDs []int64 `json:"path_ids,omitempty"`
+
+		// Number of visitors for all paths in the group.
+		Count int `json:"count"`
+
+		// Estimated number of unique visitors for all paths in the group;
+		// counted per day in UTC, like HitList.CountUnique.
+		CountUnique int `json:"count_unique"`
+	}
+
+	// PathGroupStats is a list of visitor counts for content groups.
+	PathGroupStats []PathGroupStat
+)
+
+// List the number of visitors for all of the site's content groups, sorted by
+// the number of visitors.
+//
+// Only paths in pathFilter are counted if it's not empty.
+func (s *PathGroupStats) List(ctx context.Context, rng ztime.Range, pathFilter []int64) error {
+	var (
+		site   = MustGetSite(ctx)
+		user   = MustGetUser(ctx)
+		groups = site.Settings.PathGroups
+		stats  = make(PathGroupStats, 0, len(groups))
+	)
+	for _, g := range groups {
+		args := map[string]any{
+			"site":      site.ID,
+			"start":     rng.Start,
+			"end":       rng.End,
+			"start_day": asUTCDate(user, rng.Start),
+			"end_day":   asUTCDate(user, rng.End),
+			"filter":    pathFilter,
+		}
+		paths := g.pathsQuery(args)
+
+		var total int
+		err := zdb.Get(ctx, &total, `/* PathGroupStats.List */
+			select coalesce(sum(total), 0) from hit_counts
+			where
+				site_id = :site and hour >= :start and hour <= :end and
+				{{:filter path_id in (:filter) and}}
+				path_id in (`+paths+`)`, args)
+		if err != nil {
+			return errors.Wrap(err, "PathGroupStats.List")
+		}
+
+		var sketches []struct {
+			Sketch []byte `db:"sketch"`
+		}
+		err = zdb.Select(ctx, &sketches, `/* PathGroupStats.List */
+			select sketch from visitor_stats
+			where
+				site_id = :site and day >= :start_day and day <= :end_day and
+				{{:filter path_id in (:filter) and}}
+				path_id in (`+paths+`)`, args)
+		if err != nil {
+			return errors.Wrap(err, "PathGroupStats.List")
+		}
+		unique := hll.New()
+		for _, r := range sketches {
+			var sk hll.Sketch
+			err := sk.UnmarshalBinary(r.Sketch)
+			if err != nil {
+				return errors.Wrap(err, "PathGroupStats.List")
+			}
+			unique.Merge(&sk)
+		}
+
+		stats = append(stats, PathGroupStat{
+			Name:        g.Name,
+			Count:       total,
+			CountUnique: clampUnique(unique.Count(), total),
+		})
+	}
+
+	slices.SortStableFunc(stats, func(a, b PathGroupStat) int { return cmp.Compare(b.Count, a.Count) })
+	*s = stats
+	return nil
+}
+
+// ListPathIDs sets PathIDs for all groups.
+//
+// Only paths in pathFilter are included if it's not empty.
+func (s PathGroupStats) ListPathIDs(ctx context.Context, pathFilter []int64) error {
+	groups := MustGetSite(ctx).Settings.PathGroups
+	filter := make(map[int64]struct{}, len(pathFilter))
+	for _, id := range pathFilter {
+		filter[id] = struct{}{}
+	}
+	for i := range s {
+		g := groups.Find(s[i].Name)
+		if g == nil {
+			continue
+		}
+		ids, err := g.PathIDs(ctx)
+		if err != nil {
+			return errors.Wrap(err, "PathGroupStats.ListPathIDs")
+		}
+		if len(filter) > 0 {
+			ids = slices.DeleteFunc(ids, func(id int64) bool { _, ok := filter[id]; return !ok })
+		}
+		s[i].PathIDs = ids
+	}
+	return nil
+}
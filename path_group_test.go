@@ -0,0 +1,190 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	. "zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zstd/ztime"
+)
+
+func TestParsePathGroups(t *testing.T) {
+	have, err := ParsePathGroups(`
+		# Comment
+		/pricing        = Pricing pages
+		/docs/*         = Docs
+		/search?q=x     = Search
+		/pricing/*      = Pricing pages
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := PathGroups{
+		{Name: "Pricing pages", Match: []string{"/pricing", "/pricing/*"}},
+		{Name: "Docs", Match: []string{"/docs/*"}},
+		{Name: "Search", Match: []string{"/search?q=x"}},
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("\nhave: %#v\nwant: %#v", have, want)
+	}
+
+	again, err := ParsePathGroups(have.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again, want) {
+		t.Errorf("round-trip\nhave: %#v\nwant: %#v", again, want)
+	}
+
+	_, err = ParsePathGroups("/docs/*")
+	if err == nil {
+		t.Error("no error for missing name")
+	}
+
+	err = PathGroups{{Name: "", Match: []string{"/x"}}, {Name: "x"}}.Validate(gctest.Context(nil))
+	if err == nil {
+		t.Fatal("no validation error")
+	}
+	for _, k := range []string{"1.name", "2.match"} {
+		if !strings.Contains(err.Error(), k) {
+			t.Errorf("no error for %s:\n%s", k, err)
+		}
+	}
+}
+
+func TestPathGroups(t *testing.T) {
+	ztime.SetNow(t, "2020-06-18 12:00:00")
+	ctx := gctest.DB(t)
+
+	site := MustGetSite(ctx)
+	site.Settings.PathGroups = PathGroups{
+		{Name: "Docs", Match: []string{"/docs", "/DOCS/*"}},
+		{Name: "Pricing", Match: []string{"/pricing_plans"}},
+		{Name: "Empty", Match: []string{"/nothing/*"}},
+	}
+	err := site.Update(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gctest.StoreHits(ctx, t, false,
+		Hit{Path: "/docs", FirstVisit: true},
+		Hit{Path: "/docs/api", FirstVisit: true},
+		Hit{Path: "/docs/api", FirstVisit: true},
+		Hit{Path: "/docsx", FirstVisit: true},
+		Hit{Path: "/pricing_plans", FirstVisit: true},
+		Hit{Path: "/pricingXplans", FirstVisit: true},
+	)
+
+	t.Run("PathIDs", func(t *testing.T) {
+		for _, tt := range []struct {
+			group string
+			want  []int64
+		}{
+			{"Docs", []int64{1, 2}},
+			{"Pricing", []int64{4}},
+			{"Empty", []int64{}},
+		} {
+			have, err := site.Settings.PathGroups.Find(tt.group).PathIDs(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(have, tt.want) {
+				t.Errorf("%s: %v", tt.group, have)
+			}
+		}
+	})
+
+	t.Run("PathFilter", func(t *testing.T) {
+		have, err := PathFilter(ctx, "group:Docs", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(have, []int64{1, 2}) {
+			t.Error(have)
+		}
+
+		have, err = PathFilter(ctx, "group:Empty", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(have, []int64{-1}) {
+			t.Error(have)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		var stats PathGroupStats
+		err := stats.List(ctx, ztime.NewRange(ztime.Now()).Current(ztime.Day), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		have := fmt.Sprintf("%v", stats)
+		want := "[{Docs [] 3 1} {Pricing [] 1 1} {Empty [] 0 0}]"
+		if have != want {
+			t.Errorf("\nhave: %s\nwant: %s", have, want)
+		}
+
+		err = stats.ListPathIDs(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		have = fmt.Sprintf("%v", stats)
+		want = "[{Docs [1 2] 3 1} {Pricing [4] 1 1} {Empty [] 0 0}]"
+		if have != want {
+			t.Errorf("\nhave: %s\nwant: %s", have, want)
+		}
+
+		err = stats.List(ctx, ztime.NewRange(ztime.Now()).Current(ztime.Day), []int64{2, 4})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = stats.ListPathIDs(ctx, []int64{2, 4})
+		if err != nil {
+			t.Fatal(err)
+		}
+		have = fmt.Sprintf("%v", stats)
+		want = "[{Docs [2] 2 1} {Pricing [4] 1 1} {Empty [] 0 0}]"
+		if have != want {
+			t.Errorf("\nhave: %s\nwant: %s", have, want)
+		}
+	})
+
+	t.Run("HitStats", func(t *testing.T) {
+		rng := ztime.NewRange(ztime.Now()).Current(ztime.Day)
+
+		var stats HitStats
+		err := stats.ListGroups(ctx, rng, nil, 2, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have := fmt.Sprintf("%t %v", stats.More, stats.Stats); have != "true [{Docs Docs 3 <nil>} {Pricing Pricing 1 <nil>}]" {
+			t.Error(have)
+		}
+
+		stats = HitStats{}
+		err = stats.ListGroup(ctx, "Docs", rng, nil, 10, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have := fmt.Sprintf("%t %v", stats.More, stats.Stats); have != "false [{ /docs/api 2 <nil>} { /docs 1 <nil>}]" {
+			t.Error(have)
+		}
+
+		var series HitStatsSeries
+		err = series.List(ctx, "groups", rng, nil, ztime.Day, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have := fmt.Sprintf("%v", series.Series); have != "[{Docs Docs 3 [{2020-06-18 3}]} {Pricing Pricing 1 [{2020-06-18 1}]}]" {
+			t.Error(have)
+		}
+	})
+}
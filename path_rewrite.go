@@ -0,0 +1,297 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"zgo.at/errors"
+	"zgo.at/goatcounter/v2/hll"
+	"zgo.at/guru"
+	"zgo.at/zdb"
+	"zgo.at/zstd/zjson"
+)
+
+// PathChange is a path that will be changed by PathChanges.Apply().
+type PathChange struct {
+	PathID  int64  `json:"path_id"`
+	Path    string `json:"path"`
+	NewPath string `json:"new_path"`
+
+	// Path ID to merge this path in to; this is 0 if the path is renamed
+	// rather than merged.
+	MergeWith int64 `json:"merge_with"`
+}
+
+// PathChanges is a list of path changes.
+type PathChanges []PathChange
+
+// Tables with a count that can be merged by summing the counts, and the columns
+// that make a row unique besides site_id and path_id.
+var mergeTables = []struct{ table, keys, col string }{
+	{"hit_counts", "hour", "total"},
+	{"ref_counts", "ref_id, hour", "total"},
+	{"browser_stats", "hour, browser_id", "count"},
+	{"system_stats", "hour, system_id", "count"},
+	{"location_stats", "hour, location", "count"},
+	{"size_stats", "hour, width", "count"},
+	{"language_stats", "hour, language", "count"},
+	{"channel_stats", "hour, channel", "count"},
+	{"campaign_stats", "campaign_id, ref, medium, content, term, hour", "count"},
+}
+
+// Rewrite finds all paths that match and the paths they would be rewritten
+// to.
+//
+// The match is a regular expression if it starts with "re:", and the
+// replacement can refer to submatches with $1. Otherwise it's a literal prefix
+// that's replaced.
+//
+// Paths that rewrite to the same path as an existing path (case-insensitive)
+// are merged in to that path. If several paths rewrite to a path that doesn't
+// exist yet then the first one is renamed and the others are merged in to it.
+func (p *PathChanges) Rewrite(ctx context.Context, match, replace string) error {
+	if match == "" {
+		return guru.New(400, "match is empty")
+	}
+	rewrite := func(s string) string {
+		if !strings.HasPrefix(s, match) {
+			return s
+		}
+		return replace + s[len(match):]
+	}
+	if strings.HasPrefix(match, "re:") {
+		re, err := regexp.Compile(match[3:])
+		if err != nil {
+			return guru.Errorf(400, "invalid regular expression: %w", err)
+		}
+		rewrite = func(s string) string { return re.ReplaceAllString(s, replace) }
+	}
+
+	var paths Paths
+	err := zdb.Select(ctx, &paths, `/* PathChanges.Rewrite */
+		select * from paths where site_id = ? order by path_id`, MustGetSite(ctx).ID)
+	if err != nil {
+		return errors.Wrap(err, "PathChanges.Rewrite")
+	}
+
+	var (
+		changes   = make(PathChanges, 0, 16)
+		unchanged = make(map[string]int64, len(paths))
+	)
+	for _, pp := range paths {
+		n := rewrite(pp.Path)
+		if n == "" && !pp.Event {
+			n = "/"
+		}
+		if n == "" || n == pp.Path {
+			unchanged[strings.ToLower(pp.Path)] = pp.ID
+			continue
+		}
+		changes = append(changes, PathChange{PathID: pp.ID, Path: pp.Path, NewPath: n})
+	}
+
+	renamed := make(map[string]int64)
+	for i, c := range changes {
+		k := strings.ToLower(c.NewPath)
+		if dst, ok := unchanged[k]; ok {
+			changes[i].MergeWith = dst
+		} else if dst, ok := renamed[k]; ok {
+			changes[i].MergeWith = dst
+		} else {
+			renamed[k] = c.PathID
+		}
+	}
+
+	*p = changes
+	return nil
+}
+
+// Apply the changes: rename the paths and merge the hits and stats of merged
+// paths. This is all done in one transaction.
+func (p PathChanges) Apply(ctx context.Context) error {
+	if len(p) == 0 {
+		return nil
+	}
+
+	site := MustGetSite(ctx)
+	merge := make(map[int64][]int64)
+	for _, c := range p {
+		if c.MergeWith > 0 {
+			merge[c.MergeWith] = append(merge[c.MergeWith], c.PathID)
+		}
+	}
+
+	err := zdb.TX(ctx, func(ctx context.Context) error {
+		for dst, ids := range merge {
+			err := mergePaths(ctx, site.ID, dst, ids)
+			if err != nil {
+				return err
+			}
+		}
+
+		// A path may be renamed to the current name of another path that's
+		// renamed in the same batch (e.g. "/a" → "/a/b" and "/a/b" → "/a/b/b"),
+		// so rename to a temporary name first to not depend on the order.
+		renames := slices.DeleteFunc(slices.Clone(p), func(c PathChange) bool { return c.MergeWith > 0 })
+		for _, tmp := range []bool{true, false} {
+			for _, c := range renames {
+				n := c.NewPath
+				if tmp {
+					n = fmt.Sprintf("\x01rename-%d", c.PathID)
+				}
+				err := zdb.Exec(ctx, `/* PathChanges.Apply */
+					update paths set path = ? where site_id = ? and path_id = ?`,
+					n, site.ID, c.PathID)
+				if err != nil {
+					if zdb.ErrUnique(err) {
+						return guru.Errorf(400, "path %q already exists", c.NewPath)
+					}
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "PathChanges.Apply")
+	}
+
+	site.ClearCache(ctx, true)
+	return nil
+}
+
+// Merge all stats for pathIDs in to dst, and remove the paths.
+func mergePaths(ctx context.Context, siteID, dst int64, pathIDs []int64) error {
+	args := map[string]any{"site": siteID, "dst": dst, "ids": pathIDs}
+	for _, t := range mergeTables {
+		err := zdb.Exec(ctx, fmt.Sprintf(`/* mergePaths */
+			insert into %[1]s (site_id, path_id, %[2]s, %[3]s)
+				select site_id, :dst, %[2]s, sum(%[3]s)
+				from %[1]s
+				where site_id = :site and path_id in (:ids)
+				group by site_id, %[2]s
+			on conflict(site_id, path_id, %[2]s) do update set %[3]s = %[1]s.%[3]s + excluded.%[3]s`,
+			t.table, t.keys, t.col), args)
+		if err != nil {
+			return errors.Wrapf(err, "mergePaths %s", t.table)
+		}
+	}
+
+	err := mergeHitStats(ctx, args)
+	if err != nil {
+		return err
+	}
+	err = mergeVisitorStats(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	err = zdb.Exec(ctx, `update hits set path_id = :dst where site_id = :site and path_id in (:ids)`, args)
+	if err != nil {
+		return errors.Wrap(err, "mergePaths hits")
+	}
+	for _, t := range append(statTables, "campaign_stats", "hit_counts", "ref_counts", "paths") {
+		err := zdb.Exec(ctx, `delete from `+t+` where site_id = :site and path_id in (:ids)`, args)
+		if err != nil {
+			return errors.Wrapf(err, "mergePaths delete %s", t)
+		}
+	}
+	return nil
+}
+
+// hit_stats has the counts for every hour as a JSON array, which is summed in
+// Go as doing this in SQL is rather awkward with SQLite.
+func mergeHitStats(ctx context.Context, args map[string]any) error {
+	var rows []struct {
+		Day   time.Time `db:"day"`
+		Stats []byte    `db:"stats"`
+	}
+	err := zdb.Select(ctx, &rows, `/* mergeHitStats */
+		select day, stats from hit_stats
+		where site_id = :site and (path_id = :dst or path_id in (:ids))`, args)
+	if err != nil {
+		return errors.Wrap(err, "mergeHitStats")
+	}
+
+	days := make(map[string][]int)
+	for _, r := range rows {
+		d := r.Day.Format("2006-01-02")
+		if days[d] == nil {
+			days[d] = make([]int, 24)
+		}
+		var s []int
+		zjson.MustUnmarshal(r.Stats, &s)
+		for i := range min(len(s), 24) {
+			days[d][i] += s[i]
+		}
+	}
+
+	err = zdb.Exec(ctx, `delete from hit_stats where site_id = :site and path_id = :dst`, args)
+	if err != nil {
+		return errors.Wrap(err, "mergeHitStats")
+	}
+	for _, d := range slices.Sorted(maps.Keys(days)) {
+		err := zdb.Exec(ctx, `insert into hit_stats (site_id, path_id, day, stats) values (?, ?, ?, ?)`,
+			args["site"], args["dst"], d, zjson.MustMarshal(days[d]))
+		if err != nil {
+			return errors.Wrap(err, "mergeHitStats")
+		}
+	}
+	return nil
+}
+
+// visitor_stats has a HyperLogLog sketch, which are merged so visitors aren't
+// counted twice.
+func mergeVisitorStats(ctx context.Context, args map[string]any) error {
+	var rows []struct {
+		Day    time.Time `db:"day"`
+		Sketch []byte    `db:"sketch"`
+	}
+	err := zdb.Select(ctx, &rows, `/* mergeVisitorStats */
+		select day, sketch from visitor_stats
+		where site_id = :site and (path_id = :dst or path_id in (:ids))`, args)
+	if err != nil {
+		return errors.Wrap(err, "mergeVisitorStats")
+	}
+
+	days := make(map[string]*hll.Sketch)
+	for _, r := range rows {
+		d := r.Day.Format("2006-01-02")
+		var s hll.Sketch
+		err := s.UnmarshalBinary(r.Sketch)
+		if err != nil {
+			return errors.Wrapf(err, "mergeVisitorStats %s", d)
+		}
+		if days[d] == nil {
+			days[d] = &s
+		} else {
+			days[d].Merge(&s)
+		}
+	}
+
+	err = zdb.Exec(ctx, `delete from visitor_stats where site_id = :site and path_id = :dst`, args)
+	if err != nil {
+		return errors.Wrap(err, "mergeVisitorStats")
+	}
+	for _, d := range slices.Sorted(maps.Keys(days)) {
+		b, err := days[d].MarshalBinary()
+		if err != nil {
+			return errors.Wrap(err, "mergeVisitorStats")
+		}
+		err = zdb.Exec(ctx, `insert into visitor_stats (site_id, path_id, day, sketch) values (?, ?, ?, ?)`,
+			args["site"], args["dst"], d, b)
+		if err != nil {
+			return errors.Wrap(err, "mergeVisitorStats")
+		}
+	}
+	return nil
+}
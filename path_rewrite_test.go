@@ -0,0 +1,126 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zdb"
+)
+
+func TestPathChangesRewrite(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	var site Site
+	site.Defaults(ctx)
+	site.Settings.Collect.Set(CollectHits)
+	ctx = gctest.Site(ctx, t, &site, nil)
+
+	now := time.Date(2024, 6, 18, 14, 42, 0, 0, time.UTC)
+	gctest.StoreHits(ctx, t, false,
+		Hit{Path: "/blog/1/a", FirstVisit: true, CreatedAt: now},
+		Hit{Path: "/blog/2/a", FirstVisit: true, CreatedAt: now},
+		Hit{Path: "/blog/2/a", CreatedAt: now},
+		Hit{Path: "/blog/3/b", FirstVisit: true, CreatedAt: now.Add(-time.Hour)},
+		Hit{Path: "/posts/b", FirstVisit: true, CreatedAt: now},
+		Hit{Path: "/other", FirstVisit: true, CreatedAt: now},
+	)
+
+	var changes PathChanges
+	err := changes.Rewrite(ctx, `re:^/blog/(\d+)/(.*)`, "/posts/$2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have := fmt.Sprintf("%v", changes); have != "[{1 /blog/1/a /posts/a 0} {2 /blog/2/a /posts/a 1} {3 /blog/3/b /posts/b 4}]" {
+		t.Errorf("wrong changes:\n%s", have)
+	}
+
+	err = changes.Apply(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	have := zdb.DumpString(ctx, `select path_id, path from paths order by path_id`) +
+		zdb.DumpString(ctx, `select path_id, count(*) as n from hits where site_id = 2 group by path_id order by path_id`) +
+		zdb.DumpString(ctx, `select path_id, sum(total) as total from hit_counts where site_id = 2 group by path_id order by path_id`) +
+		zdb.DumpString(ctx, `select path_id, stats from hit_stats order by path_id`)
+	want := `
+		path_id  path
+		1        /posts/a
+		4        /posts/b
+		5        /other
+		path_id  n
+		1        3
+		4        2
+		5        1
+		path_id  total
+		1        2
+		4        2
+		5        1
+		path_id  stats
+		1        [0,0,0,0,0,0,0,0,0,0,0,0,0,0,2,0,0,0,0,0,0,0,0,0]
+		4        [0,0,0,0,0,0,0,0,0,0,0,0,0,1,1,0,0,0,0,0,0,0,0,0]
+		5        [0,0,0,0,0,0,0,0,0,0,0,0,0,0,1,0,0,0,0,0,0,0,0,0]`
+	if d := zdb.Diff(have, want); d != "" {
+		t.Error(d)
+	}
+
+	err = changes.Rewrite(ctx, "/posts/", "/p/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have := fmt.Sprintf("%v", changes); have != "[{1 /posts/a /p/a 0} {4 /posts/b /p/b 0}]" {
+		t.Errorf("wrong changes:\n%s", have)
+	}
+
+	err = changes.Rewrite(ctx, "re:(", "")
+	if err == nil {
+		t.Error("no error for invalid regexp")
+	}
+}
+
+func TestPathChangesRewriteChained(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	now := time.Date(2024, 6, 18, 14, 42, 0, 0, time.UTC)
+	gctest.StoreHits(ctx, t, false,
+		Hit{Path: "/blog", FirstVisit: true, CreatedAt: now},
+		Hit{Path: "/blog/posts", FirstVisit: true, CreatedAt: now},
+		Hit{Path: "/blog/posts", FirstVisit: true, CreatedAt: now},
+	)
+
+	// "/blog" is renamed to the current name of "/blog/posts", which is itself
+	// renamed.
+	var changes PathChanges
+	err := changes.Rewrite(ctx, "/blog", "/blog/posts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have := fmt.Sprintf("%v", changes); have != "[{1 /blog /blog/posts 0} {2 /blog/posts /blog/posts/posts 0}]" {
+		t.Errorf("wrong changes:\n%s", have)
+	}
+
+	err = changes.Apply(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	have := zdb.DumpString(ctx, `select path_id, path from paths order by path_id`) +
+		zdb.DumpString(ctx, `select path_id, sum(total) as total from hit_counts group by path_id order by path_id`)
+	want := `
+		path_id  path
+		1        /blog/posts
+		2        /blog/posts/posts
+		path_id  total
+		1        1
+		2        2`
+	if d := zdb.Diff(have, want); d != "" {
+		t.Error(d)
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"zgo.at/errors"
+	"zgo.at/zdb"
+)
+
+type (
+	// PathRewrite rewrites paths matching a regular expression.
+	PathRewrite struct {
+		// Regular expression matched against the path, including the query.
+		Match string `json:"match"`
+
+		// Replacement; can refer to submatches with $1, ${name}, etc.
+		Replace string `json:"replace"`
+	}
+
+	// PathRewrites is a list of rewrites, which are all applied in order.
+	//
+	// In text form this is one rewrite per line as "match => replace".
+	PathRewrites []PathRewrite
+
+	// PathRules are rules to normalize paths before they're stored, so that
+	// e.g. "/user/1/profile" and "/user/2/profile" are counted as one path.
+	PathRules struct {
+		// Lowercase the path; the query is never changed.
+		Lowercase bool `json:"lowercase"`
+
+		// Trailing slash handling; one of the TrailingSlash* constants. Paths
+		// are left alone by default.
+		TrailingSlash string `json:"trailing_slash"`
+
+		// Remove all query parameters, except those listed in KeepQuery.
+		StripQuery bool    `json:"strip_query"`
+		KeepQuery  Strings `json:"keep_query"`
+
+		Rewrites PathRewrites `json:"rewrites"`
+	}
+)
+
+// Trailing slash handling for PathRules.
+const (
+	TrailingSlashLeave  = ""       // Don't change trailing slashes.
+	TrailingSlashAdd    = "add"    // Add a trailing slash, except for paths that look like a filename ("/file.html").
+	TrailingSlashRemove = "remove" // Remove trailing slashes.
+)
+
+// TrailingSlashes are all valid values for PathRules.TrailingSlash.
+var TrailingSlashes = []string{TrailingSlashLeave, TrailingSlashAdd, TrailingSlashRemove}
+
+func (p PathRewrite) String() string { return p.Match + " => " + p.Replace }
+
+func (p PathRewrites) String() string { return textLines(p) }
+
+// ParsePathRewrites parses the text form of the rewrites, as returned by
+// PathRewrites.String().
+func ParsePathRewrites(text string) (PathRewrites, error) {
+	rw := PathRewrites{}
+	err := parseTextLines(text, func(line string) error {
+		match, repl, ok := strings.Cut(line, "=>")
+		if !ok {
+			return fmt.Errorf("need \"match => replace\": %q", line)
+		}
+		rw = append(rw, PathRewrite{
+			Match:   strings.TrimSpace(match),
+			Replace: strings.TrimSpace(repl),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rw, nil
+}
+
+// IsZero reports if there are no rules.
+func (r PathRules) IsZero() bool {
+	return !r.Lowercase && r.TrailingSlash == TrailingSlashLeave && !r.StripQuery && len(r.Rewrites) == 0
+}
+
+// Apply the rules to the path.
+//
+// The path is expected to have been cleaned by Hit.Defaults() already.
+func (r PathRules) Apply(p string) string {
+	if r.IsZero() || p == "" {
+		return p
+	}
+
+	path, query, _ := strings.Cut(p, "?")
+	if r.StripQuery && query != "" {
+		q, err := url.ParseQuery(query)
+		if err != nil {
+			query = ""
+		} else {
+			for k := range q {
+				if !slices.Contains(r.KeepQuery, k) {
+					q.Del(k)
+				}
+			}
+			query = q.Encode()
+		}
+	}
+	if r.Lowercase {
+		path = strings.ToLower(path)
+	}
+	switch r.TrailingSlash {
+	case TrailingSlashAdd:
+		if !strings.HasSuffix(path, "/") && !strings.Contains(path[strings.LastIndexByte(path, '/')+1:], ".") {
+			path += "/"
+		}
+	case TrailingSlashRemove:
+		if len(path) > 1 {
+			path = "/" + strings.Trim(path, "/")
+		}
+	}
+
+	p = path
+	if query != "" {
+		p += "?" + query
+	}
+	for _, rw := range r.Rewrites {
+		re, err := cachedRegexp(rw.Match)
+		if err != nil {
+			continue
+		}
+		p = re.ReplaceAllString(p, rw.Replace)
+	}
+	if p == "" {
+		p = "/"
+	}
+	return p
+}
+
+// Validate the rules.
+func (r PathRules) Validate(ctx context.Context) error {
+	v := NewValidate(ctx)
+	v.Include("trailing_slash", r.TrailingSlash, TrailingSlashes)
+	for _, k := range r.KeepQuery {
+		v.Len("keep_query", k, 1, 100)
+	}
+	for i, rw := range r.Rewrites {
+		k := fmt.Sprintf("rewrites.%d", i+1)
+		v.Required(k+".match", rw.Match)
+		if _, err := regexp.Compile(rw.Match); err != nil {
+			v.Append(k+".match", err.Error())
+		}
+	}
+	return v.ErrorOrNil()
+}
+
+// PathPreview is a path changed by PathRules.Preview().
+type PathPreview struct {
+	Path    string `db:"path"`
+	NewPath string `db:"-"`
+}
+
+// Preview the rules against the last n paths.
+//
+// This returns the paths that would be changed and the number of distinct
+// paths after applying the rules.
+func (r PathRules) Preview(ctx context.Context, n int) ([]PathPreview, int, int, error) {
+	var paths []PathPreview
+	err := zdb.Select(ctx, &paths, `/* PathRules.Preview */
+		select path from paths
+		where site_id = :site and event = 0
+		order by path_id desc
+		limit :n`,
+		map[string]any{"site": MustGetSite(ctx).ID, "n": n})
+	if err != nil {
+		return nil, 0, 0, errors.Wrap(err, "PathRules.Preview")
+	}
+
+	var (
+		changed  = make([]PathPreview, 0, 16)
+		distinct = make(map[string]struct{}, len(paths))
+	)
+	for _, p := range paths {
+		p.NewPath = r.Apply(p.Path)
+		distinct[strings.ToLower(p.NewPath)] = struct{}{}
+		if p.NewPath != p.Path {
+			changed = append(changed, p)
+		}
+	}
+	return changed, len(paths), len(distinct), nil
+}
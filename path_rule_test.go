@@ -0,0 +1,123 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	. "zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+)
+
+func TestPathRulesApply(t *testing.T) {
+	tests := []struct {
+		rules PathRules
+		in    string
+		want  string
+	}{
+		{PathRules{}, "/Foo?b=1&a=2", "/Foo?b=1&a=2"},
+
+		{PathRules{Lowercase: true}, "/Foo/BAR?Q=X", "/foo/bar?Q=X"},
+
+		{PathRules{Lowercase: true}, "/Docs/", "/docs/"},
+
+		{PathRules{TrailingSlash: TrailingSlashAdd}, "/foo", "/foo/"},
+		{PathRules{TrailingSlash: TrailingSlashAdd}, "/", "/"},
+		{PathRules{TrailingSlash: TrailingSlashAdd}, "/foo?a=1", "/foo/?a=1"},
+		{PathRules{TrailingSlash: TrailingSlashAdd}, "/foo/file.html", "/foo/file.html"},
+		{PathRules{TrailingSlash: TrailingSlashRemove}, "/foo/", "/foo"},
+		{PathRules{TrailingSlash: TrailingSlashRemove}, "/foo//?a=1", "/foo?a=1"},
+		{PathRules{TrailingSlash: TrailingSlashRemove}, "/", "/"},
+
+		{PathRules{StripQuery: true}, "/search?q=x&page=2", "/search"},
+		{PathRules{StripQuery: true, KeepQuery: Strings{"page"}}, "/search?q=x&page=2", "/search?page=2"},
+		{PathRules{StripQuery: true, KeepQuery: Strings{"page"}}, "/search", "/search"},
+
+		{PathRules{Rewrites: PathRewrites{{`^/user/\d+/profile$`, "/user/:id/profile"}}},
+			"/user/1234/profile", "/user/:id/profile"},
+		{PathRules{Rewrites: PathRewrites{{`^/user/\d+/profile$`, "/user/:id/profile"}}},
+			"/user/1234/profile/x", "/user/1234/profile/x"},
+		{PathRules{Rewrites: PathRewrites{{`^/(en|nl)/(.*)`, "/$2"}, {`^/a$`, "/b"}}},
+			"/nl/a", "/b"},
+		{PathRules{Lowercase: true, Rewrites: PathRewrites{{`^/user/\d+$`, "/user/:id"}}},
+			"/USER/1", "/user/:id"},
+		{PathRules{Rewrites: PathRewrites{{`^/a$`, "/b"}}}, "/docs/", "/docs/"},
+		{PathRules{Rewrites: PathRewrites{{`^.*$`, ""}}}, "/x", "/"},
+		{PathRules{Rewrites: PathRewrites{{`(`, "x"}}}, "/x", "/x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			have := tt.rules.Apply(tt.in)
+			if have != tt.want {
+				t.Errorf("\nhave: %q\nwant: %q", have, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePathRewrites(t *testing.T) {
+	have, err := ParsePathRewrites(`
+		# Comment
+		^/user/\d+/profile$  =>  /user/:id/profile
+		^/(en|nl)/(.*) => /$2
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := PathRewrites{
+		{Match: `^/user/\d+/profile$`, Replace: "/user/:id/profile"},
+		{Match: `^/(en|nl)/(.*)`, Replace: "/$2"},
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("\nhave: %#v\nwant: %#v", have, want)
+	}
+
+	again, err := ParsePathRewrites(have.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again, want) {
+		t.Errorf("round-trip\nhave: %#v\nwant: %#v", again, want)
+	}
+
+	_, err = ParsePathRewrites("^/user")
+	if err == nil {
+		t.Error("no error for missing replacement")
+	}
+
+	err = PathRules{Rewrites: PathRewrites{{Match: "("}, {Match: "", Replace: "/x"}}}.Validate(gctest.Context(nil))
+	if err == nil {
+		t.Fatal("no validation error")
+	}
+	for _, k := range []string{"rewrites.1.match", "rewrites.2.match"} {
+		if !strings.Contains(err.Error(), k) {
+			t.Errorf("no error for %s:\n%s", k, err)
+		}
+	}
+}
+
+func TestPathRulesPreview(t *testing.T) {
+	ctx := gctest.DB(t)
+
+	gctest.StoreHits(ctx, t, false,
+		Hit{Path: "/user/1/profile"},
+		Hit{Path: "/user/2/profile"},
+		Hit{Path: "/about"},
+		Hit{Path: "event", Event: true},
+	)
+
+	changed, total, distinct, err := PathRules{
+		Rewrites: PathRewrites{{`^/user/\d+/profile$`, "/user/:id/profile"}},
+	}.Preview(ctx, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 || distinct != 2 || len(changed) != 2 || changed[0].NewPath != "/user/:id/profile" {
+		t.Errorf("total: %d; distinct: %d; changed: %#v", total, distinct, changed)
+	}
+}
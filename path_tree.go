@@ -0,0 +1,186 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+
+	"zgo.at/errors"
+	"zgo.at/goatcounter/v2/hll"
+	"zgo.at/zdb"
+	"zgo.at/zstd/ztime"
+)
+
+type (
+	// PathNode is a directory with paths, or a single path, in the tree view
+	// of paths.
+	PathNode struct {
+		// Directory prefix ending with a "/" (e.g. "/docs/") or the full path.
+		Path string `json:"path"`
+
+		// Is this a directory?
+		Dir bool `json:"dir"`
+
+		// Path ID and title; only set for single paths.
+		PathID int64  `json:"path_id,omitempty"`
+		Title  string `json:"title,omitempty"`
+
+		// Number of paths in this directory; always 1 for single paths.
+		Paths int `json:"paths"`
+
+		// Number of visitors for all paths in this directory.
+		Count int `json:"count"`
+
+		// Estimated number of unique visitors for all paths in this directory;
+		// counted per day in UTC, like HitList.CountUnique.
+		CountUnique int `json:"count_unique"`
+
+		pathIDs []int64
+	}
+
+	// PathNodes is a list of directories and paths directly under a prefix.
+	PathNodes []PathNode
+)
+
+// List the directories and paths directly under prefix, sorted by the number
+// of visitors.
+//
+// Paths in subdirectories are grouped by the first directory after the prefix,
+// so for the prefix "/" the paths "/docs/api" and "/docs/faq" are grouped as
+// "/docs/". The path "/docs" is also grouped in that directory, as it's
+// usually the index for it.
+//
+// Events and hidden paths are never included. If limit is larger than 0 at
+// most that many nodes are returned; the return value indicates if there are
+// more.
+func (n *PathNodes) List(ctx context.Context, rng ztime.Range, prefix string, pathFilter []int64, limit int) (bool, error) {
+	if !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	var (
+		index = strings.TrimSuffix(prefix, "/")
+		like  = strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`)
+		rows  []struct {
+			PathID int64  `db:"path_id"`
+			Path   string `db:"path"`
+			Title  string `db:"title"`
+			Count  int    `db:"count"`
+		}
+	)
+	err := zdb.Select(ctx, &rows, `/* PathNodes.List */
+		select paths.path_id, paths.path, paths.title, sum(hit_counts.total) as count
+		from hit_counts
+		join paths using (path_id)
+		where
+			hit_counts.site_id = :site and
+			{{:filter hit_counts.path_id in (:filter) and}}
+			paths.event = 0 and paths.hidden = 0 and
+			(paths.path like :prefix escape '!' or paths.path = :index or paths.path like :index_query escape '!') and
+			hour >= :start and hour <= :end
+		group by paths.path_id, paths.path, paths.title`,
+		map[string]any{
+			"site":        MustGetSite(ctx).ID,
+			"start":       rng.Start,
+			"end":         rng.End,
+			"filter":      pathFilter,
+			"prefix":      like.Replace(prefix) + "%",
+			"index":       index,
+			"index_query": like.Replace(index) + "?%",
+		})
+	if err != nil {
+		return false, errors.Wrap(err, "PathNodes.List")
+	}
+
+	var (
+		nodes = make(map[string]*PathNode)
+		add   = func(key string, dir bool, pathID int64, title string, count int) {
+			node, ok := nodes[key]
+			if !ok {
+				node = &PathNode{Path: key, Dir: dir}
+				nodes[key] = node
+			}
+			if !dir {
+				node.PathID, node.Title = pathID, title
+			}
+			node.Paths++
+			node.Count += count
+			node.pathIDs = append(node.pathIDs, pathID)
+		}
+	)
+	for _, r := range rows {
+		// like is case-insensitive in SQLite, so check the prefix again.
+		p, _, _ := strings.Cut(r.Path, "?")
+		if p != index && !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(p, prefix)
+		if i := strings.IndexByte(rest, '/'); i > -1 && p != index {
+			add(prefix+rest[:i+1], true, r.PathID, "", r.Count)
+		} else {
+			add(r.Path, false, r.PathID, r.Title, r.Count)
+		}
+	}
+
+	// Group "/docs" in the directory "/docs/" if it exists.
+	for k, node := range nodes {
+		if node.Dir {
+			continue
+		}
+		p, _, _ := strings.Cut(node.Path, "?")
+		if dir, ok := nodes[p+"/"]; ok && dir.Dir {
+			dir.Paths++
+			dir.Count += node.Count
+			dir.pathIDs = append(dir.pathIDs, node.PathID)
+			delete(nodes, k)
+		}
+	}
+
+	list := make(PathNodes, 0, len(nodes))
+	for _, node := range nodes {
+		list = append(list, *node)
+	}
+	slices.SortFunc(list, func(a, b PathNode) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Path, b.Path)
+	})
+
+	var more bool
+	if limit > 0 && len(list) > limit {
+		list, more = list[:limit], true
+	}
+
+	// Add unique visitors.
+	if len(list) > 0 {
+		ids := make([]int64, 0, len(list))
+		for _, node := range list {
+			ids = append(ids, node.pathIDs...)
+		}
+		sketches, err := visitorSketches(ctx, rng, ids, false)
+		if err != nil {
+			return false, errors.Wrap(err, "PathNodes.List")
+		}
+		for i := range list {
+			s := hll.New()
+			for _, id := range list[i].pathIDs {
+				if sk, ok := sketches[id]; ok {
+					s.Merge(sk)
+				}
+			}
+			list[i].CountUnique = clampUnique(s.Count(), list[i].Count)
+		}
+	}
+
+	*n = list
+	return more, nil
+}
@@ -0,0 +1,80 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	. "zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
+	"zgo.at/zstd/zint"
+	"zgo.at/zstd/ztime"
+)
+
+func TestPathNodesList(t *testing.T) {
+	ztime.SetNow(t, "2020-06-18 12:00:00")
+	ctx := gctest.DB(t)
+
+	var (
+		a  = uint64(0x1a2b3c4d5e6f7081)
+		b  = uint64(0x9f8e7d6c5b4a3921)
+		sa = TestSession
+		sb = zint.Uint128{1, 2}
+	)
+	gctest.StoreHits(ctx, t, false,
+		Hit{Path: "/docs", Session: sa, VisitorHash: a, FirstVisit: true},
+		Hit{Path: "/docs/api/hits", Session: sa, VisitorHash: a, FirstVisit: true},
+		Hit{Path: "/docs/api/hits", Session: sb, VisitorHash: b, FirstVisit: true},
+		Hit{Path: "/docs/api/paths", Session: sb, VisitorHash: b, FirstVisit: true},
+		Hit{Path: "/docs/faq", Session: sa, VisitorHash: a, FirstVisit: true},
+		Hit{Path: "/about", Session: sa, VisitorHash: a, FirstVisit: true},
+		Hit{Path: "/", Session: sb, VisitorHash: b, FirstVisit: true},
+		Hit{Path: "/a_b/x", Session: sa, VisitorHash: a, FirstVisit: true},
+		Hit{Path: "/aXb/y", Session: sa, VisitorHash: a, FirstVisit: true},
+		Hit{Path: "event", Event: true, Session: sa, VisitorHash: a, FirstVisit: true},
+	)
+
+	rng := ztime.NewRange(ztime.Now()).Current(ztime.Day)
+	tests := []struct {
+		prefix string
+		limit  int
+		want   string
+		more   bool
+	}{
+		{"/", 0, "/docs/ dir 4 5 2, / 1 1 1, /aXb/ dir 1 1 1, /a_b/ dir 1 1 1, /about 1 1 1", false},
+		{"/", 1, "/docs/ dir 4 5 2", true},
+		{"docs", 0, "/docs/api/ dir 2 3 2, /docs 1 1 1, /docs/faq 1 1 1", false},
+		{"/docs/api/", 0, "/docs/api/hits 1 2 2, /docs/api/paths 1 1 1", false},
+		{"/nothing/", 0, "", false},
+		{"/a_b/", 0, "/a_b/x 1 1 1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s-%d", tt.prefix, tt.limit), func(t *testing.T) {
+			var nodes PathNodes
+			more, err := nodes.List(ctx, rng, tt.prefix, nil, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			have := make([]string, 0, len(nodes))
+			for _, n := range nodes {
+				d := ""
+				if n.Dir {
+					d = " dir"
+				}
+				have = append(have, fmt.Sprintf("%s%s %d %d %d", n.Path, d, n.Paths, n.Count, n.CountUnique))
+			}
+			if h := strings.Join(have, ", "); h != tt.want {
+				t.Errorf("\nhave: %s\nwant: %s", h, tt.want)
+			}
+			if more != tt.more {
+				t.Errorf("more: %t", more)
+			}
+		})
+	}
+}
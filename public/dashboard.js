@@ -15,7 +15,7 @@
 
 	// Set up all the dashboard widget contents (but not the header).
 	var dashboard_widgets = function() {
-		;[init_charts, paginate_pages, load_refs, hchart_detail, ref_pages, bind_scale].forEach((f) => f.call())
+		;[init_charts, paginate_pages, load_refs, load_prefix, hchart_detail, ref_pages, bind_scale].forEach((f) => f.call())
 	}
 
 	// Open websocket for the dashboard loader.
@@ -89,9 +89,10 @@
 	var reload_widget = function(wid, data, done) {
 		data = data || {}
 		data['widget'] = wid
-		data['daily']  = $('#daily').is(':checked')
+		data['group']  = $('#group').val()
 		data['max']    = get_original_scale()
 		data['total']  = $('.js-total-utc').text()
+		data['total_unique'] = $('.js-total-unique').text()
 
 		jQuery.ajax({
 			url:  BASE_PATH + '/load-widget',
@@ -114,7 +115,7 @@
 		jQuery.ajax({
 			url:     BASE_PATH + '/',
 			data:    append_period({
-				daily:     $('#daily').is(':checked'),
+				group:     $('#group').val(),
 				max:       get_original_scale(),
 				reload:    't',
 				connectID: $('#js-connect-id').text(),
@@ -171,6 +172,10 @@
 			$('#hl-period').attr('disabled', false)
 			$('#dash-form').trigger('submit')
 		})
+		$('#group').on('change', function(e) {
+			$('#hl-period').attr('disabled', false)
+			$('#dash-form').trigger('submit')
+		})
 
 		$('#dash-select-period').on('click', 'button', function(e) {
 			e.preventDefault()
@@ -332,7 +337,7 @@
 						csrf:      CSRF,
 						name:      'default',
 						filter:    $('#filter-paths').val(),
-						daily:     $('#daily').is(':checked'),
+						group:     $('#group').val(),
 						period:    p,
 					},
 					success: () => {
@@ -482,7 +487,8 @@
 		let ctx     = canvas.getContext('2d', {alpha: false}),
 			max     = Math.max(10, parseInt(c.dataset.max, 10)),
 			scale   = get_current_scale(),
-			daily   = c.dataset.daily === 'true',
+			group   = c.dataset.group,
+			daily   = group !== 'hour',
 			isBar   = $(c).is('.chart-bar'),
 			isEvent = $(c).closest('tr').hasClass('event'),
 			isPages = $(c).closest('.count-list-pages').length > 0,
@@ -509,8 +515,10 @@
 			bar:  {color: style('chart-line')},
 			done: (chart) => {
 				// Show future as greyed out.
+				// Weeks and months are never in the future, as they start at
+				// the start of the period.
 				let last   = stats[stats.length - 1].day + (daily ? '' : ' 23:59:59'),
-					future = last > format_date_ymd(new Date()) + (daily ? '' : ' 23:59:59')
+					future = (group === 'hour' || group === 'day') && last > format_date_ymd(new Date()) + (daily ? '' : ' 23:59:59')
 				if (future) {
 					let dpr   = Math.max(1, window.devicePixelRatio || 1),
 						width = chart.barWidth() * ((get_date(last) - new Date()) / ((daily ? 86400 : 3600) * 1000))
@@ -546,7 +554,17 @@
 
 			let title = '',
 				future = futureFrom && x >= futureFrom - 1
-			if (daily)
+			if (group === 'week' || group === 'month') {
+				// First period may start before the selected start date.
+				let start = i === 0 && day.day < $('#period-start').val() ? $('#period-start').val() : day.day,
+					end   = $('#period-end').val()
+				if (i < stats.length - 1) {
+					end = get_date(stats[i + 1].day)
+					end.setDate(end.getDate() - 1)
+				}
+				title = `${format_date(start, true)} – ${format_date(end, true)}`
+			}
+			else if (daily)
 				title = `${format_date(day.day, true)}`
 			else
 				title = `${format_date(day.day, true)} ${un24(start)} – ${un24(end)}`
@@ -680,7 +698,7 @@
 					url:  BASE_PATH + '/load-widget',
 					data: append_period({
 						widget:    pages.attr('data-widget'),
-						daily:     $('#daily').is(':checked'),
+						group:     $('#group').val(),
 						exclude:   pages.find('.count-list-pages >tbody >tr').toArray().map((e) => e.dataset.id).join(','),
 						max:       get_original_scale(),
 					}),
@@ -780,6 +798,41 @@
 		})
 	}
 
+	// Expand or collapse a directory in the tree view of pages.
+	var load_prefix = function() {
+		$('.count-list-tree').on('click', '.load-prefix', function(e) {
+			e.preventDefault()
+
+			let btn    = $(this),
+				row    = btn.closest('tr'),
+				prefix = row.attr('data-prefix'),
+				widget = row.closest('.pages-list').attr('data-widget')
+
+			// Already expanded: remove all rows below this directory.
+			if (row.hasClass('expanded')) {
+				row.removeClass('expanded')
+				row.nextAll('tr').filter((_, t) => (t.dataset.parent || '').startsWith(prefix)).remove()
+				return
+			}
+
+			let done = paginate_button(btn, () => {
+				jQuery.ajax({
+					url:  BASE_PATH + '/load-widget',
+					data: append_period({
+						widget: widget,
+						prefix: prefix,
+					}),
+					success: function(data) {
+						row.addClass('expanded')
+						row.after(data.html)
+						highlight_filter($('#filter-paths').val())
+						done()
+					},
+				})
+			})
+		})
+	}
+
 	// Paginate and show details for the horizontal charts.
 	var hchart_detail = function() {
 		let get_total = () => $('.js-total-utc').text()
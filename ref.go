@@ -150,7 +150,7 @@ func (r *Ref) GetOrInsert(ctx context.Context) error {
 	return nil
 }
 
-func cleanRefURL(ref string, refURL *url.URL) (string, bool) {
+func cleanRefURL(ref string, refURL *url.URL, rules RefGroups) (string, bool) {
 	// I'm not sure where these links are generated, but there are *a lot* of
 	// them.
 	if refURL.Host == "link.oreilly.com" {
@@ -166,6 +166,11 @@ func cleanRefURL(ref string, refURL *url.URL) (string, bool) {
 		refURL.Host = a
 	}
 
+	// Custom groups from the site settings or -refgroups.
+	if g := findRefGroup(rules, refURL.Host+refURL.Path); g != nil {
+		return g.Name, true
+	}
+
 	// Group based on URL.
 	if strings.HasPrefix(refURL.Host, "www.google.") || strings.HasPrefix(refURL.Host, "google.") {
 		// Group all "google.co.nz", "google.nl", etc. as "Google".
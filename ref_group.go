@@ -0,0 +1,205 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"zgo.at/errors"
+	"zgo.at/zvalidate"
+)
+
+type (
+	// RefGroup groups referrers matching Match under a single name.
+	RefGroup struct {
+		// Host or host/path prefix to match, without the scheme. A host also
+		// matches all subdomains: "example.com" matches "www.example.com" and
+		// "example.com/page", whereas "example.com/blog" only matches paths
+		// starting with "/blog" on example.com.
+		Match string `json:"match"`
+
+		// Name to group the matching referrers under.
+		Name string `json:"name"`
+
+		// Channel for the referrers; optional, and the channel will be
+		// detected from the Match if empty.
+		Channel string `json:"channel,omitempty"`
+	}
+
+	// RefGroups is a list of referrer grouping rules; the first rule that
+	// matches is used.
+	//
+	// In text form this is one rule per line as "match = name" or
+	// "match = name; channel".
+	RefGroups []RefGroup
+)
+
+var refGroups struct {
+	sync.RWMutex
+	groups RefGroups
+}
+
+func (r RefGroup) String() string {
+	if r.Channel != "" {
+		return r.Match + " = " + r.Name + "; " + r.Channel
+	}
+	return r.Match + " = " + r.Name
+}
+
+// Matches reports if this rule matches the referrer (without scheme).
+func (r RefGroup) Matches(ref string) bool {
+	m := strings.ToLower(r.Match)
+	ref = strings.ToLower(ref)
+	if strings.Contains(m, "/") {
+		return strings.HasPrefix(ref, m)
+	}
+	host, _, _ := strings.Cut(ref, "/")
+	return matchDomain(host, m)
+}
+
+func (r RefGroups) String() string { return textLines(r) }
+
+// Find the first rule that matches the referrer, or nil if nothing matches.
+func (r RefGroups) Find(ref string) *RefGroup {
+	for i := range r {
+		if r[i].Matches(ref) {
+			return &r[i]
+		}
+	}
+	return nil
+}
+
+// Validate the rules.
+func (r RefGroups) Validate(ctx context.Context) error {
+	v := NewValidate(ctx)
+	r.validate(&v)
+	return v.ErrorOrNil()
+}
+
+func (r RefGroups) validate(v *zvalidate.Validator) {
+	for i, g := range r {
+		k := fmt.Sprintf("%d", i+1)
+		v.Required(k+".match", g.Match)
+		v.Required(k+".name", g.Name)
+		v.Len(k+".name", g.Name, 0, 250)
+		if g.Channel != "" {
+			v.Include(k+".channel", g.Channel, Channels)
+		}
+		if strings.Contains(g.Match, "://") {
+			v.Append(k+".match", "must not contain the scheme")
+		}
+	}
+}
+
+// UnmarshalJSON decodes the JSON form, which is a list of objects.
+//
+// This is implemented explicitly because UnmarshalText would otherwise be used.
+func (r *RefGroups) UnmarshalJSON(b []byte) error {
+	var g []RefGroup
+	err := json.Unmarshal(b, &g)
+	*r = g
+	return err
+}
+
+// UnmarshalText decodes the text form, for forms.
+func (r *RefGroups) UnmarshalText(b []byte) error {
+	g, err := ParseRefGroups(string(b))
+	if err != nil {
+		return err
+	}
+	*r = g
+	return nil
+}
+
+// ParseRefGroups parses the text form of the rules, as returned by
+// RefGroups.String().
+func ParseRefGroups(text string) (RefGroups, error) {
+	groups := RefGroups{}
+	err := parseTextLines(text, func(line string) error {
+		match, name, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("need \"match = name\": %q", line)
+		}
+		name, channel, _ := strings.Cut(name, ";")
+		groups = append(groups, RefGroup{
+			Match:   strings.TrimSpace(match),
+			Name:    strings.TrimSpace(name),
+			Channel: strings.ToLower(strings.TrimSpace(channel)),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// LoadRefGroups loads installation-wide referrer grouping rules from a file;
+// these are used for all sites, after the site's rules.
+//
+// This replaces any rules that were loaded previously.
+func LoadRefGroups(path string) error {
+	fp, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "LoadRefGroups")
+	}
+	g, err := ParseRefGroups(string(fp))
+	if err != nil {
+		return errors.Wrap(err, "LoadRefGroups")
+	}
+	v := zvalidate.New()
+	g.validate(&v)
+	if v.HasErrors() {
+		return errors.Wrap(&v, "LoadRefGroups")
+	}
+
+	refGroups.Lock()
+	defer refGroups.Unlock()
+	refGroups.groups = g
+	return nil
+}
+
+// WatchRefGroups loads the referrer groups from path with LoadRefGroups(), and
+// reloads it every time the file's modification time changes until the context
+// is cancelled.
+func WatchRefGroups(ctx context.Context, path string, every time.Duration) error {
+	return watchFile(ctx, "refgroups", path, every, LoadRefGroups)
+}
+
+// findRefGroup finds the grouping rule for a referrer (without the scheme);
+// the site's rules are tried first, and then the installation-wide rules.
+func findRefGroup(site RefGroups, ref string) *RefGroup {
+	if g := site.Find(ref); g != nil {
+		return g
+	}
+
+	refGroups.RLock()
+	defer refGroups.RUnlock()
+	return refGroups.groups.Find(ref)
+}
+
+// findRefGroupName finds the grouping rule with this name.
+func findRefGroupName(site RefGroups, name string) *RefGroup {
+	for i := range site {
+		if site[i].Name == name {
+			return &site[i]
+		}
+	}
+
+	refGroups.RLock()
+	defer refGroups.RUnlock()
+	for i := range refGroups.groups {
+		if refGroups.groups[i].Name == name {
+			return &refGroups.groups[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"zgo.at/errors"
+	"zgo.at/zlog"
+)
+
+var refspamList = struct {
+	sync.RWMutex
+	extra      map[string]struct{} // Loaded from -refspam file.
+	subdomains []string            // All entries prefixed with a ".", for subdomain matches.
+	file       string
+	loaded     time.Time
+	blocked    map[string]int
+}{blocked: make(map[string]int)}
+
+var refspamOnce sync.Once
+
+func refspamSubdomains() {
+	s := make([]string, 0, len(refspam)+len(refspamList.extra))
+	for v := range refspam {
+		s = append(s, "."+v)
+	}
+	for v := range refspamList.extra {
+		if _, ok := refspam[v]; !ok {
+			s = append(s, "."+v)
+		}
+	}
+	refspamList.subdomains = s
+}
+
+// LoadRefspam loads additional referrer spam domains from a file, in addition
+// to the built-in list. The file has one domain per line; blank lines and lines
+// starting with # are ignored.
+//
+// This replaces any domains that were loaded previously.
+func LoadRefspam(path string) error {
+	fp, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "LoadRefspam")
+	}
+
+	extra := make(map[string]struct{})
+	scan := bufio.NewScanner(bytes.NewReader(fp))
+	for scan.Scan() {
+		l := strings.ToLower(strings.TrimSpace(scan.Text()))
+		if l == "" || l[0] == '#' {
+			continue
+		}
+		extra[l] = struct{}{}
+	}
+	if err := scan.Err(); err != nil {
+		return errors.Wrap(err, "LoadRefspam")
+	}
+
+	refspamList.Lock()
+	defer refspamList.Unlock()
+	refspamList.extra, refspamList.file, refspamList.loaded = extra, path, time.Now()
+	refspamSubdomains()
+	return nil
+}
+
+// WatchRefspam loads the referrer spam list from path with LoadRefspam(), and
+// reloads it every time the file's modification time changes until the context
+// is cancelled.
+func WatchRefspam(ctx context.Context, path string, every time.Duration) error {
+	return watchFile(ctx, "refspam", path, every, LoadRefspam)
+}
+
+// watchFile calls load with the path, and calls it again every time the file's
+// modification time changes until the context is cancelled.
+func watchFile(ctx context.Context, module, path string, every time.Duration, load func(string) error) error {
+	st, err := os.Stat(path)
+	if err != nil {
+		return errors.Wrap(err, "watchFile")
+	}
+	err = load(path)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		defer zlog.Recover()
+		mtime := st.ModTime()
+		t := time.NewTicker(every)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			st, err := os.Stat(path)
+			if err != nil {
+				zlog.Module(module).Error(err)
+				continue
+			}
+			if st.ModTime().Equal(mtime) {
+				continue
+			}
+			mtime = st.ModTime()
+			err = load(path)
+			if err != nil {
+				zlog.Module(module).Error(err)
+				continue
+			}
+			zlog.Module(module).Printf("reloaded %q", path)
+		}
+	}()
+	return nil
+}
+
+// matchDomain reports if host is domain or a subdomain of it.
+func matchDomain(host, domain string) bool {
+	return host == domain || (len(host) > len(domain) && host[len(host)-len(domain)-1] == '.' &&
+		strings.HasSuffix(host, domain))
+}
+
+// refspamEntry gets the referrer spam list entry that matches host, or an empty
+// string if it's not in the list.
+//
+// The site's allow list takes precedence over everything, followed by the
+// site's block list and then the global list.
+func refspamEntry(host string, ss *SiteSettings) string {
+	host = strings.ToLower(host)
+	if ss != nil {
+		for _, d := range ss.RefspamAllow {
+			if matchDomain(host, strings.ToLower(d)) {
+				return ""
+			}
+		}
+		for _, d := range ss.RefspamBlock {
+			if matchDomain(host, strings.ToLower(d)) {
+				return d
+			}
+		}
+	}
+
+	refspamOnce.Do(func() {
+		refspamList.Lock()
+		defer refspamList.Unlock()
+		if refspamList.subdomains == nil {
+			refspamSubdomains()
+		}
+	})
+
+	refspamList.RLock()
+	defer refspamList.RUnlock()
+	if _, ok := refspam[host]; ok {
+		return host
+	}
+	if _, ok := refspamList.extra[host]; ok {
+		return host
+	}
+	for _, v := range refspamList.subdomains {
+		if strings.HasSuffix(host, v) {
+			return v[1:]
+		}
+	}
+	return ""
+}
+
+func countRefspam(entry string) {
+	refspamList.Lock()
+	defer refspamList.Unlock()
+	refspamList.blocked[entry]++
+}
+
+type (
+	// RefspamStats are statistics for the referrer spam list.
+	RefspamStats struct {
+		BuiltIn int           // Number of built-in entries.
+		Extra   int           // Number of entries loaded from File.
+		File    string        // File loaded with LoadRefspam(); may be empty.
+		Loaded  time.Time     // When File was last loaded.
+		Blocked []RefspamHits // Number of blocked pageviews per entry, sorted by count.
+	}
+	RefspamHits struct {
+		Entry string
+		Count int
+	}
+)
+
+// ListRefspam gets statistics for the referrer spam list.
+//
+// The number of blocked pageviews is kept in memory, and counts from when the
+// server was started.
+func ListRefspam() RefspamStats {
+	refspamList.RLock()
+	defer refspamList.RUnlock()
+
+	s := RefspamStats{
+		BuiltIn: len(refspam),
+		Extra:   len(refspamList.extra),
+		File:    refspamList.file,
+		Loaded:  refspamList.loaded,
+		Blocked: make([]RefspamHits, 0, len(refspamList.blocked)),
+	}
+	for k, v := range refspamList.blocked {
+		s.Blocked = append(s.Blocked, RefspamHits{Entry: k, Count: v})
+	}
+	slices.SortFunc(s.Blocked, func(a, b RefspamHits) int {
+		if a.Count == b.Count {
+			return strings.Compare(a.Entry, b.Entry)
+		}
+		return b.Count - a.Count
+	})
+	return s
+}
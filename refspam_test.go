@@ -5,7 +5,11 @@
 package goatcounter
 
 import (
+	"context"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestRefspam(t *testing.T) {
@@ -30,7 +34,7 @@ func TestRefspam(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.in, func(t *testing.T) {
-			got := isRefspam(tt.in)
+			got := refspamEntry(tt.in, nil) != ""
 			if got != tt.want {
 				t.Errorf("\ngot:  %t\nwant: %t", got, tt.want)
 			}
@@ -38,14 +42,94 @@ func TestRefspam(t *testing.T) {
 	}
 }
 
+func TestRefspamSite(t *testing.T) {
+	ss := &SiteSettings{
+		RefspamBlock: Strings{"example.com"},
+		RefspamAllow: Strings{"ok.adcash.com", "OK.example.com"},
+	}
+	tests := []struct {
+		in, want string
+	}{
+		{"example.com", "example.com"},
+		{"a.example.com", "example.com"},
+		{"ok.example.com", ""},
+		{"aexample.com", ""},
+		{"adcash.com", "adcash.com"},
+		{"d.adcash.com", "adcash.com"},
+		{"ok.adcash.com", ""},
+		{"x.ok.adcash.com", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got := refspamEntry(tt.in, ss)
+			if got != tt.want {
+				t.Errorf("\ngot:  %q\nwant: %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadRefspam(t *testing.T) {
+	defer func() {
+		refspamList.Lock()
+		refspamList.extra, refspamList.file = nil, ""
+		refspamSubdomains()
+		refspamList.Unlock()
+	}()
+
+	tmp := filepath.Join(t.TempDir(), "refspam")
+	err := os.WriteFile(tmp, []byte("# Comment\n\nSpam.example.com\n  other.example  \n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err = WatchRefspam(ctx, tmp, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, h := range []string{"spam.example.com", "a.spam.example.com", "other.example", "adcash.com"} {
+		if refspamEntry(h, nil) == "" {
+			t.Errorf("not refspam: %q", h)
+		}
+	}
+	if e := refspamEntry("example.com", nil); e != "" {
+		t.Errorf("refspam: %q", e)
+	}
+	if s := ListRefspam(); s.Extra != 2 || s.File != tmp {
+		t.Errorf("%#v", s)
+	}
+
+	// Reload on change.
+	err = os.WriteFile(tmp, []byte("new.example.com\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(tmp, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	for i := 0; ; i++ {
+		if refspamEntry("new.example.com", nil) != "" {
+			break
+		}
+		if i > 100 {
+			t.Fatal("not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if e := refspamEntry("other.example", nil); e != "" {
+		t.Errorf("still refspam after reload: %q", e)
+	}
+}
+
 func BenchmarkRefspam(b *testing.B) {
-	isRefspam("notinthelist.com") // Run the sync.Once
+	refspamEntry("notinthelist.com", nil) // Run the sync.Once
 
 	b.ReportAllocs()
 	b.ResetTimer()
-	v := false
+	v := ""
 	for n := 0; n < b.N; n++ {
-		v = isRefspam("notinthelist.com")
+		v = refspamEntry("notinthelist.com", nil)
 	}
 	_ = v
 }
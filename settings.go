@@ -61,16 +61,23 @@ type (
 	//
 	// This is stored as JSON in the database.
 	SiteSettings struct {
-		Public         string         `json:"public"`
-		Secret         string         `json:"secret"`
-		AllowCounter   bool           `json:"allow_counter"`
-		AllowBosmang   bool           `json:"allow_bosmang"`
-		DataRetention  int            `json:"data_retention"`
-		Campaigns      Strings        `json:"-"`
-		IgnoreIPs      Strings        `json:"ignore_ips"`
-		Collect        zint.Bitflag16 `json:"collect"`
-		CollectRegions Strings        `json:"collect_regions"`
-		AllowEmbed     Strings        `json:"allow_embed"`
+		Public            string         `json:"public"`
+		Secret            string         `json:"secret"`
+		AllowCounter      bool           `json:"allow_counter"`
+		AllowBosmang      bool           `json:"allow_bosmang"`
+		DataRetention     int            `json:"data_retention"`
+		Campaigns         Strings        `json:"-"`
+		CampaignMatchCase bool           `json:"campaign_match_case"`
+		IgnoreIPs         Strings        `json:"ignore_ips"`
+		RefspamBlock      Strings        `json:"refspam_block"`
+		RefspamAllow      Strings        `json:"refspam_allow"`
+		RefGroups         RefGroups      `json:"ref_groups"`
+		Collect           zint.Bitflag16 `json:"collect"`
+		CollectRegions    Strings        `json:"collect_regions"`
+		AllowEmbed        Strings        `json:"allow_embed"`
+		Filters           FilterRules    `json:"filters"`
+		PathRules         PathRules      `json:"path_rules"`
+		PathGroups        PathGroups     `json:"path_groups"`
 	}
 
 	// UserSettings are all user preferences.
@@ -111,7 +118,7 @@ type (
 	View  struct {
 		Name   string `json:"name"`
 		Filter string `json:"filter"`
-		Daily  bool   `json:"daily"`
+		Group  Group  `json:"group"`
 		Period string `json:"period"` // "week", "week-cur", or n days: "8"
 	}
 )
@@ -125,7 +132,7 @@ type (
 func defaultWidgets(ctx context.Context) Widgets {
 	s := defaultWidgetSettings(ctx)
 	w := Widgets{}
-	for _, n := range []string{"pages", "totalpages", "toprefs", "campaigns", "browsers", "systems", "locations", "languages", "sizes"} {
+	for _, n := range []string{"pages", "totalpages", "toprefs", "campaigns", "channels", "browsers", "systems", "locations", "languages", "sizes"} {
 		w = append(w, map[string]any{"n": n, "s": s[n].getMap()})
 	}
 	return w
@@ -174,9 +181,10 @@ func defaultWidgetSettings(ctx context.Context) map[string]WidgetSettings {
 					[2]string{"line", z18n.T(ctx, "widget-settings/line-chart|Line chart")},
 					[2]string{"bar", z18n.T(ctx, "widget-settings/bar-chart|Bar chart")},
 					[2]string{"text", z18n.T(ctx, "widget-settings/text-chart|Text table")},
+					[2]string{"tree", z18n.T(ctx, "widget-settings/tree|Directory tree")},
 				},
 				Validate: func(v *zvalidate.Validator, val any) {
-					v.Include("style", val.(string), []string{"line", "bar", "text"})
+					v.Include("style", val.(string), []string{"line", "bar", "text", "tree"})
 				},
 			},
 		},
@@ -287,6 +295,29 @@ func defaultWidgetSettings(ctx context.Context) map[string]WidgetSettings {
 				},
 			},
 		},
+		"channels": map[string]WidgetSetting{
+			"limit": WidgetSetting{
+				Type:  "number",
+				Label: z18n.T(ctx, "widget-setting/label/page-size|Page size"),
+				Help:  z18n.T(ctx, "widget-setting/help/page-size|Number of pages to load"),
+				Value: float64(8),
+				Validate: func(v *zvalidate.Validator, val any) {
+					v.Range("limit", int64(val.(float64)), 1, 20)
+				},
+			},
+		},
+		"groups": map[string]WidgetSetting{
+			"limit": WidgetSetting{
+				Type:  "number",
+				Label: z18n.T(ctx, "widget-setting/label/page-size|Page size"),
+				Help:  z18n.T(ctx, "widget-setting/help/page-size|Number of pages to load"),
+				Value: float64(6),
+				Validate: func(v *zvalidate.Validator, val any) {
+					v.Range("limit", int64(val.(float64)), 1, 20)
+				},
+			},
+			"key": WidgetSetting{Hidden: true},
+		},
 		"campaigns": map[string]WidgetSetting{
 			"limit": WidgetSetting{
 				Type:  "number",
@@ -355,6 +386,21 @@ func (ss *SiteSettings) Defaults(ctx context.Context) {
 	if ss.CollectRegions == nil {
 		ss.CollectRegions = []string{"US", "RU", "CN"}
 	}
+	if ss.Filters == nil {
+		ss.Filters = FilterRules{}
+	}
+	if ss.RefGroups == nil {
+		ss.RefGroups = RefGroups{}
+	}
+	if ss.PathGroups == nil {
+		ss.PathGroups = PathGroups{}
+	}
+	if ss.PathRules.KeepQuery == nil {
+		ss.PathRules.KeepQuery = Strings{}
+	}
+	if ss.PathRules.Rewrites == nil {
+		ss.PathRules.Rewrites = PathRewrites{}
+	}
 }
 
 func (ss *SiteSettings) Validate(ctx context.Context) error {
@@ -375,6 +421,12 @@ func (ss *SiteSettings) Validate(ctx context.Context) error {
 			v.IP("ignore_ips", ip)
 		}
 	}
+	for _, d := range ss.RefspamBlock {
+		v.Hostname("refspam_block", d)
+	}
+	for _, d := range ss.RefspamAllow {
+		v.Hostname("refspam_allow", d)
+	}
 	if len(ss.AllowEmbed) > 0 {
 		for _, d := range ss.AllowEmbed {
 			if d == "*" {
@@ -384,6 +436,18 @@ func (ss *SiteSettings) Validate(ctx context.Context) error {
 			}
 		}
 	}
+	if len(ss.RefGroups) > 0 {
+		v.Sub("ref_groups", "", ss.RefGroups.Validate(ctx))
+	}
+	if len(ss.Filters) > 0 {
+		v.Sub("filters", "", ss.Filters.Validate(ctx))
+	}
+	if len(ss.PathGroups) > 0 {
+		v.Sub("path_groups", "", ss.PathGroups.Validate(ctx))
+	}
+	if !ss.PathRules.IsZero() {
+		v.Sub("path_rules", "", ss.PathRules.Validate(ctx))
+	}
 
 	return v.ErrorOrNil()
 }
@@ -585,6 +649,25 @@ func (w Widgets) ByID(id int) Widget {
 	return w[id]
 }
 
+// UnmarshalJSON reads the "daily" field from views saved before the "group"
+// field was added.
+func (v *View) UnmarshalJSON(data []byte) error {
+	type view View
+	var vv struct {
+		view
+		Daily bool `json:"daily"`
+	}
+	err := json.Unmarshal(data, &vv)
+	if err != nil {
+		return err
+	}
+	*v = View(vv.view)
+	if vv.Daily && v.Group == GroupHourly {
+		v.Group = GroupDaily
+	}
+	return nil
+}
+
 // Get a view for this site by name and returns the view and index.
 // Returns -1 if this view doesn't exist.
 func (v Views) Get(name string) (View, int) {
@@ -0,0 +1,37 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter_test
+
+import (
+	"testing"
+
+	. "zgo.at/goatcounter/v2"
+	"zgo.at/json"
+)
+
+func TestViewUnmarshal(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Group
+	}{
+		{`{"name": "default"}`, GroupHourly},
+		{`{"name": "default", "daily": false}`, GroupHourly},
+		{`{"name": "default", "daily": true}`, GroupDaily},
+		{`{"name": "default", "group": "month"}`, GroupMonthly},
+		{`{"name": "default", "daily": true, "group": "week"}`, GroupWeekly},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			var v View
+			err := json.Unmarshal([]byte(tt.in), &v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v.Name != "default" || v.Group != tt.want {
+				t.Errorf("%#v", v)
+			}
+		})
+	}
+}
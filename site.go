@@ -31,7 +31,7 @@ var reserved = []string{
 }
 
 var statTables = []string{"hit_stats", "system_stats", "browser_stats",
-	"location_stats", "language_stats", "size_stats"}
+	"location_stats", "language_stats", "size_stats", "channel_stats", "visitor_stats"}
 
 type Site struct {
 	ID     int64  `db:"site_id" json:"id,readonly"`
@@ -594,7 +594,11 @@ func (s Site) DeleteOlderThan(ctx context.Context, days int) error {
 		}
 
 		for _, t := range append(statTables, "campaign_stats") {
-			err := zdb.Exec(ctx, `delete from `+t+` where site_id=$1 and day < `+ival, s.ID)
+			col := "hour"
+			if t == "hit_stats" || t == "visitor_stats" {
+				col = "day"
+			}
+			err := zdb.Exec(ctx, `delete from `+t+` where site_id=$1 and `+col+` < `+ival, s.ID)
 			if err != nil {
 				return errors.Wrap(err, "Site.DeleteOlderThan: delete "+t)
 			}
@@ -490,7 +490,7 @@ func HorizontalChart(ctx context.Context, stats HitStats, total int, link, pagin
 			ncol = tplfunc.Number(s.Count, user.Settings.NumberFormat)
 		}
 
-		id := s.ID
+		id := template.HTMLEscapeString(s.ID)
 		if id == "" {
 			id = name
 		}
@@ -534,6 +534,17 @@ type (
 		Site    Site
 		User    User
 	}
+	TplEmailLocked struct {
+		Context context.Context
+		Site    Site
+		User    User
+	}
+	TplEmailNewDevice struct {
+		Context context.Context
+		Site    Site
+		User    User
+		Session UserSession
+	}
 	TplEmailVerify struct {
 		Context context.Context
 		Site    Site
@@ -568,6 +579,8 @@ var tplE = ztpl.ExecuteBytes
 func (t TplEmailWelcome) Render() ([]byte, error)       { return tplE("email_welcome.gotxt", t) }
 func (t TplEmailForgotSite) Render() ([]byte, error)    { return tplE("email_forgot_site.gotxt", t) }
 func (t TplEmailPasswordReset) Render() ([]byte, error) { return tplE("email_password_reset.gotxt", t) }
+func (t TplEmailLocked) Render() ([]byte, error)        { return tplE("email_locked.gotxt", t) }
+func (t TplEmailNewDevice) Render() ([]byte, error)     { return tplE("email_new_device.gotxt", t) }
 func (t TplEmailVerify) Render() ([]byte, error)        { return tplE("email_verify.gotxt", t) }
 func (t TplEmailAddUser) Render() ([]byte, error)       { return tplE("email_adduser.gotxt", t) }
 func (t TplEmailImportError) Render() ([]byte, error)   { return tplE("email_import_error.gotxt", t) }
@@ -9,11 +9,13 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"zgo.at/errors"
 	. "zgo.at/goatcounter/v2"
 	"zgo.at/goatcounter/v2/gctest"
 	"zgo.at/zstd/zgo"
+	"zgo.at/zstd/ztype"
 	"zgo.at/ztpl"
 )
 
@@ -25,6 +27,8 @@ func TestTpl(t *testing.T) {
 	ctx := gctest.Context(nil)
 	site := Site{Code: "example"}
 	user := User{Email: "a@example.com", EmailToken: sp("T-EMAIL"), LoginRequest: sp("T-LOGIN-REQ")}
+	locked := user
+	locked.LockedUntil = ztype.Ptr(time.Date(2020, 6, 18, 14, 42, 0, 0, time.UTC))
 
 	files, _ := fs.Sub(os.DirFS(zgo.ModuleRoot()), "tpl")
 	err := ztpl.Init(files)
@@ -47,6 +51,8 @@ func TestTpl(t *testing.T) {
 		{TplEmailForgotSite{ctx, []Site{}, "test@example.com"}},
 		{TplEmailPasswordReset{ctx, site, user}},
 		{TplEmailVerify{ctx, site, user}},
+		{TplEmailLocked{ctx, site, locked}},
+		{TplEmailNewDevice{ctx, site, user, UserSession{UserAgent: "Mozilla/5.0 (X11; Linux x86_64; rv:130.0) Gecko/20100101 Firefox/130.0", IP: "127.0.0.1"}}},
 		{TplEmailImportError{ctx, errors.Unwrap(errors.New("oh noes"))}},
 		{TplEmailImportDone{ctx, site, 42, errors.NewGroup(10)}},
 		{TplEmailImportDone{ctx, site, 42, errs}},
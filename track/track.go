@@ -0,0 +1,374 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+// Package track is a net/http middleware to record pageviews server-side.
+//
+// Pageviews are buffered in memory and sent to the GoatCounter API in batches
+// in the background:
+//
+//	t := track.New(track.NewCounter("https://stats.example.com", key), track.Options{
+//		ExcludePaths: []string{"/static/", "/favicon.ico"},
+//	})
+//	defer t.Close(context.Background())
+//
+//	http.ListenAndServe(":8080", t.Handler(mux))
+//
+// The IP address is taken from http.Request.RemoteAddr; use something like
+// chi's middleware.RealIP if you're behind a proxy.
+//
+// The API key needs the "Record pageviews" permission.
+//
+// This doesn't depend on the rest of GoatCounter; aside from the standard
+// library it only uses the zgo.at/goatcounter/v2/count package and the zlog and
+// zstd packages.
+package track
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"zgo.at/goatcounter/v2/count"
+	"zgo.at/zlog"
+	"zgo.at/zstd/znet"
+)
+
+// Counter sends pageviews to GoatCounter.
+//
+// This is implemented by NewCounter(), as well as by *client.Client from
+// zgo.at/goatcounter/v2/client. Note the client package depends on most of
+// GoatCounter.
+//
+// A *count.Error should be returned if the pageviews were sent but some of
+// them were rejected; on all other errors the pageviews are sent again on the
+// next flush.
+type Counter interface {
+	Count(context.Context, count.Request) error
+}
+
+// NewCounter creates a Counter which sends pageviews to the GoatCounter site at
+// siteURL; "https://" is added if it has no scheme.
+//
+// Unlike client.Client this doesn't retry requests; pageviews that failed to
+// send are kept in the Tracker's buffer instead.
+func NewCounter(siteURL, key string) Counter {
+	siteURL = strings.TrimRight(siteURL, "/")
+	if !strings.HasPrefix(siteURL, "http://") && !strings.HasPrefix(siteURL, "https://") {
+		siteURL = "https://" + siteURL
+	}
+	return &httpCounter{
+		url:  siteURL + "/api/v0/count",
+		key:  key,
+		http: &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+type httpCounter struct {
+	url, key string
+	http     *http.Client
+}
+
+func (c *httpCounter) Count(ctx context.Context, req count.Request) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequestWithContext(ctx, "POST", c.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Authorization", "Bearer "+c.key)
+
+	resp, err := c.http.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 400 {
+		return nil
+	}
+
+	rb, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode == 400 {
+		var errs struct {
+			Errors map[int]string `json:"errors"`
+		}
+		if json.Unmarshal(rb, &errs) == nil && len(errs.Errors) > 0 {
+			return &count.Error{Errors: errs.Errors}
+		}
+	}
+	return fmt.Errorf("%s: %s: %s", c.url, resp.Status, bytes.TrimSpace(rb))
+}
+
+// Options for the tracker; zero values are set to the defaults.
+type Options struct {
+	// Send pageviews at this interval; default is 10 seconds.
+	Interval time.Duration
+
+	// Send pageviews as soon as this many are buffered; default is
+	// count.MaxHits.
+	BatchSize int
+
+	// Maximum number of pageviews to buffer; pageviews that failed to send
+	// are kept in the buffer and sent again on the next flush (e.g. because
+	// GoatCounter can't be reached). New pageviews are dropped if the buffer is
+	// full. Default is 10,000.
+	MaxBuffer int
+
+	// Don't record paths that match any of these patterns. Patterns ending
+	// with a "/" match everything starting with that prefix; all other
+	// patterns are matched with path.Match().
+	ExcludePaths []string
+
+	// Don't record the request if this returns true.
+	Exclude func(*http.Request) bool
+
+	// Get a session identifier for a request, for example from a cookie or
+	// user ID. GoatCounter will use the User-Agent and IP if this returns "".
+	Session func(*http.Request) string
+
+	// Report errors from sending pageviews; the default is to log them with
+	// zlog.
+	OnError func(error)
+}
+
+// Tracker records pageviews.
+type Tracker struct {
+	c    Counter
+	opt  Options
+	done chan struct{}
+	stop chan struct{}
+	send chan struct{}
+
+	mu     sync.Mutex
+	buf    []count.Hit
+	closed bool
+}
+
+// New creates a new tracker and starts sending pageviews in the background.
+//
+// Call Close() to send any remaining pageviews on shutdown.
+func New(c Counter, opt Options) *Tracker {
+	if opt.Interval == 0 {
+		opt.Interval = 10 * time.Second
+	}
+	if opt.BatchSize == 0 {
+		opt.BatchSize = count.MaxHits
+	}
+	if opt.MaxBuffer == 0 {
+		opt.MaxBuffer = 10_000
+	}
+	if opt.OnError == nil {
+		opt.OnError = func(err error) { zlog.Module("track").Error(err) }
+	}
+
+	t := &Tracker{
+		c:    c,
+		opt:  opt,
+		done: make(chan struct{}),
+		stop: make(chan struct{}),
+		send: make(chan struct{}, 1),
+	}
+	go t.run()
+	return t
+}
+
+// Handler records all GET requests that result in a 2xx status code, unless
+// they're excluded.
+func (t *Tracker) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || t.excluded(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		if sw.status == 0 || (sw.status >= 200 && sw.status <= 299) {
+			t.Record(r)
+		}
+	})
+}
+
+// Record a pageview for this request.
+func (t *Tracker) Record(r *http.Request) {
+	hit := count.Hit{
+		Path:      r.URL.Path,
+		Query:     r.URL.RawQuery,
+		Ref:       r.Referer(),
+		UserAgent: r.UserAgent(),
+		IP:        znet.RemovePort(r.RemoteAddr),
+		CreatedAt: time.Now().UTC(),
+	}
+	if t.opt.Session != nil {
+		hit.Session = t.opt.Session(r)
+	}
+
+	t.mu.Lock()
+	if t.closed || len(t.buf) >= t.opt.MaxBuffer {
+		t.mu.Unlock()
+		return
+	}
+	t.buf = append(t.buf, hit)
+	full := len(t.buf) >= t.opt.BatchSize
+	t.mu.Unlock()
+
+	if full {
+		select {
+		case t.send <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Flush sends all buffered pageviews now.
+//
+// Pageviews that fail to send are put back in the buffer, unless they were
+// rejected by GoatCounter.
+func (t *Tracker) Flush(ctx context.Context) error {
+	t.mu.Lock()
+	hits := t.buf
+	t.buf = nil
+	t.mu.Unlock()
+
+	var rejected error
+	for start := 0; start < len(hits); start += count.MaxHits {
+		batch := hits[start:min(start+count.MaxHits, len(hits))]
+		err := t.c.Count(ctx, count.Request{NoSessions: true, Hits: batch})
+		if err != nil {
+			// Sending these again won't help.
+			var cErr *count.Error
+			if errors.As(err, &cErr) {
+				rejected = err
+				continue
+			}
+			t.requeue(hits[start:])
+			return err
+		}
+	}
+	return rejected
+}
+
+// Put hits back at the start of the buffer; pageviews recorded after hits
+// are dropped if this makes the buffer larger than MaxBuffer.
+func (t *Tracker) requeue(hits []count.Hit) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(hits[:len(hits):len(hits)], t.buf...)
+	if len(t.buf) > t.opt.MaxBuffer {
+		t.buf = t.buf[:t.opt.MaxBuffer]
+	}
+}
+
+// Close stops recording pageviews and sends any pageviews that are still
+// buffered.
+//
+// Any pageviews that are not sent before the context is cancelled are lost.
+func (t *Tracker) Close(ctx context.Context) error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	t.mu.Unlock()
+
+	close(t.stop)
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return t.Flush(ctx)
+}
+
+func (t *Tracker) run() {
+	defer close(t.done)
+
+	tick := time.NewTicker(t.opt.Interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-tick.C:
+		case <-t.send:
+		}
+
+		err := t.Flush(context.Background())
+		if err != nil {
+			t.opt.OnError(err)
+		}
+	}
+}
+
+func (t *Tracker) excluded(r *http.Request) bool {
+	p := r.URL.Path
+	for _, e := range t.opt.ExcludePaths {
+		if strings.HasSuffix(e, "/") {
+			if strings.HasPrefix(p, e) {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(e, p); ok {
+			return true
+		}
+	}
+	return t.opt.Exclude != nil && t.opt.Exclude(r)
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// Flush and Hijack are forwarded so that streaming and websocket handlers keep
+// working; hijacked connections aren't recorded.
+func (w *statusWriter) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("track: %T doesn't implement http.Hijacker: %w", w.ResponseWriter, http.ErrNotSupported)
+	}
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
@@ -0,0 +1,284 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package track
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"zgo.at/goatcounter/v2/client"
+	"zgo.at/goatcounter/v2/count"
+)
+
+var _ Counter = (*client.Client)(nil)
+
+type countServer struct {
+	mu      sync.Mutex
+	fail    bool
+	batches [][]count.Hit
+}
+
+func (s *countServer) paths() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var p []string
+	for _, b := range s.batches {
+		for _, h := range b {
+			p = append(p, h.Path)
+		}
+	}
+	sort.Strings(p)
+	return strings.Join(p, " ")
+}
+
+func newTest(t *testing.T, opt Options) (*Tracker, *countServer) {
+	t.Helper()
+
+	cs := new(countServer)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer key" {
+			t.Errorf("wrong Authorization header: %q", r.Header.Get("Authorization"))
+		}
+		var args count.Request
+		err := json.NewDecoder(r.Body).Decode(&args)
+		if err != nil {
+			t.Error(err)
+		}
+		cs.mu.Lock()
+		defer cs.mu.Unlock()
+		if cs.fail {
+			w.WriteHeader(502)
+			return
+		}
+		cs.batches = append(cs.batches, args.Hits)
+		w.WriteHeader(202)
+	}))
+	t.Cleanup(srv.Close)
+
+	if opt.OnError == nil {
+		opt.OnError = func(err error) { t.Error(err) }
+	}
+	tr := New(NewCounter(srv.URL, "key"), opt)
+	t.Cleanup(func() { tr.Close(context.Background()) })
+	return tr, cs
+}
+
+func TestTracker(t *testing.T) {
+	tr, cs := newTest(t, Options{
+		Interval:     time.Hour,
+		ExcludePaths: []string{"/static/", "/*.ico"},
+		Exclude:      func(r *http.Request) bool { return r.URL.Query().Has("skip") },
+		Session:      func(r *http.Request) string { return "sess" },
+	})
+
+	h := tr.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/404":
+			w.WriteHeader(404)
+		case "/redirect":
+			http.Redirect(w, r, "/", 303)
+		case "/empty":
+		default:
+			fmt.Fprint(w, "hello")
+		}
+	}))
+
+	for _, u := range []string{
+		"/", "/a?utm_source=x", "/empty", "/404", "/redirect", "/static/x.css",
+		"/favicon.ico", "/b?skip", "POST /c",
+	} {
+		method := "GET"
+		if m, p, ok := strings.Cut(u, " "); ok {
+			method, u = m, p
+		}
+		r := httptest.NewRequest(method, u, nil)
+		r.Header.Set("User-Agent", "Mozilla/5.0")
+		r.Header.Set("Referer", "https://example.com")
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	if have := cs.paths(); have != "" {
+		t.Errorf("sent before flush: %q", have)
+	}
+
+	err := tr.Close(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if have, want := cs.paths(), "/ /a /empty"; have != want {
+		t.Errorf("\nhave: %q\nwant: %q", have, want)
+	}
+
+	hit := cs.batches[0][1]
+	if hit.Query != "utm_source=x" || hit.Ref != "https://example.com" ||
+		hit.UserAgent != "Mozilla/5.0" || hit.IP != "192.0.2.1" || hit.Session != "sess" {
+		t.Errorf("%s", hit)
+	}
+
+	// Closed: nothing is recorded anymore.
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	err = tr.Flush(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs.batches) != 1 {
+		t.Errorf("%d batches", len(cs.batches))
+	}
+}
+
+func TestTrackerFlushHijack(t *testing.T) {
+	tr, cs := newTest(t, Options{Interval: time.Hour})
+
+	h := tr.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/stream":
+			fmt.Fprint(w, "hello")
+			w.(http.Flusher).Flush()
+		case "/ws":
+			c, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			c.Close()
+		}
+	}))
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	for _, p := range []string{"/stream", "/ws"} {
+		resp, err := http.Get(srv.URL + p)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}
+
+	err := tr.Close(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := cs.paths(), "/stream"; have != want {
+		t.Errorf("\nhave: %q\nwant: %q", have, want)
+	}
+}
+
+func TestTrackerBatch(t *testing.T) {
+	tr, cs := newTest(t, Options{Interval: time.Hour, BatchSize: 3, MaxBuffer: 5})
+
+	for i := 0; i < 3; i++ {
+		tr.Record(httptest.NewRequest("GET", fmt.Sprintf("/%d", i), nil))
+	}
+
+	for i := 0; ; i++ {
+		if cs.paths() == "/0 /1 /2" {
+			break
+		}
+		if i > 100 {
+			t.Fatalf("not sent: %q", cs.paths())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTrackerMaxBuffer(t *testing.T) {
+	tr, cs := newTest(t, Options{Interval: time.Hour, MaxBuffer: 2})
+
+	for i := 0; i < 5; i++ {
+		tr.Record(httptest.NewRequest("GET", fmt.Sprintf("/%d", i), nil))
+	}
+	err := tr.Close(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := cs.paths(), "/0 /1"; have != want {
+		t.Errorf("\nhave: %q\nwant: %q", have, want)
+	}
+}
+
+func TestTrackerInterval(t *testing.T) {
+	tr, cs := newTest(t, Options{Interval: 10 * time.Millisecond})
+
+	tr.Record(httptest.NewRequest("GET", "/x", nil))
+	for i := 0; ; i++ {
+		if cs.paths() == "/x" {
+			break
+		}
+		if i > 100 {
+			t.Fatalf("not sent: %q", cs.paths())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTrackerRetry(t *testing.T) {
+	tr, cs := newTest(t, Options{Interval: time.Hour, MaxBuffer: 3, OnError: func(error) {}})
+	cs.mu.Lock()
+	cs.fail = true
+	cs.mu.Unlock()
+
+	for i := 0; i < 2; i++ {
+		tr.Record(httptest.NewRequest("GET", fmt.Sprintf("/%d", i), nil))
+	}
+	err := tr.Flush(context.Background())
+	if err == nil {
+		t.Fatal("no error")
+	}
+
+	// Failed pageviews are kept at the start of the buffer.
+	for i := 2; i < 4; i++ {
+		tr.Record(httptest.NewRequest("GET", fmt.Sprintf("/%d", i), nil))
+	}
+	err = tr.Flush(context.Background())
+	if err == nil {
+		t.Fatal("no error")
+	}
+
+	cs.mu.Lock()
+	cs.fail = false
+	cs.mu.Unlock()
+	err = tr.Flush(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := cs.paths(), "/0 /1 /2"; have != want {
+		t.Errorf("\nhave: %q\nwant: %q", have, want)
+	}
+}
+
+func TestCounterRejected(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(400)
+		fmt.Fprint(w, `{"errors": {"1": "oh noes"}}`)
+	}))
+	t.Cleanup(srv.Close)
+
+	tr := New(NewCounter(srv.URL, "key"), Options{Interval: time.Hour, OnError: func(error) {}})
+	t.Cleanup(func() { tr.Close(context.Background()) })
+	tr.Record(httptest.NewRequest("GET", "/0", nil))
+	tr.Record(httptest.NewRequest("GET", "/1", nil))
+
+	err := tr.Flush(context.Background())
+	var cErr *count.Error
+	if !errors.As(err, &cErr) || cErr.Errors[1] != "oh noes" {
+		t.Fatalf("wrong error: %#v", err)
+	}
+
+	tr.mu.Lock()
+	n := len(tr.buf)
+	tr.mu.Unlock()
+	if n != 0 {
+		t.Errorf("rejected pageviews were kept: %d", n)
+	}
+}
@@ -28,6 +28,17 @@ import (
 
 const totpSecretLen = 16
 
+// Login brute-force protection: after loginDelayAfter failed attempts every
+// attempt is delayed (doubling for every failure, up to loginMaxDelay), and
+// after loginLockAfter failed attempts the user is locked out for
+// loginLockDuration.
+const (
+	loginDelayAfter   = 3
+	loginMaxDelay     = 5 * time.Minute
+	loginLockAfter    = 10
+	loginLockDuration = time.Hour
+)
+
 // User entry.
 type User struct {
 	ID   int64 `db:"user_id" json:"id,readonly"`
@@ -43,14 +54,25 @@ type User struct {
 	OpenAt        *time.Time   `db:"open_at" json:"open_at,readonly"`
 	ResetAt       *time.Time   `db:"reset_at" json:"reset_at,readonly"`
 	LoginRequest  *string      `db:"login_request" json:"-"`
-	LoginToken    *string      `db:"login_token" json:"-"`
-	Token         *string      `db:"csrf_token" json:"-"`
+	LoginToken    *string      `db:"-" json:"-"` // Login token for the current session.
+	Token         *string      `db:"-" json:"-"` // CSRF token for the current session.
 	EmailToken    *string      `db:"email_token" json:"-"`
 	Settings      UserSettings `db:"settings" json:"settings"`
 
 	// Keep track when the last email report was sent, so we don't double-send them.
 	LastReportAt time.Time `db:"last_report_at" json:"last_report_at"`
 
+	// Number of failed login attempts since the last successful login, and
+	// when the last one was. Logins are delayed after a few failures, and the
+	// user is locked out until LockedUntil after too many.
+	LoginFailures int        `db:"login_failures" json:"login_failures,readonly"`
+	LoginFailedAt *time.Time `db:"login_failed_at" json:"login_failed_at,readonly"`
+	LockedUntil   *time.Time `db:"locked_until" json:"locked_until,readonly"`
+
+	// Current login session; only set if the user was loaded by the login
+	// token.
+	Session *UserSession `db:"-" json:"-"`
+
 	CreatedAt time.Time  `db:"created_at" json:"created_at,readonly"`
 	UpdatedAt *time.Time `db:"updated_at" json:"updated_at,readonly"`
 }
@@ -89,6 +111,8 @@ func (u *User) Validate(ctx context.Context, validatePassword bool) error {
 	v.Email("email", u.Email)
 	if len(u.Access) == 0 {
 		v.Append("access", "must be set")
+	} else {
+		v.Sub("access", "", u.Access.Validate(ctx))
 	}
 
 	if validatePassword {
@@ -182,7 +206,7 @@ func (u *User) Delete(ctx context.Context, lastAdmin bool) error {
 		}
 		admins = admins.Admins()
 		if len(admins) == 1 && admins[0].ID == u.ID {
-			return fmt.Errorf("can't delete last admin user for site %d", u.Site)
+			return guru.Errorf(400, "can't delete last admin user for site %d", u.Site)
 		}
 	}
 
@@ -191,9 +215,15 @@ func (u *User) Delete(ctx context.Context, lastAdmin bool) error {
 		return errors.Wrap(err, "User.Delete")
 	}
 
-	err = zdb.Exec(ctx, `delete from users where user_id=? and site_id=?`,
-		u.ID, account.ID)
-	return errors.Wrap(err, "User.Delete")
+	return zdb.TX(ctx, func(ctx context.Context) error {
+		err := zdb.Exec(ctx, `delete from users where user_id=? and site_id=?`,
+			u.ID, account.ID)
+		if err != nil {
+			return errors.Wrap(err, "User.Delete")
+		}
+		err = zdb.Exec(ctx, `delete from user_sessions where user_id=?`, u.ID)
+		return errors.Wrap(err, "User.Delete")
+	})
 }
 
 // Update this user's name, email, settings, and access.
@@ -277,6 +307,88 @@ func (u User) CorrectPassword(pwd string) (bool, error) {
 	return true, nil
 }
 
+// Locked reports if this user is currently locked out because of too many
+// failed login attempts.
+func (u User) Locked() bool {
+	return u.LockedUntil != nil && u.LockedUntil.After(ztime.Now())
+}
+
+// LoginDelay gets how long the user needs to wait before they can try to log
+// in again; this is 0 if they can log in now.
+func (u User) LoginDelay() time.Duration {
+	if u.Locked() {
+		return u.LockedUntil.Sub(ztime.Now())
+	}
+	if u.LoginFailures < loginDelayAfter || u.LoginFailedAt == nil {
+		return 0
+	}
+
+	delay := loginMaxDelay
+	if n := u.LoginFailures - loginDelayAfter; n < 16 {
+		delay = min(time.Duration(1<<n)*time.Second, loginMaxDelay)
+	}
+	return max(u.LoginFailedAt.Add(delay).Sub(ztime.Now()), 0)
+}
+
+// LoginFailed records a failed login attempt, locking the user out if there
+// were too many failures. It returns true if the user was locked out by this
+// attempt.
+func (u *User) LoginFailed(ctx context.Context) (bool, error) {
+	if u.ID == 0 {
+		return false, errors.New("u.ID == 0")
+	}
+
+	// Increment in the database rather than writing u.LoginFailures, so that
+	// concurrent attempts can't overwrite each other's count.
+	now := ztime.Now()
+	err := zdb.Get(ctx, &u.LoginFailures, `update users set
+			login_failures=login_failures+1, login_failed_at=?
+			where user_id=?
+			returning login_failures`,
+		now, u.ID)
+	if err != nil {
+		return false, errors.Wrap(err, "User.LoginFailed")
+	}
+	u.LoginFailedAt = &now
+	if u.LoginFailures < loginLockAfter {
+		return false, nil
+	}
+
+	// Only one of several concurrent attempts will get a row back here.
+	var id int64
+	lockedUntil := now.Add(loginLockDuration)
+	err = zdb.Get(ctx, &id, `update users set
+			login_failures=0, locked_until=?
+			where user_id=? and login_failures >= ?
+			returning user_id`,
+		lockedUntil, u.ID, loginLockAfter)
+	if zdb.ErrNoRows(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "User.LoginFailed")
+	}
+	u.LoginFailures, u.LockedUntil = 0, &lockedUntil
+	return true, nil
+}
+
+// ClearLoginFailures resets the failed login attempts and removes any lockout.
+func (u *User) ClearLoginFailures(ctx context.Context) error {
+	if u.ID == 0 {
+		return errors.New("u.ID == 0")
+	}
+	if u.LoginFailures == 0 && u.LoginFailedAt == nil && u.LockedUntil == nil {
+		return nil
+	}
+
+	u.LoginFailures, u.LoginFailedAt, u.LockedUntil = 0, nil, nil
+	err := zdb.Exec(ctx, `update users set
+			login_failures=0, login_failed_at=null, locked_until=null
+			where user_id=?`,
+		u.ID)
+	return errors.Wrap(err, "User.ClearLoginFailures")
+}
+
 func (u *User) VerifyEmail(ctx context.Context) error {
 	err := zdb.Exec(ctx,
 		`update users set email_verified=1, email_token=null where user_id=$1`,
@@ -351,9 +463,17 @@ func (u *User) ByToken(ctx context.Context, token string) error {
 		return sql.ErrNoRows
 	}
 
-	return errors.Wrap(zdb.Get(ctx, u,
-		`select * from users where login_token=$1`, token),
-		"User.ByToken")
+	var sess UserSession
+	err := sess.ByToken(ctx, token)
+	if err != nil {
+		return errors.Wrap(err, "User.ByToken")
+	}
+	err = zdb.Get(ctx, u, `select * from users where user_id=$1`, sess.UserID)
+	if err != nil {
+		return errors.Wrap(err, "User.ByToken")
+	}
+	u.setSession(&sess)
+	return nil
 }
 
 // ByTokenAndSite gets a user by login token.
@@ -362,9 +482,24 @@ func (u *User) ByTokenAndSite(ctx context.Context, token string) error {
 		return sql.ErrNoRows
 	}
 
-	return errors.Wrap(zdb.Get(ctx, u,
-		`select * from users where login_token=$1 and site_id=$2`,
-		token, MustGetSite(ctx).IDOrParent()), "User.ByTokenAndSite")
+	var sess UserSession
+	err := sess.ByToken(ctx, token)
+	if err != nil {
+		return errors.Wrap(err, "User.ByTokenAndSite")
+	}
+	err = zdb.Get(ctx, u, `select * from users where user_id=$1 and site_id=$2`,
+		sess.UserID, MustGetSite(ctx).IDOrParent())
+	if err != nil {
+		return errors.Wrap(err, "User.ByTokenAndSite")
+	}
+	u.setSession(&sess)
+	return nil
+}
+
+func (u *User) setSession(sess *UserSession) {
+	u.Session = sess
+	u.LoginToken = &sess.Token
+	u.Token = &sess.CSRFToken
 }
 
 // RequestReset generates a new password reset key.
@@ -415,26 +550,33 @@ func (u *User) DisableTOTP(ctx context.Context) error {
 	return nil
 }
 
-// Login a user; create a new key, CSRF token, and reset the request date.
-func (u *User) Login(ctx context.Context) error {
+// Login a user; this creates a new session with a new key and CSRF token, and
+// resets the request date.
+//
+// The userAgent and ip are stored in the session so the user can see where
+// they're logged in from.
+func (u *User) Login(ctx context.Context, userAgent, ip string) error {
 	if u.ID == 0 {
 		return errors.New("u.ID == 0")
 	}
 
-	u.Token = ztype.Ptr(zcrypto.Secret256())
-	if u.LoginToken == nil || *u.LoginToken == "" {
-		s := ztime.Now().Format("20060102") + "-" + zcrypto.Secret256()
-		u.LoginToken = &s
-	}
-
-	u.LoginAt = ztype.Ptr(ztime.Now())
-	u.OpenAt = ztype.Ptr(ztime.Now())
-	err := zdb.Exec(ctx, `update users set
-			login_request=null, login_token=?, csrf_token=?, login_at=?, open_at=?
-			where user_id = ? and site_id = ?`,
-		u.LoginToken, u.Token, u.LoginAt, u.OpenAt,
-		u.ID, MustGetSite(ctx).IDOrParent())
-	return errors.Wrap(err, "User.Login")
+	return zdb.TX(ctx, func(ctx context.Context) error {
+		sess := UserSession{UserID: u.ID, UserAgent: userAgent, IP: ip}
+		err := sess.Insert(ctx)
+		if err != nil {
+			return errors.Wrap(err, "User.Login")
+		}
+		u.setSession(&sess)
+
+		u.LoginAt = ztype.Ptr(ztime.Now())
+		u.OpenAt = ztype.Ptr(ztime.Now())
+		err = zdb.Exec(ctx, `update users set
+				login_request=null, login_at=?, open_at=?
+				where user_id = ? and site_id = ?`,
+			u.LoginAt, u.OpenAt,
+			u.ID, MustGetSite(ctx).IDOrParent())
+		return errors.Wrap(err, "User.Login")
+	})
 }
 
 func (u *User) UpdateOpenAt(ctx context.Context) error {
@@ -453,19 +595,43 @@ func (u *User) UpdateOpenAt(ctx context.Context) error {
 	return errors.Wrap(err, "User.UpdateOpenAt")
 }
 
-// Logout a user.
+// Logout a user from the current session.
 func (u *User) Logout(ctx context.Context) error {
 	if u.ID == 0 {
 		return errors.New("u.ID == 0")
 	}
 
-	u.LoginToken = nil
-	u.LoginRequest = nil
-	u.LoginAt = nil
-	err := zdb.Exec(ctx,
-		`update users set login_token=null, login_request=null where user_id=$1 and site_id=$2`,
-		u.ID, MustGetSite(ctx).IDOrParent())
-	return errors.Wrap(err, "User.Logout")
+	return zdb.TX(ctx, func(ctx context.Context) error {
+		if u.Session != nil {
+			err := u.Session.Delete(ctx)
+			if err != nil {
+				return errors.Wrap(err, "User.Logout")
+			}
+		}
+
+		u.Session, u.LoginToken, u.Token = nil, nil, nil
+		u.LoginRequest = nil
+		u.LoginAt = nil
+		err := zdb.Exec(ctx,
+			`update users set login_request=null where user_id=$1 and site_id=$2`,
+			u.ID, MustGetSite(ctx).IDOrParent())
+		return errors.Wrap(err, "User.Logout")
+	})
+}
+
+// LogoutAll logs out all sessions for this user, except the current session
+// if keepCurrent is set.
+func (u *User) LogoutAll(ctx context.Context, keepCurrent bool) error {
+	if u.ID == 0 {
+		return errors.New("u.ID == 0")
+	}
+
+	var except int64
+	if keepCurrent && u.Session != nil {
+		except = u.Session.ID
+	}
+	var sessions UserSessions
+	return errors.Wrap(sessions.DeleteAll(ctx, u.ID, except), "User.LogoutAll")
 }
 
 // CSRFToken gets the CSRF token.
@@ -476,22 +642,13 @@ func (u *User) CSRFToken() string {
 	return *u.Token
 }
 
-// HasAccess checks if this user has access to this site for the permission.
-func (u User) HasAccess(check UserAccess) bool {
-	switch check {
-	default:
-		return false
-	case AccessSuperuser:
-		return u.Access["all"] == AccessSuperuser
-	case AccessAdmin:
-		return u.Access["all"] == AccessSuperuser || u.Access["all"] == AccessAdmin
-	case AccessSettings:
-		return u.Access["all"] == AccessSuperuser || u.Access["all"] == AccessAdmin || u.Access["all"] == AccessSettings
-	case AccessReadOnly:
-		return u.Access["all"] == AccessSuperuser || u.Access["all"] == AccessAdmin || u.Access["all"] == AccessSettings || u.Access["all"] == AccessReadOnly
-	}
+// HasAccess checks if this user has access to the site for the permission.
+func (u User) HasAccess(siteID int64, check UserAccess) bool {
+	return u.Access.For(siteID).AtLeast(check)
 }
 
+// AccessSuperuser, AccessAdmin, and AccessSettings check the access for all
+// sites in the account, ignoring any per-site access.
 func (u User) AccessSuperuser() bool { return u.Access["all"] == AccessSuperuser }
 func (u User) AccessAdmin() bool     { return u.AccessSuperuser() || u.Access["all"] == AccessAdmin }
 func (u User) AccessSettings() bool  { return u.AccessAdmin() || u.Access["all"] == AccessSettings }
@@ -598,12 +755,15 @@ func (u *Users) IDs() []int64 {
 	return ids
 }
 
+// UserAccesses is the access a user has. The "all" key applies to all sites in
+// the account, and can be overridden for a site by using the site ID as the key.
 type (
 	UserAccesses map[string]UserAccess
 	UserAccess   string
 )
 
 const (
+	AccessNone      UserAccess = "-"
 	AccessReadOnly  UserAccess = "r"
 	AccessSettings  UserAccess = "s"
 	AccessAdmin     UserAccess = "a"
@@ -613,6 +773,8 @@ const (
 // TODO: this is not translated.
 func (u UserAccess) String() string {
 	switch u {
+	case AccessNone:
+		return "no access"
 	case AccessReadOnly:
 		return "read only"
 	case AccessSettings:
@@ -626,6 +788,75 @@ func (u UserAccess) String() string {
 	}
 }
 
+// AtLeast reports if this access is the same as or more than check.
+func (u UserAccess) AtLeast(check UserAccess) bool {
+	switch check {
+	default:
+		return false
+	case AccessSuperuser:
+		return u == AccessSuperuser
+	case AccessAdmin:
+		return u == AccessSuperuser || u == AccessAdmin
+	case AccessSettings:
+		return u == AccessSuperuser || u == AccessAdmin || u == AccessSettings
+	case AccessReadOnly:
+		return u == AccessSuperuser || u == AccessAdmin || u == AccessSettings || u == AccessReadOnly
+	}
+}
+
+// For gets the access for the given site ID.
+//
+// This is the access set for the site if there is one, or the access set for
+// "all" if there isn't. A superuser always has superuser access to every site.
+func (u UserAccesses) For(siteID int64) UserAccess {
+	all := u["all"]
+	if all == AccessSuperuser {
+		return all
+	}
+	if a, ok := u[strconv.FormatInt(siteID, 10)]; ok {
+		return a
+	}
+	return all
+}
+
+// Sites gets the per-site access, keyed by site ID.
+func (u UserAccesses) Sites() map[int64]UserAccess {
+	m := make(map[int64]UserAccess)
+	for k, v := range u {
+		if id, err := strconv.ParseInt(k, 10, 64); err == nil {
+			m[id] = v
+		}
+	}
+	return m
+}
+
+// Validate the access keys and values.
+func (u UserAccesses) Validate(ctx context.Context) error {
+	v := NewValidate(ctx)
+	switch u["all"] {
+	case AccessReadOnly, AccessSettings, AccessAdmin, AccessSuperuser:
+	case "":
+		v.Append("all", "must be set")
+	default:
+		v.Append("all", fmt.Sprintf("invalid value: %q", string(u["all"])))
+	}
+	for k, a := range u {
+		if k == "all" {
+			continue
+		}
+		if id, err := strconv.ParseInt(k, 10, 64); err != nil || id <= 0 {
+			v.Append(k, "not a valid site ID")
+			continue
+		}
+		switch a {
+		case AccessNone, AccessReadOnly, AccessSettings, AccessAdmin:
+		default:
+			v.Append(k, fmt.Sprintf("invalid value: %q", string(a)))
+		}
+	}
+	return v.ErrorOrNil()
+}
+
 // Value implements the SQL Value function to determine what to store in the DB.
 func (u UserAccesses) Value() (driver.Value, error) { return json.Marshal(u) }
 
@@ -0,0 +1,120 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package goatcounter
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"zgo.at/errors"
+	"zgo.at/gadget"
+	"zgo.at/zdb"
+	"zgo.at/zstd/zcrypto"
+	"zgo.at/zstd/ztime"
+)
+
+// UserSession is a login session for a user; a user can be logged in from
+// several devices at the same time, and each login has its own session.
+type UserSession struct {
+	ID     int64 `db:"user_session_id" json:"id,readonly"`
+	UserID int64 `db:"user_id" json:"user_id,readonly"`
+
+	Token     string `db:"token" json:"-"`
+	CSRFToken string `db:"csrf_token" json:"-"`
+
+	// User-Agent header and IP address this session was created from.
+	UserAgent string `db:"user_agent" json:"user_agent,readonly"`
+	IP        string `db:"ip" json:"ip,readonly"`
+
+	CreatedAt  time.Time `db:"created_at" json:"created_at,readonly"`
+	LastUsedAt time.Time `db:"last_used_at" json:"last_used_at,readonly"`
+}
+
+// Insert a new session, generating new tokens.
+func (s *UserSession) Insert(ctx context.Context) error {
+	if s.ID > 0 {
+		return errors.New("UserSession.Insert: ID > 0")
+	}
+	if s.UserID == 0 {
+		return errors.New("UserSession.Insert: UserID == 0")
+	}
+
+	s.Token = ztime.Now().Format("20060102") + "-" + zcrypto.Secret256()
+	s.CSRFToken = zcrypto.Secret256()
+	s.CreatedAt = ztime.Now()
+	s.LastUsedAt = s.CreatedAt
+
+	var err error
+	s.ID, err = zdb.InsertID(ctx, "user_session_id",
+		`insert into user_sessions (user_id, token, csrf_token, user_agent, ip, created_at, last_used_at)
+		values (?, ?, ?, ?, ?, ?, ?)`,
+		s.UserID, s.Token, s.CSRFToken, s.UserAgent, s.IP, s.CreatedAt, s.LastUsedAt)
+	return errors.Wrap(err, "UserSession.Insert")
+}
+
+// ByToken gets a session by the login token.
+func (s *UserSession) ByToken(ctx context.Context, token string) error {
+	return errors.Wrap(zdb.Get(ctx, s,
+		`select * from user_sessions where token=$1`, token),
+		"UserSession.ByToken")
+}
+
+// UpdateLastUsed updates the last used time; this is done once an hour at the
+// most.
+func (s *UserSession) UpdateLastUsed(ctx context.Context) error {
+	if s.LastUsedAt.After(ztime.Now().Add(-1 * time.Hour)) {
+		return nil
+	}
+
+	s.LastUsedAt = ztime.Now()
+	err := zdb.Exec(ctx, `update user_sessions set last_used_at=? where user_session_id=?`,
+		s.LastUsedAt, s.ID)
+	return errors.Wrap(err, "UserSession.UpdateLastUsed")
+}
+
+// Delete this session, logging out the device that uses it.
+func (s *UserSession) Delete(ctx context.Context) error {
+	err := zdb.Exec(ctx, `delete from user_sessions where user_session_id=? and user_id=?`,
+		s.ID, s.UserID)
+	return errors.Wrap(err, "UserSession.Delete")
+}
+
+// Device gets a description of the browser and system from the User-Agent
+// header, for example "Firefox 130 on Linux".
+func (s UserSession) Device() string {
+	if s.UserAgent == "" {
+		return ""
+	}
+	ua := gadget.ParseUA(s.UserAgent)
+	b := strings.TrimSpace(ua.BrowserName + " " + ua.BrowserVersion)
+	o := strings.TrimSpace(ua.OSName + " " + ua.OSVersion)
+	switch {
+	case b != "" && o != "":
+		return b + " on " + o
+	case b != "":
+		return b
+	case o != "":
+		return o
+	}
+	return s.UserAgent
+}
+
+type UserSessions []UserSession
+
+// List all sessions for a user, most recently used first.
+func (s *UserSessions) List(ctx context.Context, userID int64) error {
+	return errors.Wrap(zdb.Select(ctx, s,
+		`select * from user_sessions where user_id=? order by last_used_at desc, user_session_id desc`,
+		userID), "UserSessions.List")
+}
+
+// DeleteAll deletes all sessions for a user, except the session with the ID
+// except (if it's not 0).
+func (s *UserSessions) DeleteAll(ctx context.Context, userID, except int64) error {
+	err := zdb.Exec(ctx, `delete from user_sessions where user_id=? and user_session_id != ?`,
+		userID, except)
+	return errors.Wrap(err, "UserSessions.DeleteAll")
+}
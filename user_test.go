@@ -5,12 +5,16 @@
 package goatcounter_test
 
 import (
+	"context"
 	"testing"
 	"time"
 
 	"zgo.at/goatcounter/v2"
+	"zgo.at/goatcounter/v2/gctest"
 	"zgo.at/tz"
+	"zgo.at/zdb"
 	"zgo.at/zstd/zint"
+	"zgo.at/zstd/ztest"
 	"zgo.at/zstd/ztime"
 )
 
@@ -85,3 +89,212 @@ func TestUserEmailReportRange(t *testing.T) {
 		})
 	}
 }
+
+func TestUserAccessesFor(t *testing.T) {
+	tests := []struct {
+		in   goatcounter.UserAccesses
+		site int64
+		want goatcounter.UserAccess
+	}{
+		{goatcounter.UserAccesses{"all": "a"}, 1, goatcounter.AccessAdmin},
+		{goatcounter.UserAccesses{"all": "a", "2": "r"}, 1, goatcounter.AccessAdmin},
+		{goatcounter.UserAccesses{"all": "a", "2": "r"}, 2, goatcounter.AccessReadOnly},
+		{goatcounter.UserAccesses{"all": "r", "2": "s"}, 2, goatcounter.AccessSettings},
+		{goatcounter.UserAccesses{"all": "r", "2": "-"}, 2, goatcounter.AccessNone},
+		{goatcounter.UserAccesses{"all": "*", "2": "-"}, 2, goatcounter.AccessSuperuser},
+	}
+
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			have := tt.in.For(tt.site)
+			if have != tt.want {
+				t.Errorf("\nhave: %q\nwant: %q", have, tt.want)
+			}
+		})
+	}
+
+	if goatcounter.AccessNone.AtLeast(goatcounter.AccessReadOnly) {
+		t.Error("AccessNone.AtLeast(AccessReadOnly)")
+	}
+	if !goatcounter.AccessAdmin.AtLeast(goatcounter.AccessSettings) {
+		t.Error("!AccessAdmin.AtLeast(AccessSettings)")
+	}
+}
+
+func TestUserAccessesValidate(t *testing.T) {
+	tests := []struct {
+		in      goatcounter.UserAccesses
+		wantErr string
+	}{
+		{goatcounter.UserAccesses{"all": "a"}, ""},
+		{goatcounter.UserAccesses{"all": "r", "1": "a", "2": "-"}, ""},
+		{goatcounter.UserAccesses{"all": "-"}, "all"},
+		{goatcounter.UserAccesses{"all": "r", "1": "*"}, "1"},
+		{goatcounter.UserAccesses{"all": "r", "x": "a"}, "x"},
+	}
+
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			err := tt.in.Validate(context.Background())
+			if !ztest.ErrorContains(err, tt.wantErr) {
+				t.Errorf("wrong error\nhave: %v\nwant: %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUserSessions(t *testing.T) {
+	ctx := gctest.DB(t)
+	u := goatcounter.MustGetUser(ctx)
+
+	var tokens []string
+	for _, ua := range []string{"Mozilla/5.0 (X11; Linux x86_64; rv:130.0) Gecko/20100101 Firefox/130.0", "curl/8.0"} {
+		login := *u
+		err := login.Login(ctx, ua, "127.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, *login.LoginToken)
+	}
+	if tokens[0] == tokens[1] {
+		t.Fatal("same token for both sessions")
+	}
+
+	var sessions goatcounter.UserSessions
+	err := sessions.List(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("len = %d", len(sessions))
+	}
+	if d := sessions[1].Device(); d != "Firefox 130 on Linux" {
+		t.Errorf("Device: %q", d)
+	}
+
+	// Logout from the first session.
+	var first goatcounter.User
+	err = first.ByTokenAndSite(ctx, tokens[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = first.Logout(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = first.ByTokenAndSite(ctx, tokens[0])
+	if !zdb.ErrNoRows(err) {
+		t.Fatalf("wrong error: %v", err)
+	}
+	var second goatcounter.User
+	err = second.ByTokenAndSite(ctx, tokens[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = second.LogoutAll(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = second.ByTokenAndSite(ctx, tokens[1])
+	if !zdb.ErrNoRows(err) {
+		t.Fatalf("wrong error: %v", err)
+	}
+}
+
+func TestUserLoginFailed(t *testing.T) {
+	ctx := gctest.DB(t)
+	ztime.SetNow(t, "2020-06-18 14:42:00")
+	u := goatcounter.MustGetUser(ctx)
+
+	fail := func(wantLocked bool) {
+		t.Helper()
+		locked, err := u.LoginFailed(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if locked != wantLocked {
+			t.Fatalf("locked = %t; want %t", locked, wantLocked)
+		}
+	}
+
+	for range 2 {
+		fail(false)
+	}
+	if d := u.LoginDelay(); d != 0 {
+		t.Fatalf("delay after 2 failures: %s", d)
+	}
+
+	fail(false)
+	if d := u.LoginDelay(); d != time.Second {
+		t.Fatalf("delay after 3 failures: %s", d)
+	}
+	fail(false)
+	if d := u.LoginDelay(); d != 2*time.Second {
+		t.Fatalf("delay after 4 failures: %s", d)
+	}
+	ztime.SetNow(t, "2020-06-18 14:42:02")
+	if d := u.LoginDelay(); d != 0 {
+		t.Fatalf("delay after waiting: %s", d)
+	}
+
+	for range 5 {
+		fail(false)
+	}
+	fail(true)
+
+	var got goatcounter.User
+	err := got.ByID(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Locked() {
+		t.Fatal("not locked")
+	}
+	if d := got.LoginDelay(); d != time.Hour {
+		t.Fatalf("delay when locked: %s", d)
+	}
+
+	ztime.SetNow(t, "2020-06-18 15:42:02")
+	if got.Locked() {
+		t.Fatal("still locked after an hour")
+	}
+
+	err = got.ClearLoginFailures(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = goatcounter.User{}
+	err = got.ByID(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.LoginFailures != 0 || got.LoginFailedAt != nil || got.LockedUntil != nil {
+		t.Errorf("not cleared: %d %v %v", got.LoginFailures, got.LoginFailedAt, got.LockedUntil)
+	}
+
+	// Concurrent requests all load the same row; every failure should still
+	// be counted.
+	var nlocked int
+	for range 10 {
+		stale := got
+		locked, err := stale.LoginFailed(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if locked {
+			nlocked++
+		}
+	}
+	if nlocked != 1 {
+		t.Errorf("locked %d times", nlocked)
+	}
+	got = goatcounter.User{}
+	err = got.ByID(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Locked() {
+		t.Error("not locked after concurrent failures")
+	}
+}
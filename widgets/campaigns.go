@@ -7,7 +7,6 @@ package widgets
 import (
 	"context"
 	"html/template"
-	"strconv"
 
 	"zgo.at/goatcounter/v2"
 	"zgo.at/z18n"
@@ -21,7 +20,7 @@ type Campaigns struct {
 	s      goatcounter.WidgetSettings
 
 	Limit    int
-	Campaign int64
+	Campaign goatcounter.CampaignKey
 	Stats    goatcounter.HitStats
 }
 
@@ -41,12 +40,12 @@ func (w *Campaigns) SetSettings(s goatcounter.WidgetSettings) {
 		w.Limit = int(x.(float64))
 	}
 	if x := s["key"].Value; x != nil {
-		w.Campaign, _ = strconv.ParseInt(x.(string), 10, 64)
+		w.Campaign, _ = goatcounter.ParseCampaignKey(x.(string))
 	}
 }
 
 func (w *Campaigns) GetData(ctx context.Context, a Args) (more bool, err error) {
-	if w.Campaign > 0 {
+	if w.Campaign.Campaign > 0 {
 		err = w.Stats.ListCampaign(ctx, w.Campaign, a.Rng, a.PathFilter, w.Limit, a.Offset)
 	} else {
 		err = w.Stats.ListCampaigns(ctx, a.Rng, a.PathFilter, w.Limit, a.Offset)
@@ -70,8 +69,8 @@ func (w Campaigns) RenderHTML(ctx context.Context, shared SharedData) (string, a
 		Header       string
 		TotalUTC     int
 		Stats        goatcounter.HitStats
-		Campaign     int64
-	}{ctx, goatcounter.Config(ctx).BasePath, w.id, true, shared.RowsOnly, w.Campaign == 0, w.loaded, w.err,
+		Campaign     goatcounter.CampaignKey
+	}{ctx, goatcounter.Config(ctx).BasePath, w.id, true, shared.RowsOnly, w.Campaign.Source == nil, w.loaded, w.err,
 		isCol(ctx, goatcounter.CollectReferrer), w.Label(ctx),
 		shared.TotalUTC, w.Stats, w.Campaign}
 }
@@ -0,0 +1,77 @@
+// Copyright © Martin Tournoij – This file is part of GoatCounter and published
+// under the terms of a slightly modified EUPL v1.2 license, which can be found
+// in the LICENSE file or at https://license.goatcounter.com
+
+package widgets
+
+import (
+	"context"
+	"html/template"
+
+	"zgo.at/goatcounter/v2"
+	"zgo.at/z18n"
+)
+
+type Groups struct {
+	id     int
+	loaded bool
+	err    error
+	html   template.HTML
+	s      goatcounter.WidgetSettings
+
+	Limit  int
+	Detail string
+	Stats  goatcounter.HitStats
+}
+
+func (w Groups) Name() string { return "groups" }
+func (w Groups) Type() string { return "hchart" }
+func (w Groups) Label(ctx context.Context) string {
+	return z18n.T(ctx, "label/group-stats|Content groups")
+}
+func (w *Groups) SetHTML(h template.HTML)             { w.html = h }
+func (w Groups) HTML() template.HTML                  { return w.html }
+func (w *Groups) SetErr(h error)                      { w.err = h }
+func (w Groups) Err() error                           { return w.err }
+func (w Groups) ID() int                              { return w.id }
+func (w Groups) Settings() goatcounter.WidgetSettings { return w.s }
+
+func (w *Groups) SetSettings(s goatcounter.WidgetSettings) {
+	if x := s["limit"].Value; x != nil {
+		w.Limit = int(x.(float64))
+	}
+	if x := s["key"].Value; x != nil {
+		w.Detail = x.(string)
+	}
+	w.s = s
+}
+
+func (w *Groups) GetData(ctx context.Context, a Args) (more bool, err error) {
+	if w.Detail != "" {
+		err = w.Stats.ListGroup(ctx, w.Detail, a.Rng, a.PathFilter, w.Limit, a.Offset)
+	} else {
+		err = w.Stats.ListGroups(ctx, a.Rng, a.PathFilter, w.Limit, a.Offset)
+	}
+	w.loaded = true
+	return w.Stats.More, err
+}
+
+func (w Groups) RenderHTML(ctx context.Context, shared SharedData) (string, any) {
+	return "_dashboard_hchart.gohtml", struct {
+		Context      context.Context
+		Base         string
+		ID           int
+		CanConfigure bool
+		RowsOnly     bool
+		HasSubMenu   bool
+		Loaded       bool
+		Err          error
+		IsCollected  bool
+		Header       string
+		TotalUTC     int
+		Stats        goatcounter.HitStats
+		Detail       string
+	}{ctx, goatcounter.Config(ctx).BasePath, w.id, true, shared.RowsOnly, w.Detail == "", w.loaded, w.err,
+		true, z18n.T(ctx, "header/groups|Content groups"),
+		shared.TotalUTC, w.Stats, w.Detail}
+}
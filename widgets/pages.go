@@ -8,6 +8,7 @@ import (
 	"context"
 	"html/template"
 	"strconv"
+	"strings"
 	"sync"
 
 	"zgo.at/errors"
@@ -34,6 +35,8 @@ type Pages struct {
 	Max              int
 	Exclude          []int64
 	Diff             []float64
+	Prefix           string
+	Tree             goatcounter.PathNodes
 }
 
 func (w Pages) Name() string                         { return "pages" }
@@ -68,6 +71,15 @@ func (w *Pages) GetData(ctx context.Context, a Args) (bool, error) {
 		return w.Refs.More, err
 	}
 
+	if w.Style == "tree" {
+		if w.Prefix == "" {
+			w.Prefix = "/"
+		}
+		_, err := w.Tree.List(ctx, a.Rng, w.Prefix, a.PathFilter, 0)
+		w.loaded = true
+		return false, err
+	}
+
 	var (
 		wg   sync.WaitGroup
 		errs = errors.NewGroup(2)
@@ -82,7 +94,7 @@ func (w *Pages) GetData(ctx context.Context, a Args) (bool, error) {
 	}
 
 	var err error
-	w.Display, w.More, err = w.Pages.List(ctx, a.Rng, a.PathFilter, w.Exclude, w.Limit, a.Daily)
+	w.Display, w.More, err = w.Pages.List(ctx, a.Rng, a.PathFilter, w.Exclude, w.Limit, a.Group)
 	errs.Append(err)
 
 	if !goatcounter.MustGetUser(ctx).Settings.FewerNumbers {
@@ -118,6 +130,28 @@ func (w Pages) RenderHTML(ctx context.Context, shared SharedData) (string, any)
 			w.Refs, shared.Total}
 	}
 
+	if w.Style == "tree" {
+		t := "_dashboard_pages_tree.gohtml"
+		if shared.RowsOnly {
+			t = "_dashboard_pages_tree_rows.gohtml"
+		}
+		return t, struct {
+			Context context.Context
+			Site    *goatcounter.Site
+			User    *goatcounter.User
+
+			ID          int
+			Loaded      bool
+			Err         error
+			Tree        goatcounter.PathNodes
+			Prefix      string
+			Depth       int
+			Total       int
+			TotalUnique int
+		}{ctx, shared.Site, shared.User, w.id, w.loaded, w.err,
+			w.Tree, w.Prefix, strings.Count(w.Prefix, "/") - 1, shared.Total, shared.TotalUnique}
+	}
+
 	t := "_dashboard_pages"
 	if w.Style == "text" {
 		t += "_text"
@@ -136,7 +170,7 @@ func (w Pages) RenderHTML(ctx context.Context, shared SharedData) (string, any)
 				w.Max = m
 			}
 		}
-	} else if len(w.Pages) > 0 && len(w.Pages[0].Stats) > 0 {
+	} else if len(w.Pages) > 0 && len(w.Pages[0].Stats) > 0 && shared.Args.Group <= goatcounter.GroupDaily {
 		// Set days in the future to -1; we filter this in the JS when rendering
 		// the chart.
 		// It's easier to do this here because JavaScript Date() has piss-poor
@@ -168,6 +202,7 @@ func (w Pages) RenderHTML(ctx context.Context, shared SharedData) (string, any)
 		Pages       goatcounter.HitLists
 		Period      ztime.Range
 		Daily       bool
+		Group       goatcounter.Group
 		ForcedDaily bool
 		Offset      int
 		Max         int
@@ -175,6 +210,7 @@ func (w Pages) RenderHTML(ctx context.Context, shared SharedData) (string, any)
 		TotalDisplay int
 		Total        int
 		TotalEvents  int
+		TotalUnique  int
 		MorePages    bool
 
 		Style    string
@@ -183,9 +219,9 @@ func (w Pages) RenderHTML(ctx context.Context, shared SharedData) (string, any)
 		Diff     []float64
 	}{
 		ctx, shared.Site, shared.User,
-		w.id, w.loaded, w.err, w.Pages, shared.Args.Rng, shared.Args.Daily,
-		shared.Args.ForcedDaily, 1, w.Max,
-		w.Display, shared.Total, shared.TotalEvents, w.More,
+		w.id, w.loaded, w.err, w.Pages, shared.Args.Rng, shared.Args.Group.Daily(),
+		shared.Args.Group, shared.Args.ForcedDaily, 1, w.Max,
+		w.Display, shared.Total, shared.TotalEvents, shared.TotalUnique, w.More,
 		w.Style, w.Refs, shared.Args.ShowRefs,
 		w.Diff,
 	}
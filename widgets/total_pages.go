@@ -52,7 +52,7 @@ func (w *TotalPages) SetSettings(s goatcounter.WidgetSettings) {
 }
 
 func (w *TotalPages) GetData(ctx context.Context, a Args) (more bool, err error) {
-	w.Max, err = w.Total.Totals(ctx, a.Rng, a.PathFilter, a.Daily, w.NoEvents)
+	w.Max, err = w.Total.Totals(ctx, a.Rng, a.PathFilter, a.Group, w.NoEvents)
 	w.loaded = true
 	return false, err
 }
@@ -70,7 +70,7 @@ func (w TotalPages) RenderHTML(ctx context.Context, shared SharedData) (string,
 		today = now.Format("2006-01-02")
 		hour  = now.Hour()
 	)
-	if len(w.Total.Stats) > 0 && w.Total.Stats[len(w.Total.Stats)-1].Day == today {
+	if len(w.Total.Stats) > 0 && w.Total.Stats[len(w.Total.Stats)-1].Day == today && shared.Args.Group <= goatcounter.GroupDaily {
 		j := len(w.Total.Stats) - 1
 		w.Total.Stats[j].Hourly = w.Total.Stats[j].Hourly[:hour+1]
 	}
@@ -87,15 +87,17 @@ func (w TotalPages) RenderHTML(ctx context.Context, shared SharedData) (string,
 		NoEvents bool
 		Page     goatcounter.HitList
 		Daily    bool
+		Group    goatcounter.Group
 		Max      int
 
 		Total       int
 		TotalEvents int
+		TotalUnique int
 
 		Style string
 	}{ctx, shared.Site, shared.User, w.id, w.loaded, w.err,
 		w.Align, w.NoEvents,
-		w.Total, shared.Args.Daily, w.Max,
-		shared.Total, shared.TotalEvents,
+		w.Total, shared.Args.Group.Daily(), shared.Args.Group, w.Max,
+		shared.Total, shared.TotalEvents, shared.TotalUnique,
 		w.Style}
 }
@@ -36,7 +36,7 @@ type (
 		Rng         ztime.Range
 		Offset      int
 		PathFilter  []int64
-		Daily       bool
+		Group       goatcounter.Group
 		ForcedDaily bool
 		ShowRefs    int64
 	}
@@ -51,6 +51,7 @@ type (
 		Total       int
 		TotalUTC    int
 		TotalEvents int
+		TotalUnique int
 	}
 )
 
@@ -139,6 +140,8 @@ func ListAllWidgets() List {
 		NewWidget("systems", 0),
 		NewWidget("toprefs", 0),
 		NewWidget("campaigns", 0),
+		NewWidget("channels", 0),
+		NewWidget("groups", 0),
 		NewWidget("totalpages", 0),
 	}
 }
@@ -156,6 +159,10 @@ func NewWidget(name string, id int) Widget {
 		return &TopRefs{id: id}
 	case "campaigns":
 		return &Campaigns{id: id}
+	case "channels":
+		return &Channels{id: id}
+	case "groups":
+		return &Groups{id: id}
 	case "browsers":
 		return &Browsers{id: id}
 	case "systems":